@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/kbehouse/nsc/cmd/store"
@@ -51,6 +52,24 @@ type Action interface {
 	Run(ctx ActionCtx) (store.Status, error)
 }
 
+// DryRunUnsupported is implemented by actions with effects outside of the
+// store and the keystore, which a dry-run can't capture
+type DryRunUnsupported interface {
+	DryRunUnsupported()
+}
+
+// LongRunning is implemented by actions that run until they are interrupted,
+// they don't hold the locks of the store and the keystore while they run
+type LongRunning interface {
+	LongRunning()
+}
+
+// NoAutoCommit is implemented by actions run by git, their changes are
+// committed by the git command that runs them
+type NoAutoCommit interface {
+	NoAutoCommit()
+}
+
 type Actx struct {
 	ctx  *store.Context
 	cmd  *cobra.Command
@@ -112,11 +131,59 @@ func RunAction(cmd *cobra.Command, args []string, action interface{}) error {
 	return run(ctx, action)
 }
 
+// lockAction takes the locks of the store and the keystore, so that what
+// an action loads can't be changed by another process before it is written
+func lockAction(ctx ActionCtx) (func(), error) {
+	var unlocks []func()
+	unlock := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	if s := ctx.StoreCtx().Store; s != nil {
+		u, err := s.LockStore()
+		if err != nil {
+			return nil, err
+		}
+		unlocks = append(unlocks, u)
+	}
+	// the keystore is not created by actions that don't write to it
+	if _, err := os.Stat(store.GetKeysDir()); err == nil {
+		u, err := store.LockKeyStore()
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		unlocks = append(unlocks, u)
+	}
+	return unlock, nil
+}
+
+// statusSink when set receives the status of actions instead of it being printed,
+// it is used by commands that run other commands
+var statusSink func(rs store.Status)
+
 func run(ctx ActionCtx, action interface{}) error {
 	e, ok := action.(Action)
 	if !ok {
 		return fmt.Errorf("action provided is not an Action")
 	}
+	outermost := store.JournalCommand == ""
+	if outermost {
+		// the outermost command is the one recorded
+		store.JournalCommand = commandLine(ctx.CurrentCmd(), ctx.Args())
+		defer func() {
+			store.JournalCommand = ""
+		}()
+	}
+	if _, ok := action.(DryRunUnsupported); ok && (DryRunFlag || store.IsDryRun()) {
+		return fmt.Errorf("%s doesn't support --dry-run", ctx.CurrentCmd().CommandPath())
+	}
+	var dr *store.DryRun
+	if DryRunFlag && !store.IsDryRun() {
+		dr = store.StartDryRun()
+		defer store.StopDryRun()
+	}
 	if err := e.SetDefaults(ctx); err != nil {
 		return err
 	}
@@ -127,6 +194,14 @@ func run(ctx ActionCtx, action interface{}) error {
 		}
 	}
 
+	if _, ok := action.(LongRunning); outermost && !ok {
+		unlock, err := lockAction(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
 	if err := e.Load(ctx); err != nil {
 		return err
 	}
@@ -141,9 +216,39 @@ func run(ctx ActionCtx, action interface{}) error {
 		return err
 	}
 
+	_, noCommit := action.(NoAutoCommit)
+	commit := dr == nil && outermost && !noCommit
+	if commit {
+		// only the files the action wrote are committed
+		store.StartRecording()
+		defer store.StopRecording()
+	}
 	rs, err := e.Run(ctx)
+	if err == nil && commit {
+		if cerr := commitStoreChanges(store.JournalCommand, store.StopRecording()); cerr != nil {
+			err = fmt.Errorf("unable to commit the changes to the store: %v", cerr)
+		}
+	}
+	if statusSink != nil {
+		if rs != nil {
+			statusSink(rs)
+		}
+		return err
+	}
+	var changes *store.Report
+	if dr != nil {
+		changes = dryRunReport(dr)
+	}
+	if IsJsonOutput() {
+		return runJsonOutput(ctx, rs, changes, err)
+	}
 	if rs != nil {
 		ctx.CurrentCmd().Println(rs.Message())
+	}
+	if changes != nil {
+		ctx.CurrentCmd().Println(changes.Message())
+	}
+	if rs != nil {
 		sum, ok := rs.(store.Summarizer)
 		if ok {
 			m, err := sum.Summary()
@@ -158,6 +263,20 @@ func run(ctx ActionCtx, action interface{}) error {
 	return err
 }
 
+func runJsonOutput(ctx ActionCtx, rs store.Status, changes *store.Report, err error) error {
+	if rs == nil && changes == nil {
+		return err
+	}
+	var summary string
+	if sum, ok := rs.(store.Summarizer); ok && err == nil {
+		summary, err = sum.Summary()
+	}
+	if werr := writeJsonStatus(ctx.CurrentCmd(), rs, changes, summary, err); werr != nil {
+		return werr
+	}
+	return err
+}
+
 func (c *Actx) StoreCtx() *store.Context {
 	return c.ctx
 }
@@ -16,7 +16,11 @@
 package cmd
 
 import (
+	"bytes"
+	"os"
+	"os/exec"
 	"testing"
+	"time"
 
 	"github.com/kbehouse/nsc/cmd/store"
 	"github.com/spf13/cobra"
@@ -230,3 +234,45 @@ func TestActionMessage(t *testing.T) {
 	require.NoError(t, err)
 	require.Contains(t, "This is a test message", out)
 }
+
+// Test_EditAccountProcess edits an account as another nsc process would,
+// it is run by Test_ConcurrentEditsAreSerialized
+func Test_EditAccountProcess(t *testing.T) {
+	root := os.Getenv("NSC_TEST_EDIT_ROOT")
+	if root == "" {
+		t.Skip("run by Test_ConcurrentEditsAreSerialized")
+	}
+	ts := NewEmptyStore(t)
+	defer ts.Done(t)
+	require.NoError(t, ForceStoreRoot(t, root))
+	ForceOperator(t, "O")
+	require.NoError(t, os.Setenv(store.NKeysPathEnv, os.Getenv("NSC_TEST_EDIT_KEYS")))
+	_, _, err := ExecuteCmd(createEditAccount(), "--name", "A", "--tag", "other")
+	require.NoError(t, err)
+}
+
+func Test_ConcurrentEditsAreSerialized(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	unlock, err := ts.Store.LockStore()
+	require.NoError(t, err)
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_EditAccountProcess$")
+	cmd.Env = append(os.Environ(), "NSC_TEST_EDIT_ROOT="+ts.GetStoresRoot(), "NSC_TEST_EDIT_KEYS="+store.GetKeysDir())
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	require.NoError(t, cmd.Start())
+	// give the other process the time to load the account before it is edited here
+	time.Sleep(time.Second)
+	_, _, err = ExecuteCmd(createEditAccount(), "--name", "A", "--tag", "this")
+	unlock()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Wait(), out.String())
+
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Contains(t, ac.Tags, "this")
+	require.Contains(t, ac.Tags, "other")
+}
@@ -41,6 +41,9 @@ func CreateAddAccountCmd() *cobra.Command {
 			if err := RunAction(cmd, args, &params); err != nil {
 				return err
 			}
+			if DryRunFlag {
+				return nil
+			}
 			return GetConfig().SetAccount(params.name)
 		},
 	}
@@ -39,6 +39,9 @@ func CreateAddOperatorCmd() *cobra.Command {
 			if err := RunStoreLessAction(cmd, args, &params); err != nil {
 				return err
 			}
+			if DryRunFlag {
+				return nil
+			}
 			return GetConfig().SetOperator(params.name)
 		},
 	}
@@ -48,6 +51,7 @@ func CreateAddOperatorCmd() *cobra.Command {
 	cmd.Flags().BoolVarP(&params.sysAcc, "sys", "s", false, "generate system account with the operator (if specified will be signed with signing key)")
 	cmd.Flags().BoolVarP(&params.force, "force", "", false, "on import, overwrite existing when already present")
 	params.TimeParams.BindFlags(cmd)
+	params.mnemonic.BindFlags(cmd)
 
 	return cmd
 }
@@ -76,6 +80,7 @@ type AddOperatorParams struct {
 	force    bool
 	genSk    bool
 	keyPath  string
+	mnemonic MnemonicParams
 }
 
 func (p *AddOperatorParams) SetDefaults(ctx ActionCtx) error {
@@ -238,6 +243,9 @@ func (p *AddOperatorParams) Validate(ctx ActionCtx) error {
 		if p.sysAcc {
 			return fmt.Errorf("importing an operator is not compatible with system account generation")
 		}
+		if p.mnemonic.Enabled() {
+			return fmt.Errorf("importing an operator is not compatible with deriving keys from a mnemonic")
+		}
 		// validated on load
 		return nil
 	}
@@ -253,8 +261,15 @@ func (p *AddOperatorParams) Validate(ctx ActionCtx) error {
 		return err
 	}
 
+	if p.mnemonic.Enabled() && !p.generate {
+		return fmt.Errorf("keys derived from a mnemonic can't be used with an existing operator key")
+	}
+	if err = p.mnemonic.Resolve(); err != nil {
+		return err
+	}
+
 	if p.generate {
-		p.signerKP, err = nkeys.CreateOperator()
+		p.signerKP, err = p.mnemonic.KeyGenerator()(store.DerivedOperatorKey, nkeys.PrefixByteOperator)
 		if err != nil {
 			return err
 		}
@@ -332,7 +347,7 @@ func (p *AddOperatorParams) Run(ctx ActionCtx) (store.Status, error) {
 		}
 		sysAccSigner := p.signerKP
 		if p.genSk {
-			sysAccSigner, err = nkeys.CreateOperator()
+			sysAccSigner, err = p.mnemonic.KeyGenerator()(store.DerivedOperatorSigningKey, nkeys.PrefixByteOperator)
 			if err != nil {
 				return nil, err
 			}
@@ -346,7 +361,7 @@ func (p *AddOperatorParams) Run(ctx ActionCtx) (store.Status, error) {
 			oc.SigningKeys.Add(skPub)
 		}
 		if p.sysAcc {
-			if sAcc, sUsr, err = createSystemAccount(ctx, sysAccSigner); err != nil {
+			if sAcc, sUsr, err = createSystemAccount(ctx, sysAccSigner, p.mnemonic.KeyGenerator()); err != nil {
 				return nil, err
 			}
 			oc.SystemAccount = sAcc.PubKey
@@ -401,6 +416,7 @@ func (p *AddOperatorParams) Run(ctx ActionCtx) (store.Status, error) {
 			verb = "imported"
 		}
 		r.AddOK("%s operator %q", verb, p.name)
+		p.mnemonic.AddToReport(r)
 		r.AddOK("When running your own nats-server, make sure they run at least version 2.2.0")
 		if sAcc != nil && sUsr != nil {
 			if skPub != "" {
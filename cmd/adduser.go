@@ -188,11 +188,11 @@ func validUserSigners(ctx ActionCtx, accName string) ([]string, error) {
 		return nil, err
 	}
 	var signers []string
-	if !opc.StrictSigningKeyUsage && ctx.StoreCtx().KeyStore.HasPrivateKey(ac.Subject) {
+	if !opc.StrictSigningKeyUsage && ctx.StoreCtx().KeyStore.CanSign(ac.Subject) {
 		signers = append(signers, ac.Subject)
 	}
 	for signingKey := range ac.SigningKeys {
-		if ctx.StoreCtx().KeyStore.HasPrivateKey(signingKey) {
+		if ctx.StoreCtx().KeyStore.CanSign(signingKey) {
 			signers = append(signers, signingKey)
 		}
 	}
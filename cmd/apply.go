@@ -0,0 +1,1095 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createApplyCmd() *cobra.Command {
+	var params ApplyParams
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Converge the store to the topology described in a file",
+		Long: `Converge the store to the topology described in a file
+
+The topology file is a yaml document describing the operator and its accounts,
+with their limits, default permissions, signing keys and scopes, exports,
+imports, mappings and users. Apply compares the topology to the store and
+plans the 'nsc add', 'nsc edit' and 'nsc delete' commands that converge the
+store to it. The commands are then run in order, so the values in the
+topology are validated exactly as if the commands were typed.
+
+Attributes of an account or user that are not specified in the topology
+are reset to their defaults. Accounts, users, exports, imports, mappings
+and signing keys that are in the store but not in the topology are only
+deleted when --prune is specified. Time ranges and locales of users
+are not managed by apply.
+
+The plan is printed before it is applied. Use --dry-run to preview the
+changes without writing them, or --interactive to confirm the plan before
+it is applied.
+
+  operator:
+    name: O
+    service_urls: [nats://localhost:4222]
+  accounts:
+  - name: A
+    limits:
+      conns: 100
+    signing_keys:
+    - role: service
+      template:
+        permissions:
+          pub_allow: [svc.>]
+    exports:
+    - subject: svc.>
+      type: service
+    users:
+    - name: U
+      signing_key: service
+  - name: B
+    imports:
+    - account: A
+      subject: svc.>
+      type: service`,
+		Example: `nsc apply -f topology.yaml
+nsc apply -f topology.yaml --dry-run
+nsc apply -f topology.yaml --prune`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.file, "file", "f", "", "topology file")
+	cmd.Flags().BoolVarP(&params.prune, "prune", "", false, "delete accounts, users, exports, imports, mappings and signing keys that are not in the topology")
+	return cmd
+}
+
+func init() {
+	GetRootCmd().AddCommand(createApplyCmd())
+}
+
+type ApplyParams struct {
+	file     string
+	prune    bool
+	topology *Topology
+	plan     *applyPlan
+}
+
+func (p *ApplyParams) SetDefaults(ctx ActionCtx) error {
+	if !InteractiveFlag && p.file == "" {
+		ctx.CurrentCmd().SilenceUsage = false
+		return errors.New("a topology file is required")
+	}
+	return nil
+}
+
+func (p *ApplyParams) PreInteractive(ctx ActionCtx) error {
+	var err error
+	p.file, err = cli.Prompt("topology file", p.file, cli.NewLengthValidator(1))
+	return err
+}
+
+func (p *ApplyParams) Load(ctx ActionCtx) error {
+	var err error
+	if p.topology, err = LoadTopology(p.file); err != nil {
+		return err
+	}
+	p.plan, err = newApplyPlan(ctx, p.topology, p.prune)
+	return err
+}
+
+func (p *ApplyParams) PostInteractive(ctx ActionCtx) error {
+	if len(p.plan.steps) == 0 {
+		return nil
+	}
+	ctx.CurrentCmd().Println(p.plan.String())
+	ok, err := cli.Confirm(fmt.Sprintf("apply %d changes", len(p.plan.steps)), true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("apply cancelled")
+	}
+	return nil
+}
+
+func (p *ApplyParams) Validate(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ApplyParams) Run(ctx ActionCtx) (store.Status, error) {
+	r := store.NewReport(store.OK, "apply %#q", AbbrevHomePaths(p.file))
+	r.Add(p.plan.notes...)
+	if len(p.plan.steps) == 0 {
+		r.AddOK("the store matches the topology - nothing to do")
+		return r, nil
+	}
+
+	if !InteractiveFlag {
+		// the interactive confirmation printed the plan already
+		ctx.CurrentCmd().Println(p.plan.String())
+	}
+
+	current := GetConfig().Account
+	defer func() {
+		if !DryRunFlag && current != "" && current != GetConfig().Account && ctx.StoreCtx().Store.HasAccount(current) {
+			_ = GetConfig().SetAccount(current)
+		}
+	}()
+
+	for i, s := range p.plan.steps {
+		sr := store.NewReport(store.OK, "%s", s)
+		r.Add(sr)
+		if err := s.run(ctx, sr); err != nil {
+			sr.AddFromError(err)
+		}
+		if sr.HasErrors() {
+			if n := len(p.plan.steps) - i - 1; n > 0 {
+				r.AddWarning("stopped - the remaining %d changes were not applied", n)
+			}
+			break
+		}
+	}
+	return r, nil
+}
+
+// applyStep is an nsc command run by apply
+type applyStep struct {
+	create func() *cobra.Command
+	path   string
+	args   []string
+	// signer is the -K value for the command
+	signer string
+	// keys generated while planning that are stored before running the command
+	keys []nkeys.KeyPair
+}
+
+func (s *applyStep) String() string {
+	parts := []string{GetToolName(), s.path}
+	for _, a := range s.args {
+		if strings.ContainsAny(a, " \t\"'*>") {
+			a = strconv.Quote(a)
+		}
+		parts = append(parts, a)
+	}
+	if s.signer != "" {
+		parts = append(parts, "-K", s.signer)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *applyStep) run(ctx ActionCtx, r *store.Report) error {
+	for _, kp := range s.keys {
+		pk, err := kp.PublicKey()
+		if err != nil {
+			return err
+		}
+		if _, err := ctx.StoreCtx().KeyStore.Store(kp); err != nil {
+			return err
+		}
+		r.AddOK("generated and stored signing key %q", pk)
+	}
+
+	cmd := s.create()
+	var out bytes.Buffer
+	cmd.SetOutput(&out)
+	cmd.SetArgs(s.args)
+	cmd.SilenceErrors = true
+
+	keyPath, interactive, sink := KeyPathFlag, InteractiveFlag, statusSink
+	defer func() {
+		KeyPathFlag, InteractiveFlag, statusSink = keyPath, interactive, sink
+	}()
+	KeyPathFlag = s.signer
+	InteractiveFlag = false
+	statusSink = func(rs store.Status) {
+		r.Add(rs)
+	}
+	_, err := cmd.ExecuteC()
+	return err
+}
+
+// applyPlan holds the commands that converge the store to a topology
+type applyPlan struct {
+	topology *Topology
+	prune    bool
+	s        *store.Store
+	operator *jwt.OperatorClaims
+	// current account claims by name
+	accounts map[string]*jwt.AccountClaims
+	// public keys of the desired signing keys by account and role
+	roles map[string]map[string]string
+	notes []store.Status
+	steps []*applyStep
+}
+
+func newApplyPlan(ctx ActionCtx, t *Topology, prune bool) (*applyPlan, error) {
+	var err error
+	p := &applyPlan{
+		topology: t,
+		prune:    prune,
+		s:        ctx.StoreCtx().Store,
+		accounts: make(map[string]*jwt.AccountClaims),
+		roles:    make(map[string]map[string]string),
+	}
+	if p.operator, err = p.s.ReadOperatorClaim(); err != nil {
+		return nil, err
+	}
+	if t.Operator != nil && t.Operator.Name != "" && t.Operator.Name != p.operator.Name {
+		return nil, fmt.Errorf("the topology is for operator %q but the current operator is %q", t.Operator.Name, p.operator.Name)
+	}
+	if t.Operator != nil && t.Operator.Key != "" && t.Operator.Key != p.operator.Subject {
+		return nil, fmt.Errorf("the topology is for operator key %q but the current operator key is %q", t.Operator.Key, p.operator.Subject)
+	}
+	names, err := p.s.ListSubContainers(store.Accounts)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		if p.accounts[n], err = p.s.ReadAccountClaim(n); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.planOperator(); err != nil {
+		return nil, err
+	}
+	for _, a := range t.Accounts {
+		if _, ok := p.accounts[a.Name]; !ok {
+			p.add(CreateAddAccountCmd, "add account", flagArg("name", a.Name))
+		}
+	}
+	for _, a := range t.Accounts {
+		if err := p.planAccount(a); err != nil {
+			return nil, fmt.Errorf("account %q: %v", a.Name, err)
+		}
+	}
+	// imports are added once all the exports are in place
+	for _, a := range t.Accounts {
+		if err := p.planImports(a); err != nil {
+			return nil, fmt.Errorf("account %q: %v", a.Name, err)
+		}
+	}
+	for _, a := range t.Accounts {
+		if err := p.planUsers(a); err != nil {
+			return nil, fmt.Errorf("account %q: %v", a.Name, err)
+		}
+	}
+	// signing keys are removed once users have been signed by their new keys
+	for _, a := range t.Accounts {
+		p.planSigningKeyRemovals(a)
+	}
+	if err := p.planSystemAccount(); err != nil {
+		return nil, err
+	}
+	p.planAccountRemovals()
+	return p, nil
+}
+
+func (p *applyPlan) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("plan - %d changes:\n", len(p.steps)))
+	for _, s := range p.steps {
+		buf.WriteString(fmt.Sprintf("  %s\n", s))
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func (p *applyPlan) add(create func() *cobra.Command, path string, args ...string) *applyStep {
+	s := &applyStep{create: create, path: path, args: args}
+	p.steps = append(p.steps, s)
+	return s
+}
+
+func (p *applyPlan) note(format string, args ...interface{}) {
+	p.notes = append(p.notes, store.WarningStatus(format, args...))
+}
+
+func (p *applyPlan) accountInTopology(name string) *AccountTopology {
+	for _, a := range p.topology.Accounts {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// accountKey resolves an account name or public key, the returned key is
+// empty if the account is only in the topology
+func (p *applyPlan) accountKey(ref string) (string, error) {
+	if nkeys.IsValidPublicAccountKey(ref) {
+		return ref, nil
+	}
+	if ac, ok := p.accounts[ref]; ok {
+		return ac.Subject, nil
+	}
+	if p.accountInTopology(ref) != nil {
+		return "", nil
+	}
+	return "", fmt.Errorf("account %q is not in the store or the topology", ref)
+}
+
+func (p *applyPlan) planOperator() error {
+	o := p.topology.Operator
+	if o == nil {
+		return nil
+	}
+	oc := p.operator
+	var args []string
+	if o.AccountServerURL != oc.AccountServerURL {
+		if o.AccountServerURL == "" {
+			p.note("the account server url %q cannot be removed by apply", oc.AccountServerURL)
+		} else {
+			args = append(args, flagArg("account-jwt-server-url", o.AccountServerURL))
+		}
+	}
+	add, rm := diffStrings(oc.OperatorServiceURLs, o.ServiceURLs)
+	args = append(args, flagArgs("service-url", add)...)
+	args = append(args, flagArgs("rm-service-url", rm)...)
+	if o.RequireSigningKeys != oc.StrictSigningKeyUsage {
+		if o.RequireSigningKeys {
+			args = append(args, flagArg("require-signing-keys", true))
+		} else {
+			p.note("requiring signing keys cannot be disabled by apply")
+		}
+	}
+	args = append(args, tagArgs(oc.Tags, o.Tags)...)
+	for _, k := range o.SigningKeys {
+		if !nkeys.IsValidPublicOperatorKey(k) {
+			return fmt.Errorf("operator signing key %q is not an operator public key", k)
+		}
+	}
+	add, rm = diffStrings(oc.SigningKeys, o.SigningKeys)
+	args = append(args, flagArgs("sk", add)...)
+	if p.prune {
+		args = append(args, flagArgs("rm-sk", rm)...)
+	} else {
+		for _, k := range rm {
+			p.note("operator signing key %q is not in the topology - use --prune to remove it", k)
+		}
+	}
+	if len(args) > 0 {
+		p.add(CreateEditOperatorCmd, "edit operator", args...)
+	}
+	return nil
+}
+
+func (p *applyPlan) planSystemAccount() error {
+	o := p.topology.Operator
+	if o == nil || o.SystemAccount == "" {
+		return nil
+	}
+	pk, err := p.accountKey(o.SystemAccount)
+	if err != nil {
+		return fmt.Errorf("system account: %v", err)
+	}
+	if pk == "" || pk != p.operator.SystemAccount {
+		p.add(CreateEditOperatorCmd, "edit operator", flagArg("system-account", o.SystemAccount))
+	}
+	return nil
+}
+
+func (p *applyPlan) planAccountRemovals() {
+	var names []string
+	for n := range p.accounts {
+		if p.accountInTopology(n) == nil {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		switch {
+		case p.accounts[n].Subject == p.operator.SystemAccount:
+			if p.prune {
+				p.note("system account %q is not in the topology - it was not deleted", n)
+			}
+		case p.prune:
+			p.add(createDeleteAccountCmd, "delete account", flagArg("name", n))
+		default:
+			p.note("account %q is not in the topology - use --prune to delete it", n)
+		}
+	}
+}
+
+// desiredSigningKey is an account signing key in the topology
+type desiredSigningKey struct {
+	pk string
+	kp nkeys.KeyPair
+	t  *SigningKeyTopology
+}
+
+func (p *applyPlan) desiredSigningKeys(a *AccountTopology, cur *jwt.AccountClaims) ([]*desiredSigningKey, error) {
+	var keys []*desiredSigningKey
+	p.roles[a.Name] = make(map[string]string)
+	for _, sk := range a.SigningKeys {
+		d := &desiredSigningKey{pk: sk.Key, t: sk}
+		if d.pk == "" {
+			for k, v := range cur.SigningKeys {
+				if us, ok := v.(*jwt.UserScope); ok && us.Role == sk.Role {
+					d.pk = k
+					break
+				}
+			}
+		}
+		if d.pk == "" {
+			kp, err := nkeys.CreateAccount()
+			if err != nil {
+				return nil, err
+			}
+			if d.pk, err = kp.PublicKey(); err != nil {
+				return nil, err
+			}
+			d.kp = kp
+		}
+		if !nkeys.IsValidPublicAccountKey(d.pk) {
+			return nil, fmt.Errorf("signing key %q is not an account public key", d.pk)
+		}
+		if sk.Role != "" {
+			p.roles[a.Name][sk.Role] = d.pk
+		}
+		keys = append(keys, d)
+	}
+	return keys, nil
+}
+
+// editAccount adds an edit account step, edit account always
+// sets the wildcard exports limit so it has to be specified
+func (p *applyPlan) editAccount(a *AccountTopology, args ...string) *applyStep {
+	args = append([]string{flagArg("name", a.Name)}, args...)
+	if wc := a.Limits.jwtLimits().WildcardExports; !wc {
+		args = append(args, flagArg("wildcard-exports", wc))
+	}
+	return p.add(createEditAccount, "edit account", args...)
+}
+
+func (p *applyPlan) planAccount(a *AccountTopology) error {
+	cur, exists := p.accounts[a.Name]
+	if !exists {
+		cur = jwt.NewAccountClaims("A")
+	} else if a.Key != "" && a.Key != cur.Subject {
+		return fmt.Errorf("the topology expects key %q but the account key is %q", a.Key, cur.Subject)
+	}
+	var args []string
+
+	want := a.Limits.jwtLimits()
+	for _, l := range []struct {
+		flag      string
+		cur, want int64
+	}{
+		{"conns", cur.Limits.Conn, want.Conn},
+		{"leaf-conns", cur.Limits.LeafNodeConn, want.LeafNodeConn},
+		{"subscriptions", cur.Limits.Subs, want.Subs},
+		{"data", cur.Limits.Data, want.Data},
+		{"payload", cur.Limits.Payload, want.Payload},
+		{"imports", cur.Limits.Imports, want.Imports},
+		{"exports", cur.Limits.Exports, want.Exports},
+		{"js-mem-storage", cur.Limits.MemoryStorage, want.MemoryStorage},
+		{"js-disk-storage", cur.Limits.DiskStorage, want.DiskStorage},
+		{"js-streams", cur.Limits.Streams, want.Streams},
+		{"js-consumer", cur.Limits.Consumer, want.Consumer},
+	} {
+		if l.cur != l.want {
+			args = append(args, flagArg(l.flag, l.want))
+		}
+	}
+	// edit account adds the flag when wildcards are disabled
+	if cur.Limits.WildcardExports != want.WildcardExports && want.WildcardExports {
+		args = append(args, flagArg("wildcard-exports", true))
+	}
+	if cur.Description != a.Description {
+		args = append(args, flagArg("description", a.Description))
+	}
+	if cur.InfoURL != a.InfoURL {
+		args = append(args, flagArg("info-url", a.InfoURL))
+	}
+	args = append(args, tagArgs(cur.Tags, a.Tags)...)
+
+	perms, err := a.DefaultPermissions.jwtPermissions()
+	if err != nil {
+		return err
+	}
+	pre, permArgs := permissionsArgs(cur.DefaultPermissions, perms)
+	for _, pa := range pre {
+		p.editAccount(a, pa...)
+	}
+	args = append(args, permArgs...)
+
+	keys, err := p.desiredSigningKeys(a, cur)
+	if err != nil {
+		return err
+	}
+	var generated []nkeys.KeyPair
+	for _, k := range keys {
+		scope, found := cur.SigningKeys.GetScope(k.pk)
+		if found && scope != nil && !k.t.scoped() {
+			// a scope cannot be removed from a key, so the key is added again
+			p.editAccount(a, flagArg("rm-sk", k.pk))
+			found = false
+		}
+		if !found {
+			args = append(args, flagArg("sk", k.pk))
+			if k.kp != nil {
+				generated = append(generated, k.kp)
+			}
+		}
+	}
+	if len(args) > 0 || len(generated) > 0 {
+		s := p.editAccount(a, args...)
+		s.keys = generated
+	}
+
+	for _, k := range keys {
+		if !k.t.scoped() {
+			continue
+		}
+		template := jwt.NewUserScope().Template
+		role := ""
+		if scope, _ := cur.SigningKeys.GetScope(k.pk); scope != nil {
+			if us, ok := scope.(*jwt.UserScope); ok {
+				template = us.Template
+				role = us.Role
+			}
+		}
+		desired, err := k.t.Template.jwtUserPermissionLimits(jwt.NewUserScope().Template)
+		if err != nil {
+			return err
+		}
+		id := []string{flagArg("account", a.Name), flagArg("sk", k.pk)}
+		pre, args := userLimitsArgs(template, desired)
+		_, isScoped := cur.SigningKeys.GetScope(k.pk)
+		if role != k.t.Role || !isScoped || len(args) > 0 || len(pre) > 0 {
+			for _, pa := range pre {
+				p.add(createEditSkopedSkCmd, "edit signing-key", append(id, pa...)...)
+			}
+			args = append(args, flagArg("role", k.t.Role))
+			p.add(createEditSkopedSkCmd, "edit signing-key", append(id, args...)...)
+		}
+	}
+
+	if err := p.planExports(a, cur); err != nil {
+		return err
+	}
+	return p.planMappings(a, cur)
+}
+
+func (p *applyPlan) planSigningKeyRemovals(a *AccountTopology) {
+	cur, ok := p.accounts[a.Name]
+	if !ok {
+		return
+	}
+	var rm []string
+	for _, k := range cur.SigningKeys.Keys() {
+		found := false
+		for _, d := range a.SigningKeys {
+			if d.Key == k || (d.Key == "" && d.Role != "" && p.roles[a.Name][d.Role] == k) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			rm = append(rm, k)
+		}
+	}
+	sort.Strings(rm)
+	if len(rm) == 0 {
+		return
+	}
+	if !p.prune {
+		for _, k := range rm {
+			p.note("signing key %q of account %q is not in the topology - use --prune to remove it", k, a.Name)
+		}
+		return
+	}
+	p.editAccount(a, flagArgs("rm-sk", rm)...)
+}
+
+func exportsEqual(a *jwt.Export, b *jwt.Export) bool {
+	ra, rb := a.ResponseType, b.ResponseType
+	if a.IsService() && ra == "" {
+		ra = jwt.ResponseTypeSingleton
+	}
+	if b.IsService() && rb == "" {
+		rb = jwt.ResponseTypeSingleton
+	}
+	return a.Name == b.Name &&
+		a.Type == b.Type &&
+		a.TokenReq == b.TokenReq &&
+		ra == rb &&
+		a.ResponseThreshold == b.ResponseThreshold &&
+		reflect.DeepEqual(a.Latency, b.Latency) &&
+		a.AccountTokenPosition == b.AccountTokenPosition &&
+		a.Advertise == b.Advertise &&
+		a.Info == b.Info
+}
+
+func (p *applyPlan) planExports(a *AccountTopology, cur *jwt.AccountClaims) error {
+	desired := make(map[string]bool)
+	for _, e := range a.Exports {
+		want, err := e.jwtExport()
+		if err != nil {
+			return err
+		}
+		desired[e.Subject] = true
+		id := []string{flagArg("account", a.Name), flagArg("subject", e.Subject)}
+		var existing *jwt.Export
+		for _, c := range cur.Exports {
+			if string(c.Subject) == e.Subject {
+				existing = c
+				break
+			}
+		}
+		if existing != nil {
+			if exportsEqual(existing, want) {
+				continue
+			}
+			// exports are replaced as a whole
+			p.add(createDeleteExportCmd, "delete export", id...)
+		}
+		args := append(id, flagArg("name", want.Name))
+		if want.IsService() {
+			args = append(args, flagArg("service", true), flagArg("response-type", want.ResponseType))
+			if want.ResponseThreshold > 0 {
+				args = append(args, flagArg("response-threshold", want.ResponseThreshold))
+			}
+			if want.Latency != nil {
+				args = append(args, flagArg("latency", want.Latency.Results), flagArg("sampling", latSamplingRateToString(want.Latency.Sampling)))
+			}
+		}
+		if want.TokenReq {
+			args = append(args, flagArg("private", true))
+		}
+		if want.AccountTokenPosition > 0 {
+			args = append(args, flagArg("account-token-position", want.AccountTokenPosition))
+		}
+		if want.Advertise {
+			args = append(args, flagArg("advertise", true))
+		}
+		p.add(createAddExportCmd, "add export", args...)
+		if want.Description != "" || want.InfoURL != "" {
+			p.add(createEditExportCmd, "edit export", append(id, flagArg("description", want.Description), flagArg("info-url", want.InfoURL))...)
+		}
+	}
+	for _, c := range cur.Exports {
+		if desired[string(c.Subject)] {
+			continue
+		}
+		if p.prune {
+			p.add(createDeleteExportCmd, "delete export", flagArg("account", a.Name), flagArg("subject", string(c.Subject)))
+		} else {
+			p.note("export %q of account %q is not in the topology - use --prune to delete it", c.Subject, a.Name)
+		}
+	}
+	return nil
+}
+
+func (p *applyPlan) planMappings(a *AccountTopology, cur *jwt.AccountClaims) error {
+	var froms []string
+	for from := range a.Mappings {
+		froms = append(froms, from)
+	}
+	sort.Strings(froms)
+	for _, from := range froms {
+		var want []jwt.WeightedMapping
+		for _, m := range a.Mappings[from] {
+			want = append(want, jwt.WeightedMapping{Subject: jwt.Subject(m.Subject), Weight: m.Weight, Cluster: m.Cluster})
+		}
+		existing := cur.Mappings[jwt.Subject(from)]
+		if reflect.DeepEqual(existing, want) {
+			continue
+		}
+		id := []string{flagArg("account", a.Name), flagArg("from", from)}
+		if len(existing) > 0 {
+			p.add(createDeleteMappingCmd, "delete mapping", id...)
+		}
+		for _, m := range want {
+			args := append(id, flagArg("to", m.Subject))
+			if m.Weight != 0 {
+				args = append(args, flagArg("weight", m.Weight))
+			}
+			if m.Cluster != "" {
+				args = append(args, flagArg("cluster", m.Cluster))
+			}
+			p.add(createAddMappingCmd, "add mapping", args...)
+		}
+	}
+	var extra []string
+	for from := range cur.Mappings {
+		if _, ok := a.Mappings[string(from)]; !ok {
+			extra = append(extra, string(from))
+		}
+	}
+	sort.Strings(extra)
+	for _, from := range extra {
+		if p.prune {
+			p.add(createDeleteMappingCmd, "delete mapping", flagArg("account", a.Name), flagArg("from", from))
+		} else {
+			p.note("mapping %q of account %q is not in the topology - use --prune to delete it", from, a.Name)
+		}
+	}
+	return nil
+}
+
+// importAccountKey resolves the account of a public import, the account key
+// is used when the account isn't known to the current operator
+func (p *applyPlan) importAccountKey(im *ImportTopology) (string, error) {
+	pk, err := p.accountKey(im.Account)
+	switch {
+	case err != nil && im.AccountKey == "":
+		return "", err
+	case err != nil || im.Account == "":
+		return im.AccountKey, nil
+	case im.AccountKey != "" && pk != "" && pk != im.AccountKey:
+		return "", fmt.Errorf("import %q: account %q has key %q but the topology expects %q", im.Subject, im.Account, pk, im.AccountKey)
+	}
+	return pk, nil
+}
+
+// desiredImport returns the import described by the topology, the
+// account of the import is empty if the account is only in the topology
+func (p *applyPlan) desiredImport(im *ImportTopology) (*jwt.Import, error) {
+	var want jwt.Import
+	want.Name = im.Name
+	want.LocalSubject = jwt.RenamingSubject(im.LocalSubject)
+	if im.Token != "" {
+		data, err := LoadFromFileOrURL(im.Token)
+		if err != nil {
+			return nil, fmt.Errorf("error loading %#q: %v", im.Token, err)
+		}
+		token, err := jwt.ParseDecoratedJWT(data)
+		if err != nil {
+			return nil, fmt.Errorf("error loading %#q: %v", im.Token, err)
+		}
+		ac, err := jwt.DecodeActivationClaims(token)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %#q: %v", im.Token, err)
+		}
+		want.Token = token
+		if IsURL(im.Token) {
+			want.Token = im.Token
+		}
+		want.Subject = ac.ImportSubject
+		want.Type = ac.ImportType
+		want.Account = ac.Issuer
+		if ac.IssuerAccount != "" {
+			want.Account = ac.IssuerAccount
+		}
+		if want.Name == "" {
+			want.Name = ac.Name
+		}
+		if want.IsService() && want.LocalSubject == "" {
+			want.LocalSubject = jwt.RenamingSubject(want.Subject)
+		}
+	} else {
+		var err error
+		if want.Account, err = p.importAccountKey(im); err != nil {
+			return nil, err
+		}
+		want.Subject = jwt.Subject(im.Subject)
+		if want.Type, err = exportType(im.Type); err != nil {
+			return nil, err
+		}
+		if want.Name == "" {
+			want.Name = im.Subject
+		}
+	}
+	if want.IsService() {
+		want.Share = im.Share
+	}
+	return &want, nil
+}
+
+func (p *applyPlan) planImports(a *AccountTopology) error {
+	cur, exists := p.accounts[a.Name]
+	if !exists {
+		cur = jwt.NewAccountClaims("A")
+	}
+	desired := make(map[*jwt.Import]bool)
+	for _, im := range a.Imports {
+		want, err := p.desiredImport(im)
+		if err != nil {
+			return err
+		}
+		var existing *jwt.Import
+		for _, c := range cur.Imports {
+			if want.Account != "" && c.Account == want.Account && c.Subject == want.Subject {
+				existing = c
+				break
+			}
+		}
+		if existing != nil {
+			desired[existing] = true
+			if existing.Name == want.Name && existing.Type == want.Type && existing.Share == want.Share &&
+				existing.LocalSubject == want.LocalSubject && (im.Token == "" || existing.Token == want.Token) {
+				continue
+			}
+			p.add(createDeleteImportCmd, "delete import", flagArg("account", a.Name),
+				flagArg("subject", string(existing.Subject)), flagArg("src-account", existing.Account))
+		}
+		args := []string{flagArg("account", a.Name)}
+		if im.Token != "" {
+			args = append(args, flagArg("token", im.Token))
+		} else {
+			src := im.Account
+			if _, err := p.accountKey(src); err != nil || src == "" {
+				src = im.AccountKey
+			}
+			args = append(args, flagArg("src-account", src), flagArg("remote-subject", im.Subject))
+			if want.IsService() {
+				args = append(args, flagArg("service", true))
+			}
+		}
+		args = append(args, flagArg("name", want.Name))
+		if want.LocalSubject != "" {
+			args = append(args, flagArg("local-subject", want.LocalSubject))
+		}
+		if want.Share {
+			args = append(args, flagArg("share", true))
+		}
+		p.add(createAddImportCmd, "add import", args...)
+	}
+	for _, c := range cur.Imports {
+		if desired[c] {
+			continue
+		}
+		if p.prune {
+			p.add(createDeleteImportCmd, "delete import", flagArg("account", a.Name),
+				flagArg("subject", string(c.Subject)), flagArg("src-account", c.Account))
+		} else {
+			p.note("import %q of account %q is not in the topology - use --prune to delete it", c.Subject, a.Name)
+		}
+	}
+	return nil
+}
+
+// userSigner returns the public key of the key that should sign the user
+func (p *applyPlan) userSigner(a *AccountTopology, u *UserTopology) (string, error) {
+	if u.SigningKey == "" {
+		if ac, ok := p.accounts[a.Name]; ok {
+			return ac.Subject, nil
+		}
+		return "", nil
+	}
+	if pk, ok := p.roles[a.Name][u.SigningKey]; ok {
+		return pk, nil
+	}
+	for _, sk := range a.SigningKeys {
+		if sk.Key == u.SigningKey {
+			return sk.Key, nil
+		}
+	}
+	return "", fmt.Errorf("user %q: signing key %q is not a role or a key of the account", u.Name, u.SigningKey)
+}
+
+func (p *applyPlan) planUsers(a *AccountTopology) error {
+	var current []string
+	if _, ok := p.accounts[a.Name]; ok {
+		var err error
+		if current, err = p.s.ListEntries(store.Accounts, a.Name, store.Users); err != nil {
+			return err
+		}
+	}
+	desired := make(map[string]bool)
+	for _, u := range a.Users {
+		desired[u.Name] = true
+		signer, err := p.userSigner(a, u)
+		if err != nil {
+			return err
+		}
+		scoped := a.scopedSigningKey(u.SigningKey) != nil
+		want, err := u.jwtUserPermissionLimits(jwt.NewUserClaims("U").UserPermissionLimits)
+		if err != nil {
+			return err
+		}
+		if scoped {
+			// users signed by scoped keys get their permissions and limits from the scope
+			want = jwt.UserPermissionLimits{}
+		}
+		var cur *jwt.UserClaims
+		if containsString(current, u.Name) {
+			if cur, err = p.s.ReadUserClaim(a.Name, u.Name); err != nil {
+				return err
+			}
+			if u.Key != "" && u.Key != cur.Subject {
+				return fmt.Errorf("user %q: the topology expects key %q but the user key is %q", u.Name, u.Key, cur.Subject)
+			}
+			if scoped && !cur.HasEmptyPermissions() {
+				p.note("user %q of account %q has permissions or limits and cannot be signed by scoped key %q - delete the user to add it again", u.Name, a.Name, u.SigningKey)
+				continue
+			}
+		} else {
+			s := p.add(CreateAddUserCmd, "add user", flagArg("account", a.Name), flagArg("name", u.Name))
+			s.signer = signer
+			cur = jwt.NewUserClaims("U")
+			cur.SetScoped(scoped)
+			cur.Issuer = signer
+		}
+		id := []string{flagArg("account", a.Name), flagArg("name", u.Name)}
+		pre, args := userLimitsArgs(cur.UserPermissionLimits, want)
+		args = append(args, tagArgs(cur.Tags, u.Tags)...)
+		if signer != "" && cur.Issuer != signer && len(pre) == 0 && len(args) == 0 {
+			// the edit re-signs the user with the new signer
+			args = append(args, flagArg("bearer", want.BearerToken))
+		}
+		for _, pa := range pre {
+			s := p.add(CreateEditUserCmd, "edit user", append(id, pa...)...)
+			s.signer = signer
+		}
+		if len(args) > 0 {
+			s := p.add(CreateEditUserCmd, "edit user", append(id, args...)...)
+			s.signer = signer
+		}
+	}
+	for _, n := range current {
+		if desired[n] {
+			continue
+		}
+		if p.prune {
+			p.add(CreateDeleteUserCmd, "delete user", flagArg("account", a.Name), flagArg("name", n))
+		} else {
+			p.note("user %q of account %q is not in the topology - use --prune to delete it", n, a.Name)
+		}
+	}
+	return nil
+}
+
+func flagArg(name string, v interface{}) string {
+	return fmt.Sprintf("--%s=%v", name, v)
+}
+
+func flagArgs(name string, values []string) []string {
+	var args []string
+	for _, v := range values {
+		args = append(args, flagArg(name, v))
+	}
+	return args
+}
+
+// diffStrings returns the values that have to be added to and
+// removed from cur to match want
+func diffStrings(cur []string, want []string) ([]string, []string) {
+	var add, rm []string
+	for _, v := range want {
+		if !containsString(cur, v) && !containsString(add, v) {
+			add = append(add, v)
+		}
+	}
+	for _, v := range cur {
+		if !containsString(want, v) {
+			rm = append(rm, v)
+		}
+	}
+	return add, rm
+}
+
+func tagArgs(cur jwt.TagList, want []string) []string {
+	var tags jwt.TagList
+	tags.Add(want...)
+	add, rm := diffStrings(cur, tags)
+	return append(flagArgs("tag", add), flagArgs("rm-tag", rm)...)
+}
+
+// permissionsArgs returns the flags that change the permissions cur to want.
+// Response permissions and permissions removed from one list but added to another
+// have to be removed by separate edits which are returned as pre.
+func permissionsArgs(cur jwt.Permissions, want jwt.Permissions) (pre [][]string, args []string) {
+	if !reflect.DeepEqual(cur.Resp, want.Resp) {
+		if cur.Resp != nil {
+			pre = append(pre, []string{flagArg("rm-response-perms", true)})
+		}
+		if want.Resp != nil {
+			args = append(args, flagArg("allow-pub-response", want.Resp.MaxMsgs))
+			if want.Resp.Expires > 0 {
+				args = append(args, flagArg("response-ttl", want.Resp.Expires))
+			}
+		}
+	}
+	lists := []struct {
+		flag      string
+		cur, want jwt.StringList
+	}{
+		{"allow-pub", cur.Pub.Allow, want.Pub.Allow},
+		{"deny-pub", cur.Pub.Deny, want.Pub.Deny},
+		{"allow-sub", cur.Sub.Allow, want.Sub.Allow},
+		{"deny-sub", cur.Sub.Deny, want.Sub.Deny},
+	}
+	// --rm removes a subject from all the lists
+	var rm []string
+	for _, l := range lists {
+		_, r := diffStrings(l.cur, l.want)
+		for _, v := range r {
+			if !containsString(rm, v) {
+				rm = append(rm, v)
+			}
+		}
+	}
+	sort.Strings(rm)
+	conflict := false
+	var adds []string
+	for _, l := range lists {
+		for _, v := range l.want {
+			if !l.cur.Contains(v) || containsString(rm, v) {
+				adds = append(adds, flagArg(l.flag, v))
+				conflict = conflict || containsString(rm, v)
+			}
+		}
+	}
+	if conflict {
+		pre = append(pre, flagArgs("rm", rm))
+	} else {
+		args = append(args, flagArgs("rm", rm)...)
+	}
+	return pre, append(args, adds...)
+}
+
+// userLimitsArgs returns the flags that change the user permissions and limits cur to want
+func userLimitsArgs(cur jwt.UserPermissionLimits, want jwt.UserPermissionLimits) (pre [][]string, args []string) {
+	pre, args = permissionsArgs(cur.Permissions, want.Permissions)
+	if cur.Subs != want.Subs {
+		args = append(args, flagArg("subs", want.Subs))
+	}
+	if cur.Data != want.Data {
+		args = append(args, flagArg("data", want.Data))
+	}
+	if cur.Payload != want.Payload {
+		args = append(args, flagArg("payload", want.Payload))
+	}
+	if cur.BearerToken != want.BearerToken {
+		args = append(args, flagArg("bearer", want.BearerToken))
+	}
+	add, rm := diffStrings(cur.Src, want.Src)
+	args = append(args, flagArgs("source-network", add)...)
+	args = append(args, flagArgs("rm-source-network", rm)...)
+	add, rm = diffStrings(cur.AllowedConnectionTypes, want.AllowedConnectionTypes)
+	args = append(args, flagArgs("conn-type", add)...)
+	args = append(args, flagArgs("rm-conn-type", rm)...)
+	return pre, args
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+const testTopology = `
+operator:
+  name: O
+  service_urls: [nats://localhost:4222]
+accounts:
+- name: A
+  description: services
+  tags: [prod]
+  limits:
+    conns: 10
+    wildcard_exports: false
+  signing_keys:
+  - role: svc
+    template:
+      permissions:
+        pub_allow: [svc.>]
+      limits:
+        subs: 5
+  exports:
+  - subject: svc.q
+    type: service
+  - subject: events.a
+    type: stream
+  mappings:
+    foo:
+    - subject: bar
+      weight: 100
+  users:
+  - name: ua
+    signing_key: svc
+- name: B
+  imports:
+  - account: A
+    subject: svc.q
+    type: service
+  - account: A
+    subject: events.a
+    type: stream
+  users:
+  - name: ub
+    bearer: true
+    permissions:
+      sub_allow: [events.>]
+    source_networks: [192.168.1.0/24]
+`
+
+func writeTopology(t *testing.T, ts *TestStore, data string) string {
+	fp := filepath.Join(ts.Dir, "topology.yaml")
+	require.NoError(t, ioutil.WriteFile(fp, []byte(data), 0600))
+	return fp
+}
+
+func Test_ApplyCreatesTopology(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	fp := writeTopology(t, ts, testTopology)
+	_, stderr, err := ExecuteCmd(createApplyCmd(), "-f", fp)
+	require.NoError(t, err, stderr)
+	// the plan is printed before the changes are applied
+	plan := strings.Index(stderr, "plan - ")
+	require.True(t, plan >= 0, stderr)
+	require.True(t, plan < strings.Index(stderr, "apply `"), stderr)
+
+	oc, err := ts.Store.ReadOperatorClaim()
+	require.NoError(t, err)
+	require.Equal(t, []string{"nats://localhost:4222"}, []string(oc.OperatorServiceURLs))
+
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Equal(t, "services", ac.Description)
+	require.Equal(t, jwt.TagList{"prod"}, ac.Tags)
+	require.Equal(t, int64(10), ac.Limits.Conn)
+	require.False(t, ac.Limits.WildcardExports)
+	require.Len(t, ac.Exports, 2)
+	require.Len(t, ac.Mappings["foo"], 1)
+	require.Len(t, ac.SigningKeys, 1)
+	var sk string
+	for k, s := range ac.SigningKeys {
+		sk = k
+		us, ok := s.(*jwt.UserScope)
+		require.True(t, ok)
+		require.Equal(t, "svc", us.Role)
+		require.Equal(t, int64(5), us.Template.Subs)
+		require.True(t, us.Template.Pub.Allow.Contains("svc.>"))
+	}
+	require.True(t, ts.KeyStore.HasPrivateKey(sk))
+
+	uc, err := ts.Store.ReadUserClaim("A", "ua")
+	require.NoError(t, err)
+	require.Equal(t, sk, uc.Issuer)
+	require.Equal(t, ac.Subject, uc.IssuerAccount)
+
+	bc, err := ts.Store.ReadAccountClaim("B")
+	require.NoError(t, err)
+	require.Len(t, bc.Imports, 2)
+	for _, im := range bc.Imports {
+		require.Equal(t, ac.Subject, im.Account)
+	}
+
+	uc, err = ts.Store.ReadUserClaim("B", "ub")
+	require.NoError(t, err)
+	require.True(t, uc.BearerToken)
+	require.True(t, uc.Sub.Allow.Contains("events.>"))
+	require.Equal(t, jwt.CIDRList{"192.168.1.0/24"}, uc.Src)
+
+	// applying again finds nothing to do
+	_, stderr, err = ExecuteCmd(createApplyCmd(), "-f", fp)
+	require.NoError(t, err, stderr)
+	require.Contains(t, stderr, "the store matches the topology - nothing to do")
+}
+
+func Test_ApplyUpdates(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	_, _, err := ExecuteCmd(createEditAccount(), "--name", "A", "--conns", "5", "--allow-pub", "a.>", "--tag", "old")
+	require.NoError(t, err)
+	ts.AddUser(t, "A", "U")
+	_, _, err = ExecuteCmd(CreateEditUserCmd(), "--account", "A", "--name", "U", "--allow-pub", "x", "--payload", "100")
+	require.NoError(t, err)
+
+	fp := writeTopology(t, ts, `
+accounts:
+- name: A
+  tags: [new]
+  default_permissions:
+    sub_allow: [a.>]
+  users:
+  - name: U
+    permissions:
+      sub_allow: [x]
+`)
+	_, stderr, err := ExecuteCmd(createApplyCmd(), "-f", fp)
+	require.NoError(t, err, stderr)
+
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), ac.Limits.Conn)
+	require.Equal(t, jwt.TagList{"new"}, ac.Tags)
+	require.Empty(t, ac.DefaultPermissions.Pub.Allow)
+	require.True(t, ac.DefaultPermissions.Sub.Allow.Contains("a.>"))
+
+	uc, err := ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+	require.Empty(t, uc.Pub.Allow)
+	require.True(t, uc.Sub.Allow.Contains("x"))
+	require.Equal(t, int64(-1), uc.Limits.Payload)
+
+	_, stderr, err = ExecuteCmd(createApplyCmd(), "-f", fp)
+	require.NoError(t, err)
+	require.Contains(t, stderr, "nothing to do")
+}
+
+func Test_ApplyPrune(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	ts.AddUser(t, "A", "V")
+	ts.AddAccount(t, "B")
+
+	fp := writeTopology(t, ts, `
+accounts:
+- name: A
+  users:
+  - name: U
+`)
+	_, stderr, err := ExecuteCmd(createApplyCmd(), "-f", fp)
+	require.NoError(t, err)
+	require.Contains(t, stderr, `user "V" of account "A" is not in the topology - use --prune to delete it`)
+	require.Contains(t, stderr, `account "B" is not in the topology - use --prune to delete it`)
+	require.True(t, ts.Store.Has(store.Accounts, "B", store.JwtName("B")))
+
+	_, stderr, err = ExecuteCmd(createApplyCmd(), "-f", fp, "--prune")
+	require.NoError(t, err, stderr)
+	require.False(t, ts.Store.Has(store.Accounts, "B", store.JwtName("B")))
+	users, err := ts.Store.ListEntries(store.Accounts, "A", store.Users)
+	require.NoError(t, err)
+	require.Equal(t, []string{"U"}, users)
+}
+
+func Test_ApplyDryRun(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	fp := writeTopology(t, ts, testTopology)
+	_, stderr, err := ExecuteCmd(HoistRootFlags(createApplyCmd()), "-f", fp, "--dry-run")
+	require.NoError(t, err, stderr)
+	require.Contains(t, stderr, "nsc add account --name=A")
+	require.Contains(t, stderr, `would add account "A"`)
+	require.Contains(t, stderr, `would add user "ua"`)
+
+	accounts, err := ts.Store.ListSubContainers(store.Accounts)
+	require.NoError(t, err)
+	require.Empty(t, accounts)
+}
+
+func Test_ApplyInvalidTopology(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	_, _, err := ExecuteCmd(createApplyCmd())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "a topology file is required")
+
+	fp := writeTopology(t, ts, "accounts:\n- name: A\n  unknown: 1\n")
+	_, _, err = ExecuteCmd(createApplyCmd(), "-f", fp)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error parsing")
+
+	fp = writeTopology(t, ts, "accounts:\n- name: A\n- name: A\n")
+	_, _, err = ExecuteCmd(createApplyCmd(), "-f", fp)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid topology")
+
+	fp = writeTopology(t, ts, "operator:\n  name: X\n")
+	_, _, err = ExecuteCmd(createApplyCmd(), "-f", fp)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `the topology is for operator "X"`)
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// claim fields that are set when the merged claim is signed
+var signatureClaimFields = map[string]bool{"iat": true, "jti": true, "iss": true}
+
+// MergeConflict is a claim field changed differently on both sides
+type MergeConflict struct {
+	Path   string
+	Base   interface{}
+	Ours   interface{}
+	Theirs interface{}
+}
+
+func (c *MergeConflict) String() string {
+	return fmt.Sprintf("%s: base %s, ours %s, theirs %s", c.Path, mergeValue(c.Base), mergeValue(c.Ours), mergeValue(c.Theirs))
+}
+
+// absent marks a field or list element missing on one side of a merge
+type absent struct{}
+
+func isAbsent(v interface{}) bool {
+	_, ok := v.(absent)
+	return ok
+}
+
+func mergeValue(v interface{}) string {
+	if isAbsent(v) {
+		return "(absent)"
+	}
+	return jsonValue(v)
+}
+
+// MergeClaims does a three-way merge of the claims of JWTs. Fields and
+// list elements changed on one side only are taken from that side, lists of
+// exports, imports and other named entries are merged by element. Fields
+// set by signing the claim are taken from ours. An empty base is treated as
+// an empty claim.
+func MergeClaims(base []byte, ours []byte, theirs []byte) (map[string]interface{}, []*MergeConflict, error) {
+	var claims [3]map[string]interface{}
+	for i, t := range [][]byte{base, ours, theirs} {
+		var err error
+		if claims[i], err = claimPayload(t, true); err != nil {
+			return nil, nil, err
+		}
+	}
+	for k := range signatureClaimFields {
+		delete(claims[0], k)
+		delete(claims[2], k)
+	}
+	var conflicts []*MergeConflict
+	merged := merge3("", claims[0], claims[1], claims[2], &conflicts)
+	return merged.(map[string]interface{}), conflicts, nil
+}
+
+func merge3(path string, base, ours, theirs interface{}, conflicts *[]*MergeConflict) interface{} {
+	switch {
+	case reflect.DeepEqual(ours, theirs):
+		return ours
+	case reflect.DeepEqual(base, ours):
+		return theirs
+	case reflect.DeepEqual(base, theirs):
+		return ours
+	}
+	mb, _ := base.(map[string]interface{})
+	mo, oIsMap := ours.(map[string]interface{})
+	mt, tIsMap := theirs.(map[string]interface{})
+	if oIsMap && tIsMap {
+		return mergeMaps(path, mb, mo, mt, conflicts)
+	}
+	lo, oIsList := ours.([]interface{})
+	lt, tIsList := theirs.([]interface{})
+	if oIsList && tIsList {
+		lb, _ := base.([]interface{})
+		if merged, ok := mergeLists(path, lb, lo, lt, conflicts); ok {
+			return merged
+		}
+	}
+	if v, ok := resolveConflict(path, ours, theirs); ok {
+		return v
+	}
+	*conflicts = append(*conflicts, &MergeConflict{Path: path, Base: base, Ours: ours, Theirs: theirs})
+	return ours
+}
+
+// resolveConflict resolves conflicts that have an obvious resolution
+func resolveConflict(path string, ours, theirs interface{}) (interface{}, bool) {
+	// a key revoked at different times on both sides stays revoked
+	// with the later time
+	if strings.HasPrefix(path, "nats.revocations.") {
+		no, ook := ours.(json.Number)
+		nt, tok := theirs.(json.Number)
+		if ook && tok {
+			if a, err := no.Int64(); err == nil {
+				if b, err := nt.Int64(); err == nil && b > a {
+					return theirs, true
+				}
+				return ours, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func mergeMaps(path string, base, ours, theirs map[string]interface{}, conflicts *[]*MergeConflict) map[string]interface{} {
+	keys := make(map[string]bool)
+	for _, m := range []map[string]interface{}{base, ours, theirs} {
+		for k := range m {
+			keys[k] = true
+		}
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	merged := make(map[string]interface{})
+	for _, k := range sorted {
+		if v := merge3(joinPath(path, k), field(base, k), field(ours, k), field(theirs, k), conflicts); !isAbsent(v) {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func field(m map[string]interface{}, k string) interface{} {
+	if v, ok := m[k]; ok {
+		return v
+	}
+	return absent{}
+}
+
+// mergeLists merges lists of named entries by element and lists of values
+// as sets. It returns false if the lists can't be merged by element.
+func mergeLists(path string, base, ours, theirs []interface{}, conflicts *[]*MergeConflict) ([]interface{}, bool) {
+	kb, bok := mergeKeys(base)
+	ko, ook := mergeKeys(ours)
+	kt, tok := mergeKeys(theirs)
+	if !bok || !ook || !tok {
+		return nil, false
+	}
+	// ours order, followed by the elements added by theirs
+	var order []string
+	seen := make(map[string]bool)
+	for _, l := range [][]string{ko.order, kt.order} {
+		for _, k := range l {
+			if !seen[k] {
+				seen[k] = true
+				order = append(order, k)
+			}
+		}
+	}
+	merged := []interface{}{}
+	for _, k := range order {
+		v := merge3(fmt.Sprintf("%s[%s]", path, k), kb.element(k), ko.element(k), kt.element(k), conflicts)
+		if !isAbsent(v) {
+			merged = append(merged, v)
+		}
+	}
+	return merged, true
+}
+
+type keyedElements struct {
+	order    []string
+	elements map[string]interface{}
+}
+
+func (e *keyedElements) element(k string) interface{} {
+	if v, ok := e.elements[k]; ok {
+		return v
+	}
+	return absent{}
+}
+
+// mergeKeys keys the elements of a list by their name or, for values, by
+// their value
+func mergeKeys(l []interface{}) (*keyedElements, bool) {
+	e := &keyedElements{elements: make(map[string]interface{})}
+	for _, v := range l {
+		k, ok := listKey(v)
+		if !ok {
+			if _, isMap := v.(map[string]interface{}); isMap {
+				return nil, false
+			}
+			if _, isList := v.([]interface{}); isList {
+				return nil, false
+			}
+			k = jsonValue(v)
+		}
+		if _, dupe := e.elements[k]; dupe {
+			return nil, false
+		}
+		e.elements[k] = v
+		e.order = append(e.order, k)
+	}
+	return e, true
+}
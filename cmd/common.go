@@ -85,33 +85,35 @@ func WriteJson(fp string, v interface{}) error {
 		return fmt.Errorf("error marshaling: %v", err)
 	}
 
-	if err := ioutil.WriteFile(fp, data, 0600); err != nil {
+	if err := store.WriteFile(fp, data); err != nil {
 		return fmt.Errorf("error writing %#q: %v", fp, err)
 	}
 
 	return nil
 }
 
+// Write writes the data to stdout or to a new file, while a dry-run
+// is active the file is captured
 func Write(fp string, data []byte) error {
-	var err error
-	var f *os.File
-
-	f, err = GetOutput(fp)
+	if IsStdOut(fp) {
+		if _, err := os.Stdout.Write(data); err != nil {
+			return fmt.Errorf("error writing %#q: %v", fp, err)
+		}
+		return nil
+	}
+	afp, err := filepath.Abs(fp)
 	if err != nil {
-		return err
+		return fmt.Errorf("error calculating abs %#q: %v", fp, err)
 	}
-	if !IsStdOut(fp) {
-		defer f.Close()
+	_, err = store.Stat(afp)
+	if err == nil {
+		return fmt.Errorf("%#q already exists", afp)
 	}
-	_, err = f.Write(data)
-	if err != nil {
-		return fmt.Errorf("error writing %#q: %v", fp, err)
+	if !os.IsNotExist(err) {
+		return err
 	}
-
-	if !IsStdOut(fp) {
-		if err := f.Sync(); err != nil {
-			return err
-		}
+	if err := store.WriteFile(afp, data); err != nil {
+		return fmt.Errorf("error writing %#q: %v", fp, err)
 	}
 	return nil
 }
@@ -330,9 +332,9 @@ func IsValidDir(dir string) error {
 }
 
 func MaybeMakeDir(dir string) error {
-	fi, err := os.Stat(dir)
+	fi, err := store.Stat(dir)
 	if err != nil && os.IsNotExist(err) {
-		if err := os.MkdirAll(dir, 0700); err != nil {
+		if err := store.MkdirAll(dir); err != nil {
 			return fmt.Errorf("error creating %#q: %v", dir, err)
 		}
 	} else if err != nil {
@@ -38,6 +38,7 @@ const NscNoGitIgnoreEnv = "NSC_NO_GIT_IGNORE"
 const NscRootCasNatsEnv = "NATS_CA"
 const NscTlsKeyNatsEnv = "NATS_KEY"
 const NscTlsCertNatsEnv = "NATS_CERT"
+const NscLockTimeoutEnv = "NSC_LOCK_TIMEOUT"
 
 type ToolConfig struct {
 	ContextConfig
@@ -195,7 +195,7 @@ func (p *DeleteAccountParams) Run(ctx ActionCtx) (store.Status, error) {
 			if _, err := os.Stat(fp); os.IsNotExist(err) {
 				ru.AddOK("creds file is not stored")
 			} else {
-				if err := os.Remove(fp); err != nil {
+				if err := store.RemoveFile(fp); err != nil {
 					ru.AddError("error deleting creds file %s: %v", fp, err)
 				} else {
 					ru.AddOK("removed creds file")
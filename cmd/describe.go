@@ -76,6 +76,12 @@ func init() {
 	describeCmd.PersistentFlags().StringVarP(&JsonPath, "field", "F", "", "extract value from specified field using json structure")
 }
 
+// describeAsJson returns true if the JWT body should be displayed as JSON,
+// an explicit --raw takes precedence over --output json
+func describeAsJson() bool {
+	return Json || (IsJsonOutput() && !Raw)
+}
+
 func bodyAsJson(data []byte) ([]byte, error) {
 	chunks := bytes.Split(data, []byte{'.'})
 	if len(chunks) != 3 {
@@ -64,12 +64,12 @@ func (p *DescribeAccountParams) Load(ctx ActionCtx) error {
 	if err = p.AccountContextParams.Validate(ctx); err != nil {
 		return err
 	}
-	if Json || Raw || JsonPath != "" {
+	if describeAsJson() || Raw || JsonPath != "" {
 		p.raw, err = ctx.StoreCtx().Store.ReadRawAccountClaim(p.AccountContextParams.Name)
 		if err != nil {
 			return err
 		}
-		if Json || JsonPath != "" {
+		if describeAsJson() || JsonPath != "" {
 			p.raw, err = bodyAsJson(p.raw)
 			if err != nil {
 				return err
@@ -101,7 +101,7 @@ func (p *DescribeAccountParams) PostInteractive(_ ActionCtx) error {
 }
 
 func (p *DescribeAccountParams) Run(_ ActionCtx) (store.Status, error) {
-	if Raw || Json || JsonPath != "" {
+	if Raw || describeAsJson() || JsonPath != "" {
 		if !IsStdOut(p.outputFile) {
 			var err error
 			p.raw, err = jwt.DecorateJWT(string(p.raw))
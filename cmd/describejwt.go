@@ -98,7 +98,7 @@ func (p *DescribeFile) Validate(ctx ActionCtx) error {
 func (p *DescribeFile) handleRaw() (store.Status, error) {
 	var err error
 	var raw []byte
-	if Json || JsonPath != "" {
+	if describeAsJson() || JsonPath != "" {
 		raw, err = bodyAsJson([]byte(p.token))
 		if err != nil {
 			return nil, err
@@ -126,7 +126,7 @@ func (p *DescribeFile) handleRaw() (store.Status, error) {
 }
 
 func (p *DescribeFile) Run(ctx ActionCtx) (store.Status, error) {
-	if Json || Raw || JsonPath != "" {
+	if describeAsJson() || Raw || JsonPath != "" {
 		return p.handleRaw()
 	}
 
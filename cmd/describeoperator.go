@@ -82,12 +82,12 @@ func (p *DescribeOperatorParams) PreInteractive(_ ActionCtx) error {
 
 func (p *DescribeOperatorParams) Load(ctx ActionCtx) error {
 	var err error
-	if Json || Raw || JsonPath != "" {
+	if describeAsJson() || Raw || JsonPath != "" {
 		p.raw, err = ctx.StoreCtx().Store.ReadRawOperatorClaim()
 		if err != nil {
 			return err
 		}
-		if Json || JsonPath != "" {
+		if describeAsJson() || JsonPath != "" {
 			p.raw, err = bodyAsJson(p.raw)
 			if err != nil {
 				return err
@@ -119,7 +119,7 @@ func (p *DescribeOperatorParams) PostInteractive(_ ActionCtx) error {
 }
 
 func (p *DescribeOperatorParams) Run(_ ActionCtx) (store.Status, error) {
-	if Raw || Json || JsonPath != "" {
+	if Raw || describeAsJson() || JsonPath != "" {
 		if !IsStdOut(p.outputFile) {
 			var err error
 			p.raw, err = jwt.DecorateJWT(string(p.raw))
@@ -59,7 +59,11 @@ func (a *AccountDescriber) Describe() string {
 	}
 
 	if len(a.SigningKeys) > 0 {
-		AddListValues(table, "Signing Keys", a.SigningKeys.Keys())
+		var keys []string
+		for _, k := range a.SigningKeys.Keys() {
+			keys = append(keys, describeKey(k))
+		}
+		AddListValues(table, "Signing Keys", keys)
 		table.AddSeparator()
 	}
 
@@ -272,7 +276,7 @@ func (s *ScopedSkDescriber) Describe() string {
 	buf.WriteString("\n")
 	table := tablewriter.CreateTable()
 	table.AddTitle("Scoped Signing Key - Details")
-	table.AddRow("Key", s.Key)
+	table.AddRow("Key", describeKey(s.Key))
 	table.AddRow("role", s.Role)
 	AddPermissions(table, s.Template.Permissions)
 	AddLimits(table, s.Template.Limits)
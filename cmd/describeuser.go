@@ -92,12 +92,12 @@ func (p *DescribeUserParams) Load(ctx ActionCtx) error {
 		return fmt.Errorf("user is required")
 	}
 
-	if Json || Raw || JsonPath != "" {
+	if describeAsJson() || Raw || JsonPath != "" {
 		p.raw, err = ctx.StoreCtx().Store.ReadRawUserClaim(p.AccountContextParams.Name, p.user)
 		if err != nil {
 			return err
 		}
-		if Json || JsonPath != "" {
+		if describeAsJson() || JsonPath != "" {
 			p.raw, err = bodyAsJson(p.raw)
 			if err != nil {
 				return err
@@ -128,7 +128,7 @@ func (p *DescribeUserParams) PostInteractive(_ ActionCtx) error {
 }
 
 func (p *DescribeUserParams) Run(ctx ActionCtx) (store.Status, error) {
-	if Raw || Json || JsonPath != "" {
+	if Raw || describeAsJson() || JsonPath != "" {
 		if !IsStdOut(p.outputFile) {
 			var err error
 			p.raw, err = jwt.DecorateJWT(string(p.raw))
@@ -0,0 +1,503 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createDoctorCmd() *cobra.Command {
+	var params DoctorParams
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the structure of the current operator's store",
+		Long: `Check the structure of the current operator's store
+
+'nsc validate' checks the claims of JWTs, doctor checks that the files of
+the store agree with each other:
+- the .nsc file has the current version and kind
+- the operator JWT is named after the operator
+- account directories and user files are named after their claims
+- account JWTs are issued by the operator or its signing keys
+- users are issued by the account they are stored under
+- no account public key is stored under two names
+- user creds hold the user JWT that is in the store
+
+Every problem is reported with its fix, --fix applies them. Directories
+and files are renamed after their claims, JWTs with the wrong issuer are
+signed again with keys from the keystore, creds are regenerated and
+duplicated accounts are merged into the one named after its claim.`,
+		Example: `nsc doctor
+nsc doctor --fix`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().BoolVarP(&params.fix, "fix", "", false, "fix the problems found")
+	return cmd
+}
+
+func init() {
+	GetRootCmd().AddCommand(createDoctorCmd())
+}
+
+type DoctorParams struct {
+	fix      bool
+	problems int
+	fixes    int
+	operator *jwt.OperatorClaims
+	// account directory names, and their claims if they could be read
+	accounts []string
+	claims   map[string]*jwt.AccountClaims
+}
+
+func (p *DoctorParams) SetDefaults(ctx ActionCtx) error {
+	p.claims = make(map[string]*jwt.AccountClaims)
+	return nil
+}
+
+func (p *DoctorParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *DoctorParams) Load(ctx ActionCtx) error {
+	var err error
+	s := ctx.StoreCtx().Store
+	if p.operator, err = s.ReadOperatorClaim(); err != nil {
+		return err
+	}
+	if p.accounts, err = s.ListSubContainers(store.Accounts); err != nil {
+		return err
+	}
+	sort.Strings(p.accounts)
+	for _, a := range p.accounts {
+		if ac, err := s.ReadAccountClaim(a); err == nil {
+			p.claims[a] = ac
+		}
+	}
+	return nil
+}
+
+func (p *DoctorParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *DoctorParams) Validate(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *DoctorParams) problem(r *store.Report, format string, args ...interface{}) {
+	p.problems++
+	r.AddError(format, args...)
+}
+
+// repair reports a problem with its fix, or applies the fix when --fix is set
+func (p *DoctorParams) repair(r *store.Report, fix string, fn func() error, format string, args ...interface{}) {
+	m := fmt.Sprintf(format, args...)
+	if !p.fix {
+		p.problem(r, "%s - fix: %s", m, fix)
+		return
+	}
+	if err := fn(); err != nil {
+		p.problem(r, "%s - unable to %s: %v", m, fix, err)
+		return
+	}
+	p.fixes++
+	r.AddOK("%s - fixed: %s", m, fix)
+}
+
+// signer returns the key pair of the first of the keys the keystore can sign with
+func (p *DoctorParams) signer(ctx ActionCtx, keys ...string) (nkeys.KeyPair, error) {
+	ks := ctx.StoreCtx().KeyStore
+	for _, pk := range keys {
+		if pk != "" && ks.CanSign(pk) {
+			return ks.GetKeyPair(pk)
+		}
+	}
+	return nil, fmt.Errorf("none of the keys %s is in the keystore", strings.Join(keys, ", "))
+}
+
+// operatorSigners returns the keys that can issue accounts, preferred first
+func (p *DoctorParams) operatorSigners() []string {
+	keys := []string{p.operator.Subject}
+	if p.operator.StrictSigningKeyUsage {
+		keys = nil
+	}
+	return append(keys, p.operator.SigningKeys...)
+}
+
+// isPlainName returns true for names that can be used as a file name
+func isPlainName(n string) bool {
+	return n != "" && n != "." && n != ".." && n == filepath.Base(n) && !strings.ContainsAny(n, `/\`)
+}
+
+func (p *DoctorParams) checkInfo(ctx ActionCtx, r *store.Report) {
+	s := ctx.StoreCtx().Store
+	info := s.Info
+	if info.Version == store.Version && info.Kind == jwt.OperatorClaim {
+		return
+	}
+	p.repair(r, fmt.Sprintf("update it to version %s", store.Version), func() error {
+		info.Version = store.Version
+		info.Kind = jwt.OperatorClaim
+		d, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		if err := s.Write(d, store.NSCFile); err != nil {
+			return err
+		}
+		s.Info = info
+		return nil
+	}, "%s has version %q and kind %q", store.NSCFile, info.Version, info.Kind)
+}
+
+func (p *DoctorParams) checkOperatorName(ctx ActionCtx, r *store.Report) {
+	s := ctx.StoreCtx().Store
+	oc := p.operator
+	if oc.Name == s.GetName() {
+		return
+	}
+	// the store name is used by the context and the keystore, the claim is issued again
+	p.repair(r, fmt.Sprintf("issue the operator JWT with the name %q", s.GetName()), func() error {
+		kp, err := p.signer(ctx, oc.Subject)
+		if err != nil {
+			return err
+		}
+		oc.Name = s.GetName()
+		token, err := oc.Encode(kp)
+		if err != nil {
+			return err
+		}
+		return s.Write([]byte(token), store.JwtName(s.GetName()))
+	}, "operator %q has the JWT name %q", s.GetName(), oc.Name)
+}
+
+// checkDuplicates reports accounts stored under more than one name. It
+// returns the directories that were merged into another one.
+func (p *DoctorParams) checkDuplicates(ctx ActionCtx, r *store.Report) map[string]bool {
+	merged := make(map[string]bool)
+	bySubject := make(map[string][]string)
+	var subjects []string
+	for _, a := range p.accounts {
+		if ac, ok := p.claims[a]; ok {
+			if len(bySubject[ac.Subject]) == 0 {
+				subjects = append(subjects, ac.Subject)
+			}
+			bySubject[ac.Subject] = append(bySubject[ac.Subject], a)
+		}
+	}
+	for _, pk := range subjects {
+		dirs := bySubject[pk]
+		if len(dirs) < 2 {
+			continue
+		}
+		// keep the account named after its claim, then the newest
+		sort.SliceStable(dirs, func(i, j int) bool {
+			a, b := p.claims[dirs[i]], p.claims[dirs[j]]
+			if (a.Name == dirs[i]) != (b.Name == dirs[j]) {
+				return a.Name == dirs[i]
+			}
+			return a.IssuedAt > b.IssuedAt
+		})
+		keep, others := dirs[0], dirs[1:]
+		p.repair(r, fmt.Sprintf("merge the users of %s into %q and remove them", quoteNames(others), keep), func() error {
+			for _, o := range others {
+				if err := p.mergeAccount(ctx, o, keep); err != nil {
+					return err
+				}
+				merged[o] = true
+			}
+			return nil
+		}, "accounts %s have the same public key %s", quoteNames(dirs), pk)
+	}
+	return merged
+}
+
+func quoteNames(names []string) string {
+	var q []string
+	for _, n := range names {
+		q = append(q, fmt.Sprintf("%q", n))
+	}
+	return strings.Join(q, ", ")
+}
+
+// mergeAccount moves the users the account to keep doesn't have and removes the account
+func (p *DoctorParams) mergeAccount(ctx ActionCtx, from string, to string) error {
+	s := ctx.StoreCtx().Store
+	ks := ctx.StoreCtx().KeyStore
+	users, err := s.ListEntries(store.Accounts, from, store.Users)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		d, err := s.ReadRawUserClaim(from, u)
+		if err != nil {
+			return err
+		}
+		if existing, err := s.ReadRawUserClaim(to, u); err == nil {
+			if !bytes.Equal(bytes.TrimSpace(existing), bytes.TrimSpace(d)) {
+				return fmt.Errorf("user %q is in both accounts with different JWTs", u)
+			}
+			continue
+		}
+		if err := s.Write(d, store.Accounts, to, store.Users, store.JwtName(u)); err != nil {
+			return err
+		}
+		if err := moveCreds(ks.GetUserCredsPath(from, u), ks.CalcUserCredsPath(to, u)); err != nil {
+			return err
+		}
+	}
+	return p.removeAccount(ctx, from)
+}
+
+// removeAccount deletes the JWTs and the directories of the account
+func (p *DoctorParams) removeAccount(ctx ActionCtx, name string) error {
+	s := ctx.StoreCtx().Store
+	users, err := s.ListEntries(store.Accounts, name, store.Users)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err := s.Delete(store.Accounts, name, store.Users, store.JwtName(u)); err != nil {
+			return err
+		}
+	}
+	if err := s.Delete(store.Accounts, name, store.JwtName(name)); err != nil {
+		return err
+	}
+	if s.Has(store.Accounts, name, store.Users) {
+		if err := s.Delete(store.Accounts, name, store.Users); err != nil {
+			return err
+		}
+	}
+	return s.Delete(store.Accounts, name)
+}
+
+// moveCreds moves a creds file if it exists and the target doesn't
+func moveCreds(from string, to string) error {
+	if from == "" {
+		return nil
+	}
+	if _, err := store.Stat(to); err == nil {
+		return nil
+	}
+	d, err := store.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	if err := store.WriteFile(to, d); err != nil {
+		return err
+	}
+	return store.RemoveFile(from)
+}
+
+func (p *DoctorParams) checkAccount(ctx ActionCtx, a string, r *store.Report) {
+	s := ctx.StoreCtx().Store
+	ac, ok := p.claims[a]
+	if !ok {
+		p.problem(r, "account %q has an unreadable JWT", a)
+		return
+	}
+	if !p.operator.DidSign(ac) {
+		p.repair(r, "sign it with the operator", func() error {
+			kp, err := p.signer(ctx, p.operatorSigners()...)
+			if err != nil {
+				return err
+			}
+			token, err := ac.Encode(kp)
+			if err != nil {
+				return err
+			}
+			return s.Write([]byte(token), store.Accounts, a, store.JwtName(a))
+		}, "account %q is issued by %s, which is not operator %q or one of its signing keys", a, ac.Issuer, p.operator.Name)
+	}
+
+	users, err := s.ListEntries(store.Accounts, a, store.Users)
+	if err != nil {
+		p.problem(r, "unable to list the users of account %q: %v", a, err)
+		return
+	}
+	for _, u := range users {
+		p.checkUser(ctx, a, ac, u, r)
+	}
+
+	if ac.Name == a || !isPlainName(ac.Name) {
+		return
+	}
+	if s.Has(store.Accounts, ac.Name) {
+		p.problem(r, "account directory %q holds account %q, which is also a directory", a, ac.Name)
+		return
+	}
+	p.repair(r, fmt.Sprintf("rename it to %q", ac.Name), func() error {
+		return p.renameAccount(ctx, a, ac.Name)
+	}, "account directory %q holds account %q", a, ac.Name)
+}
+
+// renameAccount moves the account, its users and creds to the new name
+func (p *DoctorParams) renameAccount(ctx ActionCtx, from string, to string) error {
+	s := ctx.StoreCtx().Store
+	ks := ctx.StoreCtx().KeyStore
+	d, err := s.ReadRawAccountClaim(from)
+	if err != nil {
+		return err
+	}
+	if err := s.Write(d, store.Accounts, to, store.JwtName(to)); err != nil {
+		return err
+	}
+	users, err := s.ListEntries(store.Accounts, from, store.Users)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		d, err := s.ReadRawUserClaim(from, u)
+		if err != nil {
+			return err
+		}
+		if err := s.Write(d, store.Accounts, to, store.Users, store.JwtName(u)); err != nil {
+			return err
+		}
+	}
+	fp := ks.CalcAccountCredsDir(from)
+	if _, err := store.Stat(fp); err == nil {
+		if err := store.RenameDir(fp, ks.CalcAccountCredsDir(to)); err != nil {
+			return err
+		}
+	}
+	return p.removeAccount(ctx, from)
+}
+
+// checkUser checks the issuer, creds and name of the user
+func (p *DoctorParams) checkUser(ctx ActionCtx, a string, ac *jwt.AccountClaims, u string, r *store.Report) {
+	s := ctx.StoreCtx().Store
+	ks := ctx.StoreCtx().KeyStore
+	uc, err := s.ReadUserClaim(a, u)
+	if err != nil {
+		p.problem(r, "user %q in account %q has an unreadable JWT", u, a)
+		return
+	}
+
+	issuedBy := uc.IssuerAccount
+	if issuedBy == "" {
+		issuedBy = uc.Issuer
+	}
+	if !ac.DidSign(uc) || issuedBy != ac.Subject {
+		p.repair(r, fmt.Sprintf("sign it with account %q", a), func() error {
+			// scoped signing keys can't sign users with their own permissions
+			keys := []string{ac.Subject}
+			for _, k := range ac.SigningKeys.Keys() {
+				if scope, _ := ac.SigningKeys.GetScope(k); scope == nil {
+					keys = append(keys, k)
+				}
+			}
+			kp, err := p.signer(ctx, keys...)
+			if err != nil {
+				return err
+			}
+			pk, _ := kp.PublicKey()
+			uc.IssuerAccount = ""
+			if pk != ac.Subject {
+				uc.IssuerAccount = ac.Subject
+			}
+			token, err := uc.Encode(kp)
+			if err != nil {
+				return err
+			}
+			return s.Write([]byte(token), store.Accounts, a, store.Users, store.JwtName(u))
+		}, "user %q in account %q is issued for account %s", u, a, issuedBy)
+	}
+
+	if fp := ks.GetUserCredsPath(a, u); fp != "" {
+		stored, _ := s.ReadRawUserClaim(a, u)
+		d, err := store.ReadFile(fp)
+		if err == nil {
+			token, err := jwt.ParseDecoratedJWT(d)
+			if err != nil || token != string(bytes.TrimSpace(stored)) {
+				p.repair(r, "generate it again", func() error {
+					cr := store.NewDetailedReport(true)
+					if !regenerateUserCreds(ctx, a, u, uc.Subject, cr) {
+						return errors.New("the user's seed is not in the keystore")
+					}
+					if cr.HasErrors() {
+						return errors.New(strings.TrimSpace(cr.Message()))
+					}
+					return nil
+				}, "creds %#q don't hold the JWT of user %q in account %q", AbbrevHomePaths(fp), u, a)
+			}
+		}
+	}
+
+	if uc.Name == u || !isPlainName(uc.Name) {
+		return
+	}
+	if s.Has(store.Accounts, a, store.Users, store.JwtName(uc.Name)) {
+		p.problem(r, "user file %q in account %q holds user %q, which is also a user file", u, a, uc.Name)
+		return
+	}
+	p.repair(r, fmt.Sprintf("rename it to %q", uc.Name), func() error {
+		d, err := s.ReadRawUserClaim(a, u)
+		if err != nil {
+			return err
+		}
+		if err := s.Write(d, store.Accounts, a, store.Users, store.JwtName(uc.Name)); err != nil {
+			return err
+		}
+		if err := s.Delete(store.Accounts, a, store.Users, store.JwtName(u)); err != nil {
+			return err
+		}
+		return moveCreds(ks.GetUserCredsPath(a, u), ks.CalcUserCredsPath(a, uc.Name))
+	}, "user file %q in account %q holds user %q", u, a, uc.Name)
+}
+
+func (p *DoctorParams) Run(ctx ActionCtx) (store.Status, error) {
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	s := ctx.StoreCtx().Store
+
+	p.checkInfo(ctx, r)
+	p.checkOperatorName(ctx, r)
+	merged := p.checkDuplicates(ctx, r)
+	for _, a := range p.accounts {
+		if !merged[a] {
+			p.checkAccount(ctx, a, r)
+		}
+	}
+
+	if p.problems > 0 {
+		r.AddError("checked operator %q and %d account(s) - found %d problem(s)", s.GetName(), len(p.accounts), p.problems)
+		return r, fmt.Errorf("operator %q has %d problem(s)", s.GetName(), p.problems)
+	}
+	if p.fixes > 0 {
+		r.AddOK("checked operator %q and %d account(s) - fixed %d problem(s)", s.GetName(), len(p.accounts), p.fixes)
+		return r, nil
+	}
+	r.AddOK("checked operator %q and %d account(s)", s.GetName(), len(p.accounts))
+	return r, nil
+}
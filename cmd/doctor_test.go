@@ -0,0 +1,176 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func Test_DoctorClean(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+
+	_, stderr, err := ExecuteCmd(createDoctorCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, "checked operator \"O\" and 1 account(s)")
+}
+
+func Test_DoctorStaleInfoAndNames(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+
+	require.NoError(t, ts.Store.Write([]byte(`{"name":"O","kind":"operator","version":"0"}`), store.NSCFile))
+	// the account and the user are moved to names that disagree with their claims
+	accounts := filepath.Join(ts.Store.Dir, store.Accounts)
+	require.NoError(t, os.Rename(filepath.Join(accounts, "A"), filepath.Join(accounts, "B")))
+	require.NoError(t, os.Rename(filepath.Join(accounts, "B", "A.jwt"), filepath.Join(accounts, "B", "B.jwt")))
+	require.NoError(t, os.Rename(filepath.Join(accounts, "B", store.Users, "U.jwt"), filepath.Join(accounts, "B", store.Users, "V.jwt")))
+
+	_, stderr, err := ExecuteCmd(createDoctorCmd())
+	require.Error(t, err)
+	require.Contains(t, stderr, ".nsc has version \"0\"")
+	require.Contains(t, stderr, "account directory \"B\" holds account \"A\" - fix: rename it to \"A\"")
+	require.Contains(t, stderr, "user file \"V\" in account \"B\" holds user \"U\"")
+	require.Contains(t, stderr, "found 3 problem(s)")
+
+	_, stderr, err = ExecuteCmd(createDoctorCmd(), "--fix")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "fixed 3 problem(s)")
+
+	s, err := store.LoadStore(ts.Store.Dir)
+	require.NoError(t, err)
+	require.Equal(t, store.Version, s.Info.Version)
+	require.True(t, s.HasAccount("A"))
+	require.False(t, s.Has(store.Accounts, "B"))
+	_, err = s.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+
+	_, _, err = ExecuteCmd(createDoctorCmd())
+	require.NoError(t, err)
+}
+
+func Test_DoctorIssuers(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	ts.AddAccount(t, "B")
+
+	// account A issued by another operator
+	_, _, okp := CreateOperatorKey(t)
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	token, err := ac.Encode(okp)
+	require.NoError(t, err)
+	require.NoError(t, ts.Store.Write([]byte(token), store.Accounts, "A", "A.jwt"))
+
+	// user U of account A issued by account B
+	uc, err := ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+	token, err = uc.Encode(ts.GetAccountKey(t, "B"))
+	require.NoError(t, err)
+	require.NoError(t, ts.Store.Write([]byte(token), store.Accounts, "A", store.Users, "U.jwt"))
+
+	_, stderr, err := ExecuteCmd(createDoctorCmd())
+	require.Error(t, err)
+	require.Contains(t, stderr, "account \"A\" is issued by")
+	require.Contains(t, stderr, "user \"U\" in account \"A\" is issued for account "+ts.GetAccountPublicKey(t, "B"))
+	require.Contains(t, stderr, "don't hold the JWT of user \"U\"")
+
+	_, _, err = ExecuteCmd(createDoctorCmd(), "--fix")
+	require.NoError(t, err)
+
+	oc, err := ts.Store.ReadOperatorClaim()
+	require.NoError(t, err)
+	ac, err = ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.True(t, oc.DidSign(ac))
+	uc, err = ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+	require.Equal(t, ac.Subject, uc.Issuer)
+
+	d, err := ioutil.ReadFile(ts.KeyStore.CalcUserCredsPath("A", "U"))
+	require.NoError(t, err)
+	creds, err := jwt.ParseDecoratedJWT(d)
+	require.NoError(t, err)
+	raw, err := ts.Store.ReadRawUserClaim("A", "U")
+	require.NoError(t, err)
+	require.Equal(t, string(raw), creds)
+}
+
+func Test_DoctorDuplicateAccounts(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+
+	// a copy of account A with a user of its own
+	ac, err := ts.Store.ReadRawAccountClaim("A")
+	require.NoError(t, err)
+	require.NoError(t, ts.Store.Write(ac, store.Accounts, "C", "C.jwt"))
+	_, _, ukp := CreateUserKey(t)
+	upk, err := ukp.PublicKey()
+	require.NoError(t, err)
+	uc := jwt.NewUserClaims(upk)
+	uc.Name = "W"
+	token, err := uc.Encode(ts.GetAccountKey(t, "A"))
+	require.NoError(t, err)
+	require.NoError(t, ts.Store.Write([]byte(token), store.Accounts, "C", store.Users, "W.jwt"))
+
+	_, stderr, err := ExecuteCmd(createDoctorCmd())
+	require.Error(t, err)
+	require.Contains(t, stderr, "accounts \"A\", \"C\" have the same public key")
+
+	_, _, err = ExecuteCmd(createDoctorCmd(), "--fix")
+	require.NoError(t, err)
+	require.False(t, ts.Store.Has(store.Accounts, "C"))
+	_, err = ts.Store.ReadUserClaim("A", "W")
+	require.NoError(t, err)
+	_, err = ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+}
+
+func Test_DoctorFixNeedsKeys(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	_, _, okp := CreateOperatorKey(t)
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	token, err := ac.Encode(okp)
+	require.NoError(t, err)
+	require.NoError(t, ts.Store.Write([]byte(token), store.Accounts, "A", "A.jwt"))
+	opk, err := ts.OperatorKey.PublicKey()
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(ts.KeyStore.GetKeyPath(opk)))
+
+	_, stderr, err := ExecuteCmd(createDoctorCmd(), "--fix")
+	require.Error(t, err)
+	require.Contains(t, stderr, "unable to sign it with the operator")
+}
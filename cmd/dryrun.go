@@ -0,0 +1,316 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kbehouse/nsc/cmd/store"
+	"github.com/nats-io/jwt/v2"
+)
+
+// DryRunFlag captures the store and keystore writes of an action instead of committing them
+var DryRunFlag bool
+
+// claim fields that change every time a JWT is encoded
+var volatileClaimFields = map[string]bool{"iat": true, "jti": true}
+
+func dryRunReport(dr *store.DryRun) *store.Report {
+	r := store.NewReport(store.OK, "dry-run - no changes were written")
+	changes := dr.Changes()
+	if len(changes) == 0 {
+		r.AddOK("no files would change")
+		return r
+	}
+	for _, c := range changes {
+		// key metadata changes with its key
+		if store.IsKeyMetadata(c.Path) {
+			continue
+		}
+		r.Add(describeFileChange(c))
+	}
+	return r
+}
+
+func describeFileChange(c *store.FileChange) store.Status {
+	fp := AbbrevHomePaths(c.Path)
+	if c.Mode != 0 {
+		return store.NewReport(store.OK, "would change the mode of %#q to %#o", fp, c.Mode)
+	}
+	if store.IsQuarantined(c.Path) {
+		if c.Deleted {
+			return store.NewReport(store.OK, "would remove %#q from the quarantine", fp)
+		}
+		return store.NewReport(store.OK, "would quarantine %#q", fp)
+	}
+	switch filepath.Ext(c.Path) {
+	case ".jwt":
+		return describeJwtChange(c)
+	case store.NKeyExtension:
+		pk := strings.TrimSuffix(filepath.Base(c.Path), store.NKeyExtension)
+		if c.Deleted {
+			return store.NewReport(store.OK, "would remove key %s", pk)
+		}
+		return store.NewReport(store.OK, "would store key %s - the generated key was discarded", pk)
+	case store.CredsExtension:
+		if c.Deleted {
+			return store.NewReport(store.OK, "would remove creds %#q", fp)
+		}
+		return store.NewReport(store.OK, "would write creds %#q", fp)
+	default:
+		if c.Deleted {
+			return store.NewReport(store.OK, "would remove %#q", fp)
+		}
+		return store.NewReport(store.OK, "would write %#q", fp)
+	}
+}
+
+func describeJwtChange(c *store.FileChange) store.Status {
+	token := c.Data
+	if c.Deleted {
+		token = c.Original
+	}
+	gc, err := jwt.DecodeGeneric(string(token))
+	if err != nil {
+		return store.ErrorStatus("unable to decode %#q: %v", AbbrevHomePaths(c.Path), err)
+	}
+	kind := string(gc.ClaimType())
+	if c.Deleted {
+		return store.NewReport(store.OK, "would delete %s %q", kind, gc.Name)
+	}
+	action := "update"
+	if c.IsNew() {
+		action = "add"
+	}
+	r := store.NewReport(store.OK, "would %s %s %q", action, kind, gc.Name)
+	lines, err := DiffClaims(c.Original, c.Data)
+	if err != nil {
+		r.AddError("unable to compare %#q: %v", AbbrevHomePaths(c.Path), err)
+		return r
+	}
+	for _, l := range lines {
+		r.Add(store.NewServerMessage(l))
+	}
+	return r
+}
+
+// DiffClaims returns the differences between the claims of two JWTs,
+// one change per line. Lines start with + for added values, - for removed
+// values and ~ for modified values. An empty JWT is treated as an empty claim.
+func DiffClaims(a []byte, b []byte) ([]string, error) {
+	ma, err := claimPayload(a, false)
+	if err != nil {
+		return nil, err
+	}
+	mb, err := claimPayload(b, false)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	diffValues("", ma, mb, &lines)
+	return lines, nil
+}
+
+// claimPayload returns the claims of a JWT, with useNumber numbers are kept
+// as json.Number so that the claims can be decoded into typed claims
+func claimPayload(token []byte, useNumber bool) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	token = bytes.TrimSpace(token)
+	if len(token) == 0 {
+		return m, nil
+	}
+	chunks := bytes.Split(token, []byte{'.'})
+	if len(chunks) != 3 {
+		return nil, errors.New("data is not a jwt")
+	}
+	d, err := base64.RawURLEncoding.DecodeString(string(chunks[1]))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding base64: %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(d))
+	if useNumber {
+		dec.UseNumber()
+	}
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("error parsing json: %v", err)
+	}
+	return m, nil
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func jsonValue(v interface{}) string {
+	d, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(d)
+}
+
+func diffValues(path string, a interface{}, b interface{}, lines *[]string) {
+	if volatileClaimFields[path] {
+		return
+	}
+	ma, aIsMap := a.(map[string]interface{})
+	mb, bIsMap := b.(map[string]interface{})
+	if aIsMap && bIsMap {
+		diffMaps(path, ma, mb, lines)
+		return
+	}
+	la, aIsList := a.([]interface{})
+	lb, bIsList := b.([]interface{})
+	if aIsList && bIsList {
+		diffLists(path, la, lb, lines)
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*lines = append(*lines, fmt.Sprintf("~ %s: from %s to %s", path, jsonValue(a), jsonValue(b)))
+	}
+}
+
+func diffMaps(path string, a map[string]interface{}, b map[string]interface{}, lines *[]string) {
+	keys := make(map[string]bool)
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		p := joinPath(path, k)
+		if volatileClaimFields[p] {
+			continue
+		}
+		av, aok := a[k]
+		bv, bok := b[k]
+		switch {
+		case !aok:
+			*lines = append(*lines, fmt.Sprintf("+ %s: %s", p, jsonValue(bv)))
+		case !bok:
+			*lines = append(*lines, fmt.Sprintf("- %s: %s", p, jsonValue(av)))
+		default:
+			diffValues(p, av, bv, lines)
+		}
+	}
+}
+
+// listKey identifies elements of lists of exports, imports, mappings and
+// other named entries so that they can be compared independently of order
+func listKey(v interface{}) (string, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	if s, ok := m["subject"].(string); ok {
+		if a, ok := m["account"].(string); ok && a != "" {
+			return fmt.Sprintf("%s (%s)", s, a), true
+		}
+		return s, true
+	}
+	if n, ok := m["name"].(string); ok {
+		return n, true
+	}
+	// scoped signing keys
+	if k, ok := m["key"].(string); ok {
+		return k, true
+	}
+	return "", false
+}
+
+func keyedList(l []interface{}) (map[string]interface{}, bool) {
+	m := make(map[string]interface{})
+	for _, v := range l {
+		k, ok := listKey(v)
+		if !ok {
+			return nil, false
+		}
+		if _, dupe := m[k]; dupe {
+			return nil, false
+		}
+		m[k] = v
+	}
+	return m, true
+}
+
+func diffLists(path string, a []interface{}, b []interface{}, lines *[]string) {
+	ka, aok := keyedList(a)
+	kb, bok := keyedList(b)
+	if aok && bok {
+		keys := make(map[string]bool)
+		for k := range ka {
+			keys[k] = true
+		}
+		for k := range kb {
+			keys[k] = true
+		}
+		var sorted []string
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			p := fmt.Sprintf("%s[%s]", path, k)
+			av, aok := ka[k]
+			bv, bok := kb[k]
+			switch {
+			case !aok:
+				*lines = append(*lines, fmt.Sprintf("+ %s: %s", p, jsonValue(bv)))
+			case !bok:
+				*lines = append(*lines, fmt.Sprintf("- %s: %s", p, jsonValue(av)))
+			default:
+				diffValues(p, av, bv, lines)
+			}
+		}
+		return
+	}
+	// compare as sets of values
+	inA := make(map[string]bool)
+	for _, v := range a {
+		inA[jsonValue(v)] = true
+	}
+	inB := make(map[string]bool)
+	for _, v := range b {
+		inB[jsonValue(v)] = true
+	}
+	for _, v := range a {
+		if s := jsonValue(v); !inB[s] {
+			*lines = append(*lines, fmt.Sprintf("- %s: %s", path, s))
+		}
+	}
+	for _, v := range b {
+		if s := jsonValue(v); !inA[s] {
+			*lines = append(*lines, fmt.Sprintf("+ %s: %s", path, s))
+		}
+	}
+}
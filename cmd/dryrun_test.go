@@ -0,0 +1,217 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func Test_DryRunEditAccount(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	before, err := ts.Store.ReadRawAccountClaim("A")
+	require.NoError(t, err)
+
+	_, stderr, err := ExecuteCmd(HoistRootFlags(createEditAccount()), "--conns", "10", "--dry-run")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "dry-run - no changes were written")
+	require.Contains(t, stderr, `would update account "A"`)
+	require.Contains(t, stderr, "~ nats.limits.conn: from -1 to 10")
+	require.False(t, store.IsDryRun())
+
+	after, err := ts.Store.ReadRawAccountClaim("A")
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+}
+
+func Test_DryRunAddUserDiscardsKey(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	keys, err := ts.KeyStore.AllKeys()
+	require.NoError(t, err)
+
+	_, stderr, err := ExecuteCmd(HoistRootFlags(CreateAddUserCmd()), "--name", "U", "--dry-run")
+	require.NoError(t, err)
+	require.Contains(t, stderr, `would add user "U"`)
+	require.Contains(t, stderr, "the generated key was discarded")
+	require.Contains(t, stderr, "would write creds")
+
+	require.False(t, ts.Store.Has(store.Accounts, "A", store.Users, store.JwtName("U")))
+	after, err := ts.KeyStore.AllKeys()
+	require.NoError(t, err)
+	require.Equal(t, keys, after)
+}
+
+func Test_DryRunDeleteUser(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+
+	_, stderr, err := ExecuteCmd(HoistRootFlags(CreateDeleteUserCmd()), "--name", "U", "--revoke", "--rm-nkey", "--rm-creds", "--dry-run")
+	require.NoError(t, err)
+	require.Contains(t, stderr, `would delete user "U"`)
+	require.Contains(t, stderr, "+ nats.revocations")
+	require.Contains(t, stderr, "would remove key")
+
+	require.True(t, ts.Store.Has(store.Accounts, "A", store.Users, store.JwtName("U")))
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Empty(t, ac.Revocations)
+}
+
+func Test_DryRunJson(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	_, stderr, err := ExecuteCmd(HoistRootFlags(CreateAddAccountCmd()), "--name", "A", "--dry-run", "--output", "json")
+	require.NoError(t, err)
+	var doc struct {
+		Code   string `json:"code"`
+		DryRun struct {
+			Children []struct {
+				Label          string   `json:"label"`
+				ServerMessages []string `json:"server_messages"`
+			} `json:"children"`
+		} `json:"dry_run"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(stderr), &doc))
+	require.Equal(t, "OK", doc.Code)
+	require.NotEmpty(t, doc.DryRun.Children)
+	require.False(t, ts.Store.Has(store.Accounts, "A", store.JwtName("A")))
+}
+
+func Test_DryRunUnsupported(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	_, _, err := ExecuteCmd(HoistRootFlags(CreatePushCmd()), "--account", "A", "--dry-run")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't support --dry-run")
+
+	_, _, err = ExecuteCmd(HoistRootFlags(createMigrateKeysCmd()), "--dry-run")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't support --dry-run")
+}
+
+func Test_DryRunKeysVerifyFix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not checked on windows")
+	}
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	creds := ts.KeyStore.CalcUserCredsPath("A", "U")
+	require.NoError(t, os.Chmod(creds, 0644))
+
+	_, stderr, err := ExecuteCmd(HoistRootFlags(createKeysVerifyCmd()), "--fix", "--dry-run")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "would change the mode of")
+	fi, err := os.Stat(creds)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+}
+
+func Test_DryRunExportOperator(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	fp := filepath.Join(ts.Dir, "op.tgz")
+	_, stderr, err := ExecuteCmd(HoistRootFlags(createExportOperatorCmd()), "--bundle", fp, "--dry-run")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "would write")
+	require.NoFileExists(t, fp)
+}
+
+func Test_DryRunExportKeys(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	dir := filepath.Join(ts.Dir, "export")
+	require.NoError(t, os.MkdirAll(dir, 0700))
+	_, stderr, err := ExecuteCmd(HoistRootFlags(createExportKeysCmd()), "--dir", dir, "--remove", "--dry-run")
+	require.NoError(t, err)
+	require.NotContains(t, stderr, "no files would change")
+	requireEmptyDir(t, dir)
+	require.FileExists(t, store.GetKeyPath(ts.GetAccountPublicKey(t, "A")))
+
+	_, _, err = ExecuteCmd(createExportKeysCmd(), "--dir", dir)
+	require.NoError(t, err)
+	fi, err := os.Stat(filepath.Join(dir, ts.GetAccountPublicKey(t, "A")+store.NKeyExtension))
+	require.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+}
+
+func Test_DryRunDescribeOutputFile(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	fp := filepath.Join(ts.Dir, "A.txt")
+	_, stderr, err := ExecuteCmd(HoistRootFlags(createDescribeAccountCmd()), "--name", "A", "--output-file", fp, "--dry-run")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "would write")
+	require.NoFileExists(t, fp)
+}
+
+func Test_DiffClaims(t *testing.T) {
+	_, apk, _ := CreateAccountKey(t)
+	okp, err := nkeys.CreateOperator()
+	require.NoError(t, err)
+
+	a := jwt.NewAccountClaims(apk)
+	a.Name = "A"
+	a.Exports.Add(&jwt.Export{Subject: "q", Type: jwt.Service})
+	ta, err := a.Encode(okp)
+	require.NoError(t, err)
+
+	a.Exports.Add(&jwt.Export{Subject: "s", Type: jwt.Stream})
+	a.Limits.Conn = 5
+	a.Tags.Add("x")
+	tb, err := a.Encode(okp)
+	require.NoError(t, err)
+
+	lines, err := DiffClaims([]byte(ta), []byte(tb))
+	require.NoError(t, err)
+	require.Contains(t, lines, `+ nats.exports[s]: {"subject":"s","type":"stream"}`)
+	require.Contains(t, lines, "~ nats.limits.conn: from -1 to 5")
+	require.Contains(t, lines, `+ nats.tags: ["x"]`)
+	require.Len(t, lines, 3)
+
+	lines, err = DiffClaims(nil, []byte(ta))
+	require.NoError(t, err)
+	require.Contains(t, lines, `+ name: "A"`)
+}
@@ -231,7 +231,7 @@ func (p *EditAccountParams) Load(ctx ActionCtx) error {
 
 func (p *EditAccountParams) PostInteractive(ctx ActionCtx) error {
 	var err error
-	if err = p.signingKeys.Edit(); err != nil {
+	if err = p.signingKeys.Edit(ctx); err != nil {
 		return err
 	}
 
@@ -308,7 +308,7 @@ func (p *EditAccountParams) PostInteractive(ctx ActionCtx) error {
 
 func (p *EditAccountParams) Validate(ctx ActionCtx) error {
 	var err error
-	if err = p.signingKeys.Valid(); err != nil {
+	if err = p.signingKeys.Valid(ctx); err != nil {
 		return err
 	}
 	if err = p.GenericClaimsParams.Valid(); err != nil {
@@ -333,7 +333,7 @@ func (p *EditAccountParams) Run(ctx ActionCtx) (store.Status, error) {
 	r.ReportSum = false
 
 	var err error
-	keys, _ := p.signingKeys.PublicKeys()
+	keys, _ := p.signingKeys.PublicKeys(ctx)
 	if len(keys) > 0 {
 		p.claim.SigningKeys.Add(keys...)
 		for _, k := range keys {
@@ -180,7 +180,7 @@ func (p *EditOperatorParams) PostInteractive(ctx ActionCtx) error {
 		}
 	}
 
-	if err := p.signingKeys.Edit(); err != nil {
+	if err := p.signingKeys.Edit(ctx); err != nil {
 		return err
 	}
 
@@ -232,7 +232,7 @@ func (p *EditOperatorParams) Validate(ctx ActionCtx) error {
 			}
 		}
 	}
-	if err = p.signingKeys.Valid(); err != nil {
+	if err = p.signingKeys.Valid(ctx); err != nil {
 		return err
 	}
 	if err = p.SignerParams.Resolve(ctx); err != nil {
@@ -249,7 +249,7 @@ func (p *EditOperatorParams) Run(ctx ActionCtx) (store.Status, error) {
 	if err = p.GenericClaimsParams.Run(ctx, p.claim, r); err != nil {
 		return nil, err
 	}
-	keys, _ := p.signingKeys.PublicKeys()
+	keys, _ := p.signingKeys.PublicKeys(ctx)
 	if len(keys) > 0 {
 		p.claim.SigningKeys.Add(keys...)
 		for _, k := range keys {
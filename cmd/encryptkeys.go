@@ -0,0 +1,169 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createEncryptKeysCmd() *cobra.Command {
+	var params EncryptKeysParams
+	cmd := &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt the seeds in the keystore with a passphrase",
+		Long: fmt.Sprintf(`Encrypt the seeds in the keystore with a passphrase
+
+The seeds are sealed in place with a key derived from the passphrase. Once
+encrypted, nsc asks for the passphrase the first time it reads a seed. The
+passphrase can also be provided by setting $%s, or by setting
+$%s to a file descriptor to read it from.
+
+Creds files are not encrypted, as they are read by NATS clients.`, store.KeystorePassphraseEnv, store.KeystorePassphraseFdEnv),
+		Example: `nsc keys encrypt
+NSC_KEYSTORE_PASSPHRASE_FD=3 nsc keys encrypt 3<passphrase.txt`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunMaybeStorelessAction(cmd, args, &params)
+		},
+	}
+	return cmd
+}
+
+func createDecryptKeysCmd() *cobra.Command {
+	var params DecryptKeysParams
+	cmd := &cobra.Command{
+		Use:          "decrypt",
+		Short:        "Decrypt the seeds in an encrypted keystore",
+		Example:      `nsc keys decrypt`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunMaybeStorelessAction(cmd, args, &params)
+		},
+	}
+	return cmd
+}
+
+func init() {
+	keysCmd.AddCommand(createEncryptKeysCmd())
+	keysCmd.AddCommand(createDecryptKeysCmd())
+}
+
+type EncryptKeysParams struct {
+	dir        string
+	passphrase string
+}
+
+func (p *EncryptKeysParams) SetDefaults(ctx ActionCtx) error {
+	p.dir = store.GetKeysDir()
+	return nil
+}
+
+func (p *EncryptKeysParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *EncryptKeysParams) Load(ctx ActionCtx) error {
+	var err error
+	_, env := os.LookupEnv(store.KeystorePassphraseEnv)
+	_, fd := os.LookupEnv(store.KeystorePassphraseFdEnv)
+	if env || fd || store.IsKeystoreEncrypted(p.dir) {
+		p.passphrase, err = store.KeystorePassphrase(p.dir)
+		return err
+	}
+	p.passphrase, err = cli.Password("passphrase for the keystore")
+	if err != nil {
+		return err
+	}
+	confirm, err := cli.Password("enter the passphrase again")
+	if err != nil {
+		return err
+	}
+	if confirm != p.passphrase {
+		return errors.New("passphrases don't match")
+	}
+	return nil
+}
+
+func (p *EncryptKeysParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *EncryptKeysParams) Validate(ctx ActionCtx) error {
+	if p.passphrase == "" {
+		return errors.New("passphrase cannot be empty")
+	}
+	return nil
+}
+
+func (p *EncryptKeysParams) Run(ctx ActionCtx) (store.Status, error) {
+	r := store.NewDetailedReport(true)
+	n, err := store.EncryptKeystore(p.dir, p.passphrase)
+	if err != nil {
+		r.AddFromError(err)
+		return r, err
+	}
+	r.AddOK("encrypted %d seed(s) in keystore %#q", n, AbbrevHomePaths(p.dir))
+	r.AddWarning("creds files are not encrypted")
+	return r, nil
+}
+
+type DecryptKeysParams struct {
+	dir string
+}
+
+func (p *DecryptKeysParams) SetDefaults(ctx ActionCtx) error {
+	p.dir = store.GetKeysDir()
+	return nil
+}
+
+func (p *DecryptKeysParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *DecryptKeysParams) Load(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *DecryptKeysParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *DecryptKeysParams) Validate(ctx ActionCtx) error {
+	if !store.IsKeystoreEncrypted(p.dir) {
+		return fmt.Errorf("keystore %#q is not encrypted", AbbrevHomePaths(p.dir))
+	}
+	return nil
+}
+
+func (p *DecryptKeysParams) Run(ctx ActionCtx) (store.Status, error) {
+	r := store.NewDetailedReport(true)
+	n, err := store.DecryptKeystore(p.dir)
+	if err != nil {
+		r.AddFromError(err)
+		return r, err
+	}
+	r.AddOK("decrypted %d seed(s) in keystore %#q", n, AbbrevHomePaths(p.dir))
+	return r, nil
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func requireSealed(t *testing.T, pk string, sealed bool) {
+	d, err := ioutil.ReadFile(store.GetKeyPath(pk))
+	require.NoError(t, err)
+	require.Equal(t, sealed, store.IsSealedSeed(d))
+}
+
+func Test_EncryptKeys(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	require.NoError(t, os.Setenv(store.KeystorePassphraseEnv, "secret"))
+	defer os.Unsetenv(store.KeystorePassphraseEnv)
+
+	_, stderr, err := ExecuteCmd(createEncryptKeysCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, "encrypted 3 seed(s)")
+	opk := ts.GetOperatorPublicKey(t)
+	apk := ts.GetAccountPublicKey(t, "A")
+	requireSealed(t, opk, true)
+	requireSealed(t, apk, true)
+
+	// signing with sealed keys and storing new keys works
+	ts.AddAccount(t, "B")
+	requireSealed(t, ts.GetAccountPublicKey(t, "B"), true)
+	_, _, err = ExecuteCmd(createEditAccount(), "--name", "A", "--conns", "10")
+	require.NoError(t, err)
+
+	// exported seeds are decrypted
+	exportDir := filepath.Join(ts.Dir, "export")
+	_, stderr, err = ExecuteCmd(createExportKeysCmd(), "--dir", exportDir)
+	require.NoError(t, err)
+	require.Contains(t, stderr, "the exported seeds are not encrypted")
+	d, err := ioutil.ReadFile(filepath.Join(exportDir, apk+".nk"))
+	require.NoError(t, err)
+	kp, err := nkeys.FromSeed(d)
+	require.NoError(t, err)
+	pk, err := kp.PublicKey()
+	require.NoError(t, err)
+	require.Equal(t, apk, pk)
+
+	_, stderr, err = ExecuteCmd(createDecryptKeysCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, "decrypted 4 seed(s)")
+	requireSealed(t, opk, false)
+	require.False(t, store.IsKeystoreEncrypted(store.GetKeysDir()))
+
+	_, _, err = ExecuteCmd(createDecryptKeysCmd())
+	require.Error(t, err)
+}
+
+func Test_ImportKeysIntoEncryptedKeystore(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	require.NoError(t, os.Setenv(store.KeystorePassphraseEnv, "secret"))
+	defer os.Unsetenv(store.KeystorePassphraseEnv)
+	_, _, err := ExecuteCmd(createEncryptKeysCmd())
+	require.NoError(t, err)
+
+	dir := filepath.Join(ts.Dir, "import")
+	require.NoError(t, os.MkdirAll(dir, 0700))
+	seed, pk, _ := CreateAccountKey(t)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, pk+".nk"), seed, 0600))
+
+	_, _, err = ExecuteCmd(createImportKeysCmd(), "--dir", dir)
+	require.NoError(t, err)
+	requireSealed(t, pk, true)
+	s, err := ts.KeyStore.GetSeed(pk)
+	require.NoError(t, err)
+	require.Equal(t, string(seed), s)
+}
@@ -104,6 +104,16 @@ func (p *SetContextParams) PrintEnv(cmd *cobra.Command) {
 		"If set, the tls key in the referenced file will be used for nats connections")
 	table.AddRow("$"+NscTlsCertNatsEnv, envSet(NscTlsCertNatsEnv),
 		"If set, the tls cert in the referenced file will be used for nats connections")
+	table.AddRow("$"+store.KeystorePassphraseEnv, envSet(store.KeystorePassphraseEnv),
+		"If set, the passphrase of an encrypted keystore")
+	table.AddRow("$"+store.KeystorePassphraseFdEnv, envSet(store.KeystorePassphraseFdEnv),
+		"If set, the file descriptor to read the passphrase of an encrypted keystore from")
+	table.AddRow("$"+NscLockTimeoutEnv, envSet(NscLockTimeoutEnv),
+		fmt.Sprintf("Wait up to %v for the store and keystore locks", store.LockTimeout))
+	table.AddRow("$"+store.SignerEnv, envSet(store.SignerEnv),
+		"If set, an executable or unix:<socket> that signs with keys not in the keystore")
+	table.AddRow("$"+store.AgentSockEnv, envSet(store.AgentSockEnv),
+		"If set, the socket of a running 'nsc keys agent'")
 	table.AddSeparator()
 	r := conf.StoreRoot
 	if r == "" {
@@ -18,7 +18,6 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -141,7 +140,7 @@ func (p *ExportKeysParams) Run(ctx ActionCtx) (store.Status, error) {
 				continue
 			}
 			j.filepath = filepath.Join(p.Dir, fmt.Sprintf("%s.nk", k.Pub))
-			_, err = os.Stat(j.filepath)
+			_, err = store.Stat(j.filepath)
 			if os.IsNotExist(err) || (err == nil && p.Force) {
 				j.data = []byte(s)
 			} else {
@@ -162,7 +161,7 @@ func (p *ExportKeysParams) Run(ctx ActionCtx) (store.Status, error) {
 
 	for _, j := range wj {
 		if j.filepath != "" {
-			j.err = ioutil.WriteFile(j.filepath, j.data, 0700)
+			j.err = store.WriteFile(j.filepath, j.data)
 			if j.err != nil {
 				sr.AddError("error exporting %q: %v", j.description, j.err)
 			} else {
@@ -182,6 +181,9 @@ func (p *ExportKeysParams) Run(ctx ActionCtx) (store.Status, error) {
 			sr.AddWarning("skipped %q - no seed available", j.description)
 		}
 	}
+	if len(wj) > 0 && store.IsKeystoreEncrypted(store.GetKeysDir()) {
+		sr.AddWarning("the exported seeds are not encrypted")
+	}
 	return sr, err
 }
 
@@ -0,0 +1,237 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createExportOperatorCmd() *cobra.Command {
+	var params ExportOperatorParams
+	cmd := &cobra.Command{
+		Use:   "operator",
+		Short: "Export the current operator to a bundle",
+		Long: fmt.Sprintf(`Export the current operator to a bundle
+
+The bundle is a gzipped tar holding the operator directory with its
+accounts and users. With --include-keys the seeds of the operator, its
+accounts and users that are in the keystore, and the creds of the users are
+added, sealed with a passphrase. The passphrase is read from $%s
+or asked for.
+
+With --account the bundle is partial: it holds the operator JWT, the
+account and its users, and with --include-keys only the account and user
+seeds and creds. Partial bundles hand an account to another team.
+
+Bundles are restored with 'nsc import operator --bundle'.`, store.BundlePassphraseEnv),
+		Example: `nsc export operator --bundle op.tgz
+nsc export operator --bundle op.tgz --include-keys
+nsc export operator --bundle a.tgz --account A --include-keys`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.bundle, "bundle", "", "", "bundle file to write")
+	cmd.Flags().BoolVarP(&params.includeKeys, "include-keys", "", false, "add the seeds and creds, sealed with a passphrase")
+	cmd.Flags().StringVarP(&params.account, "account", "a", "", "export only the named account and its users")
+	cmd.Flags().BoolVarP(&params.force, "force", "F", false, "overwrite an existing bundle file")
+	cmd.MarkFlagRequired("bundle")
+	return cmd
+}
+
+func init() {
+	exportCmd.AddCommand(createExportOperatorCmd())
+}
+
+// bundlePassphrase returns the passphrase of operator bundles from the
+// environment, otherwise asks for it
+func bundlePassphrase(confirm bool) (string, error) {
+	if v, ok := os.LookupEnv(store.BundlePassphraseEnv); ok {
+		return v, nil
+	}
+	p, err := cli.Password("passphrase for the bundle")
+	if err != nil || !confirm {
+		return p, err
+	}
+	again, err := cli.Password("enter the passphrase again")
+	if err != nil {
+		return "", err
+	}
+	if again != p {
+		return "", errors.New("passphrases don't match")
+	}
+	return p, nil
+}
+
+type ExportOperatorParams struct {
+	bundle      string
+	includeKeys bool
+	account     string
+	force       bool
+	passphrase  string
+	accounts    []string
+}
+
+func (p *ExportOperatorParams) SetDefaults(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ExportOperatorParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ExportOperatorParams) Load(ctx ActionCtx) error {
+	var err error
+	s := ctx.StoreCtx().Store
+	if p.account != "" {
+		p.accounts = []string{p.account}
+	} else if p.accounts, err = s.ListSubContainers(store.Accounts); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *ExportOperatorParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ExportOperatorParams) Validate(ctx ActionCtx) error {
+	var err error
+	if p.bundle, err = Expand(p.bundle); err != nil {
+		return err
+	}
+	if _, err := store.Stat(p.bundle); err == nil && !p.force {
+		return fmt.Errorf("%#q already exists - use --force to overwrite it", p.bundle)
+	}
+	if p.account != "" && !ctx.StoreCtx().Store.HasAccount(p.account) {
+		return fmt.Errorf("account %q is not in the current operator", p.account)
+	}
+	if p.includeKeys {
+		p.passphrase, err = bundlePassphrase(true)
+		if err != nil {
+			return err
+		}
+		if p.passphrase == "" {
+			return errors.New("passphrase cannot be empty")
+		}
+	}
+	return nil
+}
+
+// addSeed adds the seed of the key to the bundle if the keystore has it
+func (p *ExportOperatorParams) addSeed(ctx ActionCtx, b *store.Bundle, pk string) bool {
+	if pk == "" {
+		return true
+	}
+	seed, err := ctx.StoreCtx().KeyStore.GetSeed(pk)
+	if err != nil || seed == "" {
+		return false
+	}
+	b.Keys[pk] = []byte(seed)
+	return true
+}
+
+func (p *ExportOperatorParams) Run(ctx ActionCtx) (store.Status, error) {
+	s := ctx.StoreCtx().Store
+	ks := ctx.StoreCtx().KeyStore
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+
+	oc, err := s.ReadOperatorClaim()
+	if err != nil {
+		return nil, err
+	}
+	var partial []string
+	if p.account != "" {
+		partial = p.accounts
+	}
+	b, err := store.NewBundle(s, oc.Subject, partial...)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.includeKeys {
+		var missing []string
+		need := func(pk string) {
+			if !p.addSeed(ctx, b, pk) {
+				missing = append(missing, pk)
+			}
+		}
+		if p.account == "" {
+			need(oc.Subject)
+			for _, sk := range oc.SigningKeys {
+				need(sk)
+			}
+		}
+		for _, a := range p.accounts {
+			ac, err := s.ReadAccountClaim(a)
+			if err != nil {
+				return nil, err
+			}
+			need(ac.Subject)
+			for _, sk := range ac.SigningKeys.Keys() {
+				need(sk)
+			}
+			users, err := s.ListEntries(store.Accounts, a, store.Users)
+			if err != nil {
+				return nil, err
+			}
+			for _, u := range users {
+				uc, err := s.ReadUserClaim(a, u)
+				if err != nil {
+					return nil, err
+				}
+				need(uc.Subject)
+				if fp := ks.GetUserCredsPath(a, u); fp != "" {
+					d, err := ioutil.ReadFile(fp)
+					if err != nil {
+						return nil, err
+					}
+					b.Creds[a+"/"+u+store.CredsExtension] = d
+				}
+			}
+		}
+		for _, pk := range missing {
+			r.AddWarning("seed for %s is not in the keystore", pk)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := b.Write(&buf, p.passphrase); err != nil {
+		return nil, err
+	}
+	if err := store.WriteFile(p.bundle, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	kind := "operator"
+	if p.account != "" {
+		kind = fmt.Sprintf("account %q of operator", p.account)
+	}
+	r.AddOK("exported %s %q with %d file(s), %d seed(s) and %d creds to %#q",
+		kind, oc.Name, len(b.Store), len(b.Keys), len(b.Creds), AbbrevHomePaths(p.bundle))
+	return r, nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func readTestBundle(t *testing.T, fp string) *store.Bundle {
+	f, err := os.Open(fp)
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := store.ReadBundle(f, func() (string, error) {
+		return "secret", nil
+	})
+	require.NoError(t, err)
+	return b
+}
+
+func Test_ExportOperatorBundle(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	ts.AddAccount(t, "B")
+
+	fp := filepath.Join(ts.Dir, "op.tgz")
+	_, _, err := ExecuteCmd(createExportOperatorCmd(), "--bundle", fp)
+	require.NoError(t, err)
+	b := readTestBundle(t, fp)
+	require.Equal(t, "O", b.Manifest.Operator)
+	require.Equal(t, ts.GetOperatorPublicKey(t), b.Manifest.Subject)
+	require.False(t, b.Manifest.IsPartial())
+	require.Contains(t, b.Store, "O.jwt")
+	require.Contains(t, b.Store, "accounts/A/users/U.jwt")
+	require.Contains(t, b.Store, "accounts/B/B.jwt")
+	require.Empty(t, b.Keys)
+	for n := range b.Store {
+		require.NotContains(t, n, store.HistoryDir)
+	}
+
+	_, _, err = ExecuteCmd(createExportOperatorCmd(), "--bundle", fp)
+	require.Error(t, err)
+
+	require.NoError(t, os.Setenv(store.BundlePassphraseEnv, "secret"))
+	defer os.Unsetenv(store.BundlePassphraseEnv)
+	_, stderr, err := ExecuteCmd(createExportOperatorCmd(), "--bundle", fp, "--include-keys", "--force")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "4 seed(s) and 1 creds")
+	b = readTestBundle(t, fp)
+	require.Contains(t, b.Keys, ts.GetOperatorPublicKey(t))
+	require.Contains(t, b.Keys, ts.GetUserPublicKey(t, "A", "U"))
+	require.Contains(t, b.Creds, "A/U.creds")
+
+	f, err := os.Open(fp)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = store.ReadBundle(f, func() (string, error) {
+		return "wrong", nil
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid passphrase")
+}
+
+func Test_ExportOperatorPartialBundle(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	ts.AddAccount(t, "B")
+	require.NoError(t, os.Setenv(store.BundlePassphraseEnv, "secret"))
+	defer os.Unsetenv(store.BundlePassphraseEnv)
+
+	fp := filepath.Join(ts.Dir, "a.tgz")
+	_, _, err := ExecuteCmd(createExportOperatorCmd(), "--bundle", fp, "--account", "A", "--include-keys")
+	require.NoError(t, err)
+	b := readTestBundle(t, fp)
+	require.Equal(t, []string{"A"}, b.Manifest.Accounts)
+	require.Contains(t, b.Store, "O.jwt")
+	require.Contains(t, b.Store, "accounts/A/A.jwt")
+	require.NotContains(t, b.Store, "accounts/B/B.jwt")
+	require.NotContains(t, b.Keys, ts.GetOperatorPublicKey(t))
+	require.Contains(t, b.Keys, ts.GetAccountPublicKey(t, "A"))
+	require.Contains(t, b.Creds, "A/U.creds")
+
+	_, _, err = ExecuteCmd(createExportOperatorCmd(), "--bundle", fp, "--account", "X", "--force")
+	require.Error(t, err)
+}
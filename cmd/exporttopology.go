@@ -0,0 +1,472 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createExportTopologyCmd() *cobra.Command {
+	var params ExportTopologyParams
+	cmd := &cobra.Command{
+		Use:   "topology",
+		Short: "Export the operator and its accounts as a topology file",
+		Long: `Export the operator and its accounts as a topology file
+
+The topology file describes the current operator, its accounts and users in
+the format used by 'nsc apply'. Accounts are referenced by name when the name
+can be resolved, public keys are included for cross-references. Entries are
+sorted so that exports of the same store are identical.
+
+Private keys are never exported, signing keys are listed by their public
+keys. Activation tokens of private imports are referenced by url, or written
+to the tokens directory (by default 'tokens' next to the output file) and
+referenced by path. A topology written to stdout requires --tokens-dir if
+it has tokens to write.
+
+The topology is written as json if the output file ends in '.json'
+or if --output json is specified.`,
+		Example: `nsc export topology
+nsc export topology --output-file topology.yaml
+nsc export topology --output-file topology.json --tokens-dir /tmp/tokens`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.outputFile, "output-file", "o", "--", "output file, '--' is stdout")
+	cmd.Flags().StringVarP(&params.tokensDir, "tokens-dir", "", "", "directory where activation tokens are written")
+	cmd.Flags().BoolVarP(&params.force, "force", "F", false, "overwrite existing files")
+	return cmd
+}
+
+func init() {
+	exportCmd.AddCommand(createExportTopologyCmd())
+}
+
+type ExportTopologyParams struct {
+	outputFile string
+	tokensDir  string
+	force      bool
+	names      map[string]string
+	// activation tokens by the path they are written to
+	tokens   map[string]string
+	topology *Topology
+}
+
+func (p *ExportTopologyParams) SetDefaults(ctx ActionCtx) error {
+	// a topology on stdout has no directory for the tokens
+	if p.tokensDir == "" && !IsStdOut(p.outputFile) {
+		p.tokensDir = filepath.Join(filepath.Dir(p.outputFile), "tokens")
+	}
+	return nil
+}
+
+func (p *ExportTopologyParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ExportTopologyParams) Load(ctx ActionCtx) error {
+	var err error
+	if p.names, err = friendlyNames(ctx.StoreCtx().Operator.Name); err != nil {
+		return err
+	}
+	p.tokens = make(map[string]string)
+	p.topology, err = p.exportTopology(ctx.StoreCtx().Store)
+	return err
+}
+
+func (p *ExportTopologyParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ExportTopologyParams) Validate(ctx ActionCtx) error {
+	if p.force {
+		return nil
+	}
+	files := []string{p.outputFile}
+	for fp := range p.tokens {
+		files = append(files, fp)
+	}
+	for _, fp := range files {
+		if IsStdOut(fp) {
+			continue
+		}
+		if _, err := os.Stat(fp); err == nil {
+			return fmt.Errorf("%#q already exists - specify --force to overwrite", fp)
+		}
+	}
+	return nil
+}
+
+func (p *ExportTopologyParams) asJson() bool {
+	return IsJsonOutput() || strings.EqualFold(filepath.Ext(p.outputFile), ".json")
+}
+
+func (p *ExportTopologyParams) write(fp string, data []byte) error {
+	if !IsStdOut(fp) {
+		if err := MaybeMakeDir(filepath.Dir(fp)); err != nil {
+			return err
+		}
+		if p.force {
+			if err := store.RemoveFile(fp); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return Write(fp, data)
+}
+
+func (p *ExportTopologyParams) Run(ctx ActionCtx) (store.Status, error) {
+	r := store.NewDetailedReport(true)
+	var paths []string
+	for fp := range p.tokens {
+		paths = append(paths, fp)
+	}
+	sort.Strings(paths)
+	for _, fp := range paths {
+		if err := p.write(fp, []byte(p.tokens[fp])); err != nil {
+			return nil, err
+		}
+		r.AddOK("wrote activation token %#q", AbbrevHomePaths(fp))
+	}
+
+	if IsStdOut(p.outputFile) && IsJsonOutput() {
+		// printed by the json output of the action
+		return &topologyStatus{Report: r, topology: p.topology}, nil
+	}
+	var data []byte
+	var err error
+	if p.asJson() {
+		data, err = json.MarshalIndent(p.topology, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(p.topology)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error formatting topology: %v", err)
+	}
+	if err := p.write(p.outputFile, data); err != nil {
+		return nil, err
+	}
+	if IsStdOut(p.outputFile) {
+		if len(r.Details) == 0 {
+			return nil, nil
+		}
+		return r, nil
+	}
+	r.AddOK("wrote topology to %#q", AbbrevHomePaths(p.outputFile))
+	return r, nil
+}
+
+// topologyStatus prints the topology as the json document of the action
+type topologyStatus struct {
+	*store.Report
+	topology *Topology
+}
+
+func (s *topologyStatus) JsonDocument() (interface{}, error) {
+	return s.topology, nil
+}
+
+// name returns the friendly name of a public key, or the key if it has none
+func (p *ExportTopologyParams) name(pk string) string {
+	if n, ok := p.names[pk]; ok {
+		return n
+	}
+	return pk
+}
+
+func (p *ExportTopologyParams) exportTopology(s *store.Store) (*Topology, error) {
+	oc, err := s.ReadOperatorClaim()
+	if err != nil {
+		return nil, err
+	}
+	o := &OperatorTopology{
+		Name:               oc.Name,
+		Key:                oc.Subject,
+		AccountServerURL:   oc.AccountServerURL,
+		ServiceURLs:        sortedStrings(oc.OperatorServiceURLs),
+		RequireSigningKeys: oc.StrictSigningKeyUsage,
+		SigningKeys:        sortedStrings(oc.SigningKeys),
+		Tags:               sortedStrings(oc.Tags),
+	}
+	if oc.SystemAccount != "" {
+		o.SystemAccount = p.name(oc.SystemAccount)
+	}
+	t := &Topology{Operator: o}
+
+	names, err := s.ListSubContainers(store.Accounts)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		ac, err := s.ReadAccountClaim(n)
+		if err != nil {
+			return nil, err
+		}
+		a, err := p.exportAccount(s, n, ac)
+		if err != nil {
+			return nil, fmt.Errorf("error exporting account %q: %v", n, err)
+		}
+		t.Accounts = append(t.Accounts, a)
+	}
+	return t, nil
+}
+
+func sortedStrings(v []string) []string {
+	if len(v) == 0 {
+		return nil
+	}
+	s := append([]string(nil), v...)
+	sort.Strings(s)
+	return s
+}
+
+func optionalLimit(v int64, def int64) *int64 {
+	if v == def {
+		return nil
+	}
+	return &v
+}
+
+func exportAccountLimits(l jwt.OperatorLimits) *AccountLimitsTopology {
+	def := jwt.NewAccountClaims("A").Limits
+	lt := &AccountLimitsTopology{
+		Conns:         optionalLimit(l.Conn, def.Conn),
+		LeafConns:     optionalLimit(l.LeafNodeConn, def.LeafNodeConn),
+		Subscriptions: optionalLimit(l.Subs, def.Subs),
+		Data:          optionalLimit(l.Data, def.Data),
+		Payload:       optionalLimit(l.Payload, def.Payload),
+		Imports:       optionalLimit(l.Imports, def.Imports),
+		Exports:       optionalLimit(l.Exports, def.Exports),
+		JsMemStorage:  optionalLimit(l.MemoryStorage, def.MemoryStorage),
+		JsDiskStorage: optionalLimit(l.DiskStorage, def.DiskStorage),
+		JsStreams:     optionalLimit(l.Streams, def.Streams),
+		JsConsumer:    optionalLimit(l.Consumer, def.Consumer),
+	}
+	if l.WildcardExports != def.WildcardExports {
+		wc := l.WildcardExports
+		lt.WildcardExports = &wc
+	}
+	if *lt == (AccountLimitsTopology{}) {
+		return nil
+	}
+	return lt
+}
+
+func exportPermissions(perms jwt.Permissions) *PermissionsTopology {
+	pt := &PermissionsTopology{
+		PubAllow: sortedStrings(perms.Pub.Allow),
+		PubDeny:  sortedStrings(perms.Pub.Deny),
+		SubAllow: sortedStrings(perms.Sub.Allow),
+		SubDeny:  sortedStrings(perms.Sub.Deny),
+	}
+	if perms.Resp != nil {
+		pt.MaxResponses = perms.Resp.MaxMsgs
+		if perms.Resp.Expires > 0 {
+			pt.ResponseTTL = perms.Resp.Expires.String()
+		}
+	}
+	if pt.PubAllow == nil && pt.PubDeny == nil && pt.SubAllow == nil && pt.SubDeny == nil && perms.Resp == nil {
+		return nil
+	}
+	return pt
+}
+
+func exportUserPermissionLimits(upl jwt.UserPermissionLimits, def jwt.UserPermissionLimits) UserPermissionLimitsTopology {
+	u := UserPermissionLimitsTopology{
+		Permissions:    exportPermissions(upl.Permissions),
+		Bearer:         upl.BearerToken,
+		SourceNetworks: sortedStrings(upl.Src),
+		ConnTypes:      sortedStrings(upl.AllowedConnectionTypes),
+	}
+	limits := &UserLimitsTopology{
+		Subs:    optionalLimit(upl.Subs, def.Subs),
+		Data:    optionalLimit(upl.Data, def.Data),
+		Payload: optionalLimit(upl.Payload, def.Payload),
+	}
+	if *limits != (UserLimitsTopology{}) {
+		u.Limits = limits
+	}
+	return u
+}
+
+func (p *ExportTopologyParams) exportAccount(s *store.Store, name string, ac *jwt.AccountClaims) (*AccountTopology, error) {
+	a := &AccountTopology{
+		Name:               name,
+		Key:                ac.Subject,
+		Description:        ac.Description,
+		InfoURL:            ac.InfoURL,
+		Tags:               sortedStrings(ac.Tags),
+		Limits:             exportAccountLimits(ac.Limits),
+		DefaultPermissions: exportPermissions(ac.DefaultPermissions),
+	}
+
+	// signing keys by public key and role of scoped keys
+	roles := make(map[string]string)
+	for _, k := range sortedStrings(ac.SigningKeys.Keys()) {
+		sk := &SigningKeyTopology{Key: k}
+		if scope, _ := ac.SigningKeys.GetScope(k); scope != nil {
+			if us, ok := scope.(*jwt.UserScope); ok {
+				sk.Role = us.Role
+				template := exportUserPermissionLimits(us.Template, jwt.NewUserScope().Template)
+				sk.Template = &template
+				roles[k] = us.Role
+			}
+		}
+		a.SigningKeys = append(a.SigningKeys, sk)
+	}
+
+	for _, e := range ac.Exports {
+		et := &ExportTopology{
+			Subject:              string(e.Subject),
+			Type:                 e.Type.String(),
+			Private:              e.TokenReq,
+			AccountTokenPosition: e.AccountTokenPosition,
+			Advertise:            e.Advertise,
+			Description:          e.Description,
+			InfoURL:              e.InfoURL,
+		}
+		if e.Name != string(e.Subject) {
+			et.Name = e.Name
+		}
+		if e.IsService() {
+			if e.ResponseType != "" && e.ResponseType != jwt.ResponseTypeSingleton {
+				et.ResponseType = string(e.ResponseType)
+			}
+			if e.ResponseThreshold > 0 {
+				et.ResponseThreshold = e.ResponseThreshold.String()
+			}
+			if e.Latency != nil {
+				et.Latency = &LatencyTopology{Subject: string(e.Latency.Results), Sampling: latSamplingRateToString(e.Latency.Sampling)}
+			}
+		}
+		a.Exports = append(a.Exports, et)
+	}
+	sort.Slice(a.Exports, func(i, j int) bool {
+		return a.Exports[i].Subject < a.Exports[j].Subject
+	})
+
+	for _, im := range ac.Imports {
+		it, err := p.exportImport(name, im)
+		if err != nil {
+			return nil, err
+		}
+		a.Imports = append(a.Imports, it)
+	}
+	sort.SliceStable(a.Imports, func(i, j int) bool {
+		ki := a.Imports[i].AccountKey + " " + a.Imports[i].Subject + " " + a.Imports[i].Token
+		kj := a.Imports[j].AccountKey + " " + a.Imports[j].Subject + " " + a.Imports[j].Token
+		return ki < kj
+	})
+
+	if len(ac.Mappings) > 0 {
+		a.Mappings = make(map[string][]*MappingTopology)
+		for from, to := range ac.Mappings {
+			for _, m := range to {
+				a.Mappings[string(from)] = append(a.Mappings[string(from)], &MappingTopology{Subject: string(m.Subject), Weight: m.Weight, Cluster: m.Cluster})
+			}
+		}
+	}
+
+	users, err := s.ListEntries(store.Accounts, name, store.Users)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(users)
+	for _, n := range users {
+		uc, err := s.ReadUserClaim(name, n)
+		if err != nil {
+			return nil, err
+		}
+		u := &UserTopology{Name: n, Key: uc.Subject, Tags: sortedStrings(uc.Tags)}
+		if uc.Issuer != ac.Subject {
+			u.SigningKey = uc.Issuer
+			if role := roles[uc.Issuer]; role != "" {
+				u.SigningKey = role
+			}
+		}
+		if _, scoped := roles[uc.Issuer]; !scoped || !uc.HasEmptyPermissions() {
+			u.UserPermissionLimitsTopology = exportUserPermissionLimits(uc.UserPermissionLimits, jwt.NewUserClaims("U").UserPermissionLimits)
+		}
+		a.Users = append(a.Users, u)
+	}
+	return a, nil
+}
+
+func (p *ExportTopologyParams) exportImport(account string, im *jwt.Import) (*ImportTopology, error) {
+	it := &ImportTopology{
+		Name:         im.Name,
+		LocalSubject: string(im.LocalSubject),
+		Share:        im.Share,
+	}
+	if im.Token == "" {
+		it.Account = p.name(im.Account)
+		it.AccountKey = im.Account
+		it.Subject = string(im.Subject)
+		it.Type = im.Type.String()
+		if it.Name == it.Subject {
+			it.Name = ""
+		}
+		return it, nil
+	}
+	if IsURL(im.Token) {
+		it.Token = im.Token
+		return it, nil
+	}
+	if p.tokensDir == "" {
+		return nil, fmt.Errorf("the activation token for %q of account %q has to be written to a file - specify --tokens-dir", im.Subject, account)
+	}
+	fp := filepath.Join(p.tokensDir, fmt.Sprintf("%s_%s.jwt", account, tokenFileName(string(im.Subject))))
+	if other, ok := p.tokens[fp]; ok && other != im.Token {
+		return nil, fmt.Errorf("more than one activation token for %q", im.Subject)
+	}
+	token, err := jwt.DecorateJWT(im.Token)
+	if err != nil {
+		return nil, err
+	}
+	p.tokens[fp] = string(token)
+	it.Token = fp
+	if !IsStdOut(p.outputFile) {
+		// referenced relative to the topology file
+		if rel, err := filepath.Rel(filepath.Dir(p.outputFile), fp); err == nil {
+			it.Token = rel
+		}
+	} else if abs, err := filepath.Abs(fp); err == nil {
+		it.Token = abs
+	}
+	return it, nil
+}
+
+// tokenFileName replaces the characters of a subject that are awkward in file names
+func tokenFileName(subject string) string {
+	r := strings.NewReplacer("*", "_", ">", "_", "/", "_", " ", "_")
+	return r.Replace(subject)
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_ExportTopologyRoundTrip(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	fp := writeTopology(t, ts, testTopology)
+	_, stderr, err := ExecuteCmd(createApplyCmd(), "-f", fp)
+	require.NoError(t, err, stderr)
+
+	out := filepath.Join(ts.Dir, "export", "topology.yaml")
+	_, _, err = ExecuteCmd(createExportTopologyCmd(), "--output-file", out)
+	require.NoError(t, err)
+
+	d, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	var topology Topology
+	require.NoError(t, yaml.UnmarshalStrict(d, &topology))
+	require.Equal(t, "O", topology.Operator.Name)
+	require.Len(t, topology.Accounts, 2)
+	require.Equal(t, "A", topology.Accounts[0].Name)
+	require.Equal(t, "B", topology.Accounts[1].Name)
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	for _, im := range topology.Accounts[1].Imports {
+		require.Equal(t, "A", im.Account)
+		require.Equal(t, ac.Subject, im.AccountKey)
+	}
+	require.Equal(t, "svc", topology.Accounts[0].Users[0].SigningKey)
+
+	// the export describes the store
+	_, stderr, err = ExecuteCmd(createApplyCmd(), "-f", out)
+	require.NoError(t, err)
+	require.Contains(t, stderr, "nothing to do")
+
+	// exports are stable
+	_, _, err = ExecuteCmd(createExportTopologyCmd(), "--output-file", out, "--force")
+	require.NoError(t, err)
+	d2, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, string(d), string(d2))
+
+	_, _, err = ExecuteCmd(createExportTopologyCmd(), "--output-file", out)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "already exists")
+}
+
+func Test_ExportTopologyStdoutRequiresTokensDir(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddExport(t, "A", jwt.Service, "q", false)
+	ts.AddAccount(t, "B")
+	token := ts.GenerateActivation(t, "A", "q", "B")
+	tfp := filepath.Join(ts.Dir, "token.jwt")
+	require.NoError(t, ioutil.WriteFile(tfp, []byte(token), 0600))
+	_, _, err := ExecuteCmd(createAddImportCmd(), "--account", "B", "--token", tfp)
+	require.NoError(t, err)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(ts.Dir))
+	defer os.Chdir(cwd)
+	_, stderr, err := ExecuteCmd(createExportTopologyCmd())
+	require.Error(t, err)
+	require.Contains(t, stderr, "specify --tokens-dir")
+	require.NoDirExists(t, filepath.Join(ts.Dir, "tokens"))
+
+	dir := filepath.Join(ts.Dir, "other")
+	stdout, _, err := ExecuteCmd(createExportTopologyCmd(), "--tokens-dir", dir)
+	require.NoError(t, err)
+	require.Contains(t, stdout, filepath.Join(dir, "B_q.jwt"))
+	require.FileExists(t, filepath.Join(dir, "B_q.jwt"))
+}
+
+func Test_ExportTopologyTokens(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddExport(t, "A", jwt.Service, "q", false)
+	ts.AddAccount(t, "B")
+	token := ts.GenerateActivation(t, "A", "q", "B")
+	tfp := filepath.Join(ts.Dir, "token.jwt")
+	require.NoError(t, ioutil.WriteFile(tfp, []byte(token), 0600))
+	_, _, err := ExecuteCmd(createAddImportCmd(), "--account", "B", "--token", tfp)
+	require.NoError(t, err)
+
+	out := filepath.Join(ts.Dir, "export", "topology.json")
+	_, stderr, err := ExecuteCmd(createExportTopologyCmd(), "--output-file", out)
+	require.NoError(t, err)
+	require.Contains(t, stderr, "wrote activation token")
+
+	d, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	var topology Topology
+	require.NoError(t, json.Unmarshal(d, &topology))
+	im := topology.Accounts[1].Imports[0]
+	require.Equal(t, filepath.Join("tokens", "B_q.jwt"), im.Token)
+	require.Empty(t, im.Account)
+	require.FileExists(t, filepath.Join(ts.Dir, "export", "tokens", "B_q.jwt"))
+
+	_, stderr, err = ExecuteCmd(createApplyCmd(), "-f", out)
+	require.NoError(t, err)
+	require.Contains(t, stderr, "nothing to do")
+}
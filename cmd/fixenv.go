@@ -703,6 +703,9 @@ func (p *FixCmd) LoadNKey(fp string) error {
 	if err != nil {
 		return err
 	}
+	if d, err = store.UnsealSeed(fp, d); err != nil {
+		return err
+	}
 	kp, err := jwt.ParseDecoratedNKey(d)
 	if err != nil {
 		return fmt.Errorf("error parsing nkey %#q: %v", fp, err)
@@ -17,12 +17,15 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/kbehouse/nsc/cmd/store"
 	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
 	"github.com/stretchr/testify/require"
 )
 
@@ -119,3 +122,27 @@ func Test_FixBasics(t *testing.T) {
 	ukf := filepath.Join(ofp, "keys", "keys", upk[:1], upk[1:3], fmt.Sprintf("%s.nk", upk))
 	require.FileExists(t, ukf)
 }
+
+func Test_FixEncryptedKeystore(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	require.NoError(t, os.Setenv(store.KeystorePassphraseEnv, "secret"))
+	defer os.Unsetenv(store.KeystorePassphraseEnv)
+	_, _, err := ExecuteCmd(createEncryptKeysCmd())
+	require.NoError(t, err)
+	opk := ts.GetOperatorPublicKey(t)
+	apk := ts.GetAccountPublicKey(t, "A")
+
+	ofp := filepath.Join(ts.Dir, "out")
+	_, _, err = ExecuteCmd(createFixCmd(), "--in", ts.Dir, "--out", ofp)
+	require.NoError(t, err)
+
+	// the sealed seeds were read and regenerated in the new keystore
+	for _, pk := range []string{opk, apk} {
+		d, err := ioutil.ReadFile(filepath.Join(ofp, "keys", "keys", pk[:1], pk[1:3], fmt.Sprintf("%s.nk", pk)))
+		require.NoError(t, err)
+		_, err = nkeys.FromSeed(d)
+		require.NoError(t, err)
+	}
+}
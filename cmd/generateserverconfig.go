@@ -18,7 +18,6 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
 
 	"github.com/kbehouse/nsc/cmd/store"
@@ -144,11 +143,11 @@ func (p *GenerateServerConfigParams) checkFile(fp string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	_, err = os.Stat(afp)
+	_, err = store.Stat(afp)
 	if err == nil {
 		// file exists, if force - delete it
 		if p.force {
-			if err := os.Remove(afp); err != nil {
+			if err := store.RemoveFile(afp); err != nil {
 				return "", err
 			}
 			return afp, nil
@@ -167,7 +166,7 @@ func (p *GenerateServerConfigParams) checkDir(fp string) (string, error) {
 		return "", err
 	}
 
-	fi, err := os.Stat(afp)
+	fi, err := store.Stat(afp)
 	if err == nil {
 		if !p.force {
 			return "", fmt.Errorf("%#q already exists", fp)
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/xlab/tablewriter"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createHistoryCmd() *cobra.Command {
+	var params HistoryParams
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show the changes made to the operator, its accounts, users and keys",
+		Long: `Show the changes made to the operator, its accounts, users and keys
+
+Every change to the store and the keystore is recorded in a journal in the
+operator directory. Each entry records when the change was made, by which
+OS user and nsc command, and the hashes of the JWT before and after the
+change. Previous JWTs are kept by the journal.
+
+Entries can be filtered by account, user and time range. Times are
+specified as RFC3339 dates or unix timestamps.`,
+		Example: `nsc history
+nsc history --account A
+nsc history --account A --user U
+nsc history --since 2021-06-01T00:00:00Z --until 2021-07-01T00:00:00Z`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.account, "account", "a", "", "show changes to the account and its users")
+	cmd.Flags().StringVarP(&params.user, "user", "u", "", "show changes to the user")
+	cmd.Flags().VarP(&params.since, "since", "", "show changes made at or after the date")
+	cmd.Flags().VarP(&params.until, "until", "", "show changes made before the date")
+	return cmd
+}
+
+func init() {
+	GetRootCmd().AddCommand(createHistoryCmd())
+}
+
+type HistoryParams struct {
+	account string
+	user    string
+	since   dateTime
+	until   dateTime
+	entries []*store.JournalEntry
+}
+
+func (p *HistoryParams) SetDefaults(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *HistoryParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *HistoryParams) Load(ctx ActionCtx) error {
+	var err error
+	p.entries, err = ctx.StoreCtx().Store.Journal().Entries()
+	return err
+}
+
+func (p *HistoryParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *HistoryParams) Validate(ctx ActionCtx) error {
+	if p.since != 0 && p.until != 0 && p.until <= p.since {
+		return fmt.Errorf("--until must be after --since")
+	}
+	return nil
+}
+
+func (p *HistoryParams) matches(e *store.JournalEntry) bool {
+	if p.account != "" && e.Account != p.account {
+		return false
+	}
+	if p.user != "" && (e.Kind != store.KindUser || e.Name != p.user) {
+		return false
+	}
+	if p.since != 0 && e.Time.Unix() < int64(p.since) {
+		return false
+	}
+	if p.until != 0 && e.Time.Unix() >= int64(p.until) {
+		return false
+	}
+	return true
+}
+
+func (p *HistoryParams) Run(ctx ActionCtx) (store.Status, error) {
+	h := History{Operator: ctx.StoreCtx().Store.GetName()}
+	for _, e := range p.entries {
+		if p.matches(e) {
+			h.Entries = append(h.Entries, e)
+		}
+	}
+	return &h, nil
+}
+
+// History is the status of the history command
+type History struct {
+	Operator string
+	Entries  []*store.JournalEntry
+}
+
+func (h *History) Code() store.StatusCode {
+	return store.OK
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
+
+// journalEntity describes the operator, account, user or key of an entry
+func journalEntity(e *store.JournalEntry) string {
+	if e.Kind == store.KindUser {
+		return fmt.Sprintf("%s %s/%s", e.Kind, e.Account, e.Name)
+	}
+	return fmt.Sprintf("%s %s", e.Kind, e.Name)
+}
+
+func (h *History) Message() string {
+	table := tablewriter.CreateTable()
+	table.AddTitle(fmt.Sprintf("History of %s", h.Operator))
+	if len(h.Entries) == 0 {
+		table.AddRow("No changes recorded")
+		return table.Render()
+	}
+	table.AddHeaders("Time", "User", "Change", "Previous", "New", "Command")
+	for _, e := range h.Entries {
+		table.AddRow(e.Time.Local().Format(time.RFC3339), e.User, fmt.Sprintf("%s %s", e.Op, journalEntity(e)),
+			shortHash(e.PreviousHash), shortHash(e.NewHash), e.Command)
+	}
+	return table.Render()
+}
+
+func (h *History) JsonDocument() (interface{}, error) {
+	if h.Entries == nil {
+		return []*store.JournalEntry{}, nil
+	}
+	return h.Entries, nil
+}
+
+// commandLine describes the command for the journal, values
+// of flags that are private keys are not recorded
+func commandLine(cmd *cobra.Command, args []string) string {
+	parts := []string{cmd.CommandPath()}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		v := f.Value.String()
+		if _, err := nkeys.FromSeed([]byte(v)); err == nil || f.Name == "mnemonic" {
+			v = "[redacted]"
+		}
+		parts = append(parts, fmt.Sprintf("--%s=%s", f.Name, v))
+	})
+	parts = append(parts, args...)
+	return strings.Join(parts, " ")
+}
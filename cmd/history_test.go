@@ -0,0 +1,93 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func Test_HistoryRecordsCommands(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	ts.AddAccount(t, "B")
+
+	_, _, err := ExecuteCmd(createEditAccount(), "--name", "A", "--conns", "10")
+	require.NoError(t, err)
+
+	entries, err := ts.Store.Journal().Entries()
+	require.NoError(t, err)
+	last := entries[len(entries)-1]
+	require.Equal(t, store.KindAccount, last.Kind)
+	require.Equal(t, "A", last.Name)
+	require.Contains(t, last.Command, "--conns=10")
+	require.NotEmpty(t, last.User)
+	prev, err := ts.Store.Journal().ReadJwt(last.PreviousHash)
+	require.NoError(t, err)
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.NotEqual(t, ac.Limits.Conn, int64(-1))
+	raw, err := ts.Store.ReadRawAccountClaim("A")
+	require.NoError(t, err)
+	require.NotEqual(t, string(raw), string(prev))
+
+	_, out, err := ExecuteCmd(HoistRootFlags(createHistoryCmd()), "--account", "A", "--output", "json")
+	require.NoError(t, err)
+	var filtered []*store.JournalEntry
+	require.NoError(t, json.Unmarshal([]byte(out), &filtered))
+	require.NotEmpty(t, filtered)
+	for _, e := range filtered {
+		require.Equal(t, "A", e.Account)
+	}
+
+	_, out, err = ExecuteCmd(HoistRootFlags(createHistoryCmd()), "--account", "A", "--user", "U", "--output", "json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), &filtered))
+	require.Len(t, filtered, 1)
+	require.Equal(t, "U", filtered[0].Name)
+
+	since := fmt.Sprintf("%d", time.Now().Add(time.Hour).Unix())
+	_, stderr, err := ExecuteCmd(createHistoryCmd(), "--since", since)
+	require.NoError(t, err)
+	require.Contains(t, stderr, "No changes recorded")
+
+	_, stderr, err = ExecuteCmd(createHistoryCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, "store account B")
+}
+
+func Test_HistoryRedactsSeeds(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	seed, _, _ := CreateAccountKey(t)
+
+	cmd := createEditAccount()
+	cmd.Flags().StringVarP(&KeyPathFlag, "private-key", "K", "", "")
+	require.NoError(t, cmd.Flags().Set("private-key", string(seed)))
+	line := commandLine(cmd, nil)
+	require.NotContains(t, line, string(seed))
+	require.Contains(t, line, "--private-key=[redacted]")
+	KeyPathFlag = ""
+}
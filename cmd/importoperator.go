@@ -0,0 +1,281 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createImportOperatorCmd() *cobra.Command {
+	var params ImportOperatorParams
+	cmd := &cobra.Command{
+		Use:   "operator",
+		Short: "Import an operator from a bundle",
+		Long: fmt.Sprintf(`Import an operator from a bundle
+
+The operator, accounts and users in a bundle written by 'nsc export operator'
+are restored into the store root, and its seeds and creds into the keystore.
+The passphrase of a bundle with seeds is read from $%s or asked for.
+
+The operator is created if the store root doesn't have it. An operator with
+the same name and a different identity, or the same identity under another
+name, is an error. Files that differ from the ones in the store or keystore
+are conflicts: they are reported and nothing is imported unless --force is
+set, which overwrites them. Identical files are skipped.`, store.BundlePassphraseEnv),
+		Example: `nsc import operator --bundle op.tgz
+NKEYS_PATH=/team/keys nsc import operator --bundle a.tgz
+nsc import operator --bundle a.tgz --force`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := RunStoreLessAction(cmd, args, &params); err != nil {
+				return err
+			}
+			if DryRunFlag || GetConfig().Operator != "" {
+				return nil
+			}
+			return GetConfig().SetOperator(params.b.Manifest.Operator)
+		},
+	}
+	cmd.Flags().StringVarP(&params.bundle, "bundle", "", "", "bundle file to import")
+	cmd.Flags().BoolVarP(&params.force, "force", "F", false, "overwrite files that differ from the bundle")
+	cmd.MarkFlagRequired("bundle")
+	return cmd
+}
+
+func init() {
+	importCmd.AddCommand(createImportOperatorCmd())
+}
+
+type ImportOperatorParams struct {
+	bundle string
+	force  bool
+	b      *store.Bundle
+	// dir of the operator in the store root
+	dir string
+	// exists is true if the store root has the operator
+	exists    bool
+	conflicts []string
+}
+
+func (p *ImportOperatorParams) SetDefaults(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ImportOperatorParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ImportOperatorParams) Load(ctx ActionCtx) error {
+	var err error
+	if p.bundle, err = Expand(p.bundle); err != nil {
+		return err
+	}
+	f, err := os.Open(p.bundle)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p.b, err = store.ReadBundle(f, func() (string, error) {
+		return bundlePassphrase(false)
+	})
+	if err != nil {
+		return fmt.Errorf("error reading %#q: %v", p.bundle, err)
+	}
+	return nil
+}
+
+func (p *ImportOperatorParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+// credsEntity splits <account>/<user>.creds
+func credsEntity(rel string) (string, string, bool) {
+	parts := strings.Split(rel, "/")
+	if len(parts) != 2 || !strings.HasSuffix(parts[1], store.CredsExtension) {
+		return "", "", false
+	}
+	return parts[0], strings.TrimSuffix(parts[1], store.CredsExtension), true
+}
+
+// differs returns true if the file exists with other content
+func differs(fp string, data []byte) bool {
+	d, err := store.ReadFile(fp)
+	return err == nil && !bytes.Equal(bytes.TrimSpace(d), bytes.TrimSpace(data))
+}
+
+func (p *ImportOperatorParams) Validate(ctx ActionCtx) error {
+	m := p.b.Manifest
+	root := GetConfig().StoreRoot
+	if root == "" {
+		return errors.New("no store root is set")
+	}
+	if m.Operator == "" || m.Operator == ".." || m.Operator != filepath.Base(m.Operator) || !nkeys.IsValidPublicOperatorKey(m.Subject) {
+		return errors.New("bundle manifest has an invalid operator")
+	}
+	for _, n := range GetConfig().ListOperators() {
+		s, err := store.LoadStore(filepath.Join(root, n))
+		if err != nil {
+			continue
+		}
+		oc, err := s.ReadOperatorClaim()
+		if err != nil {
+			continue
+		}
+		if n == m.Operator && oc.Subject != m.Subject {
+			return fmt.Errorf("operator %q in %#q is %s, the bundle has %s", n, AbbrevHomePaths(root), oc.Subject, m.Subject)
+		}
+		if n != m.Operator && oc.Subject == m.Subject {
+			return fmt.Errorf("operator %s of the bundle is in %#q as %q", m.Subject, AbbrevHomePaths(root), n)
+		}
+	}
+	p.dir = filepath.Join(root, m.Operator)
+	if _, err := os.Stat(filepath.Join(p.dir, store.NSCFile)); err == nil {
+		p.exists = true
+	} else if infos, err := ioutil.ReadDir(p.dir); err == nil && len(infos) > 0 {
+		return fmt.Errorf("%#q exists and is not an operator", AbbrevHomePaths(p.dir))
+	}
+	if _, ok := p.b.Store[store.NSCFile]; !ok {
+		return fmt.Errorf("bundle doesn't have the %s file", store.NSCFile)
+	}
+	if d, ok := p.b.Store[store.JwtName(m.Operator)]; !ok {
+		return errors.New("bundle doesn't have the operator jwt")
+	} else if oc, err := jwt.DecodeOperatorClaims(string(d)); err != nil || oc.Subject != m.Subject {
+		return errors.New("bundle operator jwt doesn't match its manifest")
+	}
+
+	for _, n := range p.b.StoreFiles() {
+		if differs(filepath.Join(p.dir, filepath.FromSlash(n)), p.b.Store[n]) {
+			p.conflicts = append(p.conflicts, filepath.Join(AbbrevHomePaths(p.dir), filepath.FromSlash(n)))
+		}
+	}
+	for _, pk := range p.b.KeyNames() {
+		kp, err := nkeys.FromSeed(p.b.Keys[pk])
+		if err != nil {
+			return fmt.Errorf("bundle seed for %s is invalid: %v", pk, err)
+		}
+		if k, err := kp.PublicKey(); err != nil || k != pk {
+			return fmt.Errorf("bundle seed for %s is for another key", pk)
+		}
+	}
+	ks := store.NewKeyStore(m.Operator)
+	for _, n := range p.b.CredsFiles() {
+		a, u, ok := credsEntity(n)
+		if !ok {
+			return fmt.Errorf("bundle creds %q have an invalid path", n)
+		}
+		if differs(ks.CalcUserCredsPath(a, u), p.b.Creds[n]) {
+			p.conflicts = append(p.conflicts, AbbrevHomePaths(ks.CalcUserCredsPath(a, u)))
+		}
+	}
+	if len(p.conflicts) > 0 && !p.force {
+		return fmt.Errorf("%d file(s) differ from the bundle - use --force to overwrite them:\n  %s",
+			len(p.conflicts), strings.Join(p.conflicts, "\n  "))
+	}
+	return nil
+}
+
+func (p *ImportOperatorParams) Run(ctx ActionCtx) (store.Status, error) {
+	m := p.b.Manifest
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+
+	// the store info is written first, so the directory is a store
+	s := &store.Store{Dir: p.dir}
+	files := p.b.StoreFiles()
+	for i, n := range files {
+		if n == store.NSCFile {
+			files[0], files[i] = files[i], files[0]
+		}
+	}
+	written := 0
+	for _, n := range files {
+		fp := filepath.Join(p.dir, filepath.FromSlash(n))
+		if d, err := store.ReadFile(fp); err == nil && bytes.Equal(bytes.TrimSpace(d), bytes.TrimSpace(p.b.Store[n])) {
+			continue
+		}
+		if err := s.Write(p.b.Store[n], strings.Split(n, "/")...); err != nil {
+			return nil, err
+		}
+		written++
+	}
+	if !store.IsDryRun() {
+		if err := os.MkdirAll(s.Resolve(store.Accounts), 0700); err != nil {
+			return nil, err
+		}
+	}
+	s, err := store.LoadStore(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	what := "operator"
+	if m.IsPartial() {
+		what = fmt.Sprintf("account(s) %s of operator", strings.Join(m.Accounts, ", "))
+	}
+	verb := "imported"
+	if p.exists {
+		verb = "updated"
+	}
+	r.AddOK("%s %s %q in %#q with %d file(s)", verb, what, m.Operator, AbbrevHomePaths(p.dir), written)
+	for _, c := range p.conflicts {
+		r.AddWarning("overwrote %#q", c)
+	}
+
+	ks := store.NewKeyStore(m.Operator)
+	ks.Journal = s.Journal()
+	stored := 0
+	for _, pk := range p.b.KeyNames() {
+		if ks.HasPrivateKey(pk) {
+			continue
+		}
+		kp, err := nkeys.FromSeed(p.b.Keys[pk])
+		if err != nil {
+			return r, err
+		}
+		if _, err := ks.Store(kp); err != nil {
+			return r, err
+		}
+		stored++
+	}
+	if len(p.b.Keys) > 0 {
+		r.AddOK("stored %d of %d seed(s) in %#q", stored, len(p.b.Keys), AbbrevHomePaths(store.GetKeysDir()))
+	}
+	stored = 0
+	for _, n := range p.b.CredsFiles() {
+		a, u, _ := credsEntity(n)
+		if _, err := ks.MaybeStoreUserCreds(a, u, p.b.Creds[n]); err != nil {
+			r.AddError("creds for user %q in account %q: %v", u, a, err)
+			continue
+		}
+		stored++
+	}
+	if len(p.b.Creds) > 0 {
+		r.AddOK("stored %d of %d creds", stored, len(p.b.Creds))
+	}
+	return r, nil
+}
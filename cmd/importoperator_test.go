@@ -0,0 +1,141 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+// moveToNewStore points the store root and the keystore to new directories
+func moveToNewStore(t *testing.T, ts *TestStore, name string) (string, string) {
+	root := filepath.Join(ts.Dir, name, "store")
+	keys := filepath.Join(ts.Dir, name, "keys")
+	require.NoError(t, os.MkdirAll(root, 0700))
+	require.NoError(t, os.MkdirAll(keys, 0700))
+	require.NoError(t, ForceStoreRoot(t, root))
+	ForceOperator(t, "")
+	require.NoError(t, os.Setenv(store.NKeysPathEnv, keys))
+	return root, keys
+}
+
+func Test_ImportOperatorBundle(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	opk := ts.GetOperatorPublicKey(t)
+	upk := ts.GetUserPublicKey(t, "A", "U")
+	require.NoError(t, os.Setenv(store.BundlePassphraseEnv, "secret"))
+	defer os.Unsetenv(store.BundlePassphraseEnv)
+
+	fp := filepath.Join(ts.Dir, "op.tgz")
+	_, _, err := ExecuteCmd(createExportOperatorCmd(), "--bundle", fp, "--include-keys")
+	require.NoError(t, err)
+
+	root, _ := moveToNewStore(t, ts, "other")
+	_, stderr, err := ExecuteCmd(createImportOperatorCmd(), "--bundle", fp)
+	require.NoError(t, err)
+	require.Contains(t, stderr, "imported operator \"O\"")
+	require.Equal(t, "O", GetConfig().Operator)
+
+	s, err := store.LoadStore(filepath.Join(root, "O"))
+	require.NoError(t, err)
+	uc, err := s.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+	require.Equal(t, upk, uc.Subject)
+	require.FileExists(t, store.GetKeyPath(opk))
+	require.FileExists(t, store.GetKeyPath(upk))
+	ks := store.NewKeyStore("O")
+	require.FileExists(t, ks.CalcUserCredsPath("A", "U"))
+
+	// importing again changes nothing
+	_, stderr, err = ExecuteCmd(createImportOperatorCmd(), "--bundle", fp)
+	require.NoError(t, err)
+	require.Contains(t, stderr, "updated operator \"O\"")
+	require.Contains(t, stderr, "with 0 file(s)")
+
+	// a changed file is a conflict
+	jp := filepath.Join(root, "O", store.Accounts, "A", "A.jwt")
+	require.NoError(t, ioutil.WriteFile(jp, []byte("changed"), 0600))
+	_, stderr, err = ExecuteCmd(createImportOperatorCmd(), "--bundle", fp)
+	require.Error(t, err)
+	require.Contains(t, stderr, "use --force to overwrite them")
+	d, err := ioutil.ReadFile(jp)
+	require.NoError(t, err)
+	require.Equal(t, "changed", string(d))
+
+	_, stderr, err = ExecuteCmd(createImportOperatorCmd(), "--bundle", fp, "--force")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "overwrote")
+	_, err = s.ReadAccountClaim("A")
+	require.NoError(t, err)
+}
+
+func Test_ImportOperatorBundleOperatorConflict(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	fp := filepath.Join(ts.Dir, "op.tgz")
+	_, _, err := ExecuteCmd(createExportOperatorCmd(), "--bundle", fp)
+	require.NoError(t, err)
+
+	// another operator with the same name
+	moveToNewStore(t, ts, "other")
+	_, _, err = ExecuteCmd(CreateAddOperatorCmd(), "--name", "O")
+	require.NoError(t, err)
+	_, stderr, err := ExecuteCmd(createImportOperatorCmd(), "--bundle", fp)
+	require.Error(t, err)
+	require.Contains(t, stderr, "the bundle has")
+
+	// the same operator under another name
+	root, _ := moveToNewStore(t, ts, "renamed")
+	require.NoError(t, os.Rename(filepath.Join(ts.GetStoresRoot(), "O"), filepath.Join(root, "P")))
+	_, stderr, err = ExecuteCmd(createImportOperatorCmd(), "--bundle", fp)
+	require.Error(t, err)
+	require.Contains(t, stderr, "as \"P\"")
+}
+
+func Test_ImportOperatorPartialBundle(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	ts.AddAccount(t, "B")
+	require.NoError(t, os.Setenv(store.BundlePassphraseEnv, "secret"))
+	defer os.Unsetenv(store.BundlePassphraseEnv)
+	fp := filepath.Join(ts.Dir, "a.tgz")
+	_, _, err := ExecuteCmd(createExportOperatorCmd(), "--bundle", fp, "--account", "A", "--include-keys")
+	require.NoError(t, err)
+	opk := ts.GetOperatorPublicKey(t)
+
+	root, _ := moveToNewStore(t, ts, "team")
+	_, stderr, err := ExecuteCmd(createImportOperatorCmd(), "--bundle", fp)
+	require.NoError(t, err)
+	require.Contains(t, stderr, "account(s) A of operator \"O\"")
+	s, err := store.LoadStore(filepath.Join(root, "O"))
+	require.NoError(t, err)
+	require.True(t, s.HasAccount("A"))
+	require.False(t, s.HasAccount("B"))
+	require.NoFileExists(t, store.GetKeyPath(opk))
+	ks := store.NewKeyStore("O")
+	require.FileExists(t, ks.CalcUserCredsPath("A", "U"))
+}
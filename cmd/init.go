@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -60,6 +61,7 @@ func createInitCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&params.Name, "name", "n", "", "name used for the operator, account and user")
 	cmd.Flags().StringVarP(&params.AccountServerURL, "url", "u", "", "operator account server url")
 	cmd.Flags().StringVarP(&params.ManagedOperatorName, "remote-operator", "o", "", "remote well-known operator")
+	params.Mnemonic.BindFlags(cmd)
 	HoistRootFlags(cmd)
 	return cmd
 }
@@ -88,6 +90,7 @@ type InitCmdParams struct {
 	Store            *store.Store
 	ServiceURLs      jwt.StringList
 	DebugOperatorURL string
+	Mnemonic         MnemonicParams
 }
 
 func (p *InitCmdParams) init(cmd *cobra.Command) error {
@@ -243,8 +246,15 @@ func (p *InitCmdParams) createStore(cmd *cobra.Command) error {
 	var onk store.NamedKey
 	onk.Name = p.Name
 
+	if !p.CreateOperator && p.Mnemonic.Enabled() {
+		return errors.New("keys can only be derived from a mnemonic when creating an operator")
+	}
+	if err := p.Mnemonic.Resolve(); err != nil {
+		return err
+	}
+
 	if p.CreateOperator {
-		p.Operator.KP, err = nkeys.CreateOperator()
+		p.Operator.KP, err = p.Mnemonic.KeyGenerator()(store.DerivedOperatorKey, nkeys.PrefixByteOperator)
 		if err != nil {
 			return err
 		}
@@ -304,18 +314,18 @@ func (p *InitCmdParams) createStore(cmd *cobra.Command) error {
 	return GetConfig().Save()
 }
 
-func createSystemAccount(s *store.Context, opKp nkeys.KeyPair) (*keys, *keys, error) {
+func createSystemAccount(s *store.Context, opKp nkeys.KeyPair, gen keyGenerator) (*keys, *keys, error) {
 	var acc keys
 	var sig keys
 	var usr keys
 	var err error
 	// create system account, signed by this operator
-	if acc.KP, err = nkeys.CreateAccount(); err != nil {
+	if acc.KP, err = gen(store.DerivedSystemAccountKey, nkeys.PrefixByteAccount); err != nil {
 		return nil, nil, err
 	} else if acc.PubKey, err = acc.KP.PublicKey(); err != nil {
 		return nil, nil, err
 	}
-	if sig.KP, err = nkeys.CreateAccount(); err != nil {
+	if sig.KP, err = gen(store.DerivedSystemAccountSigningKey, nkeys.PrefixByteAccount); err != nil {
 		return nil, nil, err
 	} else if sig.PubKey, err = sig.KP.PublicKey(); err != nil {
 		return nil, nil, err
@@ -353,7 +363,7 @@ func createSystemAccount(s *store.Context, opKp nkeys.KeyPair) (*keys, *keys, er
 		return nil, nil, err
 	}
 	// create system account user and creds
-	if usr.KP, err = nkeys.CreateUser(); err != nil {
+	if usr.KP, err = gen(store.DerivedSystemUserKey, nkeys.PrefixByteUser); err != nil {
 		return nil, nil, err
 	} else if usr.PubKey, err = usr.KP.PublicKey(); err != nil {
 		return nil, nil, err
@@ -381,7 +391,7 @@ func createSystemAccount(s *store.Context, opKp nkeys.KeyPair) (*keys, *keys, er
 func (p *InitCmdParams) setOperatorDefaults(ctx ActionCtx) error {
 	ctx.StoreCtx()
 	if p.CreateOperator {
-		if acc, usr, err := createSystemAccount(ctx.StoreCtx(), p.Operator.KP); err != nil {
+		if acc, usr, err := createSystemAccount(ctx.StoreCtx(), p.Operator.KP, p.Mnemonic.KeyGenerator()); err != nil {
 			return err
 		} else {
 			p.SystemAccount = *acc
@@ -485,6 +495,7 @@ func (p *InitCmdParams) Run(ctx ActionCtx) (store.Status, error) {
 			return nil, err
 		}
 		r.AddOK("created operator %s", p.Name)
+		p.Mnemonic.AddToReport(r)
 		r.AddOK("created system_account: name:SYS id:%s", p.SystemAccount.PubKey)
 		r.AddOK("created system account user: name:sys id:%s", p.SystemUser.PubKey)
 		r.AddOK("system account user creds file stored in %#q", AbbrevHomePaths(p.SystemUser.CredsPath))
@@ -289,18 +289,68 @@ func (k *Key) HasKey() bool {
 type Keys struct {
 	KeyList
 	MessageFn func(ks Keys) string
+	JsonFn    func(ks Keys) (interface{}, error)
 }
 
 func (keys Keys) Message() string {
 	return keys.MessageFn(keys)
 }
 
+func (keys Keys) JsonDocument() (interface{}, error) {
+	if keys.JsonFn != nil {
+		return keys.JsonFn(keys)
+	}
+	return keys.KeyList.Documents(), nil
+}
+
+// KeyDocument is the json representation of a Key
+type KeyDocument struct {
+	Entity       string `json:"entity"`
+	Parent       string `json:"parent,omitempty"`
+	Kind         string `json:"kind"`
+	PublicKey    string `json:"public_key"`
+	Seed         string `json:"seed,omitempty"`
+	Signing      bool   `json:"signing_key"`
+	Stored       bool   `json:"stored"`
+	Invalid      bool   `json:"invalid,omitempty"`
+	Unreferenced bool   `json:"unreferenced,omitempty"`
+
+	Metadata *store.KeyMetadata `json:"metadata,omitempty"`
+}
+
+func (k *Key) Document() *KeyDocument {
+	kind := k.ExpectedKind
+	if kind == 0 {
+		kind = nkeys.Prefix(k.Pub)
+	}
+	m, _ := store.ReadKeyMetadata(k.Pub)
+	return &KeyDocument{
+		Metadata:     m,
+		Entity:       k.Name,
+		Parent:       k.Parent,
+		Kind:         kind.String(),
+		PublicKey:    k.Pub,
+		Signing:      k.Signing,
+		Stored:       k.HasKey(),
+		Invalid:      k.Invalid,
+		Unreferenced: k.Name == "?",
+	}
+}
+
 type KeyList []*Key
 
 func (ks KeyList) Code() store.StatusCode {
 	return store.OK
 }
 
+func (ks KeyList) Documents() []*KeyDocument {
+	docs := make([]*KeyDocument, 0, len(ks))
+	for _, k := range ks {
+		docs = append(docs, k.Document())
+	}
+	return docs
+}
+
 func (ks KeyList) Len() int {
 	return len(ks)
 }
@@ -16,6 +16,8 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/kbehouse/nsc/cmd/store"
 	"github.com/spf13/cobra"
 )
@@ -35,6 +37,10 @@ func createMigrateKeysCmd() *cobra.Command {
 		Use:   "migrate",
 		Short: "migrates keystore to new layout, original keystore is preserved",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			// the keystore directories are renamed, which a dry-run can't capture
+			if DryRunFlag {
+				return fmt.Errorf("%s doesn't support --dry-run", cmd.CommandPath())
+			}
 			migration, err := store.KeysNeedMigration()
 			if err != nil {
 				return err
@@ -0,0 +1,257 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createKeysAgentCmd() *cobra.Command {
+	var params KeysAgentParams
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Run a signing agent holding keys from the keystore in memory",
+		Long: fmt.Sprintf(`Run a signing agent holding keys from the keystore in memory
+
+The agent loads the selected seeds from the keystore, unlocking them once,
+and signs on behalf of other nsc invocations that have $%s set
+to the agent's socket. The seeds can then be removed from disk for the
+duration of the session.
+
+Keys are selected with --key, which takes a public key optionally followed
+by options separated by commas:
+
+  lifetime=<duration>  forget the key after the duration
+  confirm              ask on the agent's terminal before every signature
+
+Without --key, the operator's identity and signing keys found in the
+keystore are loaded. The agent runs until interrupted.
+
+Without --sock, the socket is created in a new directory that only the
+user can access.`, store.AgentSockEnv),
+		Example: `nsc keys agent
+nsc keys agent --lifetime 1h
+nsc keys agent --key OABC...,confirm --key ADEF...,lifetime=15m
+nsc keys agent --sock ~/.nsc-agent.sock
+export NSC_AGENT_SOCK=~/.nsc-agent.sock`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunMaybeStorelessAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.sock, "sock", "", "", "path to the unix socket the agent listens on")
+	cmd.Flags().StringSliceVarP(&params.specs, "key", "", nil, "public key to load, optionally followed by ',lifetime=<duration>' and ',confirm' (can be specified multiple times)")
+	cmd.Flags().DurationVarP(&params.lifetime, "lifetime", "", 0, "default lifetime of the loaded keys (0 keeps them until the agent exits)")
+	cmd.Flags().BoolVarP(&params.confirm, "confirm", "", false, "by default ask before every signature")
+	return cmd
+}
+
+func init() {
+	keysCmd.AddCommand(createKeysAgentCmd())
+}
+
+type agentKeySpec struct {
+	publicKey string
+	lifetime  time.Duration
+	confirm   bool
+}
+
+// parseAgentKeySpec parses `<pubkey>[,lifetime=<duration>][,confirm]`
+func parseAgentKeySpec(v string, lifetime time.Duration, confirm bool) (*agentKeySpec, error) {
+	parts := strings.Split(v, ",")
+	s := &agentKeySpec{publicKey: strings.TrimSpace(parts[0]), lifetime: lifetime, confirm: confirm}
+	if !nkeys.IsValidPublicKey(s.publicKey) {
+		return nil, fmt.Errorf("%q is not a valid public key", s.publicKey)
+	}
+	for _, o := range parts[1:] {
+		o = strings.TrimSpace(o)
+		switch {
+		case o == "confirm":
+			s.confirm = true
+		case strings.HasPrefix(o, "lifetime="):
+			d, err := time.ParseDuration(strings.TrimPrefix(o, "lifetime="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid lifetime for %s: %v", s.publicKey, err)
+			}
+			s.lifetime = d
+		default:
+			return nil, fmt.Errorf("unknown key option %q", o)
+		}
+	}
+	return s, nil
+}
+
+type KeysAgentParams struct {
+	sock     string
+	specs    []string
+	lifetime time.Duration
+	confirm  bool
+	keys     []*agentKeySpec
+	agent    *store.Agent
+}
+
+// LongRunning - the agent runs until it is interrupted
+func (p *KeysAgentParams) LongRunning() {}
+
+func (p *KeysAgentParams) SetDefaults(ctx ActionCtx) error {
+	for _, v := range p.specs {
+		s, err := parseAgentKeySpec(v, p.lifetime, p.confirm)
+		if err != nil {
+			return err
+		}
+		p.keys = append(p.keys, s)
+	}
+	if len(p.keys) == 0 && ctx.StoreCtx().Store != nil {
+		oc, err := ctx.StoreCtx().Store.ReadOperatorClaim()
+		if err != nil {
+			return err
+		}
+		keys := append([]string{oc.Subject}, oc.SigningKeys...)
+		for _, k := range keys {
+			if ctx.StoreCtx().KeyStore.HasPrivateKey(k) {
+				p.keys = append(p.keys, &agentKeySpec{publicKey: k, lifetime: p.lifetime, confirm: p.confirm})
+			}
+		}
+	}
+	return nil
+}
+
+func (p *KeysAgentParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysAgentParams) Load(ctx ActionCtx) error {
+	if len(p.keys) == 0 {
+		return nil
+	}
+	p.agent = store.NewAgent()
+	p.agent.ConfirmFn = func(pk string) (bool, error) {
+		return cli.Confirm(fmt.Sprintf("sign with %s", pk), false)
+	}
+	ks := store.NewKeyStore("")
+	for _, k := range p.keys {
+		// read the seed from the keystore only, never from another agent
+		kp, err := ks.Read(store.GetKeyPath(k.publicKey))
+		if err != nil {
+			return err
+		}
+		if kp == nil {
+			return fmt.Errorf("the seed for %s is not in the keystore", k.publicKey)
+		}
+		if err := p.agent.Add(kp, k.lifetime, k.confirm); err != nil {
+			return fmt.Errorf("unable to load %s: %v", k.publicKey, err)
+		}
+	}
+	return nil
+}
+
+func (p *KeysAgentParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysAgentParams) Validate(ctx ActionCtx) error {
+	if len(p.keys) == 0 {
+		return errors.New("no keys to load - specify --key")
+	}
+	if p.sock == "" {
+		return nil
+	}
+	if _, err := os.Stat(p.sock); err == nil {
+		// a socket left behind by an agent that didn't exit cleanly is reused
+		if c, err := net.Dial("unix", p.sock); err == nil {
+			c.Close()
+			return fmt.Errorf("an agent is already listening on %#q", p.sock)
+		}
+		if err := os.Remove(p.sock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listenPrivate listens on a unix socket that is bound in a directory only
+// the user can access and then moved to its path, so that other users never
+// have a chance to connect to it
+func listenPrivate(sock string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(sock), ".nsc-agent-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	fp := filepath.Join(dir, "agent.sock")
+	ln, err := net.Listen("unix", fp)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(fp, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(fp, sock); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func (p *KeysAgentParams) Run(ctx ActionCtx) (store.Status, error) {
+	if p.sock == "" {
+		dir, err := ioutil.TempDir("", "nsc-agent-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		p.sock = filepath.Join(dir, "agent.sock")
+	}
+	ln, err := listenPrivate(p.sock)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(p.sock)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		if _, ok := <-sigCh; ok {
+			ln.Close()
+		}
+	}()
+
+	keys, _ := p.agent.PublicKeys()
+	ctx.CurrentCmd().Printf("agent holding %d key(s): %s\n", len(keys), strings.Join(keys, ", "))
+	ctx.CurrentCmd().Printf("export %s=%s\n", store.AgentSockEnv, p.sock)
+	if err := store.ServeSigner(ln, p.agent); err != nil && !errors.Is(err, net.ErrClosed) {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func Test_ParseAgentKeySpec(t *testing.T) {
+	_, pk, _ := CreateOperatorKey(t)
+	s, err := parseAgentKeySpec(pk, time.Hour, false)
+	require.NoError(t, err)
+	require.Equal(t, pk, s.publicKey)
+	require.Equal(t, time.Hour, s.lifetime)
+	require.False(t, s.confirm)
+
+	s, err = parseAgentKeySpec(pk+",lifetime=15m,confirm", time.Hour, false)
+	require.NoError(t, err)
+	require.Equal(t, 15*time.Minute, s.lifetime)
+	require.True(t, s.confirm)
+
+	_, err = parseAgentKeySpec("foo", 0, false)
+	require.Error(t, err)
+	_, err = parseAgentKeySpec(pk+",bar", 0, false)
+	require.Error(t, err)
+}
+
+func Test_KeysAgentMissingSeed(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	_, pk, _ := CreateOperatorKey(t)
+	_, _, err := ExecuteCmd(createKeysAgentCmd(), "--key", pk, "--sock", filepath.Join(ts.Dir, "agent.sock"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not in the keystore")
+}
+
+func Test_KeysAgentListenPrivate(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	dir := filepath.Join(ts.Dir, "agent")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	sock := filepath.Join(dir, "agent.sock")
+
+	l, err := listenPrivate(sock)
+	require.NoError(t, err)
+	defer l.Close()
+	fi, err := os.Stat(sock)
+	require.NoError(t, err)
+	require.True(t, fi.Mode()&os.ModeSocket != 0)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	// the private directory the socket was bound in is removed
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("unix", sock)
+	require.NoError(t, err)
+	c.Close()
+}
+
+func Test_KeysAgentSigns(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	agent := store.NewAgent()
+	require.NoError(t, agent.Add(ts.OperatorKey, 0, false))
+	sock := filepath.Join(ts.Dir, "agent.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer l.Close()
+	go store.ServeSigner(l, agent)
+	require.NoError(t, os.Setenv(store.AgentSockEnv, sock))
+	defer os.Unsetenv(store.AgentSockEnv)
+
+	// the seed is only in the agent
+	require.NoError(t, os.Remove(ts.OperatorKeyPath))
+	_, _, err = ExecuteCmd(CreateAddAccountCmd(), "--name", "A")
+	require.NoError(t, err)
+	opk, err := ts.OperatorKey.PublicKey()
+	require.NoError(t, err)
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Equal(t, opk, ac.Issuer)
+
+	KeyPathFlag = opk
+	defer func() { KeyPathFlag = "" }()
+	kp, err := ResolveKeyFlag()
+	require.NoError(t, err)
+	require.True(t, store.IsExternalKeyPair(kp))
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createKeysCombineCmd() *cobra.Command {
+	var params KeysCombineParams
+	cmd := &cobra.Command{
+		Use:   "combine",
+		Short: "Recover a seed split with 'nsc keys split' into the keystore",
+		Long: `Recover a seed split with 'nsc keys split' into the keystore
+
+The share files are read and their checksums verified. When at least the
+threshold of shares is provided, the seed is reconstructed and stored in the
+keystore if its public key is the operator's identity or one of its signing
+keys.`,
+		Example:      `nsc keys combine share-1.txt share-3.txt share-4.txt`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	return cmd
+}
+
+func init() {
+	keysCmd.AddCommand(createKeysCombineCmd())
+}
+
+type KeysCombineParams struct {
+	shares []*seedShare
+	claim  *jwt.OperatorClaims
+}
+
+func (p *KeysCombineParams) SetDefaults(ctx ActionCtx) error {
+	if len(ctx.Args()) == 0 {
+		ctx.CurrentCmd().SilenceUsage = false
+		return errors.New("specify the share files to combine")
+	}
+	return nil
+}
+
+func (p *KeysCombineParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysCombineParams) Load(ctx ActionCtx) error {
+	var err error
+	if p.claim, err = ctx.StoreCtx().Store.ReadOperatorClaim(); err != nil {
+		return err
+	}
+	for _, fp := range ctx.Args() {
+		d, err := ioutil.ReadFile(fp)
+		if err != nil {
+			return err
+		}
+		s, err := parseSeedShare(d)
+		if err != nil {
+			return fmt.Errorf("%#q: %v", fp, err)
+		}
+		p.shares = append(p.shares, s)
+	}
+	return nil
+}
+
+func (p *KeysCombineParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysCombineParams) Validate(ctx ActionCtx) error {
+	first := p.shares[0]
+	seen := make(map[int]bool)
+	for _, s := range p.shares {
+		if s.PublicKey != first.PublicKey || s.Total != first.Total || s.Threshold != first.Threshold {
+			return errors.New("the shares are not from the same split")
+		}
+		if seen[s.Index] {
+			return fmt.Errorf("share %d was provided more than once", s.Index)
+		}
+		seen[s.Index] = true
+	}
+	if len(p.shares) < first.Threshold {
+		return fmt.Errorf("%d shares are required to recover %s but only %d were provided", first.Threshold, first.PublicKey, len(p.shares))
+	}
+	if !isOperatorKey(p.claim, first.PublicKey) {
+		return fmt.Errorf("%q is not the identity or a signing key of operator %q", first.PublicKey, p.claim.Name)
+	}
+	return nil
+}
+
+func (p *KeysCombineParams) Run(ctx ActionCtx) (store.Status, error) {
+	pk := p.shares[0].PublicKey
+	var shares [][]byte
+	for _, s := range p.shares {
+		shares = append(shares, s.share())
+	}
+	seed, err := store.CombineShares(shares)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := nkeys.FromSeed(seed)
+	if err != nil {
+		return nil, fmt.Errorf("the shares don't recover a valid seed: %v", err)
+	}
+	defer kp.Wipe()
+	if !store.Match(pk, kp) {
+		return nil, fmt.Errorf("the shares don't recover the seed of %s", pk)
+	}
+	fp, err := ctx.StoreCtx().KeyStore.Store(kp)
+	if err != nil {
+		return nil, err
+	}
+	return store.OKStatus("recovered %s into %#q", pk, AbbrevHomePaths(fp)), nil
+}
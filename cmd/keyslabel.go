@@ -0,0 +1,183 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+	"github.com/xlab/tablewriter"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createKeysLabelCmd() *cobra.Command {
+	var params KeysLabelParams
+	cmd := &cobra.Command{
+		Use:   "label",
+		Short: "Set the label and purpose of a key",
+		Long: `Set the label and purpose of a key
+
+The metadata of a key is stored in a file next to the key in the keystore.
+Besides the label and purpose, it records the command that created the key,
+when and by which OS user. The label is shown by 'nsc list keys',
+'nsc describe account' and when selecting a signing key.
+
+Without --label or --purpose the metadata of the key is shown.`,
+		Example: `nsc keys label --key ABC... --label "ci signer" --purpose "signs the users of the ci pipeline"
+nsc keys label ABC...`,
+		Args:         MaxArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunMaybeStorelessAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.key, "key", "k", "", "public key to label")
+	cmd.Flags().StringVarP(&params.label, "label", "l", "", "label of the key")
+	cmd.Flags().StringVarP(&params.purpose, "purpose", "", "", "purpose of the key")
+	return cmd
+}
+
+func init() {
+	keysCmd.AddCommand(createKeysLabelCmd())
+}
+
+// describeKey returns the public key followed by its label or purpose, if
+// the key has metadata
+func describeKey(pk string) string {
+	m, err := store.ReadKeyMetadata(pk)
+	if err != nil || m.Description() == "" {
+		return pk
+	}
+	return fmt.Sprintf("%s (%s)", pk, m.Description())
+}
+
+type KeysLabelParams struct {
+	key      string
+	label    string
+	purpose  string
+	metadata *store.KeyMetadata
+}
+
+func (p *KeysLabelParams) SetDefaults(ctx ActionCtx) error {
+	p.key = nameFlagOrArgument(p.key, ctx.Args())
+	return nil
+}
+
+func (p *KeysLabelParams) PreInteractive(ctx ActionCtx) error {
+	var err error
+	if p.key == "" {
+		p.key, err = cli.Prompt("public key", "", cli.Val(func(v string) error {
+			if !nkeys.IsValidPublicKey(v) {
+				return errors.New("not a valid public key")
+			}
+			return nil
+		}))
+	}
+	return err
+}
+
+func (p *KeysLabelParams) Load(ctx ActionCtx) error {
+	if p.key == "" {
+		ctx.CurrentCmd().SilenceUsage = false
+		return errors.New("--key is required")
+	}
+	if !nkeys.IsValidPublicKey(p.key) {
+		return fmt.Errorf("%q is not a valid public key", p.key)
+	}
+	var err error
+	ks := ctx.StoreCtx().KeyStore
+	if _, err := store.Stat(store.GetKeyPath(p.key)); err != nil {
+		return fmt.Errorf("%s is not in the keystore", p.key)
+	}
+	if p.metadata, err = ks.GetKeyMetadata(p.key); err != nil {
+		return err
+	}
+	if p.metadata == nil {
+		p.metadata = &store.KeyMetadata{}
+	}
+	return nil
+}
+
+func (p *KeysLabelParams) PostInteractive(ctx ActionCtx) error {
+	var err error
+	if p.label == "" {
+		if p.label, err = cli.Prompt("label", p.metadata.Label); err != nil {
+			return err
+		}
+	}
+	if p.purpose == "" {
+		p.purpose, err = cli.Prompt("purpose", p.metadata.Purpose)
+	}
+	return err
+}
+
+func (p *KeysLabelParams) Validate(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysLabelParams) Run(ctx ActionCtx) (store.Status, error) {
+	if p.label == "" && p.purpose == "" {
+		return &KeyMetadataReport{PublicKey: p.key, KeyMetadata: p.metadata}, nil
+	}
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	if p.label != "" && p.label != p.metadata.Label {
+		p.metadata.Label = p.label
+		r.AddOK("set label of %s to %q", p.key, p.label)
+	}
+	if p.purpose != "" && p.purpose != p.metadata.Purpose {
+		p.metadata.Purpose = p.purpose
+		r.AddOK("set purpose of %s to %q", p.key, p.purpose)
+	}
+	if len(r.Details) == 0 {
+		r.AddOK("metadata of %s is unchanged", p.key)
+		return r, nil
+	}
+	if err := ctx.StoreCtx().KeyStore.StoreKeyMetadata(p.key, p.metadata); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// KeyMetadataReport shows the metadata of a key
+type KeyMetadataReport struct {
+	PublicKey string `json:"public_key"`
+	*store.KeyMetadata
+}
+
+func (r *KeyMetadataReport) Code() store.StatusCode {
+	return store.OK
+}
+
+func (r *KeyMetadataReport) Message() string {
+	table := tablewriter.CreateTable()
+	table.AddTitle("Key Metadata")
+	table.AddRow("Key", r.PublicKey)
+	table.AddRow("Label", r.Label)
+	table.AddRow("Purpose", r.Purpose)
+	table.AddRow("Created By", r.Command)
+	table.AddRow("Created", RenderDate(r.Created))
+	table.AddRow("OS User", r.User)
+	return table.Render()
+}
+
+func (r *KeyMetadataReport) JsonDocument() (interface{}, error) {
+	return r, nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func Test_KeysLabel(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	_, _, err := ExecuteCmd(createEditAccount(), "--name", "A", "--sk", "generate")
+	require.NoError(t, err)
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	sk := ac.SigningKeys.Keys()[0]
+
+	// the creation of the key was recorded
+	m, err := ts.KeyStore.GetKeyMetadata(sk)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	require.NotZero(t, m.Created)
+	require.Empty(t, m.Label)
+	entries, err := ts.Store.Journal().Entries()
+	require.NoError(t, err)
+	journaled := false
+	for _, e := range entries {
+		if e.Op == store.JournalStoreKey && e.Name == sk {
+			journaled = true
+		}
+	}
+	require.True(t, journaled)
+
+	_, stderr, err := ExecuteCmd(createKeysLabelCmd(), "--key", sk, "--label", "ci signer", "--purpose", "signs ci users")
+	require.NoError(t, err)
+	require.Contains(t, stderr, `set label of `+sk+` to "ci signer"`)
+	m, err = ts.KeyStore.GetKeyMetadata(sk)
+	require.NoError(t, err)
+	require.Equal(t, "ci signer", m.Label)
+	require.Equal(t, "signs ci users", m.Purpose)
+	require.NotZero(t, m.Created)
+
+	_, stderr, err = ExecuteCmd(createKeysLabelCmd(), sk)
+	require.NoError(t, err)
+	require.Contains(t, stderr, "signs ci users")
+
+	_, stderr, err = ExecuteCmd(createListKeysCmd(), "--accounts")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "ci signer")
+
+	stdout, _, err := ExecuteCmd(createDescribeAccountCmd(), "--name", "A")
+	require.NoError(t, err)
+	require.Contains(t, stdout, sk+" (ci signer)")
+}
+
+func Test_KeysLabelRequiresStoredKey(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	_, pk, _ := CreateAccountKey(t)
+	_, _, err := ExecuteCmd(createKeysLabelCmd(), "--key", pk, "--label", "x")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not in the keystore")
+}
+
+func Test_KeysRemoveDeletesMetadata(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	_, pk, kp := CreateAccountKey(t)
+	_, err := ts.KeyStore.Store(kp)
+	require.NoError(t, err)
+	m, err := ts.KeyStore.GetKeyMetadata(pk)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+
+	require.NoError(t, ts.KeyStore.Remove(pk))
+	m, err = ts.KeyStore.GetKeyMetadata(pk)
+	require.NoError(t, err)
+	require.Nil(t, m)
+}
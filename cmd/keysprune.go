@@ -0,0 +1,264 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createKeysPruneCmd() *cobra.Command {
+	var params KeysPruneParams
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Move unreferenced keys and creds of deleted users into a quarantine",
+		Long: `Move unreferenced keys and creds of deleted users into a quarantine
+
+Keys that are not referenced by the JWTs of any operator in the store root,
+and creds files of users or accounts that no longer exist, are moved into a
+timestamped directory under the keystore's quarantine directory. Use
+'nsc keys restore' to move them back.
+
+With --older-than only files that were not modified within the duration are
+pruned.`,
+		Example: `nsc keys prune
+nsc keys prune --older-than 720h
+nsc keys prune --dry-run`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunMaybeStorelessAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().DurationVarP(&params.olderThan, "older-than", "", 0, "only prune files not modified within the duration")
+	return cmd
+}
+
+func init() {
+	keysCmd.AddCommand(createKeysPruneCmd())
+}
+
+type KeysPruneParams struct {
+	olderThan time.Duration
+	// public keys named by the JWTs of all operators
+	keys map[string]bool
+	// creds paths relative to the creds dir of the existing users
+	users map[string]bool
+	// operator names of the keystore's creds dirs
+	operators map[string]bool
+}
+
+func (p *KeysPruneParams) SetDefaults(ctx ActionCtx) error {
+	p.keys = make(map[string]bool)
+	p.users = make(map[string]bool)
+	p.operators = make(map[string]bool)
+	return nil
+}
+
+func (p *KeysPruneParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+// collectKeyReferences returns the public keys named by the JWTs of an
+// operator, its accounts and their users
+func collectKeyReferences(s *store.Store) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	reference := func(pks ...string) {
+		for _, k := range pks {
+			if k != "" {
+				keys[k] = true
+			}
+		}
+	}
+	oc, err := s.ReadOperatorClaim()
+	if err != nil {
+		return nil, err
+	}
+	reference(oc.Subject, oc.Issuer, oc.SystemAccount)
+	reference(oc.SigningKeys...)
+
+	accounts, err := s.ListSubContainers(store.Accounts)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		ac, err := s.ReadAccountClaim(a)
+		if err != nil {
+			return nil, err
+		}
+		reference(ac.Subject, ac.Issuer)
+		reference(ac.SigningKeys.Keys()...)
+		for _, im := range ac.Imports {
+			reference(im.Account)
+			if act, err := jwt.DecodeActivationClaims(im.Token); err == nil {
+				reference(act.Issuer, act.IssuerAccount)
+			}
+		}
+		users, err := s.ListEntries(store.Accounts, a, store.Users)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			uc, err := s.ReadUserClaim(a, u)
+			if err != nil {
+				return nil, err
+			}
+			reference(uc.Subject, uc.Issuer, uc.IssuerAccount)
+		}
+	}
+	return keys, nil
+}
+
+// loadOperator records the keys referenced by an operator and the creds
+// of its users
+func (p *KeysPruneParams) loadOperator(s *store.Store) error {
+	keys, err := collectKeyReferences(s)
+	if err != nil {
+		return err
+	}
+	for k := range keys {
+		p.keys[k] = true
+	}
+	p.operators[s.Info.Name] = true
+	accounts, err := s.ListSubContainers(store.Accounts)
+	if err != nil {
+		return err
+	}
+	for _, a := range accounts {
+		users, err := s.ListEntries(store.Accounts, a, store.Users)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			p.users[filepath.Join(s.Info.Name, a, u+store.CredsExtension)] = true
+		}
+	}
+	return nil
+}
+
+func (p *KeysPruneParams) Load(ctx ActionCtx) error {
+	config := GetConfig()
+	operators := config.ListOperators()
+	if len(operators) == 0 {
+		return fmt.Errorf("no operators found in %#q - nothing can be determined to be unreferenced", AbbrevHomePaths(config.StoreRoot))
+	}
+	for _, o := range operators {
+		s, err := config.LoadStore(o)
+		if err != nil {
+			return fmt.Errorf("unable to load operator %q: %v", o, err)
+		}
+		if err := p.loadOperator(s); err != nil {
+			return fmt.Errorf("unable to read operator %q: %v", o, err)
+		}
+	}
+	return nil
+}
+
+func (p *KeysPruneParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysPruneParams) Validate(ctx ActionCtx) error {
+	if p.olderThan < 0 {
+		return errors.New("--older-than cannot be negative")
+	}
+	return nil
+}
+
+// orphanedCreds returns true if the creds belong to a user or account that
+// was deleted from a known operator. Creds of operators that aren't in the
+// store root are left alone.
+func (p *KeysPruneParams) orphanedCreds(fp string) bool {
+	rel, err := filepath.Rel(filepath.Join(store.GetKeysDir(), store.CredsDir), fp)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	if len(parts) != 3 || !p.operators[parts[0]] {
+		return false
+	}
+	return !p.users[rel]
+}
+
+func (p *KeysPruneParams) Run(ctx ActionCtx) (store.Status, error) {
+	ks := ctx.StoreCtx().KeyStore
+	now := time.Now()
+
+	var candidates []string
+	keys, err := ks.AllKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if !p.keys[k] {
+			candidates = append(candidates, store.GetKeyPath(k))
+		}
+	}
+	creds, err := ks.AllCreds()
+	if err != nil {
+		return nil, err
+	}
+	for _, fp := range creds {
+		if p.orphanedCreds(fp) {
+			candidates = append(candidates, fp)
+		}
+	}
+
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	if len(candidates) == 0 {
+		r.AddOK("no unreferenced keys or orphaned creds found")
+		return r, nil
+	}
+
+	name := ks.NewQuarantine(now)
+	var movedKeys, movedCreds, kept int
+	for _, fp := range candidates {
+		if p.olderThan > 0 {
+			if fi, err := store.Stat(fp); err == nil && now.Sub(fi.ModTime()) < p.olderThan {
+				kept++
+				continue
+			}
+		}
+		if _, err := ks.Quarantine(name, fp); err != nil {
+			r.AddError("unable to quarantine %#q: %v", AbbrevHomePaths(fp), err)
+			continue
+		}
+		if filepath.Ext(fp) == store.NKeyExtension {
+			movedKeys++
+			r.AddOK("quarantined key %s", strings.TrimSuffix(filepath.Base(fp), store.NKeyExtension))
+		} else {
+			movedCreds++
+			r.AddOK("quarantined creds %#q", AbbrevHomePaths(fp))
+		}
+	}
+	if kept > 0 {
+		r.AddOK("kept %d unreferenced file(s) modified within %v", kept, p.olderThan)
+	}
+	if movedKeys+movedCreds > 0 {
+		r.AddOK("moved %d key(s) and %d creds file(s) to %#q - restore them with 'nsc keys restore --name %s'",
+			movedKeys, movedCreds, AbbrevHomePaths(filepath.Join(store.GetQuarantineDir(), name)), name)
+	}
+	return r, nil
+}
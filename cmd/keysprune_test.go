@@ -0,0 +1,100 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func Test_KeysPruneRestore(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+
+	// a key of another operator is referenced
+	ts.AddOperator(t, "P")
+	ts.AddAccount(t, "B")
+	bpk := ts.GetAccountPublicKey(t, "B")
+	ts.SwitchOperator(t, "O")
+
+	_, orphan, kp := CreateAccountKey(t)
+	_, err := ts.KeyStore.Store(kp)
+	require.NoError(t, err)
+	creds := ts.KeyStore.CalcUserCredsPath("A", "gone")
+	require.NoError(t, store.Write(creds, []byte("creds")))
+
+	_, stderr, err := ExecuteCmd(createKeysPruneCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, "quarantined key "+orphan)
+	require.Contains(t, stderr, "moved 1 key(s) and 1 creds file(s)")
+	require.False(t, ts.KeyStore.HasPrivateKey(orphan))
+	require.NoFileExists(t, creds)
+	require.True(t, ts.KeyStore.HasPrivateKey(bpk))
+	require.True(t, ts.KeyStore.HasPrivateKey(ts.GetAccountPublicKey(t, "A")))
+	require.True(t, ts.KeyStore.HasPrivateKey(ts.GetUserPublicKey(t, "A", "U")))
+	require.FileExists(t, ts.KeyStore.CalcUserCredsPath("A", "U"))
+
+	_, stderr, err = ExecuteCmd(createKeysPruneCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, "no unreferenced keys or orphaned creds found")
+
+	_, stderr, err = ExecuteCmd(createKeysRestoreCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, "restored 2 of 2 file(s)")
+	require.True(t, ts.KeyStore.HasPrivateKey(orphan))
+	require.FileExists(t, creds)
+}
+
+func Test_KeysPruneOlderThan(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	_, orphan, kp := CreateAccountKey(t)
+	_, err := ts.KeyStore.Store(kp)
+	require.NoError(t, err)
+
+	_, stderr, err := ExecuteCmd(createKeysPruneCmd(), "--older-than", "1h")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "kept 1 unreferenced file(s)")
+	require.True(t, ts.KeyStore.HasPrivateKey(orphan))
+}
+
+func Test_KeysPruneDryRun(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	_, orphan, kp := CreateAccountKey(t)
+	_, err := ts.KeyStore.Store(kp)
+	require.NoError(t, err)
+
+	_, stderr, err := ExecuteCmd(HoistRootFlags(createKeysPruneCmd()), "--dry-run")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "would quarantine")
+	require.True(t, ts.KeyStore.HasPrivateKey(orphan))
+}
+
+func Test_KeysRestoreWithoutQuarantine(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	_, _, err := ExecuteCmd(createKeysRestoreCmd())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no quarantines found")
+}
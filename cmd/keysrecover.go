@@ -0,0 +1,191 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createKeysRecoverCmd() *cobra.Command {
+	var params KeysRecoverParams
+	cmd := &cobra.Command{
+		Use:   "recover",
+		Short: "Regenerate the operator and system account keys from a mnemonic",
+		Long: `Regenerate the operator and system account keys from a mnemonic
+
+Operators created with 'nsc init' or 'nsc add operator' with --mnemonic or
+--generate-mnemonic derive their operator, operator signing and system
+account keys from the mnemonic. This command derives them again and stores
+the keys that match the public keys in the operator's JWTs into the
+keystore. The creds of the system account user are regenerated.
+
+--mnemonic is the phrase or a file holding it, --derivation-path must be
+the path used when the operator was created.`,
+		Example: `nsc keys recover --mnemonic ./mnemonic.txt
+nsc keys recover --mnemonic "word1 word2 ..." --derivation-path m/1`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.mnemonic.Mnemonic, "mnemonic", "", "", "mnemonic phrase or a file holding it")
+	cmd.Flags().StringVarP(&params.mnemonic.DerivationPath, "derivation-path", "", store.DefaultDerivationPath, "derivation path used when the operator was created")
+	return cmd
+}
+
+func init() {
+	keysCmd.AddCommand(createKeysRecoverCmd())
+}
+
+type KeysRecoverParams struct {
+	mnemonic MnemonicParams
+	operator *jwt.OperatorClaims
+	// name and claim of the system account, if the store has it
+	sysName string
+	sys     *jwt.AccountClaims
+}
+
+func (p *KeysRecoverParams) SetDefaults(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysRecoverParams) PreInteractive(ctx ActionCtx) error {
+	var err error
+	if p.mnemonic.Mnemonic == "" {
+		p.mnemonic.Mnemonic, err = cli.Prompt("mnemonic or a file holding it", "", cli.NewLengthValidator(1))
+	}
+	return err
+}
+
+func (p *KeysRecoverParams) Load(ctx ActionCtx) error {
+	var err error
+	s := ctx.StoreCtx().Store
+	if p.operator, err = s.ReadOperatorClaim(); err != nil {
+		return err
+	}
+	if p.operator.SystemAccount == "" {
+		return nil
+	}
+	names, err := s.ListSubContainers(store.Accounts)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		ac, err := s.ReadAccountClaim(n)
+		if err == nil && ac.Subject == p.operator.SystemAccount {
+			p.sysName, p.sys = n, ac
+			break
+		}
+	}
+	return nil
+}
+
+func (p *KeysRecoverParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysRecoverParams) Validate(ctx ActionCtx) error {
+	if p.mnemonic.Mnemonic == "" {
+		ctx.CurrentCmd().SilenceUsage = false
+		return errors.New("--mnemonic is required")
+	}
+	return p.mnemonic.Resolve()
+}
+
+// recover derives the named key and stores it if it is one of the expected
+// keys. It returns the public key of the derived key.
+func (p *KeysRecoverParams) recover(ctx ActionCtx, name string, prefix nkeys.PrefixByte, r *store.Report, expected ...string) (string, bool, error) {
+	kp, err := p.mnemonic.KeyGenerator()(name, prefix)
+	if err != nil {
+		return "", false, err
+	}
+	defer kp.Wipe()
+	pk, err := kp.PublicKey()
+	if err != nil {
+		return "", false, err
+	}
+	if keys := jwt.StringList(expected); !keys.Contains(pk) {
+		return pk, false, nil
+	}
+	if _, err := ctx.StoreCtx().KeyStore.Store(kp); err != nil {
+		return pk, false, err
+	}
+	r.AddOK("recovered %s key %s", name, pk)
+	return pk, true, nil
+}
+
+func (p *KeysRecoverParams) Run(ctx ActionCtx) (store.Status, error) {
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	oc := p.operator
+
+	pk, ok, err := p.recover(ctx, store.DerivedOperatorKey, nkeys.PrefixByteOperator, r, oc.Subject)
+	if err != nil {
+		return r, err
+	}
+	if !ok {
+		err := fmt.Errorf("the mnemonic derives %s but operator %q is %s - check the mnemonic and the derivation path", pk, oc.Name, oc.Subject)
+		r.AddFromError(err)
+		return r, err
+	}
+	if _, _, err := p.recover(ctx, store.DerivedOperatorSigningKey, nkeys.PrefixByteOperator, r, oc.SigningKeys...); err != nil {
+		return r, err
+	}
+
+	if p.sys == nil {
+		r.AddOK("operator %q has no system account to recover", oc.Name)
+		return r, nil
+	}
+	if _, ok, err = p.recover(ctx, store.DerivedSystemAccountKey, nkeys.PrefixByteAccount, r, p.sys.Subject); err != nil {
+		return r, err
+	} else if !ok {
+		r.AddWarning("system account %q was not derived from the mnemonic", p.sysName)
+		return r, nil
+	}
+	if _, _, err := p.recover(ctx, store.DerivedSystemAccountSigningKey, nkeys.PrefixByteAccount, r, p.sys.SigningKeys.Keys()...); err != nil {
+		return r, err
+	}
+
+	s := ctx.StoreCtx().Store
+	users, err := s.ListEntries(store.Accounts, p.sysName, store.Users)
+	if err != nil {
+		return r, err
+	}
+	// user names by subject
+	byKey := make(map[string]string)
+	var subjects []string
+	for _, u := range users {
+		if uc, err := s.ReadUserClaim(p.sysName, u); err == nil {
+			byKey[uc.Subject] = u
+			subjects = append(subjects, uc.Subject)
+		}
+	}
+	upk, ok, err := p.recover(ctx, store.DerivedSystemUserKey, nkeys.PrefixByteUser, r, subjects...)
+	if err != nil || !ok {
+		return r, err
+	}
+	regenerateUserCreds(ctx, p.sysName, byKey[upk], upk, r)
+	return r, nil
+}
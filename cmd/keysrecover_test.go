@@ -0,0 +1,104 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func Test_KeysRecover(t *testing.T) {
+	ts := NewEmptyStore(t)
+	defer ts.Done(t)
+	m, err := store.NewMnemonic()
+	require.NoError(t, err)
+	fp := filepath.Join(ts.Dir, "mnemonic.txt")
+	require.NoError(t, ioutil.WriteFile(fp, []byte(m), 0600))
+
+	_, _, err = ExecuteCmd(CreateAddOperatorCmd(), "--name", "O", "--sys", "--generate-signing-key",
+		"--mnemonic", fp, "--derivation-path", "m/7")
+	require.NoError(t, err)
+	ts.SwitchOperator(t, "O")
+	oc, err := ts.Store.ReadOperatorClaim()
+	require.NoError(t, err)
+	ac, err := ts.Store.ReadAccountClaim("SYS")
+	require.NoError(t, err)
+	uc, err := ts.Store.ReadUserClaim("SYS", "sys")
+	require.NoError(t, err)
+	keys := []string{oc.Subject, oc.SigningKeys[0], ac.Subject, ac.SigningKeys.Keys()[0], uc.Subject}
+	creds := ts.KeyStore.CalcUserCredsPath("SYS", "sys")
+
+	// lose the keystore
+	require.NoError(t, os.RemoveAll(filepath.Join(store.GetKeysDir(), "keys")))
+	require.NoError(t, os.RemoveAll(filepath.Join(store.GetKeysDir(), store.CredsDir)))
+
+	_, stderr, err := ExecuteCmd(createKeysRecoverCmd(), "--mnemonic", fp)
+	require.Error(t, err)
+	require.Contains(t, stderr, "check the mnemonic and the derivation path")
+
+	_, stderr, err = ExecuteCmd(createKeysRecoverCmd(), "--mnemonic", m, "--derivation-path", "m/7")
+	require.NoError(t, err)
+	for _, k := range keys {
+		require.FileExists(t, store.GetKeyPath(k))
+		require.Contains(t, stderr, k)
+	}
+	require.FileExists(t, creds)
+}
+
+func Test_AddOperatorGenerateMnemonic(t *testing.T) {
+	ts := NewEmptyStore(t)
+	defer ts.Done(t)
+
+	_, stderr, err := ExecuteCmd(CreateAddOperatorCmd(), "--name", "O", "--generate-mnemonic")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "write down the generated mnemonic")
+
+	_, _, err = ExecuteCmd(CreateAddOperatorCmd(), "--name", "X", "--generate-mnemonic", "--mnemonic", "a b c")
+	require.Error(t, err)
+}
+
+func Test_InitWithMnemonic(t *testing.T) {
+	ts := NewEmptyStore(t)
+	defer ts.Done(t)
+	m, err := store.NewMnemonic()
+	require.NoError(t, err)
+
+	_, _, err = ExecuteCmd(createInitCmd(), "--name", "O", "--mnemonic", m)
+	require.NoError(t, err)
+	ts.SwitchOperator(t, "O")
+	oc, err := ts.Store.ReadOperatorClaim()
+	require.NoError(t, err)
+
+	d, err := store.NewKeyDeriver(m, "")
+	require.NoError(t, err)
+	kp, err := d.Derive(store.DerivedOperatorKey, nkeys.PrefixByteOperator)
+	require.NoError(t, err)
+	pk, err := kp.PublicKey()
+	require.NoError(t, err)
+	require.Equal(t, oc.Subject, pk)
+	kp, err = d.Derive(store.DerivedSystemAccountKey, nkeys.PrefixByteAccount)
+	require.NoError(t, err)
+	pk, err = kp.PublicKey()
+	require.NoError(t, err)
+	require.Equal(t, oc.SystemAccount, pk)
+}
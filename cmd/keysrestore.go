@@ -0,0 +1,151 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createKeysRestoreCmd() *cobra.Command {
+	var params KeysRestoreParams
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Move keys and creds quarantined by 'nsc keys prune' back into the keystore",
+		Long: `Move keys and creds quarantined by 'nsc keys prune' back into the keystore
+
+Without --name the most recent quarantine is restored. Files that are in the
+keystore again with different contents are left in the quarantine.`,
+		Example: `nsc keys restore
+nsc keys restore --list
+nsc keys restore --name 20211015T101500Z`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunMaybeStorelessAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.name, "name", "n", "", "name of the quarantine to restore")
+	cmd.Flags().BoolVarP(&params.list, "list", "", false, "list the quarantines")
+	return cmd
+}
+
+func init() {
+	keysCmd.AddCommand(createKeysRestoreCmd())
+}
+
+type KeysRestoreParams struct {
+	name        string
+	list        bool
+	quarantines []string
+	files       []string
+}
+
+func (p *KeysRestoreParams) SetDefaults(ctx ActionCtx) error {
+	if p.list && p.name != "" {
+		ctx.CurrentCmd().SilenceUsage = false
+		return errors.New("--list and --name are mutually exclusive")
+	}
+	return nil
+}
+
+func (p *KeysRestoreParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysRestoreParams) Load(ctx ActionCtx) error {
+	var err error
+	p.quarantines, err = ctx.StoreCtx().KeyStore.Quarantines()
+	if err != nil {
+		return err
+	}
+	if len(p.quarantines) == 0 {
+		return fmt.Errorf("no quarantines found in %#q", AbbrevHomePaths(store.GetQuarantineDir()))
+	}
+	return nil
+}
+
+func (p *KeysRestoreParams) PostInteractive(ctx ActionCtx) error {
+	if p.list || p.name != "" {
+		return nil
+	}
+	i, err := cli.Select("select the quarantine to restore", p.quarantines[len(p.quarantines)-1], p.quarantines)
+	if err != nil {
+		return err
+	}
+	p.name = p.quarantines[i]
+	return nil
+}
+
+func (p *KeysRestoreParams) Validate(ctx ActionCtx) error {
+	if p.list {
+		return nil
+	}
+	if p.name == "" {
+		p.name = p.quarantines[len(p.quarantines)-1]
+	}
+	var err error
+	p.files, err = ctx.StoreCtx().KeyStore.QuarantinedFiles(p.name)
+	return err
+}
+
+func (p *KeysRestoreParams) Run(ctx ActionCtx) (store.Status, error) {
+	ks := ctx.StoreCtx().KeyStore
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	if p.list {
+		for _, n := range p.quarantines {
+			files, err := ks.QuarantinedFiles(n)
+			if err != nil {
+				r.AddError("unable to read quarantine %q: %v", n, err)
+				continue
+			}
+			r.AddOK("%s - %d file(s)", n, len(files))
+		}
+		return r, nil
+	}
+
+	restored, total := 0, 0
+	for _, rel := range p.files {
+		fp, err := ks.Restore(p.name, rel)
+		if err != nil {
+			r.AddError("unable to restore %#q: %v", rel, err)
+		}
+		// the metadata of a key is restored with it
+		if store.IsKeyMetadata(rel) {
+			continue
+		}
+		total++
+		if err != nil {
+			continue
+		}
+		restored++
+		if filepath.Ext(fp) == store.NKeyExtension {
+			r.AddOK("restored key %s", strings.TrimSuffix(filepath.Base(fp), store.NKeyExtension))
+		} else {
+			r.AddOK("restored creds %#q", AbbrevHomePaths(fp))
+		}
+	}
+	r.AddOK("restored %d of %d file(s) from quarantine %q", restored, total, p.name)
+	return r, nil
+}
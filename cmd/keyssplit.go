@@ -0,0 +1,297 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createKeysSplitCmd() *cobra.Command {
+	var params KeysSplitParams
+	cmd := &cobra.Command{
+		Use:   "split",
+		Short: "Split an operator seed into shares for a custodial backup",
+		Long: `Split an operator seed into shares for a custodial backup
+
+The seed is split with Shamir's secret sharing into --shares printable text
+files, any --threshold of which recover it with 'nsc keys combine'. Fewer
+shares reveal nothing about the seed. Each share carries a checksum so that
+transcription errors are detected when it is read back.
+
+--operator selects the operator's identity, --key an operator signing key.`,
+		Example: `nsc keys split --operator --shares 5 --threshold 3 --dir ./shares
+nsc keys split --key OABC... --shares 3 --threshold 2`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().BoolVarP(&params.operator, "operator", "o", false, "split the operator's identity seed")
+	cmd.Flags().StringVarP(&params.key, "key", "", "", "public key of the operator signing key to split")
+	cmd.Flags().IntVarP(&params.shares, "shares", "", 5, "number of shares to create")
+	cmd.Flags().IntVarP(&params.threshold, "threshold", "", 3, "number of shares required to recover the seed")
+	cmd.Flags().StringVarP(&params.dir, "dir", "d", ".", "directory to write the share files to")
+	cmd.Flags().BoolVarP(&params.force, "force", "F", false, "overwrite existing share files")
+	return cmd
+}
+
+func init() {
+	keysCmd.AddCommand(createKeysSplitCmd())
+}
+
+const seedShareHeader = "NSC SEED SHARE"
+
+// seedShare is one share of a seed split by 'nsc keys split'
+type seedShare struct {
+	Operator  string
+	PublicKey string
+	Index     int
+	Total     int
+	Threshold int
+	Data      []byte
+}
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (s *seedShare) checksum() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%d\n%d\n", s.PublicKey, s.Index, s.Total, s.Threshold)
+	h.Write(s.Data)
+	return hex.EncodeToString(h.Sum(nil)[:4])
+}
+
+// share returns the share in the form expected by store.CombineShares
+func (s *seedShare) share() []byte {
+	return append([]byte{byte(s.Index)}, s.Data...)
+}
+
+func (s *seedShare) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %d OF %d\n", seedShareHeader, s.Index, s.Total)
+	fmt.Fprintf(&buf, "# any %d shares recover the seed with 'nsc keys combine'\n\n", s.Threshold)
+	fmt.Fprintf(&buf, "operator: %s\n", s.Operator)
+	fmt.Fprintf(&buf, "key: %s\n", s.PublicKey)
+	fmt.Fprintf(&buf, "share: %d\n", s.Index)
+	fmt.Fprintf(&buf, "shares: %d\n", s.Total)
+	fmt.Fprintf(&buf, "threshold: %d\n", s.Threshold)
+	// groups of 4 characters, 6 groups a line
+	d := shareEncoding.EncodeToString(s.Data)
+	var groups []string
+	for len(d) > 0 {
+		n := 4
+		if len(d) < n {
+			n = len(d)
+		}
+		groups = append(groups, d[:n])
+		d = d[n:]
+	}
+	for len(groups) > 0 {
+		n := 6
+		if len(groups) < n {
+			n = len(groups)
+		}
+		fmt.Fprintf(&buf, "data: %s\n", strings.Join(groups[:n], " "))
+		groups = groups[n:]
+	}
+	fmt.Fprintf(&buf, "checksum: %s\n", s.checksum())
+	return buf.String()
+}
+
+func parseSeedShare(d []byte) (*seedShare, error) {
+	var s seedShare
+	var data, sum string
+	header := false
+	sc := bufio.NewScanner(bytes.NewReader(d))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, seedShareHeader) {
+			header = true
+			continue
+		}
+		i := strings.Index(line, ":")
+		if line == "" || strings.HasPrefix(line, "#") || i == -1 {
+			continue
+		}
+		k := strings.TrimSpace(line[:i])
+		v := strings.TrimSpace(line[i+1:])
+		var err error
+		switch k {
+		case "operator":
+			s.Operator = v
+		case "key":
+			s.PublicKey = v
+		case "share":
+			s.Index, err = strconv.Atoi(v)
+		case "shares":
+			s.Total, err = strconv.Atoi(v)
+		case "threshold":
+			s.Threshold, err = strconv.Atoi(v)
+		case "data":
+			data += strings.Join(strings.Fields(v), "")
+		case "checksum":
+			sum = strings.ToLower(v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", k, v)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, errors.New("not a seed share")
+	}
+	if s.PublicKey == "" || data == "" || sum == "" {
+		return nil, errors.New("share is incomplete")
+	}
+	if s.Index < 1 || s.Index > s.Total || s.Threshold < 2 || s.Threshold > s.Total {
+		return nil, fmt.Errorf("share %d of %d with threshold %d is invalid", s.Index, s.Total, s.Threshold)
+	}
+	var err error
+	if s.Data, err = shareEncoding.DecodeString(strings.ToUpper(data)); err != nil {
+		return nil, fmt.Errorf("invalid data: %v", err)
+	}
+	if s.checksum() != sum {
+		return nil, errors.New("checksum doesn't match - check the share for transcription errors")
+	}
+	return &s, nil
+}
+
+// isOperatorKey returns true if the key is the operator's identity or one
+// of its signing keys
+func isOperatorKey(oc *jwt.OperatorClaims, pk string) bool {
+	return oc.Subject == pk || oc.SigningKeys.Contains(pk)
+}
+
+type KeysSplitParams struct {
+	operator  bool
+	key       string
+	shares    int
+	threshold int
+	dir       string
+	force     bool
+	claim     *jwt.OperatorClaims
+}
+
+func (p *KeysSplitParams) SetDefaults(ctx ActionCtx) error {
+	if p.operator && p.key != "" {
+		ctx.CurrentCmd().SilenceUsage = false
+		return errors.New("--operator and --key are mutually exclusive")
+	}
+	if !p.operator && p.key == "" {
+		ctx.CurrentCmd().SilenceUsage = false
+		return errors.New("--operator or --key is required")
+	}
+	return nil
+}
+
+func (p *KeysSplitParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysSplitParams) Load(ctx ActionCtx) error {
+	var err error
+	p.claim, err = ctx.StoreCtx().Store.ReadOperatorClaim()
+	if err != nil {
+		return err
+	}
+	if p.operator {
+		p.key = p.claim.Subject
+	}
+	return nil
+}
+
+func (p *KeysSplitParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysSplitParams) Validate(ctx ActionCtx) error {
+	if !isOperatorKey(p.claim, p.key) {
+		return fmt.Errorf("%q is not the identity or a signing key of operator %q", p.key, p.claim.Name)
+	}
+	if p.threshold < 2 {
+		return errors.New("--threshold must be at least 2")
+	}
+	if p.shares < p.threshold || p.shares > 255 {
+		return fmt.Errorf("--shares must be between the threshold (%d) and 255", p.threshold)
+	}
+	return nil
+}
+
+func (p *KeysSplitParams) shareFile(i int) string {
+	return filepath.Join(p.dir, fmt.Sprintf("%s.share-%d-of-%d.txt", p.key, i, p.shares))
+}
+
+func (p *KeysSplitParams) Run(ctx ActionCtx) (store.Status, error) {
+	var err error
+	if p.dir, err = Expand(p.dir); err != nil {
+		return nil, err
+	}
+	for i := 1; i <= p.shares; i++ {
+		fp := p.shareFile(i)
+		if _, err := os.Stat(fp); err == nil && !p.force {
+			return nil, fmt.Errorf("%#q already exists - specify --force to overwrite", fp)
+		}
+	}
+
+	seed, err := ctx.StoreCtx().KeyStore.GetSeed(p.key)
+	if err != nil {
+		return nil, fmt.Errorf("the seed for %s is not available: %v", p.key, err)
+	}
+	shares, err := store.SplitSecret([]byte(seed), p.shares, p.threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	for _, d := range shares {
+		s := seedShare{
+			Operator:  p.claim.Name,
+			PublicKey: p.key,
+			Index:     int(d[0]),
+			Total:     p.shares,
+			Threshold: p.threshold,
+			Data:      d[1:],
+		}
+		fp := p.shareFile(s.Index)
+		if err := store.Write(fp, []byte(s.String())); err != nil {
+			r.AddError("unable to write share %d: %v", s.Index, err)
+			continue
+		}
+		r.AddOK("wrote share %d of %d to %#q", s.Index, s.Total, AbbrevHomePaths(fp))
+	}
+	if r.HasNoErrors() {
+		r.AddOK("any %d of the %d shares recover %s with 'nsc keys combine'", p.threshold, p.shares, p.key)
+	}
+	return r, nil
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func splitOperator(t *testing.T, ts *TestStore, dir string) (string, []string) {
+	_, _, err := ExecuteCmd(createKeysSplitCmd(), "--operator", "--shares", "5", "--threshold", "3", "--dir", dir)
+	require.NoError(t, err)
+	opk, err := ts.OperatorKey.PublicKey()
+	require.NoError(t, err)
+	var files []string
+	for i := 1; i <= 5; i++ {
+		fp := filepath.Join(dir, fmt.Sprintf("%s.share-%d-of-5.txt", opk, i))
+		require.FileExists(t, fp)
+		files = append(files, fp)
+	}
+	return opk, files
+}
+
+func Test_KeysSplitCombine(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	dir := filepath.Join(ts.Dir, "shares")
+	opk, files := splitOperator(t, ts, dir)
+
+	seed, err := ts.KeyStore.GetSeed(opk)
+	require.NoError(t, err)
+	for _, fp := range files {
+		d, err := ioutil.ReadFile(fp)
+		require.NoError(t, err)
+		require.NotContains(t, string(d), seed)
+		require.Contains(t, string(d), "checksum: ")
+	}
+
+	require.NoError(t, os.Remove(ts.OperatorKeyPath))
+	require.False(t, ts.KeyStore.HasPrivateKey(opk))
+
+	_, stderr, err := ExecuteCmd(createKeysCombineCmd(), files[4], files[0], files[2])
+	require.NoError(t, err)
+	require.Contains(t, stderr, "recovered "+opk)
+	s, err := ts.KeyStore.GetSeed(opk)
+	require.NoError(t, err)
+	require.Equal(t, seed, s)
+}
+
+func Test_KeysCombineBelowThreshold(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	_, files := splitOperator(t, ts, filepath.Join(ts.Dir, "shares"))
+
+	_, _, err := ExecuteCmd(createKeysCombineCmd(), files[0], files[1])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "3 shares are required")
+
+	_, _, err = ExecuteCmd(createKeysCombineCmd(), files[0], files[1], files[1])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "more than once")
+}
+
+func Test_KeysCombineDetectsTranscriptionErrors(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	_, files := splitOperator(t, ts, filepath.Join(ts.Dir, "shares"))
+
+	d, err := ioutil.ReadFile(files[0])
+	require.NoError(t, err)
+	lines := strings.Split(string(d), "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(l, "data: ") {
+			c := "A"
+			if l[6] == 'A' {
+				c = "B"
+			}
+			lines[i] = "data: " + c + l[7:]
+			break
+		}
+	}
+	require.NoError(t, ioutil.WriteFile(files[0], []byte(strings.Join(lines, "\n")), 0600))
+
+	_, _, err = ExecuteCmd(createKeysCombineCmd(), files[0], files[1], files[2])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "checksum doesn't match")
+}
+
+func Test_KeysCombineRequiresOperatorKey(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	_, files := splitOperator(t, ts, filepath.Join(ts.Dir, "shares"))
+
+	ts.AddOperator(t, "P")
+	_, _, err := ExecuteCmd(createKeysCombineCmd(), files[0], files[1], files[2])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not the identity or a signing key of operator \"P\"")
+}
+
+func Test_KeysSplitSigningKey(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	_, _, err := ExecuteCmd(createKeysSplitCmd(), "--key", "OABC", "--dir", ts.Dir)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not the identity or a signing key")
+
+	_, _, err = ExecuteCmd(CreateEditOperatorCmd(), "--sk", "generate")
+	require.NoError(t, err)
+	oc, err := ts.Store.ReadOperatorClaim()
+	require.NoError(t, err)
+	sk := oc.SigningKeys[0]
+	dir := filepath.Join(ts.Dir, "shares")
+	_, _, err = ExecuteCmd(createKeysSplitCmd(), "--key", sk, "--shares", "2", "--threshold", "2", "--dir", dir)
+	require.NoError(t, err)
+
+	// existing shares are not overwritten
+	_, _, err = ExecuteCmd(createKeysSplitCmd(), "--key", sk, "--shares", "2", "--threshold", "2", "--dir", dir)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "already exists")
+
+	require.NoError(t, ts.KeyStore.Remove(sk))
+	_, _, err = ExecuteCmd(createKeysCombineCmd(),
+		filepath.Join(dir, sk+".share-1-of-2.txt"), filepath.Join(dir, sk+".share-2-of-2.txt"))
+	require.NoError(t, err)
+	require.True(t, ts.KeyStore.HasPrivateKey(sk))
+}
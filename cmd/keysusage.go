@@ -0,0 +1,315 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/cobra"
+	"github.com/xlab/tablewriter"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createKeysUsageCmd() *cobra.Command {
+	var params KeysUsageParams
+	cmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Show which accounts, users and activations each key issued",
+		Long: `Show which accounts, users and activations each key issued
+
+Every key in the keystore and every identity and signing key in the operator
+and account JWTs is listed with the entities it signed. Keys referenced by
+the JWTs but missing in the keystore, keys in the keystore that no operator
+in the store root references, and entities that violate the operator's
+strict signing key usage are flagged. Keys of the other operators are not
+listed.`,
+		Example:      `nsc keys usage`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	return cmd
+}
+
+func init() {
+	keysCmd.AddCommand(createKeysUsageCmd())
+}
+
+// KeyUsage describes a key, what it is and what it signed
+type KeyUsage struct {
+	PublicKey    string   `json:"public_key"`
+	Kind         string   `json:"kind"`
+	Role         string   `json:"role,omitempty"`
+	Owner        string   `json:"owner,omitempty"`
+	Stored       bool     `json:"stored"`
+	Referenced   bool     `json:"referenced"`
+	Issued       []string `json:"issued,omitempty"`
+	Problems     []string `json:"problems,omitempty"`
+	unreferenced bool
+}
+
+type KeysUsageParams struct {
+	keys  map[string]*KeyUsage
+	order []string
+	// public keys named by the JWTs of the other operators
+	others map[string]bool
+}
+
+func (p *KeysUsageParams) SetDefaults(ctx ActionCtx) error {
+	p.keys = make(map[string]*KeyUsage)
+	return nil
+}
+
+func (p *KeysUsageParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysUsageParams) Load(ctx ActionCtx) error {
+	// the keystore is shared, keys of the other operators are referenced
+	p.others = make(map[string]bool)
+	config := GetConfig()
+	for _, o := range config.ListOperators() {
+		if o == ctx.StoreCtx().Store.Info.Name {
+			continue
+		}
+		s, err := config.LoadStore(o)
+		if err != nil {
+			return fmt.Errorf("unable to load operator %q: %v", o, err)
+		}
+		keys, err := collectKeyReferences(s)
+		if err != nil {
+			return fmt.Errorf("unable to read operator %q: %v", o, err)
+		}
+		for k := range keys {
+			p.others[k] = true
+		}
+	}
+	return nil
+}
+
+func (p *KeysUsageParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysUsageParams) Validate(ctx ActionCtx) error {
+	return nil
+}
+
+// key returns the usage of a key, adding it if needed
+func (p *KeysUsageParams) key(pk string) *KeyUsage {
+	k, ok := p.keys[pk]
+	if !ok {
+		k = &KeyUsage{PublicKey: pk}
+		if kind, err := store.PubKeyType(pk); err == nil {
+			k.Kind = kind.String()
+		}
+		p.keys[pk] = k
+		p.order = append(p.order, pk)
+	}
+	return k
+}
+
+// declare records a key referenced by a JWT
+func (p *KeysUsageParams) declare(pk string, role string, owner string) *KeyUsage {
+	k := p.key(pk)
+	k.Referenced = true
+	if k.Role == "" {
+		k.Role = role
+		k.Owner = owner
+	}
+	return k
+}
+
+func (p *KeysUsageParams) issued(pk string, format string, args ...interface{}) {
+	k := p.key(pk)
+	k.Referenced = true
+	k.Issued = append(k.Issued, fmt.Sprintf(format, args...))
+}
+
+func (p *KeysUsageParams) Run(ctx ActionCtx) (store.Status, error) {
+	s := ctx.StoreCtx().Store
+	ks := ctx.StoreCtx().KeyStore
+
+	oc, err := s.ReadOperatorClaim()
+	if err != nil {
+		return nil, err
+	}
+	p.declare(oc.Subject, "operator", oc.Name)
+	for _, sk := range oc.SigningKeys {
+		p.declare(sk, "operator signing key", oc.Name)
+	}
+	p.issued(oc.Issuer, "operator %q", oc.Name)
+
+	accounts, err := s.ListSubContainers(store.Accounts)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(accounts)
+	var claims []*jwt.AccountClaims
+	for _, a := range accounts {
+		ac, err := s.ReadAccountClaim(a)
+		if err != nil {
+			return nil, err
+		}
+		claims = append(claims, ac)
+		p.declare(ac.Subject, "account", ac.Name)
+		sks := ac.SigningKeys.Keys()
+		sort.Strings(sks)
+		for _, sk := range sks {
+			role := "account signing key"
+			if us, ok := ac.SigningKeys[sk].(*jwt.UserScope); ok && us.Role != "" {
+				role = fmt.Sprintf("account signing key (role %s)", us.Role)
+			}
+			p.declare(sk, role, ac.Name)
+		}
+	}
+
+	for _, ac := range claims {
+		p.issued(ac.Issuer, "account %q", ac.Name)
+		if oc.StrictSigningKeyUsage && ac.Issuer == oc.Subject {
+			k := p.key(ac.Issuer)
+			k.Problems = append(k.Problems,
+				fmt.Sprintf("account %q is signed by the operator identity but the operator requires signing keys", ac.Name))
+		}
+		if ac.Issuer != oc.Subject && !oc.SigningKeys.Contains(ac.Issuer) {
+			k := p.key(ac.Issuer)
+			k.Problems = append(k.Problems, fmt.Sprintf("account %q is signed by a key that is not a key of operator %q", ac.Name, oc.Name))
+		}
+
+		users, err := s.ListEntries(store.Accounts, ac.Name, store.Users)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(users)
+		for _, u := range users {
+			uc, err := s.ReadUserClaim(ac.Name, u)
+			if err != nil {
+				return nil, err
+			}
+			p.declare(uc.Subject, "user", fmt.Sprintf("%s/%s", ac.Name, uc.Name))
+			p.issued(uc.Issuer, "user %q in account %q", uc.Name, ac.Name)
+			if oc.StrictSigningKeyUsage && uc.Issuer == ac.Subject {
+				k := p.key(uc.Issuer)
+				k.Problems = append(k.Problems,
+					fmt.Sprintf("user %q is signed by the identity of account %q but the operator requires signing keys", uc.Name, ac.Name))
+			}
+			if !ac.DidSign(uc) {
+				k := p.key(uc.Issuer)
+				k.Problems = append(k.Problems, fmt.Sprintf("user %q is signed by a key that is not a key of account %q", uc.Name, ac.Name))
+			}
+		}
+
+		for _, im := range ac.Imports {
+			if im.Token == "" {
+				continue
+			}
+			act, err := jwt.DecodeActivationClaims(im.Token)
+			if err != nil {
+				continue
+			}
+			p.issued(act.Issuer, "activation %q for account %q", im.Name, ac.Name)
+		}
+	}
+
+	all, err := ks.AllKeys()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(all)
+	for _, pk := range all {
+		if _, ok := p.keys[pk]; !ok && !p.others[pk] {
+			k := p.key(pk)
+			k.unreferenced = true
+			k.Problems = append(k.Problems, "not referenced by operator "+oc.Name)
+		}
+	}
+
+	var r KeysUsage
+	for _, pk := range p.order {
+		k := p.keys[pk]
+		k.Stored = ks.HasPrivateKey(pk)
+		if k.Referenced && !k.Stored && (len(k.Issued) > 0 || k.Role != "user") {
+			k.Problems = append(k.Problems, "referenced but missing in the keystore")
+		}
+		r = append(r, k)
+	}
+	return r, nil
+}
+
+// KeysUsage is the status of the keys usage command
+type KeysUsage []*KeyUsage
+
+func (r KeysUsage) Code() store.StatusCode {
+	for _, k := range r {
+		if len(k.Problems) > 0 {
+			return store.WARN
+		}
+	}
+	return store.OK
+}
+
+func (r KeysUsage) Message() string {
+	table := tablewriter.CreateTable()
+	table.AddTitle("Key Usage")
+	table.AddHeaders("Key", "Role", "Owner", "Stored", "Issued")
+	for _, k := range r {
+		stored := ""
+		if k.Stored {
+			stored = "*"
+		}
+		role := k.Role
+		if k.unreferenced {
+			role = "?"
+		}
+		issued := "-"
+		if len(k.Issued) > 0 {
+			issued = k.Issued[0]
+		}
+		table.AddRow(k.PublicKey, role, k.Owner, stored, issued)
+		for i := 1; i < len(k.Issued); i++ {
+			table.AddRow("", "", "", "", k.Issued[i])
+		}
+	}
+	out := table.Render()
+
+	var problems []string
+	for _, k := range r {
+		for _, m := range k.Problems {
+			problems = append(problems, fmt.Sprintf("%s: %s", k.PublicKey, m))
+		}
+	}
+	if len(problems) > 0 {
+		pt := tablewriter.CreateTable()
+		pt.AddTitle("Problems")
+		for _, m := range problems {
+			pt.AddRow(m)
+		}
+		out += pt.Render()
+	}
+	return out
+}
+
+func (r KeysUsage) JsonDocument() (interface{}, error) {
+	if r == nil {
+		return []*KeyUsage{}, nil
+	}
+	return r, nil
+}
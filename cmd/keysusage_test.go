@@ -0,0 +1,120 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func keyUsageOf(t *testing.T, ku KeysUsage, pk string) *KeyUsage {
+	for _, k := range ku {
+		if k.PublicKey == pk {
+			return k
+		}
+	}
+	t.Fatalf("key %s is not in the usage report", pk)
+	return nil
+}
+
+func Test_KeysUsage(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddExport(t, "A", jwt.Service, "q", false)
+	ts.AddUser(t, "A", "U")
+	ts.AddAccount(t, "B")
+	ts.AddImport(t, "A", "q", "B")
+
+	// a referenced operator signing key that is not in the keystore
+	// and strict signing key usage the accounts violate
+	_, osk, _ := CreateOperatorKey(t)
+	oc, err := ts.Store.ReadOperatorClaim()
+	require.NoError(t, err)
+	oc.SigningKeys.Add(osk)
+	oc.StrictSigningKeyUsage = true
+	token, err := oc.Encode(ts.OperatorKey)
+	require.NoError(t, err)
+	require.NoError(t, ts.Store.StoreRaw([]byte(token)))
+	// a key that nothing references
+	_, stray, skp := CreateAccountKey(t)
+	_, err = ts.KeyStore.Store(skp)
+	require.NoError(t, err)
+
+	var p KeysUsageParams
+	ctx, err := NewActx(createKeysUsageCmd(), nil)
+	require.NoError(t, err)
+	require.NoError(t, p.SetDefaults(ctx))
+	rs, err := p.Run(ctx)
+	require.NoError(t, err)
+	ku := rs.(KeysUsage)
+
+	opk := ts.GetOperatorPublicKey(t)
+	apk := ts.GetAccountPublicKey(t, "A")
+	o := keyUsageOf(t, ku, opk)
+	require.Equal(t, "operator", o.Role)
+	require.True(t, o.Stored)
+	require.Contains(t, o.Issued, `account "A"`)
+	require.Contains(t, o.Issued, `account "B"`)
+	require.Contains(t, o.Problems, `account "A" is signed by the operator identity but the operator requires signing keys`)
+
+	a := keyUsageOf(t, ku, apk)
+	require.Contains(t, a.Issued, `user "U" in account "A"`)
+	require.Contains(t, a.Issued, `activation "q" for account "B"`)
+
+	require.Contains(t, keyUsageOf(t, ku, osk).Problems, "referenced but missing in the keystore")
+	require.Contains(t, keyUsageOf(t, ku, stray).Problems, "not referenced by operator O")
+	require.Empty(t, keyUsageOf(t, ku, ts.GetUserPublicKey(t, "A", "U")).Problems)
+
+	_, stderr, err := ExecuteCmd(createKeysUsageCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, "Key Usage")
+	require.Contains(t, stderr, "Problems")
+}
+
+func Test_KeysUsageOtherOperators(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	_, _, err := ExecuteCmd(CreateAddOperatorCmd(), "--name", "P")
+	require.NoError(t, err)
+	ts.SwitchOperator(t, "P")
+	ts.AddAccount(t, "B")
+	ppk := ts.GetOperatorPublicKey(t)
+	bpk := ts.GetAccountPublicKey(t, "B")
+	ts.SwitchOperator(t, "O")
+	// a key that nothing references
+	_, stray, skp := CreateAccountKey(t)
+	_, err = ts.KeyStore.Store(skp)
+	require.NoError(t, err)
+
+	var p KeysUsageParams
+	ctx, err := NewActx(createKeysUsageCmd(), nil)
+	require.NoError(t, err)
+	require.NoError(t, p.SetDefaults(ctx))
+	require.NoError(t, p.Load(ctx))
+	rs, err := p.Run(ctx)
+	require.NoError(t, err)
+	ku := rs.(KeysUsage)
+
+	for _, k := range ku {
+		require.NotEqual(t, ppk, k.PublicKey)
+		require.NotEqual(t, bpk, k.PublicKey)
+	}
+	require.Contains(t, keyUsageOf(t, ku, stray).Problems, "not referenced by operator O")
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createKeysVerifyCmd() *cobra.Command {
+	var params KeysVerifyParams
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the integrity of the keystore",
+		Long: `Verify the integrity of the keystore
+
+Every key file must hold a seed and be stored at the path derived from the
+seed's public key. Every creds file must hold a user JWT and the seed for
+it, match the user's JWT in the store, and have its seed in the keystore.
+Key and creds files must not be readable by other users.
+
+With --fix misplaced keys are moved to their expected path, missing seeds
+are stored from the creds files and permissions are restricted to the
+owner. Corrupted files and creds that don't match their users are only
+reported.`,
+		Example: `nsc keys verify
+nsc keys verify --fix`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunMaybeStorelessAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().BoolVarP(&params.fix, "fix", "", false, "relocate misplaced keys, store missing seeds and restrict permissions")
+	return cmd
+}
+
+func init() {
+	keysCmd.AddCommand(createKeysVerifyCmd())
+}
+
+type KeysVerifyParams struct {
+	fix bool
+	// stores of the operators in the store root by operator name
+	stores   map[string]*store.Store
+	problems int
+	fixes    int
+	keys     int
+	creds    int
+}
+
+func (p *KeysVerifyParams) SetDefaults(ctx ActionCtx) error {
+	p.stores = make(map[string]*store.Store)
+	return nil
+}
+
+func (p *KeysVerifyParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysVerifyParams) Load(ctx ActionCtx) error {
+	config := GetConfig()
+	for _, o := range config.ListOperators() {
+		s, err := config.LoadStore(o)
+		if err != nil {
+			return fmt.Errorf("unable to load operator %q: %v", o, err)
+		}
+		p.stores[s.Info.Name] = s
+	}
+	return nil
+}
+
+func (p *KeysVerifyParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *KeysVerifyParams) Validate(ctx ActionCtx) error {
+	dir := store.GetKeysDir()
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return fmt.Errorf("keystore %#q does not exist", dir)
+	}
+	old, err := store.IsOldKeyRing(dir)
+	if err != nil {
+		return err
+	}
+	if old {
+		return fmt.Errorf("keystore %#q uses the old layout - run 'nsc keys migrate' first", AbbrevHomePaths(dir))
+	}
+	return nil
+}
+
+func (p *KeysVerifyParams) problem(r *store.Report, format string, args ...interface{}) {
+	p.problems++
+	r.AddError(format, args...)
+}
+
+// repair reports a problem, or fixes it with fn when --fix is set
+func (p *KeysVerifyParams) repair(r *store.Report, fn func() (string, error), format string, args ...interface{}) {
+	m := fmt.Sprintf(format, args...)
+	if !p.fix {
+		p.problem(r, "%s", m)
+		return
+	}
+	done, err := fn()
+	if err != nil {
+		p.problem(r, "%s - unable to fix: %v", m, err)
+		return
+	}
+	p.fixes++
+	r.AddOK("%s - %s", m, done)
+}
+
+// checkPermissions reports files that can be accessed by other users
+func (p *KeysVerifyParams) checkPermissions(ctx ActionCtx, fp string, info os.FileInfo, r *store.Report) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	mode := info.Mode().Perm()
+	if mode&0077 == 0 {
+		return
+	}
+	p.repair(r, func() (string, error) {
+		return "changed the mode to 0600", ctx.StoreCtx().KeyStore.Chmod(fp, 0600)
+	}, "%#q can be accessed by other users (mode %#o)", AbbrevHomePaths(fp), mode)
+}
+
+func (p *KeysVerifyParams) checkKey(ctx ActionCtx, fp string, r *store.Report) {
+	ks := ctx.StoreCtx().KeyStore
+	p.keys++
+	kp, err := ks.Read(fp)
+	if err != nil || kp == nil {
+		p.problem(r, "%#q cannot be read: %v", AbbrevHomePaths(fp), err)
+		return
+	}
+	defer kp.Wipe()
+	if _, err := kp.Seed(); err != nil {
+		p.problem(r, "%#q doesn't hold a seed", AbbrevHomePaths(fp))
+		return
+	}
+	pk, err := kp.PublicKey()
+	if err != nil {
+		p.problem(r, "%#q holds an invalid seed: %v", AbbrevHomePaths(fp), err)
+		return
+	}
+	expected := store.GetKeyPath(pk)
+	if fp == expected {
+		return
+	}
+	p.repair(r, func() (string, error) {
+		// storing a key that is already at the expected path only verifies it's the same seed
+		return "moved it to the expected path", ks.Relocate(fp, kp)
+	}, "%#q holds %s but is not at %#q", AbbrevHomePaths(fp), pk, AbbrevHomePaths(expected))
+}
+
+func (p *KeysVerifyParams) checkCreds(ctx ActionCtx, fp string, r *store.Report) {
+	ks := ctx.StoreCtx().KeyStore
+	p.creds++
+	name := AbbrevHomePaths(fp)
+	d, err := ioutil.ReadFile(fp)
+	if err != nil {
+		p.problem(r, "%#q cannot be read: %v", name, err)
+		return
+	}
+	token, err := jwt.ParseDecoratedJWT(d)
+	if err != nil {
+		p.problem(r, "%#q doesn't hold a JWT: %v", name, err)
+		return
+	}
+	uc, err := jwt.DecodeUserClaims(token)
+	if err != nil {
+		p.problem(r, "%#q doesn't hold a user JWT: %v", name, err)
+		return
+	}
+	kp, err := jwt.ParseDecoratedUserNKey(d)
+	if err != nil {
+		p.problem(r, "%#q doesn't hold a user seed: %v", name, err)
+		return
+	}
+	defer kp.Wipe()
+	if !store.Match(uc.Subject, kp) {
+		p.problem(r, "the seed in %#q is not for its JWT's subject %s", name, uc.Subject)
+		return
+	}
+
+	rel, err := filepath.Rel(filepath.Join(store.GetKeysDir(), store.CredsDir), fp)
+	parts := strings.Split(rel, string(filepath.Separator))
+	if err != nil || len(parts) != 3 {
+		p.problem(r, "%#q is not in a creds/<operator>/<account> directory", name)
+	} else if s, ok := p.stores[parts[0]]; !ok {
+		r.AddWarning("%#q belongs to operator %q which is not in the store root", name, parts[0])
+	} else {
+		account, user := parts[1], strings.TrimSuffix(parts[2], store.CredsExtension)
+		sc, err := s.ReadUserClaim(account, user)
+		if err != nil {
+			p.problem(r, "%#q has no matching user %q in account %q", name, user, account)
+		} else if sc.Subject != uc.Subject {
+			p.problem(r, "%#q is for %s but user %q has the key %s", name, uc.Subject, user, sc.Subject)
+		}
+	}
+
+	if _, err := store.Stat(store.GetKeyPath(uc.Subject)); err == nil {
+		return
+	}
+	p.repair(r, func() (string, error) {
+		_, err := ks.Store(kp)
+		return "stored it from the creds", err
+	}, "the seed of %s in %#q is not in the keystore", uc.Subject, name)
+}
+
+func (p *KeysVerifyParams) Run(ctx ActionCtx) (store.Status, error) {
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	root := store.GetKeysDir()
+
+	var keys, creds []string
+	err := filepath.Walk(root, func(fp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if store.IsQuarantined(fp) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(fp) {
+		case store.NKeyExtension:
+			keys = append(keys, fp)
+		case store.CredsExtension:
+			creds = append(creds, fp)
+		default:
+			return nil
+		}
+		p.checkPermissions(ctx, fp, info, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, fp := range keys {
+		p.checkKey(ctx, fp, r)
+	}
+	for _, fp := range creds {
+		p.checkCreds(ctx, fp, r)
+	}
+
+	if p.problems > 0 {
+		r.AddError("checked %d key(s) and %d creds file(s) - found %d problem(s)", p.keys, p.creds, p.problems)
+		return r, fmt.Errorf("keystore %#q has %d problem(s)", AbbrevHomePaths(root), p.problems)
+	}
+	if p.fixes > 0 {
+		r.AddOK("checked %d key(s) and %d creds file(s) - fixed %d problem(s)", p.keys, p.creds, p.fixes)
+		return r, nil
+	}
+	r.AddOK("checked %d key(s) and %d creds file(s)", p.keys, p.creds)
+	return r, nil
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func Test_KeysVerifyClean(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+
+	_, stderr, err := ExecuteCmd(createKeysVerifyCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, "checked 3 key(s) and 1 creds file(s)")
+}
+
+func Test_KeysVerifyMisplacedKey(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	apk := ts.GetAccountPublicKey(t, "A")
+	fp := store.GetKeyPath(apk)
+	_, _, err := ExecuteCmd(createKeysLabelCmd(), "--key", apk, "--label", "account a")
+	require.NoError(t, err)
+	misplaced := filepath.Join(store.GetKeysDir(), "keys", "copied.nk")
+	require.NoError(t, os.Rename(fp, misplaced))
+	// the metadata sidecar was copied with the key
+	misplacedMeta := filepath.Join(store.GetKeysDir(), "keys", "copied"+store.MetadataExtension)
+	require.NoError(t, os.Rename(store.GetKeyMetadataPath(apk), misplacedMeta))
+
+	_, stderr, err := ExecuteCmd(createKeysVerifyCmd())
+	require.Error(t, err)
+	require.Contains(t, stderr, "holds "+apk+" but is not at")
+
+	_, _, err = ExecuteCmd(createKeysVerifyCmd(), "--fix")
+	require.NoError(t, err)
+	require.FileExists(t, fp)
+	require.NoFileExists(t, misplaced)
+	require.NoFileExists(t, misplacedMeta)
+	m, err := ts.KeyStore.GetKeyMetadata(apk)
+	require.NoError(t, err)
+	require.Equal(t, "account a", m.Label)
+
+	_, _, err = ExecuteCmd(createKeysVerifyCmd())
+	require.NoError(t, err)
+}
+
+func Test_KeysVerifyPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not checked on windows")
+	}
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	creds := ts.KeyStore.CalcUserCredsPath("A", "U")
+	require.NoError(t, os.Chmod(creds, 0644))
+	upk := ts.GetUserPublicKey(t, "A", "U")
+	require.NoError(t, os.Chmod(store.GetKeyPath(upk), 0644))
+
+	_, stderr, err := ExecuteCmd(createKeysVerifyCmd())
+	require.Error(t, err)
+	require.Contains(t, stderr, "can be accessed by other users")
+
+	_, _, err = ExecuteCmd(createKeysVerifyCmd(), "--fix")
+	require.NoError(t, err)
+	for _, fp := range []string{creds, store.GetKeyPath(upk)} {
+		fi, err := os.Stat(fp)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+	// the change of the mode of the key was journaled
+	entries, err := ts.Store.Journal().Entries()
+	require.NoError(t, err)
+	e := entries[len(entries)-1]
+	require.Equal(t, store.JournalChmodKey, e.Op)
+	require.Equal(t, upk, e.Name)
+}
+
+func Test_KeysVerifyCorruptedKey(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	fp := filepath.Join(store.GetKeysDir(), "keys", "A", "AB", "ABAD.nk")
+	require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0700))
+	require.NoError(t, ioutil.WriteFile(fp, []byte("garbage"), 0600))
+
+	_, stderr, err := ExecuteCmd(createKeysVerifyCmd(), "--fix")
+	require.Error(t, err)
+	require.Contains(t, stderr, "cannot be read")
+	require.FileExists(t, fp)
+}
+
+func Test_KeysVerifyCreds(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	upk := ts.GetUserPublicKey(t, "A", "U")
+	creds := ts.KeyStore.CalcUserCredsPath("A", "U")
+	d, err := ioutil.ReadFile(creds)
+	require.NoError(t, err)
+
+	// creds of a user that was deleted
+	gone := ts.KeyStore.CalcUserCredsPath("A", "gone")
+	require.NoError(t, ioutil.WriteFile(gone, d, 0600))
+	_, stderr, err := ExecuteCmd(createKeysVerifyCmd())
+	require.Error(t, err)
+	require.Contains(t, stderr, `has no matching user "gone" in account "A"`)
+	require.NoError(t, os.Remove(gone))
+
+	// the seed is only in the creds
+	require.NoError(t, os.Remove(store.GetKeyPath(upk)))
+	_, stderr, err = ExecuteCmd(createKeysVerifyCmd())
+	require.Error(t, err)
+	require.Contains(t, stderr, "the seed of "+upk)
+
+	_, _, err = ExecuteCmd(createKeysVerifyCmd(), "--fix")
+	require.NoError(t, err)
+	require.FileExists(t, store.GetKeyPath(upk))
+}
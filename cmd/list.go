@@ -57,7 +57,9 @@ func createListOperatorsCmd() *cobra.Command {
 				return errors.New("no store set - `env --store <dir>`")
 			}
 			operators := config.ListOperators()
-			if len(operators) == 0 {
+			if len(operators) == 0 && IsJsonOutput() {
+				return writeJsonDocument(cmd, entityDocuments(nil, ""))
+			} else if len(operators) == 0 {
 				fmt.Println("no operators defined - init an environment")
 			} else {
 				sort.Strings(operators)
@@ -82,6 +84,9 @@ func createListOperatorsCmd() *cobra.Command {
 					}
 					i.Claims = c
 				}
+				if IsJsonOutput() {
+					return writeJsonDocument(cmd, entityDocuments(infos, config.Operator))
+				}
 				cmd.Println(listEntities("Operators", infos, config.Operator))
 			}
 
@@ -155,6 +160,9 @@ func createListAccountsCmd() *cobra.Command {
 				}
 				i.Claims = ac
 			}
+			if IsJsonOutput() {
+				return writeJsonDocument(cmd, entityDocuments(infos, config.Account))
+			}
 			cmd.Println(listEntities("Accounts", infos, config.Account))
 			return nil
 		},
@@ -230,6 +238,9 @@ func CreateListUsersCmd() *cobra.Command {
 				return err
 			}
 
+			if IsJsonOutput() {
+				return writeJsonDocument(cmd, entityDocuments(infos, ""))
+			}
 			cmd.Println(listEntities("Users", infos, config.Account))
 			return nil
 		},
@@ -272,3 +283,27 @@ func listEntities(title string, infos []*EntryInfo, current string) string {
 	}
 	return table.Render()
 }
+
+// EntityDocument is the json representation of a listed entity
+type EntityDocument struct {
+	Name      string `json:"name"`
+	JwtName   string `json:"jwt_name,omitempty"`
+	PublicKey string `json:"public_key,omitempty"`
+	Current   bool   `json:"current,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func entityDocuments(infos []*EntryInfo, current string) []*EntityDocument {
+	docs := make([]*EntityDocument, 0, len(infos))
+	for _, v := range infos {
+		d := &EntityDocument{Name: v.Name, Current: v.Name == current}
+		if v.Err != nil || v.Claims == nil {
+			d.Error = fmt.Sprintf("error loading jwt - %v", v.Err)
+		} else if c := v.Claims.Claims(); c != nil {
+			d.JwtName = c.Name
+			d.PublicKey = c.Subject
+		}
+		docs = append(docs, d)
+	}
+	return docs
+}
@@ -123,9 +123,27 @@ func (p *ListKeysParams) Run(ctx ActionCtx) (store.Status, error) {
 
 	keys.KeyList, err = p.KeyCollectorParams.Run(ctx)
 	keys.MessageFn = p.Report
+	keys.JsonFn = p.JsonReport
 	return keys, err
 }
 
+func (p *ListKeysParams) JsonReport(ks Keys) (interface{}, error) {
+	docs := ks.KeyList.Documents()
+	if p.Seeds {
+		for i, k := range ks.KeyList {
+			if k.Invalid || k.KeyPath == "" {
+				continue
+			}
+			seed, err := p.KS.GetSeed(k.Pub)
+			if err != nil {
+				return nil, err
+			}
+			docs[i].Seed = seed
+		}
+	}
+	return docs, nil
+}
+
 func (p *ListKeysParams) Report(ks Keys) string {
 	if ks.Len() == 0 {
 		return "no keys matched query"
@@ -136,7 +154,7 @@ func (p *ListKeysParams) Report(ks Keys) string {
 	var hasUnreferenced bool
 	table := tablewriter.CreateTable()
 	table.AddTitle("Keys")
-	table.AddHeaders("Entity", "Key", "Signing Key", "Stored")
+	table.AddHeaders("Entity", "Key", "Signing Key", "Stored", "Label")
 	for _, k := range ks.KeyList {
 		unreferenced := false
 		if k.Name == "?" {
@@ -164,7 +182,11 @@ func (p *ListKeysParams) Report(ks Keys) string {
 			}
 		}
 		n := fmt.Sprintf("%s%s", pad, k.Name)
-		table.AddRow(n, k.Pub, sk, stored)
+		label := ""
+		if m, err := p.KS.GetKeyMetadata(k.Pub); err == nil {
+			label = m.Description()
+		}
+		table.AddRow(n, k.Pub, sk, stored, label)
 	}
 	s := table.Render()
 	if hasUnreferenced {
@@ -19,7 +19,6 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"path/filepath"
 	"sort"
 
@@ -110,7 +109,7 @@ func (cb *MemResolverConfigBuilder) GenerateConfig() ([]byte, error) {
 
 func (cb *MemResolverConfigBuilder) writeFile(dir string, name string, token string) (string, error) {
 	fp := filepath.Join(dir, store.JwtName(name))
-	err := ioutil.WriteFile(fp, []byte(token), 0666)
+	err := store.WriteFile(fp, []byte(token))
 	return fp, err
 }
 
@@ -160,7 +159,7 @@ func (cb *MemResolverConfigBuilder) GenerateDir() ([]byte, error) {
 	}
 	buf.WriteString("}\n")
 
-	err = ioutil.WriteFile(filepath.Join(cb.dir, "resolver.conf"), buf.Bytes(), 0666)
+	err = store.WriteFile(filepath.Join(cb.dir, "resolver.conf"), buf.Bytes())
 	if err != nil {
 		return nil, err
 	}
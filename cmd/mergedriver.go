@@ -0,0 +1,269 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createMergeDriverCmd() *cobra.Command {
+	var params MergeDriverParams
+	cmd := &cobra.Command{
+		Use:   "merge-driver <base> <ours> <theirs> [<path>]",
+		Short: "Merge account and user JWTs changed on two git branches",
+		Long: `Merge account and user JWTs changed on two git branches
+
+The command is a git merge driver for JWT files. It decodes the common
+ancestor, our and their version of an account or user JWT and merges
+their claims: changes made on one side only are kept, exports, imports,
+signing keys, revocations, mappings and other lists are merged by entry,
+limits by field. Both versions have to be issued by the operator, for an
+account, or by the account, for a user, or by one of their signing keys. A
+clean merge is signed with the issuer of our or their version, which has to
+be in the keystore or available from the external signer. Our version is
+replaced with the result.
+
+Fields changed differently on both sides are conflicts. They are reported
+and the file is left with conflict markers around both versions.
+
+To use the driver, add to .gitattributes in the store root:
+  *.jwt merge=nsc
+and configure it with:
+  git config merge.nsc.name "nsc JWT merge"
+  git config merge.nsc.driver "nsc merge-driver %O %A %B %P"`,
+		Args:         cobra.RangeArgs(3, 4),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunMaybeStorelessAction(cmd, args, &params)
+		},
+	}
+	return cmd
+}
+
+func init() {
+	GetRootCmd().AddCommand(createMergeDriverCmd())
+}
+
+type MergeDriverParams struct {
+	// path of our version, the result is written to it
+	oursPath string
+	// name of the file in the work tree
+	name      string
+	base      []byte
+	ours      []byte
+	theirs    []byte
+	kind      jwt.ClaimType
+	issuers   []string
+	merged    map[string]interface{}
+	conflicts []*MergeConflict
+}
+
+func (p *MergeDriverParams) SetDefaults(ctx ActionCtx) error {
+	args := ctx.Args()
+	p.oursPath = args[1]
+	p.name = args[1]
+	if len(args) == 4 {
+		p.name = args[3]
+	}
+	return nil
+}
+
+// NoAutoCommit - the merged file is committed by the git merge running the driver
+func (p *MergeDriverParams) NoAutoCommit() {}
+
+func (p *MergeDriverParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *MergeDriverParams) Load(ctx ActionCtx) error {
+	args := ctx.Args()
+	for i, v := range []*[]byte{&p.base, &p.ours, &p.theirs} {
+		d, err := ioutil.ReadFile(args[i])
+		if err != nil {
+			return err
+		}
+		*v = bytes.TrimSpace(d)
+	}
+	return nil
+}
+
+func (p *MergeDriverParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *MergeDriverParams) Validate(ctx ActionCtx) error {
+	for _, t := range [][]byte{p.ours, p.theirs} {
+		gc, err := jwt.DecodeGeneric(string(t))
+		if err != nil {
+			return fmt.Errorf("%#q is not a JWT: %v", p.name, err)
+		}
+		if gc.ClaimType() != jwt.AccountClaim && gc.ClaimType() != jwt.UserClaim {
+			return fmt.Errorf("%#q is an %s JWT - only account and user JWTs can be merged", p.name, gc.ClaimType())
+		}
+		if p.kind != "" && p.kind != gc.ClaimType() {
+			return fmt.Errorf("%#q is an %s JWT on one side and a %s JWT on the other", p.name, p.kind, gc.ClaimType())
+		}
+		p.kind = gc.ClaimType()
+		p.issuers = append(p.issuers, gc.Issuer)
+	}
+	return nil
+}
+
+// checkIssuer verifies that an account version is issued by the operator,
+// and a user version by its account, or one of their signing keys
+func (p *MergeDriverParams) checkIssuer(ctx ActionCtx, token []byte) error {
+	s := ctx.StoreCtx().Store
+	if s == nil {
+		return errors.New("no operator is set to verify the issuer")
+	}
+	if p.kind == jwt.AccountClaim {
+		ac, err := jwt.DecodeAccountClaims(string(token))
+		if err != nil {
+			return err
+		}
+		oc, err := s.ReadOperatorClaim()
+		if err != nil {
+			return err
+		}
+		if !oc.DidSign(ac) {
+			return fmt.Errorf("account %q is not signed by operator %q or one of its signing keys", ac.Subject, oc.Name)
+		}
+		return nil
+	}
+	uc, err := jwt.DecodeUserClaims(string(token))
+	if err != nil {
+		return err
+	}
+	apk := uc.IssuerAccount
+	if apk == "" {
+		apk = uc.Issuer
+	}
+	names, err := s.ListSubContainers(store.Accounts)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		ac, err := s.ReadAccountClaim(n)
+		if err != nil || ac.Subject != apk {
+			continue
+		}
+		if !ac.DidSign(uc) {
+			return fmt.Errorf("user %q is not signed by account %q or one of its signing keys", uc.Subject, ac.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("user %q is issued for %q, which is not an account of operator %q", uc.Subject, apk, s.GetName())
+}
+
+// signer returns the first issuer of our or their version that can sign
+func (p *MergeDriverParams) signer(ctx ActionCtx) (nkeys.KeyPair, error) {
+	ks := ctx.StoreCtx().KeyStore
+	for _, pk := range p.issuers {
+		if ks.CanSign(pk) {
+			return ks.GetKeyPair(pk)
+		}
+	}
+	return nil, fmt.Errorf("none of the issuers %s can sign - add one of their keys to the keystore", jwt.StringList(p.issuers))
+}
+
+// encode decodes the merged claims into typed claims and signs them
+func (p *MergeDriverParams) encode(ctx ActionCtx) (string, error) {
+	d, err := json.Marshal(p.merged)
+	if err != nil {
+		return "", err
+	}
+	// the constructors initialize the maps the claims are decoded into
+	sub, _ := p.merged["sub"].(string)
+	if sub == "" {
+		return "", errors.New("merged claims have no subject")
+	}
+	var claims jwt.Claims
+	switch p.kind {
+	case jwt.AccountClaim:
+		claims = jwt.NewAccountClaims(sub)
+	case jwt.UserClaim:
+		claims = jwt.NewUserClaims(sub)
+	}
+	if err := json.Unmarshal(d, claims); err != nil {
+		return "", fmt.Errorf("merged claims are invalid: %v", err)
+	}
+	kp, err := p.signer(ctx)
+	if err != nil {
+		return "", err
+	}
+	return claims.Encode(kp)
+}
+
+// writeConflict leaves both versions in the file between conflict markers
+func (p *MergeDriverParams) writeConflict() error {
+	d := fmt.Sprintf("<<<<<<< ours\n%s\n=======\n%s\n>>>>>>> theirs\n", p.ours, p.theirs)
+	return ioutil.WriteFile(p.oursPath, []byte(d), 0600)
+}
+
+func (p *MergeDriverParams) Run(ctx ActionCtx) (store.Status, error) {
+	var err error
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	// the merged claims are signed, so both versions have to be trusted
+	for _, t := range [][]byte{p.ours, p.theirs} {
+		if err := p.checkIssuer(ctx, t); err != nil {
+			r.AddError("%#q can't be merged: %v", p.name, err)
+			if werr := p.writeConflict(); werr != nil {
+				return r, werr
+			}
+			return r, errors.New("merge failed")
+		}
+	}
+	p.merged, p.conflicts, err = MergeClaims(p.base, p.ours, p.theirs)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := p.merged["name"].(string)
+	if len(p.conflicts) > 0 {
+		if err := p.writeConflict(); err != nil {
+			return nil, err
+		}
+		for _, c := range p.conflicts {
+			r.AddError("%s", c)
+		}
+		err := fmt.Errorf("%s %q in %#q has %d conflict(s)", p.kind, name, p.name, len(p.conflicts))
+		r.AddFromError(err)
+		return r, err
+	}
+	token, err := p.encode(ctx)
+	if err != nil {
+		r.AddError("merged %s %q in %#q but could not sign it: %v", p.kind, name, p.name, err)
+		if werr := p.writeConflict(); werr != nil {
+			return r, werr
+		}
+		return r, errors.New("merge failed")
+	}
+	if err := ioutil.WriteFile(p.oursPath, []byte(token), 0600); err != nil {
+		return nil, err
+	}
+	r.AddOK("merged %s %q in %#q", p.kind, name, p.name)
+	return r, nil
+}
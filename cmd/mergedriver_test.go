@@ -0,0 +1,226 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+// writeMergeVersions writes the base, ours and theirs versions of the
+// account A edited by the functions and returns their paths
+func writeMergeVersions(t *testing.T, ts *TestStore, ours func(*jwt.AccountClaims), theirs func(*jwt.AccountClaims)) []string {
+	base, err := ts.Store.ReadRawAccountClaim("A")
+	require.NoError(t, err)
+	var files []string
+	for i, fn := range []func(*jwt.AccountClaims){nil, ours, theirs} {
+		token := string(base)
+		if fn != nil {
+			ac, err := jwt.DecodeAccountClaims(token)
+			require.NoError(t, err)
+			fn(ac)
+			token, err = ac.Encode(ts.OperatorKey)
+			require.NoError(t, err)
+		}
+		fp := filepath.Join(ts.Dir, []string{"base", "ours", "theirs"}[i])
+		require.NoError(t, ioutil.WriteFile(fp, []byte(token), 0600))
+		files = append(files, fp)
+	}
+	return files
+}
+
+func Test_MergeDriver(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	_, upk, _ := CreateUserKey(t)
+	_, sk, _ := CreateAccountKey(t)
+
+	files := writeMergeVersions(t, ts, func(ac *jwt.AccountClaims) {
+		ac.Exports.Add(&jwt.Export{Subject: "a.>", Type: jwt.Stream})
+		ac.Limits.Conn = 10
+		ac.Revocations = jwt.RevocationList{upk: 100}
+	}, func(ac *jwt.AccountClaims) {
+		ac.Exports.Add(&jwt.Export{Subject: "b.>", Type: jwt.Service})
+		ac.Limits.Subs = 5
+		ac.SigningKeys.Add(sk)
+		ac.Revocations = jwt.RevocationList{upk: 200}
+	})
+	_, stderr, err := ExecuteCmd(createMergeDriverCmd(), append(files, "O/accounts/A/A.jwt")...)
+	require.NoError(t, err)
+	require.Contains(t, stderr, `merged account "A" in `+"`O/accounts/A/A.jwt`")
+
+	d, err := ioutil.ReadFile(files[1])
+	require.NoError(t, err)
+	ac, err := jwt.DecodeAccountClaims(string(d))
+	require.NoError(t, err)
+	require.Len(t, ac.Exports, 2)
+	require.Equal(t, int64(10), ac.Limits.Conn)
+	require.Equal(t, int64(5), ac.Limits.Subs)
+	require.True(t, ac.SigningKeys.Contains(sk))
+	require.Equal(t, int64(200), ac.Revocations[upk])
+	require.Equal(t, ts.GetOperatorPublicKey(t), ac.Issuer)
+}
+
+func Test_MergeDriverConflict(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	files := writeMergeVersions(t, ts, func(ac *jwt.AccountClaims) {
+		ac.Limits.Conn = 10
+		ac.Exports.Add(&jwt.Export{Subject: "a.>", Type: jwt.Stream})
+	}, func(ac *jwt.AccountClaims) {
+		ac.Limits.Conn = 20
+		ac.Exports.Add(&jwt.Export{Subject: "a.>", Type: jwt.Service})
+	})
+	ours, err := ioutil.ReadFile(files[1])
+	require.NoError(t, err)
+	_, stderr, err := ExecuteCmd(createMergeDriverCmd(), files...)
+	require.Error(t, err)
+	require.Contains(t, stderr, "nats.limits.conn: base -1, ours 10, theirs 20")
+	require.Contains(t, stderr, `nats.exports[a.>].type: base (absent), ours "stream", theirs "service"`)
+	require.Contains(t, stderr, "has 2 conflict(s)")
+
+	d, err := ioutil.ReadFile(files[1])
+	require.NoError(t, err)
+	require.Contains(t, string(d), "<<<<<<< ours\n"+string(ours)+"\n=======")
+}
+
+func Test_MergeDriverUntrustedIssuer(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	files := writeMergeVersions(t, ts, func(ac *jwt.AccountClaims) {
+		ac.Limits.Conn = 10
+	}, nil)
+	// their version is signed by a key the operator doesn't trust
+	_, _, okp := CreateOperatorKey(t)
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	ac.Limits.Subs = 5
+	token, err := ac.Encode(okp)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(files[2], []byte(token), 0600))
+
+	_, stderr, err := ExecuteCmd(createMergeDriverCmd(), files...)
+	require.Error(t, err)
+	require.Contains(t, stderr, "is not signed by operator \"O\"")
+	d, err := ioutil.ReadFile(files[1])
+	require.NoError(t, err)
+	require.Contains(t, string(d), "<<<<<<< ours")
+}
+
+func Test_MergeDriverUntrustedUserIssuer(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+
+	base, err := ts.Store.ReadRawUserClaim("A", "U")
+	require.NoError(t, err)
+	uc, err := ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+	uc.Tags.Add("ours")
+	ours, err := uc.Encode(ts.GetAccountKey(t, "A"))
+	require.NoError(t, err)
+	// their version claims to be issued for account A by a foreign key
+	_, _, akp := CreateAccountKey(t)
+	uc, err = ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+	uc.IssuerAccount = ts.GetAccountPublicKey(t, "A")
+	uc.Tags.Add("theirs")
+	theirs, err := uc.Encode(akp)
+	require.NoError(t, err)
+
+	var files []string
+	for i, d := range []string{string(base), ours, theirs} {
+		fp := filepath.Join(ts.Dir, []string{"base", "ours", "theirs"}[i])
+		require.NoError(t, ioutil.WriteFile(fp, []byte(d), 0600))
+		files = append(files, fp)
+	}
+	_, stderr, err := ExecuteCmd(createMergeDriverCmd(), files...)
+	require.Error(t, err)
+	require.Contains(t, stderr, "is not signed by account \"A\"")
+}
+
+// Test_MergeDriverProcess runs the merge driver as git would,
+// it is run by Test_MergeDriverGitMerge
+func Test_MergeDriverProcess(t *testing.T) {
+	args := strings.Fields(os.Getenv("NSC_TEST_MERGE_ARGS"))
+	if len(args) == 0 {
+		t.Skip("run by Test_MergeDriverGitMerge")
+	}
+	ts := NewEmptyStore(t)
+	defer ts.Done(t)
+	require.NoError(t, ForceStoreRoot(t, os.Getenv("NSC_TEST_MERGE_ROOT")))
+	ForceOperator(t, "O")
+	require.NoError(t, os.Setenv(store.NKeysPathEnv, os.Getenv("NSC_TEST_MERGE_KEYS")))
+	_, _, err := ExecuteCmd(createMergeDriverCmd(), args...)
+	require.NoError(t, err)
+}
+
+func Test_MergeDriverGitMerge(t *testing.T) {
+	requireGit(t)
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	root := ts.GetStoresRoot()
+	_, _, err := ExecuteCmd(createStoreAutoCommitCmd())
+	require.NoError(t, err)
+
+	git := func(args ...string) string {
+		var out bytes.Buffer
+		cmd := exec.Command("git", append([]string{"-C", root, "-c", "user.name=nsc", "-c", "user.email=nsc@localhost"}, args...)...)
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		require.NoError(t, cmd.Run(), out.String())
+		return strings.TrimSpace(out.String())
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, ".gitattributes"), []byte("*.jwt merge=nsc\n"), 0600))
+	git("add", ".gitattributes")
+	git("commit", "-q", "-m", "merge JWTs with nsc")
+	git("config", "merge.nsc.driver", fmt.Sprintf("NSC_TEST_MERGE_ARGS='%%O %%A %%B %%P' NSC_TEST_MERGE_ROOT='%s' NSC_TEST_MERGE_KEYS='%s' '%s' -test.run='^Test_MergeDriverProcess$'",
+		root, store.GetKeysDir(), os.Args[0]))
+
+	main := git("rev-parse", "--abbrev-ref", "HEAD")
+	git("checkout", "-q", "-b", "other")
+	_, _, err = ExecuteCmd(createEditAccount(), "--name", "A", "--conns", "10")
+	require.NoError(t, err)
+	git("checkout", "-q", main)
+	_, _, err = ExecuteCmd(createEditAccount(), "--name", "A", "--data", "5")
+	require.NoError(t, err)
+
+	git("merge", "--no-edit", "other")
+	require.Empty(t, git("status", "--porcelain"))
+	require.Contains(t, lastCommitMessage(t, root), "Merge branch 'other'")
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), ac.Limits.Conn)
+	require.Equal(t, int64(5), ac.Limits.Data)
+}
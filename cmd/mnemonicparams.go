@@ -0,0 +1,101 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+// keyGenerator returns the named key of an entity - names are one of the
+// store.Derived* constants
+type keyGenerator func(name string, prefix nkeys.PrefixByte) (nkeys.KeyPair, error)
+
+// randomKey is the keyGenerator used when keys are not derived from a mnemonic
+func randomKey(_ string, prefix nkeys.PrefixByte) (nkeys.KeyPair, error) {
+	return nkeys.CreatePair(prefix)
+}
+
+// MnemonicParams derive the operator and system account keys from a mnemonic
+type MnemonicParams struct {
+	Mnemonic       string
+	DerivationPath string
+	Generate       bool
+	deriver        *store.KeyDeriver
+}
+
+func (p *MnemonicParams) BindFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&p.Mnemonic, "mnemonic", "", "", "derive the operator and system account keys from a mnemonic phrase or a file holding it")
+	cmd.Flags().StringVarP(&p.DerivationPath, "derivation-path", "", store.DefaultDerivationPath, "derivation path of the keys derived from the mnemonic")
+	cmd.Flags().BoolVarP(&p.Generate, "generate-mnemonic", "", false, "generate a mnemonic and derive the operator and system account keys from it")
+}
+
+// Enabled returns true if keys are derived from a mnemonic
+func (p *MnemonicParams) Enabled() bool {
+	return p.Mnemonic != "" || p.Generate
+}
+
+// Resolve reads or generates the mnemonic
+func (p *MnemonicParams) Resolve() error {
+	if !p.Enabled() {
+		return nil
+	}
+	if p.Mnemonic != "" && p.Generate {
+		return errors.New("specify either --mnemonic or --generate-mnemonic")
+	}
+	if p.Generate {
+		m, err := store.NewMnemonic()
+		if err != nil {
+			return err
+		}
+		p.Mnemonic = m
+	} else if _, err := os.Stat(p.Mnemonic); err == nil {
+		d, err := ioutil.ReadFile(p.Mnemonic)
+		if err != nil {
+			return err
+		}
+		p.Mnemonic = string(d)
+	}
+	var err error
+	p.deriver, err = store.NewKeyDeriver(p.Mnemonic, p.DerivationPath)
+	return err
+}
+
+// KeyGenerator returns a generator deriving keys from the mnemonic, or
+// generating random keys if no mnemonic was specified
+func (p *MnemonicParams) KeyGenerator() keyGenerator {
+	if p.deriver == nil {
+		return randomKey
+	}
+	return p.deriver.Derive
+}
+
+// AddToReport reports the generated mnemonic, it is the only copy of it
+func (p *MnemonicParams) AddToReport(r *store.Report) {
+	if p.deriver == nil {
+		return
+	}
+	if p.Generate {
+		r.AddWarning("write down the generated mnemonic and keep it safe - 'nsc keys recover' regenerates the operator and system account keys from it:\n%s", store.NormalizeMnemonic(p.Mnemonic))
+	}
+	r.AddOK("derived the operator and system account keys with derivation path %q", p.deriver.Path())
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kbehouse/nsc/cmd/store"
+	"github.com/spf13/cobra"
+)
+
+const (
+	TextOutput = "text"
+	JsonOutput = "json"
+)
+
+// OutputFlag selects how results are printed
+var OutputFlag = outputFormat(TextOutput)
+
+// lastStatusCode is the code of the last status produced by an action,
+// it determines the exit code when the output is json
+var lastStatusCode = store.NONE
+
+// jsonDocumentWritten is set once a command printed its json document
+var jsonDocumentWritten bool
+
+type outputFormat string
+
+func (o *outputFormat) Set(val string) error {
+	switch val {
+	case TextOutput, JsonOutput:
+		*o = outputFormat(val)
+		return nil
+	}
+	return fmt.Errorf("unsupported output format %q - valid formats are %q or %q", val, TextOutput, JsonOutput)
+}
+
+func (o *outputFormat) String() string {
+	return string(*o)
+}
+
+func (o *outputFormat) Type() string {
+	return "format"
+}
+
+// IsJsonOutput returns true if results should be printed as json documents
+func IsJsonOutput() bool {
+	return OutputFlag == JsonOutput
+}
+
+// JsonStatus is implemented by statuses that have a structured
+// representation that is more useful than their report tree
+type JsonStatus interface {
+	JsonDocument() (interface{}, error)
+}
+
+// reportDocument is the json document printed for the status returned by an action
+type reportDocument struct {
+	*store.StatusNode
+	Summary string            `json:"summary,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	DryRun  *store.StatusNode `json:"dry_run,omitempty"`
+}
+
+func writeJsonStatus(cmd *cobra.Command, rs store.Status, changes *store.Report, summary string, err error) error {
+	if rs != nil {
+		lastStatusCode = rs.Code()
+	}
+	if js, ok := rs.(JsonStatus); ok && err == nil {
+		doc, err := js.JsonDocument()
+		if err != nil {
+			return err
+		}
+		return writeJsonDocument(cmd, doc)
+	}
+	doc := reportDocument{StatusNode: store.NewStatusNode(rs), Summary: summary}
+	if changes != nil {
+		doc.DryRun = store.NewStatusNode(changes)
+	}
+	if doc.StatusNode == nil {
+		doc.StatusNode = &store.StatusNode{Code: store.NONE}
+	}
+	if err != nil {
+		doc.Error = err.Error()
+		doc.Code = store.ERR
+		lastStatusCode = store.ERR
+	}
+	return writeJsonDocument(cmd, doc)
+}
+
+func writeJsonDocument(cmd *cobra.Command, v interface{}) error {
+	d, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error formatting json: %v", err)
+	}
+	jsonDocumentWritten = true
+	_, err = fmt.Fprintln(cmd.OutOrStdout(), string(d))
+	return err
+}
+
+// ExitCode maps the outcome of a command to the process exit code:
+// 0 on success, 1 on errors and 2 when the json output reports warnings
+func ExitCode(err error) int {
+	if err != nil {
+		return 1
+	}
+	if IsJsonOutput() {
+		switch lastStatusCode {
+		case store.ERR:
+			return 1
+		case store.WARN:
+			return 2
+		}
+	}
+	return 0
+}
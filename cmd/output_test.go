@@ -0,0 +1,130 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/kbehouse/nsc/cmd/store"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_OutputJsonReport(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	_, stderr, err := ExecuteCmd(HoistRootFlags(CreateAddAccountCmd()), "--name", "A", "--output", "json")
+	require.NoError(t, err)
+	var doc struct {
+		Code     string `json:"code"`
+		Children []struct {
+			Code  string `json:"code"`
+			Label string `json:"label"`
+		} `json:"children"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(stderr), &doc))
+	require.Equal(t, "OK", doc.Code)
+	require.NotEmpty(t, doc.Children)
+	require.Contains(t, doc.Children[0].Label, "generated and stored account key")
+}
+
+func Test_OutputJsonBadFormat(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	_, _, err := ExecuteCmd(HoistRootFlags(CreateAddAccountCmd()), "--name", "A", "--output", "yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported output format")
+}
+
+func Test_OutputJsonListKeys(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+
+	_, stderr, err := ExecuteCmd(HoistRootFlags(createListKeysCmd()), "--all", "--output", "json")
+	require.NoError(t, err)
+	var keys []KeyDocument
+	require.NoError(t, json.Unmarshal([]byte(stderr), &keys))
+	require.Len(t, keys, 3)
+	require.Equal(t, "O", keys[0].Entity)
+	require.Equal(t, "operator", keys[0].Kind)
+	require.Equal(t, ts.GetAccountPublicKey(t, "A"), keys[1].PublicKey)
+	require.True(t, keys[2].Stored)
+	require.Empty(t, keys[2].Seed)
+}
+
+func Test_OutputJsonListAccounts(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddAccount(t, "B")
+
+	_, stderr, err := ExecuteCmd(HoistRootFlags(createListAccountsCmd()), "--output", "json")
+	require.NoError(t, err)
+	var accounts []EntityDocument
+	require.NoError(t, json.Unmarshal([]byte(stderr), &accounts))
+	require.Len(t, accounts, 2)
+	require.Equal(t, "A", accounts[0].Name)
+	require.Equal(t, ts.GetAccountPublicKey(t, "A"), accounts[0].PublicKey)
+	require.Equal(t, "B", accounts[1].Name)
+	require.True(t, accounts[1].Current)
+}
+
+func Test_OutputJsonValidate(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	_, stderr, err := ExecuteCmd(HoistRootFlags(createValidateCommand()), "--all-accounts", "--output", "json")
+	require.NoError(t, err)
+	var doc struct {
+		Code     string                `json:"code"`
+		Operator *ValidationDocument   `json:"operator"`
+		Accounts []*ValidationDocument `json:"accounts"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(stderr), &doc))
+	require.Equal(t, "O", doc.Operator.Name)
+	require.Len(t, doc.Accounts, 1)
+	require.Equal(t, "A", doc.Accounts[0].Name)
+}
+
+func Test_OutputJsonDescribe(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	stdout, _, err := ExecuteCmd(HoistRootFlags(createDescribeAccountCmd()), "--output", "json")
+	require.NoError(t, err)
+	m := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal([]byte(stdout), &m))
+	require.Equal(t, ts.GetAccountPublicKey(t, "A"), m["sub"])
+}
+
+func Test_OutputExitCode(t *testing.T) {
+	defer ResetSharedFlags()
+	require.Equal(t, 1, ExitCode(errors.New("failed")))
+
+	lastStatusCode = store.WARN
+	require.Equal(t, 0, ExitCode(nil))
+	OutputFlag = JsonOutput
+	require.Equal(t, 2, ExitCode(nil))
+	lastStatusCode = store.OK
+	require.Equal(t, 0, ExitCode(nil))
+}
@@ -18,6 +18,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -159,9 +160,6 @@ func (p *PullParams) Validate(ctx ActionCtx) error {
 	if oc.AccountServerURL == "" {
 		return fmt.Errorf("operator %q doesn't set account server url - unable to pull", ctx.StoreCtx().Operator.Name)
 	}
-	if IsNatsUrl(oc.AccountServerURL) && !p.All {
-		return fmt.Errorf("operator %q can only pull all jwt - unable to pull by account", ctx.StoreCtx().Operator.Name)
-	}
 	return nil
 }
 
@@ -254,43 +252,7 @@ func (p *PullParams) Run(ctx ActionCtx) (store.Status, error) {
 		r.AddError("operator %s: %v", op.Name, err)
 		return r, err
 	} else if url := op.AccountServerURL; IsNatsUrl(url) {
-		subR := store.NewReport(store.OK, `pull from cluster using system account`)
-		r.Add(subR)
-		ib := nats.NewInbox()
-		_, opt, err := getSystemAccountUser(ctx, p.sysAcc, p.sysAccUser, ib, "$SYS.REQ.CLAIMS.PACK")
-		if err != nil {
-			subR.AddError("failed to obtain system user: %v", err)
-			return r, nil
-		}
-		nc, err := nats.Connect(url, createDefaultToolOptions("nsc_pull", ctx, opt)...)
-		if err != nil {
-			subR.AddError("failed to connect to %s: %v", url, err)
-			return r, nil
-		}
-		defer nc.Close()
-
-		sub, err := nc.SubscribeSync(ib)
-		if err != nil {
-			subR.AddError("failed to subscribe to response subject: %v", err)
-			return r, nil
-		}
-		if err := nc.PublishRequest("$SYS.REQ.CLAIMS.PACK", ib, nil); err != nil {
-			subR.AddError("failed to pull accounts: %v", err)
-			return r, nil
-		}
-		for {
-			if resp, err := sub.NextMsg(time.Second); err != nil {
-				subR.AddError("failed to get response to pull: %v", err)
-				break
-			} else if msg := string(resp.Data); msg == "" { // empty response means end
-				break
-			} else if tk := strings.Split(string(resp.Data), "|"); len(tk) != 2 {
-				subR.AddError("pull response bad")
-				break
-			} else {
-				p.maybeStoreJWT(ctx, subR, tk[1])
-			}
-		}
+		r.Add(p.pullFromNats(ctx, url))
 		return r, nil
 	}
 
@@ -326,3 +288,176 @@ func (p *PullParams) Run(ctx ActionCtx) (store.Status, error) {
 	}
 	return r, nil
 }
+
+// pullFromNats pulls account JWTs from nats-servers running the full nats-resolver.
+// Servers are asked for the accounts they have, then every account of interest
+// is looked up, keeping the newest version returned by any of the servers.
+func (p *PullParams) pullFromNats(ctx ActionCtx, url string) *store.Report {
+	r := store.NewReport(store.OK, `pull from cluster using system account`)
+	_, opt, err := getSystemAccountUser(ctx, p.sysAcc, p.sysAccUser, nats.InboxPrefix+">",
+		"$SYS.REQ.CLAIMS.LIST", "$SYS.REQ.ACCOUNT.*.CLAIMS.LOOKUP")
+	if err != nil {
+		r.AddError("failed to obtain system user: %v", err)
+		return r
+	}
+	nc, err := nats.Connect(url, createDefaultToolOptions("nsc_pull", ctx, opt)...)
+	if err != nil {
+		r.AddError("failed to connect to %s: %v", url, err)
+		return r
+	}
+	defer nc.Close()
+
+	servers := map[string][]string{}
+	if multiRequest(nc, r, "list accounts", "$SYS.REQ.CLAIMS.LIST", nil, func(srv string, data interface{}) {
+		var ids []string
+		if l, ok := data.([]interface{}); ok {
+			for _, v := range l {
+				if id, ok := v.(string); ok {
+					ids = append(ids, id)
+				}
+			}
+		}
+		servers[srv] = ids
+	}) == 0 {
+		r.AddError("no servers responded to the account list request")
+		return r
+	}
+
+	var accounts []string
+	if p.All {
+		seen := map[string]bool{}
+		for _, ids := range servers {
+			for _, id := range ids {
+				if !seen[id] {
+					seen[id] = true
+					accounts = append(accounts, id)
+				}
+			}
+		}
+		sort.Strings(accounts)
+	} else {
+		ac, err := ctx.StoreCtx().Store.ReadAccountClaim(p.Name)
+		if err != nil {
+			r.AddError("unable to read account %q: %v", p.Name, err)
+			return r
+		}
+		accounts = []string{ac.Subject}
+	}
+
+	srvNames := make([]string, 0, len(servers))
+	for srv := range servers {
+		srvNames = append(srvNames, srv)
+	}
+	sort.Strings(srvNames)
+	for _, srv := range srvNames {
+		srvR := store.NewReport(store.OK, "server %s", srv)
+		r.Add(srvR)
+		if p.All {
+			srvR.AddOK("has %d accounts", len(servers[srv]))
+			continue
+		}
+		if containsString(servers[srv], accounts[0]) {
+			srvR.AddOK("has account %q", p.Name)
+		} else {
+			srvR.AddWarning("doesn't have account %q", p.Name)
+		}
+	}
+
+	for _, id := range accounts {
+		subR := store.NewReport(store.OK, "pull %q from the cluster", id)
+		subR.Opt = store.DetailsOnErrorOrWarning
+		r.Add(subR)
+		expected := 0
+		for _, ids := range servers {
+			if containsString(ids, id) {
+				expected++
+			}
+		}
+		tokens := lookupAccount(nc, subR, id, expected)
+		if len(tokens) == 0 {
+			subR.AddError("no server returned a jwt for %q", id)
+			continue
+		}
+		token := newestAccountJWT(subR, id, tokens)
+		if token == "" {
+			continue
+		}
+		p.maybeStoreJWT(ctx, subR, token)
+	}
+	return r
+}
+
+// newestAccountJWT returns the most recently issued of the JWTs servers returned for the account,
+// or an empty string if none of them is valid
+func newestAccountJWT(report *store.Report, id string, tokens []string) string {
+	var newest *jwt.AccountClaims
+	token := ""
+	for _, t := range tokens {
+		ac, err := jwt.DecodeAccountClaims(t)
+		if err != nil {
+			report.AddError("error decoding remote token: %v %s", err, t)
+			continue
+		}
+		if ac.Subject != id {
+			report.AddError("server returned a jwt for %q when looking up %q", ac.Subject, id)
+			continue
+		}
+		if newest == nil || ac.IssuedAt > newest.IssuedAt {
+			newest = ac
+			token = t
+		}
+	}
+	if newest == nil {
+		return ""
+	}
+	for _, t := range tokens {
+		if t != token {
+			report.AddWarning("servers returned different versions of %q - using the newest", newest.Name)
+			break
+		}
+	}
+	return token
+}
+
+// lookupAccount returns the distinct JWTs servers responded with for the account,
+// it stops waiting once the expected number of servers responded
+func lookupAccount(nc *nats.Conn, report *store.Report, id string, expected int) []string {
+	ib := nats.NewInbox()
+	sub, err := nc.SubscribeSync(ib)
+	if err != nil {
+		report.AddError("failed to subscribe to response subject: %v", err)
+		return nil
+	}
+	defer sub.Unsubscribe()
+	if err := nc.PublishRequest(fmt.Sprintf("$SYS.REQ.ACCOUNT.%s.CLAIMS.LOOKUP", id), ib, nil); err != nil {
+		report.AddError("failed to lookup %q: %v", id, err)
+		return nil
+	}
+	var tokens []string
+	responses := 0
+	now := time.Now()
+	end := now.Add(time.Second)
+	for ; end.After(now) && (expected == 0 || responses < expected); now = time.Now() {
+		resp, err := sub.NextMsg(end.Sub(now))
+		if err != nil {
+			if err != nats.ErrTimeout {
+				report.AddError("failed to get response to lookup of %q: %v", id, err)
+			}
+			break
+		}
+		responses++
+		if t := string(resp.Data); t != "" && !containsString(tokens, t) {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+func containsString(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -27,6 +27,8 @@ import (
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nkeys"
 	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
 )
 
 func editAccount(t *testing.T, kp nkeys.KeyPair, d []byte, tag string) []byte {
@@ -232,3 +234,102 @@ func Test_V1OperatorDoesntFail(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, oc.Version, 2)
 }
+
+func Test_SyncAccountFromNatsResolver(t *testing.T) {
+	ts := NewEmptyStore(t)
+	defer ts.Done(t)
+	_, _, err := ExecuteCmd(CreateAddOperatorCmd(), "--name", "OP", "--sys")
+	require.NoError(t, err)
+	ts.SwitchOperator(t, "OP")
+	serverconf := filepath.Join(ts.Dir, "server.conf")
+	_, _, err = ExecuteCmd(createServerConfigCmd(), "--nats-resolver", "--config-file", serverconf)
+	require.NoError(t, err)
+	_, _, err = ExecuteCmd(CreateAddAccountCmd(), "--name", "AC1")
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(serverconf)
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "Test_SyncAccountFromNatsResolver-jwt-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	data = bytes.ReplaceAll(data, []byte(`dir: './jwt'`), []byte(fmt.Sprintf(`dir: '%s'`, dir)))
+	err = ioutil.WriteFile(serverconf, data, 0660)
+	require.NoError(t, err)
+
+	// the server has a version of AC1 that was modified outside of nsc
+	opKey, err := ts.Store.GetRootPublicKey()
+	require.NoError(t, err)
+	opKp, err := ts.KeyStore.GetKeyPair(opKey)
+	require.NoError(t, err)
+	ac, err := ts.Store.ReadAccountClaim("AC1")
+	require.NoError(t, err)
+	ac.Tags.Add("remote")
+	remote, err := ac.Encode(opKp)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, ac.Subject+".jwt"), []byte(remote), 0660)
+	require.NoError(t, err)
+
+	ports := ts.RunServerWithConfig(t, serverconf)
+	require.NotNil(t, ports)
+	_, _, err = ExecuteCmd(CreateEditOperatorCmd(), "--account-jwt-server-url", ports.Nats[0])
+	require.NoError(t, err)
+
+	_, stderr, err := ExecuteCmd(createPullCmd(), "--account", "AC1")
+	require.NoError(t, err)
+	require.Contains(t, stderr, fmt.Sprintf("server %s:", ts.Server.ID()))
+	require.Contains(t, stderr, "has account \"AC1\"")
+	require.Contains(t, stderr, "pulled \"AC1\" from the account server")
+	ac, err = ts.Store.ReadAccountClaim("AC1")
+	require.NoError(t, err)
+	require.Contains(t, ac.Tags, "remote")
+
+	// a newer local version is not overwritten unless requested
+	time.Sleep(time.Second)
+	_, _, err = ExecuteCmd(createEditAccount(), "--name", "AC1", "--tag", "local")
+	require.NoError(t, err)
+	_, _, err = ExecuteCmd(createPullCmd(), "--account", "AC1")
+	require.Error(t, err)
+	ac, err = ts.Store.ReadAccountClaim("AC1")
+	require.NoError(t, err)
+	require.Contains(t, ac.Tags, "local")
+
+	_, _, err = ExecuteCmd(createPullCmd(), "--account", "AC1", "--overwrite-newer")
+	require.NoError(t, err)
+	ac, err = ts.Store.ReadAccountClaim("AC1")
+	require.NoError(t, err)
+	require.NotContains(t, ac.Tags, "local")
+	require.Contains(t, ac.Tags, "remote")
+}
+
+func Test_PullKeepsNewestAccountJWT(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddAccount(t, "B")
+	apk := ts.GetAccountPublicKey(t, "A")
+
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	ac.Tags.Add("older")
+	older, err := ac.Encode(ts.OperatorKey)
+	require.NoError(t, err)
+	// IssuedAt has a resolution of a second
+	time.Sleep(time.Second)
+	ac.Tags.Remove("older")
+	ac.Tags.Add("newer")
+	newer, err := ac.Encode(ts.OperatorKey)
+	require.NoError(t, err)
+	other, err := ts.Store.ReadRawAccountClaim("B")
+	require.NoError(t, err)
+
+	for _, tokens := range [][]string{{older, newer}, {newer, older}, {string(other), older, newer}} {
+		r := store.NewReport(store.OK, "pull")
+		require.Equal(t, newer, newestAccountJWT(r, apk, tokens))
+		require.Contains(t, r.Format(""), "servers returned different versions of \"A\" - using the newest")
+	}
+
+	r := store.NewReport(store.OK, "pull")
+	require.Equal(t, older, newestAccountJWT(r, apk, []string{older}))
+	require.False(t, r.HasErrors())
+	require.Empty(t, newestAccountJWT(r, apk, []string{string(other)}))
+	require.True(t, r.HasErrors())
+}
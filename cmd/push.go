@@ -197,6 +197,9 @@ func getSystemAccountUser(ctx ActionCtx, sysAccName, sysAccUserName, allowSub st
 	return "", nil, fmt.Errorf(`no system account user with corresponding nkey found`)
 }
 
+// DryRunUnsupported - a push updates the servers, which a dry-run can't capture
+func (p *PushCmdParams) DryRunUnsupported() {}
+
 func (p *PushCmdParams) SetDefaults(ctx ActionCtx) error {
 	if p.allAccounts && p.Name != "" {
 		return errors.New("specify only one of --account or --all-accounts")
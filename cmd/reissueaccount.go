@@ -0,0 +1,300 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createReIssueAccountCmd() *cobra.Command {
+	var params reIssueAccount
+	cmd := &cobra.Command{
+		Use:   "account",
+		Short: "Re-issues an account with a new identity and fixes the references to it",
+		Long: `Re-issues an account with a new identity and fixes the references to it
+
+A new identity key is generated for the account. Its exports, imports,
+limits, signing keys, mappings and revocations are kept. Users are re-signed,
+imports of other accounts in the store that name the old public key are
+rewritten, and the activation tokens the account issued to them are
+regenerated. The changed accounts are listed in a push plan.
+
+Users issued by a signing key whose seed is not in the keystore cannot be
+re-signed. Activation tokens given to accounts outside the store must be
+regenerated with 'nsc generate activation'.`,
+		Example:      `nsc reissue account --name A`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.AccountContextParams.Name, "name", "n", "", "account to re-issue")
+	return cmd
+}
+
+func init() {
+	reIssue.AddCommand(createReIssueAccountCmd())
+}
+
+type reIssueAccount struct {
+	AccountContextParams
+	SignerParams
+	claim *jwt.AccountClaims
+}
+
+func (p *reIssueAccount) SetDefaults(ctx ActionCtx) error {
+	p.AccountContextParams.Name = NameFlagOrArgument(p.AccountContextParams.Name, ctx)
+	if err := p.AccountContextParams.SetDefaults(ctx); err != nil {
+		return err
+	}
+	p.SignerParams.SetDefaults(nkeys.PrefixByteOperator, true, ctx)
+	return nil
+}
+
+func (p *reIssueAccount) PreInteractive(ctx ActionCtx) error {
+	return p.AccountContextParams.Edit(ctx)
+}
+
+func (p *reIssueAccount) Load(ctx ActionCtx) error {
+	var err error
+	if err = p.AccountContextParams.Validate(ctx); err != nil {
+		return err
+	}
+	p.claim, err = ctx.StoreCtx().Store.ReadAccountClaim(p.AccountContextParams.Name)
+	return err
+}
+
+func (p *reIssueAccount) PostInteractive(ctx ActionCtx) error {
+	return p.SignerParams.Edit(ctx)
+}
+
+func (p *reIssueAccount) Validate(ctx ActionCtx) error {
+	return p.SignerParams.ResolveWithPriority(ctx, p.claim.Issuer)
+}
+
+// reissueActivation returns a copy of an activation issued by the old
+// identity signed by the new one, or "" if the token wasn't issued by it
+func reissueActivation(token string, old string, kp nkeys.KeyPair) (string, error) {
+	act, err := jwt.DecodeActivationClaims(token)
+	if err != nil {
+		return "", err
+	}
+	if act.Issuer != old && act.IssuerAccount != old {
+		return "", nil
+	}
+	nc := jwt.NewActivationClaims(act.Subject)
+	nc.Name = act.Name
+	nc.Audience = act.Audience
+	nc.Expires = act.Expires
+	nc.NotBefore = act.NotBefore
+	nc.Activation = act.Activation
+	nc.IssuerAccount = ""
+	return nc.Encode(kp)
+}
+
+// fixReferences rewrites the imports of an account that name the old
+// identity, returning true if the account changed
+func fixReferences(ac *jwt.AccountClaims, old string, pk string, kp nkeys.KeyPair, r *store.Report) (bool, error) {
+	changed := false
+	for _, im := range ac.Imports {
+		if im.Account != old {
+			continue
+		}
+		im.Account = pk
+		changed = true
+		r.AddOK("account %q import %q now names %q", ac.Name, im.Name, pk)
+		if im.Token == "" {
+			continue
+		}
+		token, err := reissueActivation(im.Token, old, kp)
+		if err != nil {
+			return changed, fmt.Errorf("unable to regenerate the activation of import %q: %v", im.Name, err)
+		}
+		if token != "" {
+			im.Token = token
+			r.AddOK("regenerated the activation of account %q import %q", ac.Name, im.Name)
+		}
+	}
+	return changed, nil
+}
+
+func (p *reIssueAccount) storeAccount(ctx ActionCtx, ac *jwt.AccountClaims, r *store.Report) bool {
+	token, err := ac.Encode(p.signerKP)
+	if err != nil {
+		r.AddError("unable to sign account %q: %v", ac.Name, err)
+		return false
+	}
+	ar := store.NewDetailedReport(true)
+	StoreAccountAndUpdateStatus(ctx, token, ar)
+	r.Add(ar)
+	return ar.HasNoErrors()
+}
+
+// reissueUsers re-signs the users of the account for its new identity
+func (p *reIssueAccount) reissueUsers(ctx ActionCtx, kp nkeys.KeyPair, r *store.Report) {
+	s := ctx.StoreCtx().Store
+	ks := ctx.StoreCtx().KeyStore
+	name := p.AccountContextParams.Name
+	old := p.claim.Subject
+	pk, _ := kp.PublicKey()
+	users, err := s.ListEntries(store.Accounts, name, store.Users)
+	if err != nil {
+		r.AddError("unable to list users: %v", err)
+		return
+	}
+	for _, n := range users {
+		uc, err := s.ReadUserClaim(name, n)
+		if err != nil {
+			r.AddError("unable to read user %q: %v", n, err)
+			continue
+		}
+		signer := kp
+		if uc.Issuer == old {
+			uc.IssuerAccount = ""
+		} else if uc.IssuerAccount == old {
+			skp, err := ks.GetKeyPair(uc.Issuer)
+			if err != nil || skp == nil || !ks.CanSign(uc.Issuer) {
+				r.AddError("user %q cannot be re-signed - the seed of its signing key %q is not available", n, uc.Issuer)
+				continue
+			}
+			signer = skp
+			uc.IssuerAccount = pk
+		} else {
+			continue
+		}
+		token, err := uc.Encode(signer)
+		if err != nil {
+			r.AddError("unable to re-sign user %q: %v", n, err)
+			continue
+		}
+		if err := s.StoreRaw([]byte(token)); err != nil {
+			r.AddError("unable to store user %q: %v", n, err)
+			continue
+		}
+		r.AddOK("re-signed user %q", n)
+		regenerateUserCreds(ctx, name, n, uc.Subject, r)
+	}
+}
+
+func (p *reIssueAccount) Run(ctx ActionCtx) (store.Status, error) {
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	s := ctx.StoreCtx().Store
+	ks := ctx.StoreCtx().KeyStore
+	name := p.AccountContextParams.Name
+	old := p.claim.Subject
+
+	kp, err := nkeys.CreateAccount()
+	if err != nil {
+		return nil, err
+	}
+	pk, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ks.Store(kp); err != nil {
+		return nil, err
+	}
+
+	nc := jwt.NewAccountClaims(pk)
+	nc.Name = p.claim.Name
+	nc.Audience = p.claim.Audience
+	nc.Expires = p.claim.Expires
+	nc.NotBefore = p.claim.NotBefore
+	nc.Account = p.claim.Account
+	if _, err := fixReferences(nc, old, pk, kp, r); err != nil {
+		r.AddError("%v", err)
+		return r, nil
+	}
+	if !p.storeAccount(ctx, nc, r) {
+		return r, nil
+	}
+	r.AddOK("account %q successfully changed identity to: %s", name, pk)
+	changed := []string{name}
+
+	p.reissueUsers(ctx, kp, r)
+	for _, im := range nc.Imports {
+		if im.Token == "" {
+			continue
+		}
+		if act, err := jwt.DecodeActivationClaims(im.Token); err == nil && act.Subject == old {
+			r.AddWarning("import %q has an activation issued to the old identity - it must be regenerated by the exporting account", im.Name)
+		}
+	}
+
+	accounts, err := s.ListSubContainers(store.Accounts)
+	if err != nil {
+		r.AddError("unable to list accounts: %v", err)
+		return r, nil
+	}
+	sort.Strings(accounts)
+	for _, n := range accounts {
+		if n == name {
+			continue
+		}
+		ac, err := s.ReadAccountClaim(n)
+		if err != nil {
+			r.AddError("unable to read account %q: %v", n, err)
+			continue
+		}
+		ok, err := fixReferences(ac, old, pk, kp, r)
+		if err != nil {
+			r.AddError("account %q: %v", n, err)
+			continue
+		}
+		if ok && p.storeAccount(ctx, ac, r) {
+			changed = append(changed, n)
+		}
+	}
+
+	if oc, err := s.ReadOperatorClaim(); err == nil && oc.SystemAccount == old {
+		okp, _ := ks.GetKeyPair(oc.Subject)
+		if okp == nil || !ks.CanSign(oc.Subject) {
+			r.AddWarning("the operator's system account is %q - update it with 'nsc edit operator --system-account %s'", name, name)
+		} else {
+			oc.SystemAccount = pk
+			token, err := oc.Encode(okp)
+			if err == nil {
+				err = s.StoreRaw([]byte(token))
+			}
+			if err != nil {
+				r.AddError("unable to update the operator's system account: %v", err)
+			} else {
+				r.AddOK("updated the operator's system account to %q", pk)
+			}
+		}
+	}
+
+	plan := store.NewReport(store.OK, "push plan")
+	if s.IsManaged() {
+		plan.AddOK("the changed accounts were pushed to the account server")
+	} else {
+		for _, n := range changed {
+			plan.AddOK("nsc push --account %s", n)
+		}
+	}
+	plan.AddWarning("the old identity %q remains on the account server and in the keystore", old)
+	r.Add(plan)
+	return r, nil
+}
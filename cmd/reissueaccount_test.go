@@ -0,0 +1,89 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReIssueAccount(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddExport(t, "A", jwt.Service, "q", false)
+	ts.AddExport(t, "A", jwt.Stream, "s", true)
+	ts.AddUser(t, "A", "U")
+	ts.AddAccount(t, "B")
+	ts.AddImport(t, "A", "q", "B")
+	ts.AddImport(t, "A", "s", "B")
+	ts.AddAccount(t, "C")
+
+	old, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	bpk := ts.GetAccountPublicKey(t, "B")
+
+	_, stderr, err := ExecuteCmd(createReIssueAccountCmd(), "--name", "A")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "nsc push --account A")
+	require.Contains(t, stderr, "nsc push --account B")
+	require.NotContains(t, stderr, "nsc push --account C")
+
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.NotEqual(t, old.Subject, ac.Subject)
+	require.Equal(t, old.Exports, ac.Exports)
+	require.True(t, ts.KeyStore.HasPrivateKey(ac.Subject))
+
+	uc, err := ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+	require.Equal(t, ac.Subject, uc.Issuer)
+
+	bc, err := ts.Store.ReadAccountClaim("B")
+	require.NoError(t, err)
+	require.Len(t, bc.Imports, 2)
+	tokens := 0
+	for _, im := range bc.Imports {
+		require.Equal(t, ac.Subject, im.Account)
+		if im.Token != "" {
+			act, err := jwt.DecodeActivationClaims(im.Token)
+			require.NoError(t, err)
+			require.Equal(t, ac.Subject, act.Issuer)
+			require.Equal(t, bpk, act.Subject)
+			tokens++
+		}
+	}
+	require.Equal(t, 1, tokens)
+	var vr jwt.ValidationResults
+	bc.Validate(&vr)
+	require.Empty(t, vr.Errors())
+}
+
+func Test_ReIssueSystemAccount(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "SYS")
+	_, _, err := ExecuteCmd(CreateEditOperatorCmd(), "--system-account", "SYS")
+	require.NoError(t, err)
+
+	_, _, err = ExecuteCmd(createReIssueAccountCmd(), "--name", "SYS")
+	require.NoError(t, err)
+	oc, err := ts.Store.ReadOperatorClaim()
+	require.NoError(t, err)
+	require.Equal(t, ts.GetAccountPublicKey(t, "SYS"), oc.SystemAccount)
+}
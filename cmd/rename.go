@@ -189,7 +189,7 @@ func (p *RenameAccountParams) moveCreds(ctx ActionCtx) (store.Status, error) {
 		return r, nil
 	}
 	tfp := ctx.StoreCtx().KeyStore.CalcAccountCredsDir(p.to)
-	if err := os.Rename(fp, tfp); err != nil {
+	if err := store.RenameDir(fp, tfp); err != nil {
 		tfp = AbbrevHomePaths(tfp)
 		r.AddError("error renaming dir %q: %v", tfp, err)
 		return r, err
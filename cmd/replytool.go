@@ -61,6 +61,9 @@ type RepParams struct {
 	maxMessages int
 }
 
+// LongRunning - it replies to requests until it is interrupted
+func (p *RepParams) LongRunning() {}
+
 func (p *RepParams) SetDefaults(ctx ActionCtx) error {
 	return p.AccountUserContextParams.SetDefaults(ctx)
 }
@@ -21,7 +21,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kbehouse/nsc/cmd/store"
 	"github.com/mitchellh/go-homedir"
@@ -87,6 +89,12 @@ func ResolveKeyFlag() (nkeys.KeyPair, error) {
 		if err != nil {
 			return nil, err
 		}
+		// a public key can be signed with by an agent or external signer
+		if kp != nil && nkeys.IsValidPublicKey(KeyPathFlag) {
+			if ekp, err := store.ExternalKeyPair(KeyPathFlag); err == nil && ekp != nil {
+				return ekp, nil
+			}
+		}
 		return kp, nil
 	}
 	return nil, nil
@@ -171,8 +179,11 @@ Alternatively you can downgrade' %q to a compatible version using:
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	err := ExecuteWithWriter(rootCmd.OutOrStderr())
-	if err != nil {
-		os.Exit(1)
+	if err != nil && IsJsonOutput() && !jsonDocumentWritten {
+		_ = writeJsonStatus(rootCmd, nil, nil, "", err)
+	}
+	if code := ExitCode(err); code != 0 {
+		os.Exit(code)
 	}
 }
 
@@ -204,6 +215,30 @@ func SetEnvOptions() {
 	if okKey || okCert {
 		tlsKeyNats = nats.ClientCert(cert, key)
 	}
+	if v, ok := os.LookupEnv(NscLockTimeoutEnv); ok {
+		d, err := parseLockTimeout(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ignoring $%s: %v\n", NscLockTimeoutEnv, err)
+		} else {
+			store.LockTimeout = d
+		}
+	}
+}
+
+// parseLockTimeout parses a duration, or a number of seconds
+func parseLockTimeout(v string) (time.Duration, error) {
+	v = strings.TrimSpace(v)
+	if n, err := strconv.Atoi(v); err == nil {
+		v = fmt.Sprintf("%ds", n)
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%q is negative", v)
+	}
+	return d, nil
 }
 
 func init() {
@@ -217,6 +252,8 @@ func init() {
 func HoistRootFlags(cmd *cobra.Command) *cobra.Command {
 	cmd.PersistentFlags().StringVarP(&KeyPathFlag, "private-key", "K", "", "Key used to sign. Can be specified as role (where applicable), public key (private portion is retrieved) or file path to a private key or private key ")
 	cmd.PersistentFlags().BoolVarP(&InteractiveFlag, "interactive", "i", false, "ask questions for various settings")
+	cmd.PersistentFlags().BoolVarP(&DryRunFlag, "dry-run", "", false, "show the changes to the store and keystore without writing them")
+	cmd.PersistentFlags().Var(&OutputFlag, "output", "output format, one of text or json - with json the exit code is 0 on success, 1 on errors and 2 on warnings")
 	return cmd
 }
 
@@ -0,0 +1,253 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createRotateSigningKeyCmd() *cobra.Command {
+	var params RotateSigningKeyParams
+	cmd := &cobra.Command{
+		Use:   "signing-key",
+		Short: "Replace an account signing key and re-sign the users it issued",
+		Long: `Replace an account signing key and re-sign the users it issued
+
+A new signing key is generated and added to the account. If the old key is
+scoped, the scope (role and permission template) is copied to the new key.
+Every user issued by the old key is re-signed with the new key and its creds
+file is regenerated when the user's nkey is in the keystore. Finally the old
+key is removed from the account.
+
+Users whose nkey is missing are still re-signed, but their creds files
+cannot be regenerated. The updated JWT must be given to their owners.`,
+		Example: `nsc rotate signing-key --account A --sk AABC...
+nsc rotate signing-key --account A --sk admin`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.oldKey, "sk", "", "", "signing key or role name of the scoped signing key to replace")
+	params.AccountContextParams.BindFlags(cmd)
+	return cmd
+}
+
+func init() {
+	rotateCmd.AddCommand(createRotateSigningKeyCmd())
+}
+
+type RotateSigningKeyParams struct {
+	AccountContextParams
+	SignerParams
+	oldKey string
+	claim  *jwt.AccountClaims
+	users  []string
+}
+
+func (p *RotateSigningKeyParams) SetDefaults(ctx ActionCtx) error {
+	if err := p.AccountContextParams.SetDefaults(ctx); err != nil {
+		return err
+	}
+	p.SignerParams.SetDefaults(nkeys.PrefixByteOperator, true, ctx)
+	return nil
+}
+
+func (p *RotateSigningKeyParams) PreInteractive(ctx ActionCtx) error {
+	return p.AccountContextParams.Edit(ctx)
+}
+
+func (p *RotateSigningKeyParams) Load(ctx ActionCtx) error {
+	var err error
+	if err = p.AccountContextParams.Validate(ctx); err != nil {
+		return err
+	}
+	s := ctx.StoreCtx().Store
+	if p.claim, err = s.ReadAccountClaim(p.AccountContextParams.Name); err != nil {
+		return err
+	}
+	p.users, err = s.ListEntries(store.Accounts, p.AccountContextParams.Name, store.Users)
+	return err
+}
+
+func (p *RotateSigningKeyParams) PostInteractive(ctx ActionCtx) error {
+	if p.oldKey == "" {
+		keys := p.claim.SigningKeys.Keys()
+		if len(keys) == 0 {
+			return fmt.Errorf("account %q has no signing keys", p.AccountContextParams.Name)
+		}
+		sort.Strings(keys)
+		i, err := cli.Select("select the signing key to rotate", "", keys)
+		if err != nil {
+			return err
+		}
+		p.oldKey = keys[i]
+	}
+	return p.SignerParams.Edit(ctx)
+}
+
+// resolveOldKey matches --sk against the account's signing keys by public
+// key or by the role of a scoped key
+func (p *RotateSigningKeyParams) resolveOldKey() (string, error) {
+	if _, ok := p.claim.SigningKeys[p.oldKey]; ok {
+		return p.oldKey, nil
+	}
+	for k, v := range p.claim.SigningKeys {
+		if us, ok := v.(*jwt.UserScope); ok && us.Role == p.oldKey {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a signing key of account %q", p.oldKey, p.AccountContextParams.Name)
+}
+
+func (p *RotateSigningKeyParams) Validate(ctx ActionCtx) error {
+	var err error
+	if p.oldKey == "" {
+		ctx.CurrentCmd().SilenceUsage = false
+		return errors.New("--sk is required")
+	}
+	if p.oldKey, err = p.resolveOldKey(); err != nil {
+		return err
+	}
+	return p.SignerParams.ResolveWithPriority(ctx, p.claim.Issuer)
+}
+
+// storeAccount signs and stores the account, returning false on failure
+func (p *RotateSigningKeyParams) storeAccount(ctx ActionCtx, r *store.Report) bool {
+	token, err := p.claim.Encode(p.signerKP)
+	if err != nil {
+		r.AddError("unable to sign account %q: %v", p.claim.Name, err)
+		return false
+	}
+	StoreAccountAndUpdateStatus(ctx, token, r)
+	return r.HasNoErrors()
+}
+
+func (p *RotateSigningKeyParams) Run(ctx ActionCtx) (store.Status, error) {
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	s := ctx.StoreCtx().Store
+	ks := ctx.StoreCtx().KeyStore
+
+	kp, err := nkeys.CreateAccount()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ks.Store(kp); err != nil {
+		return nil, err
+	}
+	pk, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if old, ok := p.claim.SigningKeys[p.oldKey].(*jwt.UserScope); ok {
+		scope := jwt.NewUserScope()
+		scope.Key = pk
+		scope.Role = old.Role
+		scope.Template = old.Template
+		p.claim.SigningKeys.AddScopedSigner(scope)
+		r.AddOK("added signing key %q with the scope of %q", pk, p.oldKey)
+	} else {
+		p.claim.SigningKeys.Add(pk)
+		r.AddOK("added signing key %q", pk)
+	}
+	// the account must trust the new key before users signed by it are stored
+	if !p.storeAccount(ctx, r) {
+		return r, nil
+	}
+
+	var missing []string
+	for _, n := range p.users {
+		uc, err := s.ReadUserClaim(p.AccountContextParams.Name, n)
+		if err != nil {
+			r.AddError("unable to read user %q: %v", n, err)
+			continue
+		}
+		if uc.Issuer != p.oldKey {
+			continue
+		}
+		token, err := uc.Encode(kp)
+		if err != nil {
+			r.AddError("unable to re-sign user %q: %v", n, err)
+			continue
+		}
+		rs, err := s.StoreClaim([]byte(token))
+		if rs != nil {
+			r.Add(rs)
+		}
+		if err != nil {
+			r.AddError("unable to store user %q: %v", n, err)
+			continue
+		}
+		r.AddOK("re-signed user %q", n)
+		if !regenerateUserCreds(ctx, p.AccountContextParams.Name, n, uc.Subject, r) {
+			missing = append(missing, n)
+		}
+	}
+	if !r.HasNoErrors() {
+		r.AddWarning("the old signing key %q was not removed", p.oldKey)
+		return r, nil
+	}
+
+	p.claim.SigningKeys.Remove(p.oldKey)
+	if !p.storeAccount(ctx, r) {
+		return r, nil
+	}
+	r.AddOK("removed signing key %q", p.oldKey)
+	if len(missing) > 0 {
+		r.AddWarning("the creds of user(s) %s could not be reissued because their nkeys are missing - distribute their updated JWTs",
+			strings.Join(missing, ", "))
+	}
+	r.AddOK("rotated signing key %q of account %q", p.oldKey, p.AccountContextParams.Name)
+	return r, nil
+}
+
+// regenerateUserCreds stores a new creds file for the user, returning false
+// if the user's nkey is not in the keystore
+func regenerateUserCreds(ctx ActionCtx, account string, user string, subject string, r *store.Report) bool {
+	ks := ctx.StoreCtx().KeyStore
+	if !ks.HasPrivateKey(subject) {
+		return false
+	}
+	ukp, err := ks.GetKeyPair(subject)
+	if err != nil {
+		r.AddError("unable to read keypair of user %q: %v", user, err)
+		return true
+	}
+	d, err := GenerateConfig(ctx.StoreCtx().Store, account, user, ukp)
+	if err != nil {
+		r.AddError("unable to generate creds for user %q: %v", user, err)
+		return true
+	}
+	fp, err := ks.MaybeStoreUserCreds(account, user, d)
+	if err != nil {
+		r.AddError("error storing creds for user %q: %v", user, err)
+		return true
+	}
+	r.AddOK("generated user creds file %#q", AbbrevHomePaths(fp))
+	return true
+}
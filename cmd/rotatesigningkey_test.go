@@ -0,0 +1,98 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func Test_RotateSigningKey(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	_, pk, kp := CreateAccountKey(t)
+	_, err := ts.KeyStore.Store(kp)
+	require.NoError(t, err)
+	_, _, err = ExecuteCmd(createEditAccount(), "--name", "A", "--sk", pk)
+	require.NoError(t, err)
+	_, _, err = ExecuteCmd(createEditSkopedSkCmd(), "--account", "A", "--sk", pk, "--role", "admin", "--allow-pub", "foo")
+	require.NoError(t, err)
+
+	ts.AddUserWithSigner(t, "A", "U", kp)
+	ts.AddUserWithSigner(t, "A", "W", kp)
+	ts.AddUser(t, "A", "V")
+	vc, err := ts.Store.ReadUserClaim("A", "V")
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(store.GetKeyPath(ts.GetUserPublicKey(t, "A", "W"))))
+
+	_, stderr, err := ExecuteCmd(createRotateSigningKeyCmd(), "--account", "A", "--sk", "admin")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "re-signed user \"U\"")
+	require.Contains(t, stderr, "re-signed user \"W\"")
+	require.NotContains(t, stderr, "re-signed user \"V\"")
+	require.Contains(t, stderr, "could not be reissued because their nkeys are missing")
+
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Len(t, ac.SigningKeys, 1)
+	require.NotContains(t, ac.SigningKeys, pk)
+	nk := ac.SigningKeys.Keys()[0]
+	scope, ok := ac.SigningKeys[nk].(*jwt.UserScope)
+	require.True(t, ok)
+	require.Equal(t, "admin", scope.Role)
+	require.Equal(t, jwt.StringList{"foo"}, scope.Template.Pub.Allow)
+	require.True(t, ts.KeyStore.HasPrivateKey(nk))
+
+	for _, n := range []string{"U", "W"} {
+		uc, err := ts.Store.ReadUserClaim("A", n)
+		require.NoError(t, err)
+		require.Equal(t, nk, uc.Issuer)
+		require.Equal(t, ac.Subject, uc.IssuerAccount)
+	}
+	uc, err := ts.Store.ReadUserClaim("A", "V")
+	require.NoError(t, err)
+	require.Equal(t, vc.ID, uc.ID)
+
+	// the creds hold the re-signed jwt
+	d, err := ioutil.ReadFile(ts.KeyStore.CalcUserCredsPath("A", "U"))
+	require.NoError(t, err)
+	token, err := jwt.ParseDecoratedJWT(d)
+	require.NoError(t, err)
+	cc, err := jwt.DecodeUserClaims(token)
+	require.NoError(t, err)
+	require.Equal(t, nk, cc.Issuer)
+}
+
+func Test_RotateSigningKeyNotFound(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	_, _, err := ExecuteCmd(createRotateSigningKeyCmd(), "--account", "A")
+	require.Error(t, err)
+	_, pk, _ := CreateAccountKey(t)
+	_, _, err = ExecuteCmd(createRotateSigningKeyCmd(), "--account", "A", "--sk", pk)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not a signing key of account")
+}
@@ -0,0 +1,274 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createRotateUserCmd() *cobra.Command {
+	var params RotateUserParams
+	cmd := &cobra.Command{
+		Use:   "user",
+		Short: "Replace the nkey of a user and revoke the old one",
+		Long: `Replace the nkey of a user and revoke the old one
+
+A new user nkey is generated and a JWT with the same permissions, limits,
+tags and validity period is issued for it. The new creds file is stored in
+the keystore, and the old public key is added to the account's revocation
+list, like 'nsc revocations add-user' does.
+
+With --grace the old keys are not revoked, and their creds keep working.
+The server rejects every JWT of a revoked key issued before the revocation
+timestamp, even when the timestamp is in the future, so a revocation can't
+be delayed. Instead the command that revokes the old keys is printed, to be
+run once the grace period is over. Use --all to rotate every user of the
+account.`,
+		Example: `nsc rotate user --account A --name U
+nsc rotate user --account A --name U --grace 1h
+nsc rotate user --account A --all`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.name, "name", "n", "", "user to rotate")
+	cmd.Flags().BoolVarP(&params.all, "all", "", false, "rotate all users of the account")
+	cmd.Flags().DurationVarP(&params.grace, "grace", "", 0, "don't revoke the old keys, print the command that revokes them after the duration")
+	params.AccountContextParams.BindFlags(cmd)
+	return cmd
+}
+
+func init() {
+	rotateCmd.AddCommand(createRotateUserCmd())
+}
+
+type RotateUserParams struct {
+	AccountContextParams
+	SignerParams
+	name  string
+	all   bool
+	grace time.Duration
+	claim *jwt.AccountClaims
+	users []string
+}
+
+func (p *RotateUserParams) SetDefaults(ctx ActionCtx) error {
+	if p.name != "" && p.all {
+		ctx.CurrentCmd().SilenceUsage = false
+		return errors.New("--name and --all are mutually exclusive")
+	}
+	if err := p.AccountContextParams.SetDefaults(ctx); err != nil {
+		return err
+	}
+	p.SignerParams.SetDefaults(nkeys.PrefixByteOperator, true, ctx)
+	return nil
+}
+
+func (p *RotateUserParams) PreInteractive(ctx ActionCtx) error {
+	return p.AccountContextParams.Edit(ctx)
+}
+
+func (p *RotateUserParams) Load(ctx ActionCtx) error {
+	var err error
+	if err = p.AccountContextParams.Validate(ctx); err != nil {
+		return err
+	}
+	s := ctx.StoreCtx().Store
+	if p.claim, err = s.ReadAccountClaim(p.AccountContextParams.Name); err != nil {
+		return err
+	}
+	if p.all {
+		p.users, err = s.ListEntries(store.Accounts, p.AccountContextParams.Name, store.Users)
+		return err
+	}
+	if p.name == "" && !InteractiveFlag {
+		uc, err := ctx.StoreCtx().DefaultUserClaim(p.AccountContextParams.Name)
+		if err != nil {
+			return err
+		}
+		p.name = uc.Name
+	}
+	return nil
+}
+
+func (p *RotateUserParams) PostInteractive(ctx ActionCtx) error {
+	if !p.all && p.name == "" {
+		var err error
+		p.name, err = ctx.StoreCtx().PickUser(p.AccountContextParams.Name)
+		if err != nil {
+			return err
+		}
+	}
+	if p.grace == 0 {
+		v, err := cli.Prompt("keep the old key valid for", "0s", cli.Val(func(s string) error {
+			_, err := time.ParseDuration(s)
+			return err
+		}))
+		if err != nil {
+			return err
+		}
+		p.grace, _ = time.ParseDuration(v)
+	}
+	return p.SignerParams.Edit(ctx)
+}
+
+func (p *RotateUserParams) Validate(ctx ActionCtx) error {
+	if p.grace < 0 {
+		return errors.New("--grace cannot be negative")
+	}
+	if !p.all {
+		if p.name == "" {
+			ctx.CurrentCmd().SilenceUsage = false
+			return errors.New("--name or --all is required")
+		}
+		if !ctx.StoreCtx().Store.Has(store.Accounts, p.AccountContextParams.Name, store.Users, store.JwtName(p.name)) {
+			return fmt.Errorf("user %q not found in account %q", p.name, p.AccountContextParams.Name)
+		}
+		p.users = []string{p.name}
+	}
+	if len(p.users) == 0 {
+		return fmt.Errorf("account %q has no users", p.AccountContextParams.Name)
+	}
+	return p.SignerParams.ResolveWithPriority(ctx, p.claim.Issuer)
+}
+
+// userSigner returns the key that issued the user, or the account identity
+// if the user was issued by it
+func (p *RotateUserParams) userSigner(ctx ActionCtx, uc *jwt.UserClaims) (nkeys.KeyPair, error) {
+	kp, err := ctx.StoreCtx().KeyStore.GetKeyPair(uc.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	if kp == nil || !ctx.StoreCtx().KeyStore.CanSign(uc.Issuer) {
+		return nil, fmt.Errorf("the key %q that issued the user is not available", uc.Issuer)
+	}
+	return kp, nil
+}
+
+// rotate issues a copy of the user for a new nkey, returning the old public key
+func (p *RotateUserParams) rotate(ctx ActionCtx, name string, r *store.Report) (string, error) {
+	s := ctx.StoreCtx().Store
+	uc, err := s.ReadUserClaim(p.AccountContextParams.Name, name)
+	if err != nil {
+		return "", err
+	}
+	signer, err := p.userSigner(ctx, uc)
+	if err != nil {
+		return "", err
+	}
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		return "", err
+	}
+	pk, err := kp.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	nc := jwt.NewUserClaims(pk)
+	nc.Name = uc.Name
+	nc.Audience = uc.Audience
+	nc.User = uc.User
+	now := time.Now().Unix()
+	if uc.NotBefore > now {
+		nc.NotBefore = uc.NotBefore
+	}
+	// keep the validity period of the old credentials
+	if uc.Expires > 0 {
+		start := uc.IssuedAt
+		if uc.NotBefore > start {
+			start = uc.NotBefore
+		}
+		nc.Expires = now + uc.Expires - start
+		if nc.NotBefore > now {
+			nc.Expires = nc.NotBefore + uc.Expires - start
+		}
+	}
+	token, err := nc.Encode(signer)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ctx.StoreCtx().KeyStore.Store(kp); err != nil {
+		return "", err
+	}
+	rs, err := s.StoreClaim([]byte(token))
+	if rs != nil {
+		r.Add(rs)
+	}
+	if err != nil {
+		return "", err
+	}
+	r.AddOK("user %q has the new nkey %q", name, pk)
+	regenerateUserCreds(ctx, p.AccountContextParams.Name, name, pk, r)
+	return uc.Subject, nil
+}
+
+func (p *RotateUserParams) Run(ctx ActionCtx) (store.Status, error) {
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+
+	var revoked []string
+	for _, n := range p.users {
+		old, err := p.rotate(ctx, n, r)
+		if err != nil {
+			r.AddError("unable to rotate user %q: %v", n, err)
+			continue
+		}
+		revoked = append(revoked, old)
+	}
+	if len(revoked) == 0 {
+		return r, nil
+	}
+	if p.grace > 0 {
+		after := time.Now().Add(p.grace).Format(time.RFC3339)
+		for _, k := range revoked {
+			r.AddWarning("user key %q is not revoked - after %s run `nsc revocations add-user --account %s --user-public-key %s`",
+				k, after, p.AccountContextParams.Name, k)
+		}
+		return r, nil
+	}
+	at := time.Now()
+	for _, k := range revoked {
+		p.claim.RevokeAt(k, at)
+	}
+
+	token, err := p.claim.Encode(p.signerKP)
+	if err != nil {
+		r.AddError("unable to sign account %q: %v", p.claim.Name, err)
+		return r, nil
+	}
+	ar := store.NewDetailedReport(true)
+	StoreAccountAndUpdateStatus(ctx, token, ar)
+	r.Add(ar)
+	if !ar.HasNoErrors() {
+		r.AddWarning("the old user keys were not revoked")
+		return r, nil
+	}
+	for _, k := range revoked {
+		r.AddOK("revoked user key %q at %s", k, at.Format(time.RFC3339))
+	}
+	return r, nil
+}
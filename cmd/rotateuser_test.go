@@ -0,0 +1,112 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RotateUser(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	_, _, err := ExecuteCmd(CreateAddUserCmd(), "--account", "A", "--name", "U",
+		"--tag", "red", "--allow-pub", "foo", "--expiry", "30d")
+	require.NoError(t, err)
+	old, err := ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+
+	_, _, err = ExecuteCmd(createRotateUserCmd(), "--account", "A", "--name", "U")
+	require.NoError(t, err)
+
+	uc, err := ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+	require.NotEqual(t, old.Subject, uc.Subject)
+	require.Equal(t, old.Tags, uc.Tags)
+	require.Equal(t, old.Pub, uc.Pub)
+	require.Equal(t, old.Issuer, uc.Issuer)
+	require.InDelta(t, old.Expires-old.IssuedAt, uc.Expires-uc.IssuedAt, 2)
+	require.True(t, ts.KeyStore.HasPrivateKey(uc.Subject))
+
+	d, err := ioutil.ReadFile(ts.KeyStore.CalcUserCredsPath("A", "U"))
+	require.NoError(t, err)
+	token, err := jwt.ParseDecoratedJWT(d)
+	require.NoError(t, err)
+	cc, err := jwt.DecodeUserClaims(token)
+	require.NoError(t, err)
+	require.Equal(t, uc.Subject, cc.Subject)
+
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Contains(t, ac.Revocations, old.Subject)
+	require.True(t, ac.IsClaimRevoked(old))
+	require.False(t, ac.IsClaimRevoked(uc))
+}
+
+func Test_RotateUserAllWithGrace(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddUser(t, "A", "U")
+	_, pk, kp := CreateAccountKey(t)
+	_, err := ts.KeyStore.Store(kp)
+	require.NoError(t, err)
+	_, _, err = ExecuteCmd(createEditAccount(), "--name", "A", "--sk", pk)
+	require.NoError(t, err)
+	ts.AddUserWithSigner(t, "A", "V", kp)
+	u, err := ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+	v, err := ts.Store.ReadUserClaim("A", "V")
+	require.NoError(t, err)
+
+	_, stderr, err := ExecuteCmd(createRotateUserCmd(), "--account", "A", "--all", "--grace", "1h")
+	require.NoError(t, err)
+
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	for _, old := range []*jwt.UserClaims{u, v} {
+		// the old creds keep working until the printed command is run
+		require.NotContains(t, ac.Revocations, old.Subject)
+		require.Contains(t, stderr, "nsc revocations add-user --account A --user-public-key "+old.Subject)
+		uc, err := ts.Store.ReadUserClaim("A", old.Name)
+		require.NoError(t, err)
+		require.NotEqual(t, old.Subject, uc.Subject)
+		require.Equal(t, old.Issuer, uc.Issuer)
+		require.Equal(t, old.IssuerAccount, uc.IssuerAccount)
+	}
+}
+
+func Test_RotateUserMissingSigner(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	_, pk, kp := CreateAccountKey(t)
+	_, _, err := ExecuteCmd(createEditAccount(), "--name", "A", "--sk", pk)
+	require.NoError(t, err)
+	ts.AddUserWithSigner(t, "A", "U", kp)
+	old, err := ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+
+	_, stderr, err := ExecuteCmd(createRotateUserCmd(), "--account", "A", "--name", "U")
+	require.Error(t, err)
+	require.Contains(t, stderr, "is not available")
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.NotContains(t, ac.Revocations, old.Subject)
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+const (
+	accountServerOperatorPath   = "/jwt/v1/operator"
+	accountServerOperatorPathV2 = "/jwt/v2/operator"
+	accountServerAccountsPath   = "/jwt/v1/accounts/"
+	// maxAccountJwtSize bounds the account jwts posted to the server
+	maxAccountJwtSize = 1 << 20
+)
+
+func createServeAccountServerCmd() *cobra.Command {
+	var params ServeAccountServerParams
+	cmd := &cobra.Command{
+		Use:   "account-server",
+		Short: "Serve the operator and account JWTs in the store over HTTP",
+		Long: `Serve the operator and account JWTs in the store over HTTP
+
+The server implements the account server protocol used by 'nsc push',
+'nsc pull' and nats-server URL resolvers:
+
+  GET  /jwt/v1/operator          returns the operator JWT
+  GET  /jwt/v1/accounts/<pubkey> returns the account JWT
+  POST /jwt/v1/accounts/<pubkey> stores the account JWT
+
+Pushed account JWTs must be signed by the operator or one of its signing keys.
+Set the operator's account server url to the printed url to have push and
+pull use this server.`,
+		Example: `nsc serve account-server
+nsc serve account-server --listen 0.0.0.0:9090`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().StringVarP(&params.listen, "listen", "l", "127.0.0.1:9090", "host:port the account server listens on")
+	return cmd
+}
+
+func init() {
+	serveCmd.AddCommand(createServeAccountServerCmd())
+}
+
+type ServeAccountServerParams struct {
+	listen string
+}
+
+// LongRunning - the server runs until it is interrupted
+func (p *ServeAccountServerParams) LongRunning() {}
+
+func (p *ServeAccountServerParams) SetDefaults(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ServeAccountServerParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ServeAccountServerParams) Load(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ServeAccountServerParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *ServeAccountServerParams) Validate(ctx ActionCtx) error {
+	if _, _, err := net.SplitHostPort(p.listen); err != nil {
+		return fmt.Errorf("invalid listen address %q: %v", p.listen, err)
+	}
+	if _, err := ctx.StoreCtx().Store.ReadOperatorClaim(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *ServeAccountServerParams) Run(ctx ActionCtx) (store.Status, error) {
+	ln, err := net.Listen("tcp", p.listen)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: NewAccountServerHandler(ctx.StoreCtx().Store)}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		if _, ok := <-sigCh; ok {
+			srv.Close()
+		}
+	}()
+
+	ctx.CurrentCmd().Printf("serving operator %q on http://%s/jwt/v1\n", ctx.StoreCtx().Operator.Name, ln.Addr().String())
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// AccountServerHandler serves the operator and account JWTs in a store
+// using the account server protocol
+type AccountServerHandler struct {
+	sync.Mutex
+	s *store.Store
+}
+
+func NewAccountServerHandler(s *store.Store) *AccountServerHandler {
+	return &AccountServerHandler{s: s}
+}
+
+func (h *AccountServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	defer h.Unlock()
+
+	switch {
+	case r.URL.Path == accountServerOperatorPath || r.URL.Path == accountServerOperatorPathV2:
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.getOperator(w)
+	case strings.HasPrefix(r.URL.Path, accountServerAccountsPath):
+		pk := strings.TrimPrefix(r.URL.Path, accountServerAccountsPath)
+		if !nkeys.IsValidPublicAccountKey(pk) {
+			http.Error(w, fmt.Sprintf("%q is not a valid account public key", pk), http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			h.getAccount(w, pk)
+		case http.MethodPost:
+			h.updateAccount(w, r, pk)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *AccountServerHandler) getOperator(w http.ResponseWriter) {
+	d, err := h.s.ReadRawOperatorClaim()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJwtResponse(w, d)
+}
+
+func (h *AccountServerHandler) getAccount(w http.ResponseWriter, pk string) {
+	name, err := h.accountName(pk)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if name == "" {
+		http.Error(w, fmt.Sprintf("account %q not found", pk), http.StatusNotFound)
+		return
+	}
+	d, err := h.s.ReadRawAccountClaim(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJwtResponse(w, d)
+}
+
+func (h *AccountServerHandler) updateAccount(w http.ResponseWriter, r *http.Request, pk string) {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAccountJwtSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token := strings.TrimSpace(string(body))
+	ac, err := jwt.DecodeAccountClaims(token)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error decoding account jwt: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := h.validateAccount(pk, ac); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the name is the directory of the account in the store
+	if ac.Name == "" || ac.Name == "." || ac.Name == ".." || strings.ContainsAny(ac.Name, `/\`) {
+		http.Error(w, fmt.Sprintf("account name %q is not valid", ac.Name), http.StatusBadRequest)
+		return
+	}
+	name, err := h.accountName(pk)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if name == "" && h.s.HasAccount(ac.Name) {
+		http.Error(w, fmt.Sprintf("an account named %q with a different public key already exists", ac.Name), http.StatusConflict)
+		return
+	}
+	if name != "" && name != ac.Name {
+		http.Error(w, fmt.Sprintf("account %q is stored as %q - renaming is not supported", pk, name), http.StatusConflict)
+		return
+	}
+	// the server is the account server of a managed store, storing the claim
+	// must not push it back to itself
+	if _, err := h.s.StoreClaimPush([]byte(token), false); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("stored account %q", ac.Name)))
+}
+
+func (h *AccountServerHandler) validateAccount(pk string, ac *jwt.AccountClaims) error {
+	if ac.Subject != pk {
+		return fmt.Errorf("account jwt subject %q doesn't match %q", ac.Subject, pk)
+	}
+	oc, err := h.s.ReadOperatorClaim()
+	if err != nil {
+		return err
+	}
+	if !oc.DidSign(ac) {
+		return fmt.Errorf("account %q is not signed by operator %q or one of its signing keys", ac.Subject, oc.Name)
+	}
+	var vr jwt.ValidationResults
+	ac.Validate(&vr)
+	if vr.IsBlocking(true) {
+		return fmt.Errorf("account %q is not valid: %v", ac.Subject, vr.Errors())
+	}
+	return nil
+}
+
+// accountName returns the name of the account stored with the specified
+// public key, or an empty string if the store doesn't have it
+func (h *AccountServerHandler) accountName(pk string) (string, error) {
+	names, err := h.s.ListSubContainers(store.Accounts)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range names {
+		ac, err := h.s.ReadAccountClaim(n)
+		if err != nil {
+			continue
+		}
+		if ac.Subject == pk {
+			return n, nil
+		}
+	}
+	return "", nil
+}
+
+func writeJwtResponse(w http.ResponseWriter, d []byte) {
+	w.Header().Add("Content-Type", "application/jwt")
+	w.WriteHeader(http.StatusOK)
+	w.Write(d)
+}
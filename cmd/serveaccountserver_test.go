@@ -0,0 +1,188 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ServeAccountServer(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	hts := httptest.NewServer(NewAccountServerHandler(ts.Store))
+	defer hts.Close()
+
+	_, _, err := ExecuteCmd(CreateEditOperatorCmd(), "--account-jwt-server-url", hts.URL+"/jwt/v1")
+	require.NoError(t, err)
+
+	r, err := http.Get(hts.URL + "/jwt/v1/operator")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, r.StatusCode)
+	d, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	require.NoError(t, err)
+	od, err := ts.Store.ReadRawOperatorClaim()
+	require.NoError(t, err)
+	require.Equal(t, od, d)
+
+	_, _, err = ExecuteCmd(CreatePushCmd(), "--account", "A")
+	require.NoError(t, err)
+	_, _, err = ExecuteCmd(createPullCmd(), "--all")
+	require.NoError(t, err)
+
+	r, err = http.Get(hts.URL + "/jwt/v1/accounts/" + ts.GetAccountPublicKey(t, "A"))
+	require.NoError(t, err)
+	r.Body.Close()
+	require.Equal(t, http.StatusOK, r.StatusCode)
+
+	akp, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	apk, err := akp.PublicKey()
+	require.NoError(t, err)
+	r, err = http.Get(hts.URL + "/jwt/v1/accounts/" + apk)
+	require.NoError(t, err)
+	r.Body.Close()
+	require.Equal(t, http.StatusNotFound, r.StatusCode)
+}
+
+func Test_ServeAccountServerAcceptsOperatorSignedAccounts(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	hts := httptest.NewServer(NewAccountServerHandler(ts.Store))
+	defer hts.Close()
+
+	akp, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	apk, err := akp.PublicKey()
+	require.NoError(t, err)
+	ac := jwt.NewAccountClaims(apk)
+	ac.Name = "B"
+	u := hts.URL + "/jwt/v1/accounts/" + apk
+
+	// self-signed accounts are rejected
+	token, err := ac.Encode(akp)
+	require.NoError(t, err)
+	r, err := http.Post(u, "application/jwt", bytes.NewReader([]byte(token)))
+	require.NoError(t, err)
+	r.Body.Close()
+	require.Equal(t, http.StatusBadRequest, r.StatusCode)
+	require.False(t, ts.Store.HasAccount("B"))
+
+	// signed by an operator signing key
+	_, spk, skp := CreateOperatorKey(t)
+	_, _, err = ExecuteCmd(CreateEditOperatorCmd(), "--sk", spk)
+	require.NoError(t, err)
+	token, err = ac.Encode(skp)
+	require.NoError(t, err)
+	r, err = http.Post(u, "application/jwt", bytes.NewReader([]byte(token)))
+	require.NoError(t, err)
+	r.Body.Close()
+	require.Equal(t, http.StatusOK, r.StatusCode)
+
+	stored, err := ts.Store.ReadAccountClaim("B")
+	require.NoError(t, err)
+	require.Equal(t, apk, stored.Subject)
+	require.Equal(t, spk, stored.Issuer)
+
+	// the subject must match the url
+	other, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	opk, err := other.PublicKey()
+	require.NoError(t, err)
+	r, err = http.Post(hts.URL+"/jwt/v1/accounts/"+opk, "application/jwt", bytes.NewReader([]byte(token)))
+	require.NoError(t, err)
+	r.Body.Close()
+	require.Equal(t, http.StatusBadRequest, r.StatusCode)
+}
+
+func Test_ServeAccountServerManagedStore(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	hts := httptest.NewServer(NewAccountServerHandler(ts.Store))
+	defer hts.Close()
+	_, _, err := ExecuteCmd(CreateEditOperatorCmd(), "--account-jwt-server-url", hts.URL+"/jwt/v1")
+	require.NoError(t, err)
+	// a managed store pushes stored accounts to its account server, which is this server
+	ts.Store.Info.Managed = true
+
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	ac.Tags.Add("managed")
+	token, err := ac.Encode(ts.OperatorKey)
+	require.NoError(t, err)
+	c := &http.Client{Timeout: 5 * time.Second}
+	r, err := c.Post(hts.URL+"/jwt/v1/accounts/"+ac.Subject, "application/jwt", bytes.NewReader([]byte(token)))
+	require.NoError(t, err)
+	r.Body.Close()
+	require.Equal(t, http.StatusOK, r.StatusCode)
+
+	stored, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.True(t, stored.Tags.Contains("managed"))
+}
+
+func Test_ServeAccountServerRejectsInvalidPosts(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	hts := httptest.NewServer(NewAccountServerHandler(ts.Store))
+	defer hts.Close()
+
+	akp, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	apk, err := akp.PublicKey()
+	require.NoError(t, err)
+	u := hts.URL + "/jwt/v1/accounts/" + apk
+	post := func(d []byte) int {
+		r, err := http.Post(u, "application/jwt", bytes.NewReader(d))
+		require.NoError(t, err)
+		r.Body.Close()
+		return r.StatusCode
+	}
+
+	ac := jwt.NewAccountClaims(apk)
+	for _, n := range []string{"..", "../B", `a\b`} {
+		ac.Name = n
+		token, err := ac.Encode(ts.OperatorKey)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, post([]byte(token)))
+	}
+
+	require.Equal(t, http.StatusBadRequest, post(bytes.Repeat([]byte("a"), maxAccountJwtSize+1)))
+
+	// a stored account is journaled
+	ac.Name = "B"
+	token, err := ac.Encode(ts.OperatorKey)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, post([]byte(token)))
+	entries, err := ts.Store.Journal().Entries()
+	require.NoError(t, err)
+	require.Equal(t, "B", entries[len(entries)-1].Account)
+}
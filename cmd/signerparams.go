@@ -17,7 +17,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/nats-io/jwt/v2"
@@ -54,11 +53,9 @@ func (p *SignerParams) SelectFromSigners(ctx ActionCtx, signers []string) error
 	var choices []string
 
 	for _, s := range signers {
-		fp := ctx.StoreCtx().KeyStore.GetKeyPath(s)
-		_, err := os.Stat(fp)
-		if err == nil {
-			keys = append(keys, fp)
-			choices = append(choices, s)
+		if ctx.StoreCtx().KeyStore.CanSign(s) {
+			keys = append(keys, s)
+			choices = append(choices, describeKey(s))
 		} else {
 			notFound = append(notFound, s)
 		}
@@ -66,7 +63,7 @@ func (p *SignerParams) SelectFromSigners(ctx ActionCtx, signers []string) error
 	// if we have more than one key, we prompt
 	if len(keys) == 1 && len(notFound) == 0 {
 		var err error
-		p.signerKP, err = ctx.StoreCtx().ResolveKey(keys[0], p.kind...)
+		p.signerKP, err = p.resolveSigner(ctx, keys[0])
 		if err != nil {
 			return err
 		}
@@ -101,7 +98,7 @@ func (p *SignerParams) SelectFromSigners(ctx ActionCtx, signers []string) error
 			return err
 		} else {
 			// they picked one
-			p.signerKP, err = ctx.StoreCtx().ResolveKey(keys[choice], p.kind...)
+			p.signerKP, err = p.resolveSigner(ctx, keys[choice])
 			return err
 		}
 	}
@@ -253,20 +250,39 @@ func (p *SignerParams) ResolveWithPriority(ctx ActionCtx, preferKey string) erro
 
 	var selected string
 	for _, s := range signers {
-		fp := ctx.StoreCtx().KeyStore.GetKeyPath(s)
-		_, err = os.Stat(fp)
-		if err == nil {
-			selected = fp
+		if ctx.StoreCtx().KeyStore.CanSign(s) {
+			selected = s
 			break
 		}
 	}
 	if selected == "" {
 		return fmt.Errorf("unable to resolve any of the following signing keys in the keystore: %s", strings.Join(signers, ", "))
 	}
-	p.signerKP, err = ctx.StoreCtx().ResolveKey(selected, p.kind...)
+	p.signerKP, err = p.resolveSigner(ctx, selected)
 	return err
 }
 
+// resolveSigner returns the key pair for a signer's public key, from the
+// keystore or the external signer
+func (p *SignerParams) resolveSigner(ctx ActionCtx, pubkey string) (nkeys.KeyPair, error) {
+	kp, err := ctx.StoreCtx().KeyStore.GetKeyPair(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	if kp == nil {
+		return nil, fmt.Errorf("unable to resolve the signing key %s", pubkey)
+	}
+	if len(p.kind) == 0 {
+		return kp, nil
+	}
+	for _, kind := range p.kind {
+		if store.KeyPairTypeOk(kind, kp) {
+			return kp, nil
+		}
+	}
+	return nil, fmt.Errorf("key %s is not of the expected type", pubkey)
+}
+
 func (p *SignerParams) ForceManagedAccountKey(ctx ActionCtx, kp nkeys.KeyPair) {
 	p.Resolve(ctx)
 	if !ctx.StoreCtx().Store.IsManaged() {
@@ -286,9 +302,9 @@ func (p *SignerParams) ForceManagedAccountKey(ctx ActionCtx, kp nkeys.KeyPair) {
 		// try to load it
 		pub, _ := p.signerKP.PublicKey()
 		kp, err := ctx.StoreCtx().KeyStore.GetKeyPair(pub)
-		if err == nil {
+		if err == nil && kp != nil {
 			pk, _ := kp.PrivateKey()
-			if pk != nil {
+			if pk != nil || store.IsExternalKeyPair(kp) {
 				p.signerKP = kp
 			}
 		}
@@ -222,3 +222,26 @@ func Test_SignerParamsSeed(t *testing.T) {
 	_, _, err = ExecuteCmd(HoistRootFlags(CreateAddUserCmd()), "--name", "a", "-K", string(s))
 	require.NoError(t, err)
 }
+
+func Test_SignerParamsExternalSigner(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+
+	require.NoError(t, os.Remove(ts.OperatorKeyPath))
+	_, _, err := ExecuteCmd(CreateAddAccountCmd(), "--name", "A")
+	require.Error(t, err)
+
+	ls, err := store.NewLocalSigner(ts.OperatorKey)
+	require.NoError(t, err)
+	store.SetSigner(ls)
+	defer store.SetSigner(nil)
+
+	_, _, err = ExecuteCmd(CreateAddAccountCmd(), "--name", "A")
+	require.NoError(t, err)
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	opk, err := ts.OperatorKey.PublicKey()
+	require.NoError(t, err)
+	require.Equal(t, opk, ac.Issuer)
+	require.NoFileExists(t, ts.OperatorKeyPath)
+}
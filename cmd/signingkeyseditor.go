@@ -38,21 +38,22 @@ func (e *SigningKeysParams) BindFlags(flagName string, shorthand string, kind nk
 	cmd.Flags().StringSliceVarP(&e.paths, flagName, shorthand, nil, `signing key or keypath or the value "generate"" to generate a key pair on the fly - comma separated list or option can be specified multiple times`)
 }
 
-func (e *SigningKeysParams) valid(s string) error {
-	_, err := e.resolve(s)
+func (e *SigningKeysParams) valid(ctx ActionCtx, s string) error {
+	_, err := e.resolve(ctx, s)
 	return err
 }
 
-func (e *SigningKeysParams) resolve(s string) (nkeys.KeyPair, error) {
+func (e *SigningKeysParams) resolve(ctx ActionCtx, s string) (nkeys.KeyPair, error) {
 	if s == "" {
 		return nil, fmt.Errorf("signing key cannot be empty")
 	}
 	if s == "generate" {
+		ks := ctx.StoreCtx().KeyStore
 		if kp, err := nkeys.CreatePair(e.kind); err != nil {
 			return nil, err
 		} else if s, err = kp.PublicKey(); err != nil {
 			return nil, err
-		} else if _, err = store.StoreKey(kp); err != nil {
+		} else if _, err = ks.Store(kp); err != nil {
 			return nil, err
 		}
 	}
@@ -70,19 +71,19 @@ func (e *SigningKeysParams) resolve(s string) (nkeys.KeyPair, error) {
 	return kp, nil
 }
 
-func (e *SigningKeysParams) Valid() error {
+func (e *SigningKeysParams) Valid(ctx ActionCtx) error {
 	for _, v := range e.paths {
-		if err := e.valid(v); err != nil {
+		if err := e.valid(ctx, v); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (e *SigningKeysParams) PublicKeys() ([]string, error) {
+func (e *SigningKeysParams) PublicKeys(ctx ActionCtx) ([]string, error) {
 	var keys []string
 	for _, v := range e.paths {
-		kp, err := e.resolve(v)
+		kp, err := e.resolve(ctx, v)
 		if err != nil {
 			return nil, err
 		}
@@ -95,10 +96,13 @@ func (e *SigningKeysParams) PublicKeys() ([]string, error) {
 	return keys, nil
 }
 
-func (e *SigningKeysParams) Edit() error {
+func (e *SigningKeysParams) Edit(ctx ActionCtx) error {
+	valid := func(s string) error {
+		return e.valid(ctx, s)
+	}
 	// verify any keys that were added via flags
 	for i, v := range e.paths {
-		sv, err := cli.Prompt(fmt.Sprintf("path to %s nkey or nkey", e.flagName), v, cli.Val(e.valid))
+		sv, err := cli.Prompt(fmt.Sprintf("path to %s nkey or nkey", e.flagName), v, cli.Val(valid))
 		if err != nil {
 			return err
 		}
@@ -119,7 +123,7 @@ func (e *SigningKeysParams) Edit() error {
 		if !ok {
 			break
 		}
-		sv, err := cli.Prompt(fmt.Sprintf("path to %s nkey or nkey", e.flagName), "", cli.Val(e.valid))
+		sv, err := cli.Prompt(fmt.Sprintf("path to %s nkey or nkey", e.flagName), "", cli.Val(valid))
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nkeys"
+)
+
+// AgentSockEnv is the unix socket of a running `nsc keys agent`
+const AgentSockEnv = "NSC_AGENT_SOCK"
+
+type agentKey struct {
+	kp      nkeys.KeyPair
+	expires time.Time
+	confirm bool
+	// timer removes the key when its lifetime ends
+	timer *time.Timer
+}
+
+// Agent is a Signer holding unlocked keys in memory. Keys can expire
+// and can require confirmation before every signature.
+type Agent struct {
+	mu   sync.Mutex
+	keys map[string]*agentKey
+	// ConfirmFn is asked before signing with a key added with confirm
+	ConfirmFn func(pubkey string) (bool, error)
+	confirmMu sync.Mutex
+	now       func() time.Time
+}
+
+func NewAgent() *Agent {
+	return &Agent{keys: make(map[string]*agentKey), now: time.Now}
+}
+
+// Add adds a key to the agent. A zero lifetime keeps the key until the
+// agent exits.
+func (a *Agent) Add(kp nkeys.KeyPair, lifetime time.Duration, confirm bool) error {
+	if _, err := kp.Seed(); err != nil {
+		return err
+	}
+	pk, err := kp.PublicKey()
+	if err != nil {
+		return err
+	}
+	k := &agentKey{kp: kp, confirm: confirm}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if lifetime > 0 {
+		k.expires = a.now().Add(lifetime)
+		// an idle agent doesn't keep the key past its lifetime
+		k.timer = time.AfterFunc(lifetime, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			a.remove(pk, k)
+		})
+	}
+	if old := a.keys[pk]; old != nil {
+		a.remove(pk, old)
+	}
+	a.keys[pk] = k
+	return nil
+}
+
+// remove wipes and removes the key if the agent still holds it, the lock
+// must be held
+func (a *Agent) remove(pk string, k *agentKey) {
+	if a.keys[pk] != k {
+		return
+	}
+	if k.timer != nil {
+		k.timer.Stop()
+	}
+	k.kp.Wipe()
+	delete(a.keys, pk)
+}
+
+// expire wipes and removes the keys whose lifetime ended, the lock must be held
+func (a *Agent) expire() {
+	now := a.now()
+	for pk, k := range a.keys {
+		if !k.expires.IsZero() && !now.Before(k.expires) {
+			a.remove(pk, k)
+		}
+	}
+}
+
+func (a *Agent) PublicKeys() ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire()
+	var keys []string
+	for pk := range a.keys {
+		keys = append(keys, pk)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (a *Agent) Sign(pubkey string, payload []byte) ([]byte, error) {
+	a.mu.Lock()
+	a.expire()
+	k := a.keys[pubkey]
+	a.mu.Unlock()
+	if k == nil {
+		return nil, fmt.Errorf("key %s is not available in the agent", pubkey)
+	}
+	if k.confirm {
+		// one confirmation prompt at a time
+		a.confirmMu.Lock()
+		ok := false
+		var err error
+		if a.ConfirmFn != nil {
+			ok, err = a.ConfirmFn(pubkey)
+		}
+		a.confirmMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("signing with %s was refused", pubkey)
+		}
+	}
+	// sign under the lock, so that the key can't be wiped meanwhile
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire()
+	if a.keys[pubkey] != k {
+		return nil, fmt.Errorf("key %s is not available in the agent", pubkey)
+	}
+	return k.kp.Sign(payload)
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentKeyLifetime(t *testing.T) {
+	now := time.Now()
+	a := NewAgent()
+	a.now = func() time.Time { return now }
+
+	_, opk, okp := CreateOperatorKey(t)
+	_, apk, akp := CreateAccountKey(t)
+	require.NoError(t, a.Add(okp, time.Minute, false))
+	require.NoError(t, a.Add(akp, 0, false))
+	keys, err := a.PublicKeys()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{opk, apk}, keys)
+
+	now = now.Add(time.Minute)
+	keys, err = a.PublicKeys()
+	require.NoError(t, err)
+	require.Equal(t, []string{apk}, keys)
+	_, err = a.Sign(opk, []byte("payload"))
+	require.Error(t, err)
+	_, err = a.Sign(apk, []byte("payload"))
+	require.NoError(t, err)
+}
+
+func TestAgentWipesIdleKeys(t *testing.T) {
+	a := NewAgent()
+	seed, opk, okp := CreateOperatorKey(t)
+	require.NoError(t, a.Add(okp, 50*time.Millisecond, false))
+	_, err := a.Sign(opk, []byte("payload"))
+	require.NoError(t, err)
+
+	// no request is needed to remove the key
+	require.Eventually(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return len(a.keys) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	wiped, _ := okp.Seed()
+	require.NotEqual(t, seed, wiped)
+}
+
+func TestAgentSignWhileExpiring(t *testing.T) {
+	a := NewAgent()
+	_, opk, okp := CreateOperatorKey(t)
+	require.NoError(t, a.Add(okp, 20*time.Millisecond, false))
+	pub, err := nkeys.FromPublicKey(opk)
+	require.NoError(t, err)
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		sig, err := a.Sign(opk, []byte("payload"))
+		if err != nil {
+			require.Contains(t, err.Error(), "is not available")
+			continue
+		}
+		require.NoError(t, pub.Verify([]byte("payload"), sig))
+	}
+}
+
+func TestAgentConfirm(t *testing.T) {
+	a := NewAgent()
+	_, pk, kp := CreateOperatorKey(t)
+	require.NoError(t, a.Add(kp, 0, true))
+
+	var asked []string
+	allow := false
+	a.ConfirmFn = func(k string) (bool, error) {
+		asked = append(asked, k)
+		return allow, nil
+	}
+	_, err := a.Sign(pk, []byte("payload"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "refused")
+
+	allow = true
+	sig, err := a.Sign(pk, []byte("payload"))
+	require.NoError(t, err)
+	require.NoError(t, kp.Verify([]byte("payload"), sig))
+	require.Equal(t, []string{pk, pk}, asked)
+}
+
+func TestAgentRequiresSeeds(t *testing.T) {
+	_, _, kp := CreateOperatorKey(t)
+	pk, err := kp.PublicKey()
+	require.NoError(t, err)
+	pub, err := ResolveKey(pk)
+	require.NoError(t, err)
+	require.Error(t, NewAgent().Add(pub, 0, false))
+}
+
+func TestKeyStorePrefersAgent(t *testing.T) {
+	dir, done := withTestKeystore(t)
+	defer done()
+
+	ks := NewKeyStore("O")
+	_, pk, kp := CreateOperatorKey(t)
+	_, err := ks.Store(kp)
+	require.NoError(t, err)
+	_, err = EncryptKeystore(dir, "secret")
+	require.NoError(t, err)
+	// forget the passphrase, asking for it again fails
+	unlockedKeys.Lock()
+	unlockedKeys.m = make(map[string][]byte)
+	unlockedKeys.Unlock()
+	fn := PassphraseFn
+	PassphraseFn = func(dir string) (string, error) {
+		return "", errors.New("asked for the passphrase")
+	}
+	defer func() { PassphraseFn = fn }()
+
+	a := NewAgent()
+	require.NoError(t, a.Add(kp, 0, false))
+	sock := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer l.Close()
+	go ServeSigner(l, a)
+	require.NoError(t, os.Setenv(AgentSockEnv, sock))
+	defer os.Unsetenv(AgentSockEnv)
+
+	akp, err := ks.GetKeyPair(pk)
+	require.NoError(t, err)
+	require.True(t, IsExternalKeyPair(akp))
+	require.True(t, ks.CanSign(pk))
+	sig, err := akp.Sign([]byte("payload"))
+	require.NoError(t, err)
+	require.NoError(t, kp.Verify([]byte("payload"), sig))
+
+	// the seed itself is only read from the keystore
+	_, err = ks.GetSeed(pk)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "asked for the passphrase")
+}
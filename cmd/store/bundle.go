@@ -0,0 +1,342 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An operator bundle is a gzipped tar holding a manifest, the files of the
+// operator directory under store/ and, optionally, seeds under keys/ and
+// creds under creds/. Seeds and creds are sealed with AES-256-GCM using a
+// key derived from a passphrase, the derivation parameters are in the manifest.
+
+// BundlePassphraseEnv holds the passphrase of operator bundles
+const BundlePassphraseEnv = "NSC_BUNDLE_PASSPHRASE"
+
+const bundleManifest = "manifest.json"
+const bundleStoreDir = "store"
+const bundleKeysDir = "keys"
+const bundleCredsDir = "creds"
+const bundleCheck = "nsc bundle"
+
+// the manifest and entries of a bundle are not trusted, entries are JWTs,
+// seeds and creds, which are much smaller than the limit
+const maxBundleEntry = 1 << 20
+const maxBundleSize = 64 << 20
+
+// BundleManifest describes the content of an operator bundle
+type BundleManifest struct {
+	Version  int    `json:"version"`
+	Operator string `json:"operator"`
+	Subject  string `json:"subject"`
+	// Accounts of a partial bundle, empty if the bundle has all accounts
+	Accounts []string `json:"accounts,omitempty"`
+	Created  int64    `json:"created"`
+	// Encryption of the seeds and creds, set if the bundle has any
+	Encryption *keystoreEncryption `json:"encryption,omitempty"`
+}
+
+// IsPartial returns true if the bundle has only some of the accounts
+func (m *BundleManifest) IsPartial() bool {
+	return len(m.Accounts) > 0
+}
+
+// Bundle is the content of an operator bundle. Paths are slash separated.
+type Bundle struct {
+	Manifest BundleManifest
+	// Store holds the operator files by path relative to the operator directory
+	Store map[string][]byte
+	// Keys holds seeds by public key
+	Keys map[string][]byte
+	// Creds holds user creds by <account>/<user>.creds
+	Creds map[string][]byte
+}
+
+// NewBundle collects the files of the operator. If accounts are named, only
+// the operator files and the files of the named accounts are collected. The
+// journal and lock files are never collected.
+func NewBundle(s *Store, subject string, accounts ...string) (*Bundle, error) {
+	b := &Bundle{
+		Manifest: BundleManifest{
+			Version:  1,
+			Operator: s.Info.Name,
+			Subject:  subject,
+			Accounts: accounts,
+			Created:  time.Now().Unix(),
+		},
+		Store: make(map[string][]byte),
+		Keys:  make(map[string][]byte),
+		Creds: make(map[string][]byte),
+	}
+	err := walkFiles(s.Dir, func(fp string, info os.FileInfo) error {
+		rel, err := filepath.Rel(s.Dir, fp)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if isGitIgnored(rel) || !b.includes(rel) {
+			return nil
+		}
+		d, err := readFile(fp)
+		if err != nil {
+			return err
+		}
+		b.Store[rel] = d
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// includes returns true if the file is part of the bundle
+func (b *Bundle) includes(rel string) bool {
+	if !b.Manifest.IsPartial() {
+		return true
+	}
+	parts := strings.Split(rel, "/")
+	if len(parts) == 1 {
+		return true
+	}
+	if parts[0] != Accounts || len(parts) < 3 {
+		return false
+	}
+	for _, a := range b.Manifest.Accounts {
+		if parts[1] == a {
+			return true
+		}
+	}
+	return false
+}
+
+// StoreFiles returns the paths of the operator files, sorted
+func (b *Bundle) StoreFiles() []string {
+	return sortedKeys(b.Store)
+}
+
+// KeyNames returns the public keys of the seeds, sorted
+func (b *Bundle) KeyNames() []string {
+	return sortedKeys(b.Keys)
+}
+
+// CredsFiles returns the paths of the creds, sorted
+func (b *Bundle) CredsFiles() []string {
+	return sortedKeys(b.Creds)
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Write writes the bundle to w. Seeds and creds are sealed with the passphrase,
+// which is required if the bundle has any.
+func (b *Bundle) Write(w io.Writer, passphrase string) error {
+	var key []byte
+	b.Manifest.Encryption = nil
+	if len(b.Keys)+len(b.Creds) > 0 {
+		if passphrase == "" {
+			return errors.New("a passphrase is required to bundle keys and creds")
+		}
+		e := &keystoreEncryption{Version: 1, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 32)}
+		if _, err := rand.Read(e.Salt); err != nil {
+			return err
+		}
+		var err error
+		if key, err = e.deriveKey(passphrase); err != nil {
+			return err
+		}
+		if e.Check, err = seal(key, []byte(bundleCheck)); err != nil {
+			return err
+		}
+		b.Manifest.Encryption = e
+	}
+	m, err := json.MarshalIndent(b.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Unix(b.Manifest.Created, 0)}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(bundleManifest, m); err != nil {
+		return err
+	}
+	for _, n := range b.StoreFiles() {
+		if err := add(path.Join(bundleStoreDir, n), b.Store[n]); err != nil {
+			return err
+		}
+	}
+	for _, n := range b.KeyNames() {
+		sealed, err := seal(key, b.Keys[n])
+		if err != nil {
+			return err
+		}
+		if err := add(path.Join(bundleKeysDir, n+NKeyExtension), sealed); err != nil {
+			return err
+		}
+	}
+	for _, n := range b.CredsFiles() {
+		sealed, err := seal(key, b.Creds[n])
+		if err != nil {
+			return err
+		}
+		if err := add(path.Join(bundleCredsDir, n), sealed); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// cleanBundlePath rejects paths that would resolve outside of the bundle
+func cleanBundlePath(name string) (string, error) {
+	c := path.Clean(name)
+	if path.IsAbs(c) || c == ".." || strings.HasPrefix(c, "../") || strings.Contains(c, "\\") {
+		return "", fmt.Errorf("bundle entry %q has an invalid path", name)
+	}
+	return c, nil
+}
+
+// ReadBundle reads a bundle written by Bundle.Write. The passphrase function
+// is called only if the bundle has sealed seeds or creds.
+func ReadBundle(r io.Reader, passphrase func() (string, error)) (*Bundle, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not an operator bundle: %v", err)
+	}
+	defer zr.Close()
+	b := &Bundle{Store: make(map[string][]byte), Keys: make(map[string][]byte), Creds: make(map[string][]byte)}
+	sealed := make(map[string][]byte)
+	hasManifest := false
+	size := 0
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading bundle: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, err := cleanBundlePath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Size > maxBundleEntry {
+			return nil, fmt.Errorf("bundle entry %q is larger than %d bytes", name, maxBundleEntry)
+		}
+		d, err := ioutil.ReadAll(io.LimitReader(tr, maxBundleEntry+1))
+		if err != nil {
+			return nil, fmt.Errorf("error reading bundle: %v", err)
+		}
+		if len(d) > maxBundleEntry {
+			return nil, fmt.Errorf("bundle entry %q is larger than %d bytes", name, maxBundleEntry)
+		}
+		if size += len(d); size > maxBundleSize {
+			return nil, fmt.Errorf("bundle is larger than %d bytes", maxBundleSize)
+		}
+		parts := strings.SplitN(name, "/", 2)
+		switch {
+		case name == bundleManifest:
+			if err := json.Unmarshal(d, &b.Manifest); err != nil {
+				return nil, fmt.Errorf("error parsing bundle manifest: %v", err)
+			}
+			hasManifest = true
+		case len(parts) == 2 && parts[0] == bundleStoreDir:
+			b.Store[parts[1]] = d
+		case len(parts) == 2 && (parts[0] == bundleKeysDir || parts[0] == bundleCredsDir):
+			sealed[name] = d
+		default:
+			return nil, fmt.Errorf("bundle has an unexpected entry %q", name)
+		}
+	}
+	if !hasManifest {
+		return nil, errors.New("not an operator bundle: the manifest is missing")
+	}
+	if b.Manifest.Version != 1 {
+		return nil, fmt.Errorf("unsupported operator bundle version %d", b.Manifest.Version)
+	}
+	if len(sealed) == 0 {
+		return b, nil
+	}
+
+	e := b.Manifest.Encryption
+	if e == nil || e.KDF != "scrypt" {
+		return nil, errors.New("bundle has keys or creds but no supported encryption")
+	}
+	if err := e.checkScrypt(); err != nil {
+		return nil, fmt.Errorf("bundle has %v", err)
+	}
+	if passphrase == nil {
+		return nil, fmt.Errorf("bundle has keys or creds - set $%s", BundlePassphraseEnv)
+	}
+	pp, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	key, err := e.deriveKey(pp)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := openSealed(key, e.Check); err != nil {
+		return nil, errors.New("invalid passphrase for the bundle")
+	}
+	for name, d := range sealed {
+		plain, err := openSealed(key, d)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %q in the bundle: %v", name, err)
+		}
+		parts := strings.SplitN(name, "/", 2)
+		if parts[0] == bundleKeysDir {
+			b.Keys[strings.TrimSuffix(parts[1], NKeyExtension)] = plain
+		} else {
+			b.Creds[parts[1]] = plain
+		}
+	}
+	return b, nil
+}
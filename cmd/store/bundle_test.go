@@ -0,0 +1,83 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeRawBundle writes a bundle with the manifest and the entries as is
+func writeRawBundle(t *testing.T, m BundleManifest, entries map[string][]byte) *bytes.Buffer {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	d, err := json.Marshal(m)
+	require.NoError(t, err)
+	entries[bundleManifest] = d
+	for _, n := range sortedKeys(entries) {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: n, Mode: 0600, Size: int64(len(entries[n]))}))
+		_, err := tw.Write(entries[n])
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+	return &buf
+}
+
+func TestReadBundleRejectsLargeEntries(t *testing.T) {
+	m := BundleManifest{Version: 1, Operator: "O"}
+	buf := writeRawBundle(t, m, map[string][]byte{
+		"store/O.jwt": bytes.Repeat([]byte("a"), maxBundleEntry+1),
+	})
+	_, err := ReadBundle(buf, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is larger than")
+
+	buf = writeRawBundle(t, m, map[string][]byte{
+		"store/O.jwt": bytes.Repeat([]byte("a"), maxBundleEntry),
+	})
+	b, err := ReadBundle(buf, nil)
+	require.NoError(t, err)
+	require.Len(t, b.Store["O.jwt"], maxBundleEntry)
+}
+
+func TestReadBundleRejectsScryptParameters(t *testing.T) {
+	for _, e := range []keystoreEncryption{
+		{N: 1 << 30, R: scryptR, P: scryptP},
+		{N: 1 << 20, R: 1 << 20, P: scryptP},
+		{N: 1000, R: scryptR, P: scryptP},
+		{N: scryptN, R: 0, P: scryptP},
+		{N: scryptN, R: scryptR, P: 1 << 20},
+	} {
+		e.Version = 1
+		e.KDF = "scrypt"
+		buf := writeRawBundle(t, BundleManifest{Version: 1, Operator: "O", Encryption: &e}, map[string][]byte{
+			"keys/A.nk": []byte("sealed"),
+		})
+		_, err := ReadBundle(buf, func() (string, error) {
+			t.Fatal("the passphrase is not needed")
+			return "", nil
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported scrypt parameters")
+	}
+}
@@ -0,0 +1,427 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// An encrypted keystore has an encryption file in its root directory holding
+// the parameters used to derive a key from the passphrase. The seeds in the
+// keystore are sealed with AES-256-GCM using the derived key. Creds files are
+// not encrypted, as they are read by NATS clients.
+
+// KeystoreEncryptionFile marks a keystore as encrypted
+const KeystoreEncryptionFile = ".encryption"
+
+// KeystorePassphraseEnv holds the passphrase of an encrypted keystore
+const KeystorePassphraseEnv = "NSC_KEYSTORE_PASSPHRASE"
+
+// KeystorePassphraseFdEnv names a file descriptor to read the passphrase from
+const KeystorePassphraseFdEnv = "NSC_KEYSTORE_PASSPHRASE_FD"
+
+const sealedSeedPrefix = "NSCSEALED1:"
+const encryptionCheck = "nsc keystore"
+
+// scrypt cost parameters for new encrypted keystores
+var scryptN = 1 << 15
+
+const (
+	scryptR = 8
+	scryptP = 1
+)
+
+// the key derivation can take at most 128*N*R bytes of memory, the
+// parameters are read from files that could be tampered with
+const maxScryptMemory = 256 << 20
+const maxScryptP = 16
+
+// PassphraseFn asks for the passphrase of the encrypted keystore in dir
+var PassphraseFn = func(dir string) (string, error) {
+	return cli.Password(fmt.Sprintf("passphrase for keystore %s", AbbrevHomePaths(dir)))
+}
+
+type keystoreEncryption struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	// Check is a known value sealed with the key, used to verify passphrases
+	Check []byte `json:"check"`
+}
+
+// unlocked keys by salt, so a passphrase is asked for once per process
+var unlockedKeys = struct {
+	sync.Mutex
+	m map[string][]byte
+}{m: make(map[string][]byte)}
+
+func readEncryption(dir string) (*keystoreEncryption, error) {
+	d, err := readFile(filepath.Join(dir, KeystoreEncryptionFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e keystoreEncryption
+	if err := json.Unmarshal(d, &e); err != nil {
+		return nil, fmt.Errorf("error parsing keystore encryption %#q: %v", dir, err)
+	}
+	if e.Version != 1 || e.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported keystore encryption %q version %d", e.KDF, e.Version)
+	}
+	if err := e.checkScrypt(); err != nil {
+		return nil, fmt.Errorf("keystore encryption %#q has %v", dir, err)
+	}
+	return &e, nil
+}
+
+// IsKeystoreEncrypted returns true if the seeds in the keystore are encrypted
+func IsKeystoreEncrypted(dir string) bool {
+	e, err := readEncryption(dir)
+	return err == nil && e != nil
+}
+
+// checkScrypt rejects derivation parameters that are invalid or
+// that would take too much memory or time
+func (e *keystoreEncryption) checkScrypt() error {
+	if e.N <= 1 || e.N&(e.N-1) != 0 || e.R <= 0 || e.R > maxScryptMemory/256 ||
+		e.N > maxScryptMemory/(128*e.R) || e.P <= 0 || e.P > maxScryptP {
+		return fmt.Errorf("unsupported scrypt parameters N=%d r=%d p=%d", e.N, e.R, e.P)
+	}
+	return nil
+}
+
+func (e *keystoreEncryption) deriveKey(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), e.Salt, e.N, e.R, e.P, 32)
+}
+
+// verify returns the key for the passphrase if it is the keystore passphrase
+func (e *keystoreEncryption) verify(dir string, passphrase string) ([]byte, error) {
+	key, err := e.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := openSealed(key, e.Check); err != nil {
+		return nil, fmt.Errorf("invalid passphrase for keystore %#q", dir)
+	}
+	e.remember(key)
+	return key, nil
+}
+
+func (e *keystoreEncryption) remember(key []byte) {
+	unlockedKeys.Lock()
+	unlockedKeys.m[string(e.Salt)] = key
+	unlockedKeys.Unlock()
+}
+
+func (e *keystoreEncryption) unlock(dir string) ([]byte, error) {
+	unlockedKeys.Lock()
+	key, ok := unlockedKeys.m[string(e.Salt)]
+	unlockedKeys.Unlock()
+	if ok {
+		return key, nil
+	}
+	passphrase, err := KeystorePassphrase(dir)
+	if err != nil {
+		return nil, err
+	}
+	return e.verify(dir, passphrase)
+}
+
+// KeystorePassphrase returns the passphrase of the keystore in dir from
+// the environment, or a file descriptor, otherwise asks for it
+func KeystorePassphrase(dir string) (string, error) {
+	if v, ok := os.LookupEnv(KeystorePassphraseEnv); ok {
+		return v, nil
+	}
+	if v, ok := os.LookupEnv(KeystorePassphraseFdEnv); ok {
+		fd, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return "", fmt.Errorf("$%s is not a file descriptor: %v", KeystorePassphraseFdEnv, err)
+		}
+		f := os.NewFile(uintptr(fd), "passphrase")
+		if f == nil {
+			return "", fmt.Errorf("$%s is not a file descriptor", KeystorePassphraseFdEnv)
+		}
+		defer f.Close()
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("error reading passphrase from $%s: %v", KeystorePassphraseFdEnv, err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	if PassphraseFn == nil {
+		return "", fmt.Errorf("keystore %#q is encrypted - set $%s", dir, KeystorePassphraseEnv)
+	}
+	return PassphraseFn(dir)
+}
+
+func seal(key []byte, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+	return []byte(sealedSeedPrefix + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func openSealed(key []byte, data []byte) ([]byte, error) {
+	if !IsSealedSeed(data) {
+		return nil, errors.New("data is not sealed")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data))[len(sealedSeedPrefix):])
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// IsSealedSeed returns true if the data is a seed sealed by an encrypted keystore
+func IsSealedSeed(d []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(d), []byte(sealedSeedPrefix))
+}
+
+// sealingKeystore returns the encrypted keystore holding the file, if any
+func sealingKeystore(fp string) (string, *keystoreEncryption, error) {
+	dir := filepath.Dir(fp)
+	// keys are stored at keys/<kind>/<shard>/<pk>.nk
+	for i := 0; i < 4 && dir != filepath.Dir(dir); i++ {
+		e, err := readEncryption(dir)
+		if err != nil {
+			return "", nil, err
+		}
+		if e != nil {
+			return dir, e, nil
+		}
+		dir = filepath.Dir(dir)
+	}
+	return "", nil, nil
+}
+
+// UnsealSeed returns the seed in data read from the file, which is opened
+// with the key of the encrypted keystore holding the file if it is sealed
+func UnsealSeed(fp string, data []byte) ([]byte, error) {
+	if !IsSealedSeed(data) {
+		return data, nil
+	}
+	dir, e, err := sealingKeystore(fp)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		// a sealed file copied out of its keystore
+		dir = GetKeysDir()
+		if e, err = readEncryption(dir); err != nil {
+			return nil, err
+		}
+		if e == nil {
+			return nil, fmt.Errorf("%#q is encrypted but is not in an encrypted keystore", fp)
+		}
+	}
+	key, err := e.unlock(dir)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := openSealed(key, data)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %#q: %v", fp, err)
+	}
+	return seed, nil
+}
+
+// sealSeed seals the seed if the keystore in dir is encrypted
+func sealSeed(dir string, seed []byte) ([]byte, error) {
+	e, err := readEncryption(dir)
+	if err != nil || e == nil {
+		return seed, err
+	}
+	key, err := e.unlock(dir)
+	if err != nil {
+		return nil, err
+	}
+	return seal(key, seed)
+}
+
+func keystoreSeedFiles(dir string) ([]string, error) {
+	var files []string
+	err := walkFiles(filepath.Join(dir, KeysDir), func(fp string, info os.FileInfo) error {
+		if filepath.Ext(fp) == NKeyExtension {
+			files = append(files, fp)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// EncryptKeystore seals the seeds in the keystore with the passphrase and
+// returns the number of seeds sealed. Encrypting an encrypted keystore seals
+// the seeds that are not, provided the passphrase matches.
+func EncryptKeystore(dir string, passphrase string) (int, error) {
+	if passphrase == "" {
+		return 0, errors.New("passphrase cannot be empty")
+	}
+	if err := makeKeyStore(dir); err != nil {
+		return 0, err
+	}
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	e, err := readEncryption(dir)
+	if err != nil {
+		return 0, err
+	}
+	var key []byte
+	if e != nil {
+		if key, err = e.verify(dir, passphrase); err != nil {
+			return 0, err
+		}
+	} else {
+		e = &keystoreEncryption{Version: 1, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 32)}
+		if _, err := rand.Read(e.Salt); err != nil {
+			return 0, err
+		}
+		if key, err = e.deriveKey(passphrase); err != nil {
+			return 0, err
+		}
+		if e.Check, err = seal(key, []byte(encryptionCheck)); err != nil {
+			return 0, err
+		}
+		d, err := json.MarshalIndent(e, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		// written first, so an interrupted encryption can be resumed
+		if err := writeFile(filepath.Join(dir, KeystoreEncryptionFile), d, 0600); err != nil {
+			return 0, err
+		}
+		e.remember(key)
+	}
+
+	files, err := keystoreSeedFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, fp := range files {
+		d, err := readFile(fp)
+		if err != nil {
+			return count, err
+		}
+		if IsSealedSeed(d) {
+			continue
+		}
+		sealed, err := seal(key, d)
+		if err != nil {
+			return count, err
+		}
+		if err := writeFile(fp, sealed, 0600); err != nil {
+			return count, fmt.Errorf("error writing %#q: %v", fp, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// DecryptKeystore opens the sealed seeds in the keystore, and returns
+// the number of seeds decrypted
+func DecryptKeystore(dir string) (int, error) {
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	e, err := readEncryption(dir)
+	if err != nil {
+		return 0, err
+	}
+	if e == nil {
+		return 0, fmt.Errorf("keystore %#q is not encrypted", dir)
+	}
+	key, err := e.unlock(dir)
+	if err != nil {
+		return 0, err
+	}
+	files, err := keystoreSeedFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, fp := range files {
+		d, err := readFile(fp)
+		if err != nil {
+			return count, err
+		}
+		if !IsSealedSeed(d) {
+			continue
+		}
+		seed, err := openSealed(key, d)
+		if err != nil {
+			return count, fmt.Errorf("error decrypting %#q: %v", fp, err)
+		}
+		if err := writeFile(fp, seed, 0600); err != nil {
+			return count, fmt.Errorf("error writing %#q: %v", fp, err)
+		}
+		count++
+	}
+	// removed last, so an interrupted decryption can be resumed
+	if err := removeFile(filepath.Join(dir, KeystoreEncryptionFile)); err != nil {
+		return count, err
+	}
+	return count, nil
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func withTestKeystore(t *testing.T) (string, func()) {
+	dir := MakeTempDir(t)
+	old := os.Getenv(NKeysPathEnv)
+	require.NoError(t, os.Setenv(NKeysPathEnv, dir))
+	n := scryptN
+	scryptN = 1 << 10
+	return dir, func() {
+		scryptN = n
+		os.Setenv(NKeysPathEnv, old)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEncryptKeystoreRoundTrip(t *testing.T) {
+	dir, done := withTestKeystore(t)
+	defer done()
+
+	ks := NewKeyStore("O")
+	seed, pk, kp := CreateAccountKey(t)
+	_, err := ks.Store(kp)
+	require.NoError(t, err)
+
+	n, err := EncryptKeystore(dir, "secret")
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.True(t, IsKeystoreEncrypted(dir))
+
+	d, err := ioutil.ReadFile(GetKeyPath(pk))
+	require.NoError(t, err)
+	require.True(t, IsSealedSeed(d))
+	require.NotContains(t, string(d), string(seed))
+
+	s, err := ks.GetSeed(pk)
+	require.NoError(t, err)
+	require.Equal(t, string(seed), s)
+
+	// keys stored in an encrypted keystore are sealed
+	_, upk, ukp := CreateAccountKey(t)
+	_, err = ks.Store(ukp)
+	require.NoError(t, err)
+	d, err = ioutil.ReadFile(GetKeyPath(upk))
+	require.NoError(t, err)
+	require.True(t, IsSealedSeed(d))
+	// storing it again compares the seeds
+	_, err = ks.Store(ukp)
+	require.NoError(t, err)
+
+	// encrypting again seals nothing new
+	n, err = EncryptKeystore(dir, "secret")
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	n, err = DecryptKeystore(dir)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.False(t, IsKeystoreEncrypted(dir))
+	d, err = ioutil.ReadFile(GetKeyPath(pk))
+	require.NoError(t, err)
+	require.Equal(t, string(seed), string(d))
+}
+
+func TestEncryptKeystoreWrongPassphrase(t *testing.T) {
+	dir, done := withTestKeystore(t)
+	defer done()
+
+	_, err := EncryptKeystore(dir, "secret")
+	require.NoError(t, err)
+	_, err = EncryptKeystore(dir, "other")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid passphrase")
+
+	e, err := readEncryption(dir)
+	require.NoError(t, err)
+	_, err = e.verify(dir, "other")
+	require.Error(t, err)
+}
+
+func TestEncryptKeystoreRejectsScryptParameters(t *testing.T) {
+	dir, done := withTestKeystore(t)
+	defer done()
+
+	_, err := EncryptKeystore(dir, "secret")
+	require.NoError(t, err)
+	e, err := readEncryption(dir)
+	require.NoError(t, err)
+	// a tampered file can't make the key derivation exhaust the memory
+	e.N = 1 << 30
+	d, err := json.Marshal(e)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, KeystoreEncryptionFile), d, 0600))
+	_, err = readEncryption(dir)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported scrypt parameters")
+	_, err = EncryptKeystore(dir, "secret")
+	require.Error(t, err)
+}
+
+func TestSealedSeedOutsideKeystore(t *testing.T) {
+	dir, done := withTestKeystore(t)
+	defer done()
+
+	ks := NewKeyStore("O")
+	seed, pk, kp := CreateAccountKey(t)
+	_, err := ks.Store(kp)
+	require.NoError(t, err)
+	_, err = EncryptKeystore(dir, "secret")
+	require.NoError(t, err)
+
+	// a sealed seed copied elsewhere is opened with the current keystore
+	d, err := ioutil.ReadFile(GetKeyPath(pk))
+	require.NoError(t, err)
+	other := MakeTempDir(t)
+	defer os.RemoveAll(other)
+	fp := filepath.Join(other, "key.nk")
+	require.NoError(t, ioutil.WriteFile(fp, d, 0600))
+	rkp, err := ResolveKey(fp)
+	require.NoError(t, err)
+	rs, err := rkp.Seed()
+	require.NoError(t, err)
+	require.Equal(t, seed, rs)
+}
@@ -0,0 +1,586 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// All reads and writes of the store and the keystore go through the functions
+// in this file. While a dry-run is active, writes and removals are captured in
+// memory instead of being committed, and reads see the captured state.
+
+var dryRun *DryRun
+
+// written collects the paths of the files written or removed while it is
+// active, so that the changes made by a command can be told apart from other
+// changes to the same directories
+var written *writeLog
+
+type writeLog struct {
+	sync.Mutex
+	paths map[string]bool
+}
+
+// StartRecording starts collecting the paths of the files written or removed
+func StartRecording() {
+	written = &writeLog{paths: make(map[string]bool)}
+}
+
+// StopRecording stops collecting paths and returns the ones collected, sorted
+func StopRecording() []string {
+	w := written
+	written = nil
+	if w == nil {
+		return nil
+	}
+	w.Lock()
+	defer w.Unlock()
+	paths := make([]string, 0, len(w.paths))
+	for fp := range w.paths {
+		paths = append(paths, fp)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// RecordWrite adds the dir to the paths collected, so all of its changes
+// are treated as written by the command
+func RecordWrite(dir string) {
+	recordWrite(dir)
+}
+
+// recordWrite adds the paths to the ones collected, a path can be a
+// directory whose files were all changed
+func recordWrite(paths ...string) {
+	w := written
+	if w == nil {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	for _, fp := range paths {
+		w.paths[cleanPath(fp)] = true
+	}
+}
+
+// DryRun holds the files written or removed while it is active
+type DryRun struct {
+	sync.Mutex
+	files map[string]*capturedFile
+	dirs  map[string]bool
+	order []string
+}
+
+type capturedFile struct {
+	data    []byte
+	deleted bool
+	// mode is set when only the mode of the file changed
+	mode os.FileMode
+}
+
+// FileChange describes a file that would have been modified by a dry-run
+type FileChange struct {
+	Path     string
+	Original []byte
+	Data     []byte
+	Deleted  bool
+	// Mode is set when the mode of the file changes
+	Mode os.FileMode
+}
+
+// IsNew returns true if the file didn't exist before the dry-run
+func (fc *FileChange) IsNew() bool {
+	return fc.Original == nil && !fc.Deleted
+}
+
+// StartDryRun starts capturing writes to the store and the keystore
+func StartDryRun() *DryRun {
+	dryRun = &DryRun{files: make(map[string]*capturedFile), dirs: make(map[string]bool)}
+	return dryRun
+}
+
+// StopDryRun discards all captured writes
+func StopDryRun() {
+	dryRun = nil
+}
+
+// IsDryRun returns true if writes are being captured
+func IsDryRun() bool {
+	return dryRun != nil
+}
+
+// Changes returns the files that differ from their on-disk version
+func (d *DryRun) Changes() []*FileChange {
+	d.Lock()
+	defer d.Unlock()
+	var changes []*FileChange
+	for _, fp := range d.order {
+		cf, ok := d.files[fp]
+		if !ok {
+			continue
+		}
+		orig, err := ioutil.ReadFile(fp)
+		if err != nil {
+			orig = nil
+		}
+		if cf.deleted && orig == nil {
+			continue
+		}
+		if cf.mode != 0 {
+			if fi, err := os.Stat(fp); err == nil && fi.Mode().Perm() != cf.mode {
+				changes = append(changes, &FileChange{Path: fp, Original: orig, Data: cf.data, Mode: cf.mode})
+			}
+			continue
+		}
+		if !cf.deleted && orig != nil && bytes.Equal(orig, cf.data) {
+			continue
+		}
+		changes = append(changes, &FileChange{Path: fp, Original: orig, Data: cf.data, Deleted: cf.deleted})
+	}
+	return changes
+}
+
+func (d *DryRun) capture(fp string, cf *capturedFile) {
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.files[fp]; !ok {
+		d.order = append(d.order, fp)
+	}
+	d.files[fp] = cf
+	for dir := filepath.Dir(fp); !cf.deleted && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		// a directory removed earlier comes back with the file
+		if df, ok := d.files[dir]; ok && df.deleted {
+			delete(d.files, dir)
+		}
+		d.dirs[dir] = true
+	}
+}
+
+func (d *DryRun) lookup(fp string) (*capturedFile, bool) {
+	d.Lock()
+	defer d.Unlock()
+	cf, ok := d.files[fp]
+	return cf, ok
+}
+
+// children returns the captured entries directly under the directory
+func (d *DryRun) children(dir string) map[string]os.FileInfo {
+	d.Lock()
+	defer d.Unlock()
+	m := make(map[string]os.FileInfo)
+	prefix := dir + string(os.PathSeparator)
+	for fp, cf := range d.files {
+		if !strings.HasPrefix(fp, prefix) {
+			continue
+		}
+		rel := fp[len(prefix):]
+		if i := strings.IndexRune(rel, os.PathSeparator); i != -1 {
+			if !cf.deleted {
+				n := rel[:i]
+				m[n] = &capturedFileInfo{name: n, dir: true}
+			}
+			continue
+		}
+		if cf.deleted {
+			m[rel] = nil
+		} else {
+			m[rel] = &capturedFileInfo{name: rel, size: int64(len(cf.data))}
+		}
+	}
+	for dp := range d.dirs {
+		if filepath.Dir(dp) == dir {
+			n := filepath.Base(dp)
+			if _, ok := m[n]; !ok {
+				m[n] = &capturedFileInfo{name: n, dir: true}
+			}
+		}
+	}
+	return m
+}
+
+type capturedFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi *capturedFileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *capturedFileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *capturedFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0700
+	}
+	return 0600
+}
+
+func (fi *capturedFileInfo) ModTime() time.Time {
+	return time.Now()
+}
+
+func (fi *capturedFileInfo) IsDir() bool {
+	return fi.dir
+}
+
+func (fi *capturedFileInfo) Sys() interface{} {
+	return nil
+}
+
+func notExist(op string, fp string) error {
+	return &os.PathError{Op: op, Path: fp, Err: os.ErrNotExist}
+}
+
+func cleanPath(fp string) string {
+	if abs, err := filepath.Abs(fp); err == nil {
+		return abs
+	}
+	return filepath.Clean(fp)
+}
+
+func readFile(fp string) ([]byte, error) {
+	if dryRun != nil {
+		if cf, ok := dryRun.lookup(cleanPath(fp)); ok {
+			if cf.deleted {
+				return nil, notExist("open", fp)
+			}
+			return append([]byte(nil), cf.data...), nil
+		}
+	}
+	return ioutil.ReadFile(fp)
+}
+
+func writeFile(fp string, data []byte, perm os.FileMode) error {
+	if dryRun != nil {
+		dryRun.capture(cleanPath(fp), &capturedFile{data: append([]byte(nil), data...)})
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(fp), 0700); err != nil {
+		return err
+	}
+	recordWrite(fp)
+	// write a temp file and rename it, so that readers
+	// never see a partially written file
+	f, err := ioutil.TempFile(filepath.Dir(fp), "."+filepath.Base(fp)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, fp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func appendFile(fp string, data []byte) error {
+	if dryRun != nil {
+		d, err := readFile(fp)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		dryRun.capture(cleanPath(fp), &capturedFile{data: append(d, data...)})
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(fp), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fp, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func removeFile(fp string) error {
+	if dryRun != nil {
+		cp := cleanPath(fp)
+		fi, err := statFile(cp)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			infos, err := readDir(cp)
+			if err != nil {
+				return err
+			}
+			if len(infos) > 0 {
+				return &os.PathError{Op: "remove", Path: fp, Err: fmt.Errorf("directory not empty")}
+			}
+			dryRun.Lock()
+			delete(dryRun.dirs, cp)
+			dryRun.Unlock()
+		}
+		dryRun.capture(cp, &capturedFile{deleted: true})
+		return nil
+	}
+	recordWrite(fp)
+	return os.Remove(fp)
+}
+
+func chmodFile(fp string, mode os.FileMode) error {
+	if dryRun != nil {
+		cp := cleanPath(fp)
+		if cf, ok := dryRun.lookup(cp); ok {
+			if cf.deleted {
+				return notExist("chmod", fp)
+			}
+			// a file written by the dry-run is already written with its mode
+			return nil
+		}
+		d, err := ioutil.ReadFile(cp)
+		if err != nil {
+			return err
+		}
+		dryRun.capture(cp, &capturedFile{data: d, mode: mode})
+		return nil
+	}
+	return os.Chmod(fp, mode)
+}
+
+func statFile(fp string) (os.FileInfo, error) {
+	if dryRun != nil {
+		cp := cleanPath(fp)
+		if cf, ok := dryRun.lookup(cp); ok {
+			if cf.deleted {
+				return nil, notExist("stat", fp)
+			}
+			return &capturedFileInfo{name: filepath.Base(cp), size: int64(len(cf.data))}, nil
+		}
+		dryRun.Lock()
+		isDir := dryRun.dirs[cp]
+		dryRun.Unlock()
+		if isDir {
+			return &capturedFileInfo{name: filepath.Base(cp), dir: true}, nil
+		}
+	}
+	return os.Stat(fp)
+}
+
+func mkdirAll(dir string) error {
+	if dryRun != nil {
+		cp := cleanPath(dir)
+		dryRun.Lock()
+		for d := cp; d != filepath.Dir(d); d = filepath.Dir(d) {
+			dryRun.dirs[d] = true
+		}
+		dryRun.Unlock()
+		return nil
+	}
+	return os.MkdirAll(dir, 0700)
+}
+
+func readDir(dir string) ([]os.FileInfo, error) {
+	if dryRun == nil {
+		return ioutil.ReadDir(dir)
+	}
+	cp := cleanPath(dir)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	captured := dryRun.children(cp)
+	if err != nil && len(captured) == 0 {
+		if _, serr := statFile(cp); serr != nil {
+			return nil, err
+		}
+	}
+	var merged []os.FileInfo
+	for _, fi := range infos {
+		if c, ok := captured[fi.Name()]; ok {
+			if c != nil {
+				merged = append(merged, c)
+			}
+			delete(captured, fi.Name())
+			continue
+		}
+		merged = append(merged, fi)
+	}
+	for _, c := range captured {
+		if c != nil {
+			merged = append(merged, c)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Name() < merged[j].Name()
+	})
+	return merged, nil
+}
+
+// walkFiles calls fn for every file under root, including the files
+// captured by a dry-run. A root that doesn't exist has no files.
+func walkFiles(root string, fn func(fp string, info os.FileInfo) error) error {
+	fi, err := statFile(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fn(root, fi)
+	}
+	infos, err := readDir(root)
+	if err != nil {
+		return err
+	}
+	for _, i := range infos {
+		fp := filepath.Join(root, i.Name())
+		if i.IsDir() {
+			if err := walkFiles(fp, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(fp, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFile reads a file honoring an active dry-run
+func ReadFile(fp string) ([]byte, error) {
+	return readFile(fp)
+}
+
+// WriteFile writes a file creating any parent directories,
+// while a dry-run is active the write is captured
+func WriteFile(fp string, data []byte) error {
+	return writeFile(fp, data, 0600)
+}
+
+// Stat returns the file info of a file honoring an active dry-run
+func Stat(fp string) (os.FileInfo, error) {
+	return statFile(fp)
+}
+
+// MkdirAll creates a directory and its parents,
+// while a dry-run is active the directories are captured
+func MkdirAll(dir string) error {
+	return mkdirAll(dir)
+}
+
+// RemoveFile removes a file or an empty directory,
+// while a dry-run is active the removal is captured
+func RemoveFile(fp string) error {
+	return removeFile(fp)
+}
+
+// RenameDir moves all the files in a directory to a new directory
+func RenameDir(from string, to string) error {
+	if dryRun == nil {
+		recordWrite(from, to)
+		return os.Rename(from, to)
+	}
+	if _, err := statFile(to); err == nil {
+		return &os.PathError{Op: "rename", Path: to, Err: os.ErrExist}
+	}
+	var files []string
+	if err := walkFiles(from, func(fp string, info os.FileInfo) error {
+		files = append(files, fp)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, fp := range files {
+		d, err := readFile(fp)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, fp)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(to, rel), d, 0600); err != nil {
+			return err
+		}
+		if err := removeFile(fp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFile moves a file, creating the parent directories of the target.
+// While a dry-run is active the move is captured as a write and a removal.
+func moveFile(from string, to string) error {
+	if dryRun == nil {
+		if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+			return err
+		}
+		recordWrite(from, to)
+		return os.Rename(from, to)
+	}
+	d, err := readFile(from)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(to, d, 0600); err != nil {
+		return err
+	}
+	return removeFile(from)
+}
+
+// removeEmptyDirs removes dir and its parents up to, but excluding, root
+// while they are empty
+func removeEmptyDirs(dir string, root string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		infos, err := readDir(dir)
+		if err != nil || len(infos) > 0 {
+			return
+		}
+		if err := removeFile(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
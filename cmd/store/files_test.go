@@ -0,0 +1,62 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunCapturesWrites(t *testing.T) {
+	s := CreateTestStore(t, "O")
+	require.NoError(t, s.Write([]byte("one"), "a.txt"))
+
+	dr := StartDryRun()
+	defer StopDryRun()
+
+	require.NoError(t, s.Write([]byte("two"), "a.txt"))
+	require.NoError(t, s.Write([]byte("new"), "dir", "b.txt"))
+	require.NoError(t, s.Delete(".nsc"))
+
+	d, err := s.Read("a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "two", string(d))
+	require.True(t, s.Has("dir", "b.txt"))
+	require.False(t, s.Has("", ".nsc"))
+
+	infos, err := s.List("dir", "")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, "b.txt", infos[0].Name())
+
+	changes := dr.Changes()
+	require.Len(t, changes, 3)
+	require.Equal(t, "one", string(changes[0].Original))
+	require.Equal(t, "two", string(changes[0].Data))
+	require.True(t, changes[1].IsNew())
+	require.True(t, changes[2].Deleted)
+
+	StopDryRun()
+	d, err = s.Read("a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "one", string(d))
+	_, err = os.Stat(filepath.Join(s.Dir, "dir"))
+	require.True(t, os.IsNotExist(err))
+	require.True(t, s.Has("", ".nsc"))
+}
@@ -0,0 +1,374 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitAutoCommitKey is the git config key that enables committing the
+// changes made by nsc commands
+const GitAutoCommitKey = "nsc.autocommit"
+
+// GitRepo is a git work tree holding the store root
+type GitRepo struct {
+	// Dir is the top level directory of the work tree
+	Dir string
+}
+
+// GitChange is a file changed in the work tree
+type GitChange struct {
+	// Path relative to the top level directory
+	Path    string
+	Deleted bool
+}
+
+// GitCommit is an entry of the git log
+type GitCommit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+}
+
+func runGit(dir string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if m := strings.TrimSpace(stderr.String()); m != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], m)
+		}
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// OpenGitRepo returns the git work tree holding the directory, or nil if
+// the directory is not in a work tree or git is not installed
+func OpenGitRepo(dir string) *GitRepo {
+	// avoid running git for directories that are not in a work tree
+	found := false
+	for d := filepath.Clean(dir); !found; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			found = true
+		} else if d == filepath.Dir(d) {
+			return nil
+		}
+	}
+	d, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil
+	}
+	return &GitRepo{Dir: filepath.Clean(strings.TrimSpace(string(d)))}
+}
+
+// InitGitRepo creates a git work tree in the directory. Lock files
+// and journals are ignored.
+func InitGitRepo(dir string) (*GitRepo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.New("git is not installed")
+	}
+	if _, err := runGit(dir, "init", "-q"); err != nil {
+		return nil, err
+	}
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		if err := writeFile(ignore, []byte("**/"+LockFile+"\n**/"+HistoryDir+"/\n"), 0600); err != nil {
+			return nil, err
+		}
+	}
+	return OpenGitRepo(dir), nil
+}
+
+func (g *GitRepo) run(args ...string) ([]byte, error) {
+	return runGit(g.Dir, args...)
+}
+
+// AutoCommit returns true if changes made by nsc are committed
+func (g *GitRepo) AutoCommit() bool {
+	d, err := g.run("config", "--bool", GitAutoCommitKey)
+	return err == nil && strings.TrimSpace(string(d)) == "true"
+}
+
+// SetAutoCommit enables or disables committing the changes made by nsc
+func (g *GitRepo) SetAutoCommit(on bool) error {
+	if on {
+		_, err := g.run("config", GitAutoCommitKey, "true")
+		return err
+	}
+	if !g.AutoCommit() {
+		return nil
+	}
+	_, err := g.run("config", "--unset", GitAutoCommitKey)
+	return err
+}
+
+// Rel returns the path relative to the top level directory, with forward slashes
+func (g *GitRepo) Rel(fp string) (string, error) {
+	// the top level directory is reported with symlinks resolved
+	if p, err := filepath.EvalSymlinks(fp); err == nil {
+		fp = p
+	}
+	rel, err := filepath.Rel(g.Dir, fp)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Changes returns the uncommitted changes in the directory
+func (g *GitRepo) Changes(dir string) ([]GitChange, error) {
+	rel, err := g.Rel(dir)
+	if err != nil {
+		return nil, err
+	}
+	d, err := g.run(append([]string{"status", "--porcelain", "-z", "--untracked-files=all", "--", rel}, ignored(rel)...)...)
+	if err != nil {
+		return nil, err
+	}
+	var changes []GitChange
+	entries := strings.Split(string(d), "\x00")
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+		if len(e) < 4 {
+			continue
+		}
+		status := e[:2]
+		changes = append(changes, GitChange{Path: e[3:], Deleted: strings.Contains(status, "D")})
+		if status[0] == 'R' || status[0] == 'C' {
+			// the original path of a rename follows
+			i++
+		}
+	}
+	return changes, nil
+}
+
+// IndexLocked returns true if another git command holds the index, such
+// as the merge that runs a merge driver
+func (g *GitRepo) IndexLocked() bool {
+	d, err := g.run("rev-parse", "--git-path", "index.lock")
+	if err != nil {
+		return false
+	}
+	fp := filepath.FromSlash(strings.TrimSpace(string(d)))
+	if !filepath.IsAbs(fp) {
+		fp = filepath.Join(g.Dir, fp)
+	}
+	_, err = os.Stat(fp)
+	return err == nil
+}
+
+// HasHead returns true if the work tree has a commit
+func (g *GitRepo) HasHead() bool {
+	_, err := g.run("rev-parse", "--verify", "-q", "HEAD")
+	return err == nil
+}
+
+// Merging returns true if a merge, rebase or cherry-pick is in progress
+func (g *GitRepo) Merging() bool {
+	for _, ref := range []string{"MERGE_HEAD", "REBASE_HEAD", "CHERRY_PICK_HEAD"} {
+		if _, err := g.run("rev-parse", "--verify", "-q", ref); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Show returns the content of the file at the revision
+func (g *GitRepo) Show(rev string, rel string) ([]byte, error) {
+	return g.run("show", rev+":"+rel)
+}
+
+// ResolveRev returns the full hash of the revision
+func (g *GitRepo) ResolveRev(rev string) (string, error) {
+	d, err := g.run("rev-parse", "--verify", "-q", rev+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("%q is not a revision of %#q", rev, g.Dir)
+	}
+	return strings.TrimSpace(string(d)), nil
+}
+
+// Commit commits the changes, other changes in the work tree are left
+// uncommitted. The author defaults to the OS user when git has no identity
+// configured.
+func (g *GitRepo) Commit(changes []GitChange, message string) error {
+	var paths []string
+	for _, c := range changes {
+		paths = append(paths, ":(literal)"+c.Path)
+	}
+	if _, err := g.run(append([]string{"add", "-A", "--"}, paths...)...); err != nil {
+		return err
+	}
+	var args []string
+	if d, err := g.run("config", "user.email"); err != nil || len(bytes.TrimSpace(d)) == 0 {
+		u := journalUser()
+		args = append(args, "-c", "user.name="+u, "-c", "user.email="+u+"@localhost")
+	}
+	args = append(args, "commit", "-q", "-m", message, "--")
+	_, err := g.run(append(args, paths...)...)
+	return err
+}
+
+// Log returns the commits that changed the directory, newest first
+func (g *GitRepo) Log(dir string, max int) ([]GitCommit, error) {
+	if !g.HasHead() {
+		return nil, nil
+	}
+	rel, err := g.Rel(dir)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"log", "--format=%H%x1f%an%x1f%at%x1f%s%x1e"}
+	if max > 0 {
+		args = append(args, "-n", strconv.Itoa(max))
+	}
+	d, err := g.run(append(args, "--", rel)...)
+	if err != nil {
+		return nil, err
+	}
+	var commits []GitCommit
+	for _, rec := range strings.Split(string(d), "\x1e") {
+		f := strings.Split(strings.TrimSpace(rec), "\x1f")
+		if len(f) != 4 {
+			continue
+		}
+		t, _ := strconv.ParseInt(f[2], 10, 64)
+		commits = append(commits, GitCommit{Hash: f[0], Author: f[1], Time: time.Unix(t, 0).UTC(), Subject: f[3]})
+	}
+	return commits, nil
+}
+
+// ignored returns pathspecs excluding the journal and lock files of the directory
+func ignored(rel string) []string {
+	prefix := rel + "/"
+	if rel == "." {
+		prefix = ""
+	}
+	return []string{":(exclude,glob)" + prefix + "**/" + HistoryDir + "/**", ":(exclude,glob)" + prefix + "**/" + LockFile}
+}
+
+// isGitIgnored returns true for journal and lock files
+func isGitIgnored(rel string) bool {
+	for _, p := range strings.Split(rel, "/") {
+		if p == HistoryDir || p == LockFile {
+			return true
+		}
+	}
+	return false
+}
+
+// readJwts returns the jwts in the operator directory by their path
+// relative to it, the journal is skipped
+func readJwts(dir string) (map[string][]byte, error) {
+	jwts := make(map[string][]byte)
+	err := filepath.Walk(dir, func(fp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == HistoryDir {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(dir, fp)
+		if err != nil || info.IsDir() || !IsJwtName(rel) {
+			return err
+		}
+		d, err := ioutil.ReadFile(fp)
+		if err != nil {
+			return err
+		}
+		jwts[rel] = d
+		return nil
+	})
+	return jwts, err
+}
+
+// Checkout restores the directory to its state at the revision. Files
+// that didn't exist at the revision, tracked or not, are removed. The journal is kept
+// and records the jwts restored, the change is left uncommitted.
+func (g *GitRepo) Checkout(rev string, dir string) error {
+	rel, err := g.Rel(dir)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d, err := g.run("ls-tree", "-r", "--name-only", rev, "--", rel)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(d)) == 0 {
+		return fmt.Errorf("%#q doesn't exist at %s", rel, rev)
+	}
+	before, err := readJwts(dir)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	for _, n := range strings.Split(strings.TrimSpace(string(d)), "\n") {
+		keep[n] = true
+	}
+	d, err = g.run("ls-files", "--cached", "--others", "--exclude-standard", "--", rel)
+	if err != nil {
+		return err
+	}
+	for _, n := range strings.Split(strings.TrimSpace(string(d)), "\n") {
+		if n != "" && !keep[n] && !isGitIgnored(n) {
+			if err := removeFile(filepath.Join(g.Dir, filepath.FromSlash(n))); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	recordWrite(dir)
+	if _, err = g.run(append([]string{"checkout", rev, "--", rel}, ignored(rel)...)...); err != nil {
+		return err
+	}
+	after, err := readJwts(dir)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for n := range before {
+		paths = append(paths, n)
+	}
+	for n := range after {
+		if _, ok := before[n]; !ok {
+			paths = append(paths, n)
+		}
+	}
+	sort.Strings(paths)
+	j := &Journal{Dir: filepath.Join(dir, HistoryDir)}
+	for _, n := range paths {
+		if err := j.recordJwt(n, before[n], after[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+// HistoryDir is the directory in the operator directory holding the journal
+const HistoryDir = ".history"
+const journalFile = "journal.jsonl"
+const journalJwtDir = "jwts"
+
+const (
+	JournalStore     = "store"
+	JournalDelete    = "delete"
+	JournalStoreKey  = "store key"
+	JournalRemoveKey = "remove key"
+	JournalChmodKey  = "chmod key"
+)
+
+const (
+	KindOperator = "operator"
+	KindAccount  = "account"
+	KindUser     = "user"
+	KindKey      = "key"
+)
+
+// JournalCommand is the command line recorded with the journal entries
+var JournalCommand string
+
+// journalReverts is the id of the entry being reverted
+var journalReverts string
+
+// JournalEntry records a change to the store or the keystore
+type JournalEntry struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Command string    `json:"command,omitempty"`
+	Op      string    `json:"op"`
+	Kind    string    `json:"kind"`
+	Account string    `json:"account,omitempty"`
+	Name    string    `json:"name"`
+	// Path of the jwt relative to the operator directory
+	Path         string `json:"path,omitempty"`
+	PreviousHash string `json:"previous_hash,omitempty"`
+	NewHash      string `json:"new_hash,omitempty"`
+	// Reverts is the id of the entry whose change this entry reverted
+	Reverts string `json:"reverts,omitempty"`
+}
+
+// IsJwtChange returns true if the entry records a change to a jwt
+func (e *JournalEntry) IsJwtChange() bool {
+	return e.Path != ""
+}
+
+// Journal is the append-only log of the changes made to an operator
+type Journal struct {
+	Dir string
+}
+
+// Journal returns the journal of the store
+func (s *Store) Journal() *Journal {
+	return &Journal{Dir: s.resolve(HistoryDir)}
+}
+
+// HashJwt returns the hash used to reference a jwt in the journal
+func HashJwt(data []byte) string {
+	h := sha256.Sum256(bytes.TrimSpace(data))
+	return hex.EncodeToString(h[:])
+}
+
+func journalUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	return os.Getenv("USERNAME")
+}
+
+func newJournalEntry(op string, kind string) *JournalEntry {
+	return &JournalEntry{
+		ID:      nuid.Next(),
+		Reverts: journalReverts,
+		Time:    time.Now().UTC(),
+		User:    journalUser(),
+		Command: JournalCommand,
+		Op:      op,
+		Kind:    kind,
+	}
+}
+
+// JwtEntity returns the kind, account and name of a jwt from its path
+// relative to the operator directory
+func JwtEntity(rel string) (kind string, account string, name string, ok bool) {
+	if !IsJwtName(rel) {
+		return "", "", "", false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	switch {
+	case len(parts) == 1:
+		return KindOperator, "", PlainName(parts[0]), true
+	case len(parts) == 3 && parts[0] == Accounts:
+		return KindAccount, parts[1], parts[1], true
+	case len(parts) == 4 && parts[0] == Accounts && parts[2] == Users:
+		return KindUser, parts[1], PlainName(parts[3]), true
+	}
+	return "", "", "", false
+}
+
+// recordJwt journals a change to a jwt in the store, previous and
+// next are empty when the jwt is created or deleted respectively
+func (j *Journal) recordJwt(rel string, previous []byte, next []byte) error {
+	kind, account, name, ok := JwtEntity(rel)
+	if !ok || IsDryRun() || bytes.Equal(previous, next) {
+		return nil
+	}
+	op := JournalStore
+	if next == nil {
+		op = JournalDelete
+	}
+	e := newJournalEntry(op, kind)
+	e.Account = account
+	e.Name = name
+	e.Path = filepath.ToSlash(rel)
+	if previous != nil {
+		e.PreviousHash = HashJwt(previous)
+		if err := j.keepJwt(e.PreviousHash, previous); err != nil {
+			return err
+		}
+	}
+	if next != nil {
+		e.NewHash = HashJwt(next)
+		if err := j.keepJwt(e.NewHash, next); err != nil {
+			return err
+		}
+	}
+	return j.append(e)
+}
+
+// recordKey journals a change to the keystore
+func (j *Journal) recordKey(op string, pk string) error {
+	if j == nil || IsDryRun() {
+		return nil
+	}
+	e := newJournalEntry(op, KindKey)
+	e.Name = pk
+	return j.append(e)
+}
+
+func (j *Journal) keepJwt(hash string, data []byte) error {
+	fp := filepath.Join(j.Dir, journalJwtDir, hash+".jwt")
+	if _, err := statFile(fp); err == nil {
+		return nil
+	}
+	if err := writeFile(fp, data, 0600); err != nil {
+		return fmt.Errorf("error writing journal %#q: %v", fp, err)
+	}
+	return nil
+}
+
+func (j *Journal) append(e *JournalEntry) error {
+	d, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	fp := filepath.Join(j.Dir, journalFile)
+	if err := appendFile(fp, append(d, '\n')); err != nil {
+		return fmt.Errorf("error writing journal %#q: %v", fp, err)
+	}
+	return nil
+}
+
+// ReadJwt returns a jwt kept by the journal
+func (j *Journal) ReadJwt(hash string) ([]byte, error) {
+	fp := filepath.Join(j.Dir, journalJwtDir, hash+".jwt")
+	d, err := readFile(fp)
+	if err != nil {
+		return nil, fmt.Errorf("error reading journal %#q: %v", fp, err)
+	}
+	return d, nil
+}
+
+// Entries returns the entries of the journal, oldest first
+func (j *Journal) Entries() ([]*JournalEntry, error) {
+	fp := filepath.Join(j.Dir, journalFile)
+	d, err := readFile(fp)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*JournalEntry
+	scanner := bufio.NewScanner(bytes.NewReader(d))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("error parsing journal %#q line %d: %v", fp, n, err)
+		}
+		entries = append(entries, &e)
+	}
+	return entries, scanner.Err()
+}
+
+// Revert runs fn, which reverts the change recorded by the entry,
+// and marks the entries it records as reverting the entry
+func (j *Journal) Revert(e *JournalEntry, fn func() error) error {
+	journalReverts = e.ID
+	defer func() {
+		journalReverts = ""
+	}()
+	return fn()
+}
+
+// Revertible returns the jwt changes that were not reverted and don't
+// revert other changes, oldest first
+func (j *Journal) Revertible() ([]*JournalEntry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	reverted := make(map[string]bool)
+	for _, e := range entries {
+		if e.Reverts != "" {
+			reverted[e.Reverts] = true
+		}
+	}
+	var changes []*JournalEntry
+	for _, e := range entries {
+		if e.IsJwtChange() && e.Reverts == "" && !reverted[e.ID] {
+			changes = append(changes, e)
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"os"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalRecordsChanges(t *testing.T) {
+	_, _, okp := CreateOperatorKey(t)
+	s := CreateTestStoreForOperator(t, "O", okp)
+	defer os.RemoveAll(s.Dir)
+
+	_, apk, _ := CreateAccountKey(t)
+	ac := jwt.NewAccountClaims(apk)
+	ac.Name = "A"
+	first, err := ac.Encode(okp)
+	require.NoError(t, err)
+	require.NoError(t, s.StoreRaw([]byte(first)))
+
+	ac.Limits.Conn = 10
+	second, err := ac.Encode(okp)
+	require.NoError(t, err)
+	require.NoError(t, s.StoreRaw([]byte(second)))
+	// storing the same jwt is not a change
+	require.NoError(t, s.StoreRaw([]byte(second)))
+	require.NoError(t, s.Delete(Accounts, "A", JwtName("A")))
+
+	j := s.Journal()
+	entries, err := j.Entries()
+	require.NoError(t, err)
+	// the first entry is the operator created with the store
+	require.Len(t, entries, 4)
+	require.Equal(t, KindOperator, entries[0].Kind)
+
+	require.Equal(t, JournalStore, entries[1].Op)
+	require.Equal(t, KindAccount, entries[1].Kind)
+	require.Equal(t, "A", entries[1].Name)
+	require.Empty(t, entries[1].PreviousHash)
+	require.Equal(t, HashJwt([]byte(first)), entries[1].NewHash)
+
+	require.Equal(t, HashJwt([]byte(first)), entries[2].PreviousHash)
+	require.Equal(t, HashJwt([]byte(second)), entries[2].NewHash)
+
+	require.Equal(t, JournalDelete, entries[3].Op)
+	require.Equal(t, HashJwt([]byte(second)), entries[3].PreviousHash)
+	require.Empty(t, entries[3].NewHash)
+
+	d, err := j.ReadJwt(entries[2].PreviousHash)
+	require.NoError(t, err)
+	require.Equal(t, first, string(d))
+}
+
+func TestJournalSkipsDryRun(t *testing.T) {
+	_, _, okp := CreateOperatorKey(t)
+	s := CreateTestStoreForOperator(t, "O", okp)
+	defer os.RemoveAll(s.Dir)
+
+	_, apk, _ := CreateAccountKey(t)
+	ac := jwt.NewAccountClaims(apk)
+	ac.Name = "A"
+	token, err := ac.Encode(okp)
+	require.NoError(t, err)
+
+	StartDryRun()
+	require.NoError(t, s.StoreRaw([]byte(token)))
+	StopDryRun()
+
+	entries, err := s.Journal().Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestJournalRecordsKeys(t *testing.T) {
+	dir := MakeTempDir(t)
+	defer os.RemoveAll(dir)
+	old := os.Getenv(NKeysPathEnv)
+	require.NoError(t, os.Setenv(NKeysPathEnv, dir))
+	defer os.Setenv(NKeysPathEnv, old)
+
+	j := &Journal{Dir: dir}
+	ks := KeyStore{Env: "O", Journal: j}
+	_, pk, kp := CreateAccountKey(t)
+	_, err := ks.Store(kp)
+	require.NoError(t, err)
+	// storing an existing key is not a change
+	_, err = ks.Store(kp)
+	require.NoError(t, err)
+	require.NoError(t, ks.Remove(pk))
+
+	entries, err := j.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, JournalStoreKey, entries[0].Op)
+	require.Equal(t, KindKey, entries[0].Kind)
+	require.Equal(t, pk, entries[0].Name)
+	require.Equal(t, JournalRemoveKey, entries[1].Op)
+}
+
+func TestJournalRevertible(t *testing.T) {
+	_, _, okp := CreateOperatorKey(t)
+	s := CreateTestStoreForOperator(t, "O", okp)
+	defer os.RemoveAll(s.Dir)
+
+	_, apk, _ := CreateAccountKey(t)
+	ac := jwt.NewAccountClaims(apk)
+	ac.Name = "A"
+	token, err := ac.Encode(okp)
+	require.NoError(t, err)
+	require.NoError(t, s.StoreRaw([]byte(token)))
+
+	j := s.Journal()
+	changes, err := j.Revertible()
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	created := changes[1]
+
+	require.NoError(t, j.Revert(created, func() error {
+		return s.Delete(Accounts, "A", JwtName("A"))
+	}))
+	entries, err := j.Entries()
+	require.NoError(t, err)
+	require.Equal(t, created.ID, entries[len(entries)-1].Reverts)
+
+	// neither the reverted change nor its revert can be reverted
+	changes, err = j.Revertible()
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, KindOperator, changes[0].Kind)
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// MetadataExtension is the extension of the sidecar file stored next to a
+// key holding its metadata
+const MetadataExtension = ".meta.json"
+
+// KeyMetadata describes a key of the keystore
+type KeyMetadata struct {
+	Label   string `json:"label,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+	// Command is the nsc command line that created the key
+	Command string `json:"command,omitempty"`
+	Created int64  `json:"created,omitempty"`
+	User    string `json:"user,omitempty"`
+}
+
+// Description returns the label of the key, or its purpose if it has no label
+func (m *KeyMetadata) Description() string {
+	if m == nil {
+		return ""
+	}
+	if m.Label != "" {
+		return m.Label
+	}
+	return m.Purpose
+}
+
+func GetKeyMetadataPath(pubkey string) string {
+	kp := GetKeyPath(pubkey)
+	if kp == "" {
+		return ""
+	}
+	return strings.TrimSuffix(kp, NKeyExtension) + MetadataExtension
+}
+
+// IsKeyMetadata returns true if the path is a key metadata sidecar
+func IsKeyMetadata(fp string) bool {
+	return strings.HasSuffix(fp, MetadataExtension)
+}
+
+// GetKeyMetadata returns the metadata of a key, or nil if it has none
+func (k *KeyStore) GetKeyMetadata(pubkey string) (*KeyMetadata, error) {
+	return ReadKeyMetadata(pubkey)
+}
+
+// ReadKeyMetadata returns the metadata of a key, or nil if it has none
+func ReadKeyMetadata(pubkey string) (*KeyMetadata, error) {
+	fp := GetKeyMetadataPath(pubkey)
+	if fp == "" {
+		return nil, nil
+	}
+	d, err := readFile(fp)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m KeyMetadata
+	if err := json.Unmarshal(d, &m); err != nil {
+		return nil, fmt.Errorf("error parsing %#q: %v", fp, err)
+	}
+	return &m, nil
+}
+
+// StoreKeyMetadata writes the metadata of a key stored in the keystore
+func (k *KeyStore) StoreKeyMetadata(pubkey string, m *KeyMetadata) error {
+	unlock, err := lockDir(GetKeysDir())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := statFile(GetKeyPath(pubkey)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s is not in the keystore", pubkey)
+		}
+		return err
+	}
+	d, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(GetKeyMetadataPath(pubkey), d, 0600)
+}
+
+// newKeyMetadata returns the metadata recorded for a key created now
+func newKeyMetadata() *KeyMetadata {
+	return &KeyMetadata{
+		Command: JournalCommand,
+		Created: time.Now().Unix(),
+		User:    journalUser(),
+	}
+}
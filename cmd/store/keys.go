@@ -79,6 +79,8 @@ func ResolveKey(value string) (nkeys.KeyPair, error) {
 
 type KeyStore struct {
 	Env string
+	// Journal records the keys stored and removed, if set
+	Journal *Journal
 }
 
 func NewKeyStore(environmentName string) KeyStore {
@@ -109,8 +111,8 @@ func IsOldKeyRing(dir string) (bool, error) {
 		if err != nil {
 			return err
 		}
-		// this is the keys/creds dir - ignore them
-		if (rel == KeysDir || rel == CredsDir) && info.IsDir() {
+		// this is the keys/creds/quarantine dir - ignore them
+		if (rel == KeysDir || rel == CredsDir || rel == QuarantineDir) && info.IsDir() {
 			// walking new dirs
 			return filepath.SkipDir
 		}
@@ -146,6 +148,9 @@ func KeysNeedMigration() (bool, error) {
 }
 
 func Migrate() (string, error) {
+	if IsDryRun() {
+		return "", errors.New("the keystore cannot be migrated during a dry-run")
+	}
 	dir := GetKeysDir()
 	// make a new directory next to it
 	name := nuid.Next()
@@ -173,7 +178,10 @@ func Migrate() (string, error) {
 
 func (k *KeyStore) AllKeys() ([]string, error) {
 	var keys []string
-	err := filepath.Walk(GetKeysDir(), func(src string, info os.FileInfo, err error) error {
+	err := walkFiles(GetKeysDir(), func(src string, info os.FileInfo) error {
+		if IsQuarantined(src) {
+			return nil
+		}
 		ext := filepath.Ext(src)
 		switch ext {
 		case NKeyExtension:
@@ -199,7 +207,7 @@ func (k *KeyStore) CalcUserCredsPath(account string, user string) string {
 
 func (k *KeyStore) GetUserCredsPath(account string, user string) string {
 	fp := k.CalcUserCredsPath(account, user)
-	if _, err := os.Stat(fp); err != nil {
+	if _, err := statFile(fp); err != nil {
 		return ""
 	}
 	return fp
@@ -224,13 +232,18 @@ func (k *KeyStore) MaybeStoreUserCreds(account string, user string, data []byte)
 		return "", fmt.Errorf("unable to store creds file - error examining user's seed file: %v", err)
 	}
 
+	unlock, err := lockDir(GetKeysDir())
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
 	fp := k.CalcUserCredsPath(account, user)
 	dir := filepath.Dir(fp)
 	if err := MaybeMakeDir(dir); err != nil {
 		return "", err
 	}
 
-	return fp, ioutil.WriteFile(fp, data, 0600)
+	return fp, writeFile(fp, data, 0600)
 }
 
 func keypath(kp nkeys.KeyPair) (string, error) {
@@ -267,25 +280,58 @@ func GetKeyPath(pubkey string) string {
 	return filepath.Join(GetKeysDir(), KeysDir, kind, shard, fmt.Sprintf("%s%s", pubkey, NKeyExtension))
 }
 
+// GetKeyPair returns the key pair for the public key. A key pair signing
+// with a running agent is returned if the agent holds the key, so that an
+// encrypted keystore is not unlocked. If the keystore doesn't have the seed,
+// a key pair signing with the external signer is returned if the signer
+// holds the key.
 func (k *KeyStore) GetKeyPair(pubkey string) (nkeys.KeyPair, error) {
-	return k.Read(GetKeyPath(pubkey))
+	if strings.TrimSpace(os.Getenv(AgentSockEnv)) != "" {
+		if kp, err := ExternalKeyPair(pubkey); err == nil && kp != nil {
+			return kp, nil
+		}
+	}
+	kp, err := k.Read(GetKeyPath(pubkey))
+	if kp == nil && err == nil {
+		return ExternalKeyPair(pubkey)
+	}
+	return kp, err
 }
 
 func (k *KeyStore) GetPublicKey(pubkey string) (string, error) {
 	return k.getPublicKey(k.GetKeyPair(pubkey))
 }
 
+// HasPrivateKey returns true if the keystore has the seed of the public key
 func (k *KeyStore) HasPrivateKey(pubkey string) bool {
+	kp, err := k.Read(GetKeyPath(pubkey))
+	if kp == nil || err != nil {
+		return false
+	}
+	_, err = kp.Seed()
+	return err == nil
+}
+
+// CanSign returns true if the keystore or the external signer can sign
+// with the public key
+func (k *KeyStore) CanSign(pubkey string) bool {
 	kp, err := k.GetKeyPair(pubkey)
 	if kp == nil || err != nil {
 		return false
 	}
+	if IsExternalKeyPair(kp) {
+		return true
+	}
 	_, err = kp.Seed()
 	return err == nil
 }
 
 func (k *KeyStore) GetSeed(pubkey string) (string, error) {
-	return k.getSeed(k.GetKeyPair(pubkey))
+	kp, err := k.Read(GetKeyPath(pubkey))
+	if kp == nil && err == nil {
+		return "", fmt.Errorf("the seed for %s is not in the keystore", pubkey)
+	}
+	return k.getSeed(kp, err)
 }
 
 func (k *KeyStore) getPublicKey(kp nkeys.KeyPair, err error) (string, error) {
@@ -304,39 +350,104 @@ func (k *KeyStore) getSeed(kp nkeys.KeyPair, err error) (string, error) {
 }
 
 func (k *KeyStore) Remove(pubkey string) error {
+	unlock, err := lockDir(GetKeysDir())
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	kp := GetKeyPath(pubkey)
-	_, err := os.Stat(kp)
+	_, err = statFile(kp)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
 		}
 		return err
 	}
-	if err := os.Remove(kp); err != nil {
+	if err := removeFile(kp); err != nil {
+		return err
+	}
+	if mp := GetKeyMetadataPath(pubkey); mp != "" {
+		if _, err := statFile(mp); err == nil {
+			if err := removeFile(mp); err != nil {
+				return err
+			}
+		}
+	}
+	if err := k.Journal.recordKey(JournalRemoveKey, pubkey); err != nil {
 		return err
 	}
 	pd := filepath.Dir(kp)
-	infos, err := ioutil.ReadDir(pd)
+	infos, err := readDir(pd)
 	// nothing to do from here, but attempt to cleanup
 	// empty directories - go won't delete empty dirs
 	// but we check anyway
 	if err == nil && len(infos) == 0 {
-		os.Remove(pd)
+		removeFile(pd)
 	}
 	return nil
 }
 
+// Chmod changes the mode of a file in the keystore, the change of the
+// mode of a key is journaled
+func (k *KeyStore) Chmod(fp string, mode os.FileMode) error {
+	if err := chmodFile(fp, mode); err != nil {
+		return err
+	}
+	if filepath.Ext(fp) != NKeyExtension {
+		return nil
+	}
+	pk := strings.TrimSuffix(filepath.Base(fp), NKeyExtension)
+	if !nkeys.IsValidPublicKey(pk) {
+		return nil
+	}
+	return k.Journal.recordKey(JournalChmodKey, pk)
+}
+
+// Relocate stores the key read from a seed file that is not at the path
+// of its public key, moving the metadata sidecar of the file with it, and
+// removes the file. The metadata of a key already at its path is kept.
+func (k *KeyStore) Relocate(fp string, kp nkeys.KeyPair) error {
+	unlock, err := lockDir(GetKeysDir())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	pk, err := kp.PublicKey()
+	if err != nil {
+		return err
+	}
+	m, err := ReadKeyMetadata(pk)
+	if err != nil {
+		return err
+	}
+	if _, err := k.Store(kp); err != nil {
+		return err
+	}
+	mp := strings.TrimSuffix(fp, NKeyExtension) + MetadataExtension
+	if d, err := readFile(mp); err == nil {
+		if m == nil {
+			if err := writeFile(GetKeyMetadataPath(pk), d, 0600); err != nil {
+				return err
+			}
+		}
+		if err := removeFile(mp); err != nil {
+			return err
+		}
+	}
+	return removeFile(fp)
+}
+
 func AddGitIgnore(dir string) error {
 	if NscNotGitIgnore {
 		return nil
 	}
 	if dir != "" {
-		_, err := os.Stat(dir)
+		_, err := statFile(dir)
 		if err != nil {
 			return nil
 		}
 		ignoreFile := filepath.Join(dir, ".gitignore")
-		_, err = os.Stat(ignoreFile)
+		_, err = statFile(ignoreFile)
 		if os.IsNotExist(err) {
 			d := `# ignore all nk files 
 **/*.nk
@@ -344,20 +455,48 @@ func AddGitIgnore(dir string) error {
 # ignore all creds files
 **/*.creds
 `
-			return ioutil.WriteFile(ignoreFile, []byte(d), 0600)
+			return writeFile(ignoreFile, []byte(d), 0600)
 		}
 	}
 	return nil
 }
 
 func (k *KeyStore) Store(kp nkeys.KeyPair) (string, error) {
-	return StoreKey(kp)
+	unlock, err := lockDir(GetKeysDir())
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	fp := GetKeysDir()
+	if pk, err := kp.PublicKey(); err == nil {
+		fp = GetKeyPath(pk)
+	}
+	_, err = statFile(fp)
+	isNew := os.IsNotExist(err)
+	if fp, err = StoreKey(kp); err != nil {
+		return fp, err
+	}
+	if isNew {
+		pk, _ := kp.PublicKey()
+		if err := k.StoreKeyMetadata(pk, newKeyMetadata()); err != nil {
+			return fp, err
+		}
+		if err := k.Journal.recordKey(JournalStoreKey, pk); err != nil {
+			return fp, err
+		}
+	}
+	return fp, nil
 }
 
 func StoreKey(kp nkeys.KeyPair) (string, error) {
 	if err := makeKeyStore(GetKeysDir()); err != nil {
 		return "", err
 	}
+	unlock, err := lockDir(GetKeysDir())
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
 	fp, err := keypath(kp)
 	if err != nil {
 		return "", err
@@ -372,21 +511,27 @@ func StoreKey(kp nkeys.KeyPair) (string, error) {
 		return "", err
 	}
 
-	_, err = os.Stat(fp)
+	_, err = statFile(fp)
 	if err != nil {
 		if os.IsNotExist(err) {
-			err := ioutil.WriteFile(fp, seed, 0600)
+			data, err := sealSeed(GetKeysDir(), seed)
 			if err != nil {
+				return "", err
+			}
+			if err := writeFile(fp, data, 0600); err != nil {
 				return "", fmt.Errorf("error writing %#q: %v", fp, err)
 			}
 			return fp, nil
 		}
 	}
 
-	d, err := ioutil.ReadFile(fp)
+	d, err := readFile(fp)
 	if err != nil {
 		return "", fmt.Errorf("error reading %#q: %v", fp, err)
 	}
+	if d, err = UnsealSeed(fp, d); err != nil {
+		return "", err
+	}
 	if string(d) != string(seed) {
 		return "", fmt.Errorf("key %#q already exists and is different", fp)
 	}
@@ -419,14 +564,14 @@ func dataFromFile(path string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	if _, err := os.Stat(path); err != nil {
+	if _, err := statFile(path); err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
 		} else {
 			return nil, err
 		}
 	}
-	return ioutil.ReadFile(path)
+	return readFile(path)
 }
 
 func keyFromFile(path string) (nkeys.KeyPair, error) {
@@ -441,6 +586,9 @@ func keyFromFile(path string) (nkeys.KeyPair, error) {
 	if err != nil {
 		return nil, err
 	}
+	if d, err = UnsealSeed(path, d); err != nil {
+		return nil, err
+	}
 	kp, err := resolveAsKey(d)
 	if err != nil {
 		return nil, err
@@ -544,6 +692,12 @@ func migrateKeyStore(ksroot string, to string) (keys []string, creds []string, e
 	if err := MaybeMakeDir(filepath.Join(to, "creds")); err != nil {
 		return nil, nil, err
 	}
+	// sealed seeds are copied as they are, with the keystore encryption
+	if d, err := readFile(filepath.Join(ksroot, KeystoreEncryptionFile)); err == nil {
+		if err := Write(filepath.Join(to, KeystoreEncryptionFile), d); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	err = filepath.Walk(ksroot, func(src string, info os.FileInfo, err error) error {
 		ext := filepath.Ext(src)
@@ -578,7 +732,11 @@ func migrateKey(src string, to string) (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("error processing %#q: %v", src, err)
 		}
-		kp, err := nkeys.FromSeed(d)
+		seed, err := UnsealSeed(src, d)
+		if err != nil {
+			return "", fmt.Errorf("error processing %#q: %v", src, err)
+		}
+		kp, err := nkeys.FromSeed(seed)
 		if err != nil {
 			return "", fmt.Errorf("error processing %#q: %v", src, err)
 		}
@@ -643,9 +801,9 @@ func migrateCreds(ksroot string, src string, to string) (string, error) {
 }
 
 func MaybeMakeDir(dir string) error {
-	fi, err := os.Stat(dir)
+	fi, err := statFile(dir)
 	if err != nil && os.IsNotExist(err) {
-		if err := os.MkdirAll(dir, 0700); err != nil {
+		if err := mkdirAll(dir); err != nil {
 			return fmt.Errorf("error creating %#q: %v", dir, err)
 		}
 	} else if err != nil {
@@ -660,7 +818,7 @@ func Write(name string, data []byte) error {
 	if err := MaybeMakeDir(filepath.Dir(name)); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(name, data, 0600)
+	return writeFile(name, data, 0600)
 }
 
 func ExtractSeed(s string) (nkeys.KeyPair, error) {
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Writes to the store and the keystore hold an advisory lock on a file in
+// the operator directory or the keystore directory, so that concurrent nsc
+// processes don't interleave their changes. The lock is released by the
+// operating system if the process holding it exits.
+
+// LockFile is the name of the lock file in the operator and keystore directories
+const LockFile = ".lock"
+
+// LockTimeout is how long a write waits for a lock held by another process
+var LockTimeout = 30 * time.Second
+
+const lockRetryInterval = 50 * time.Millisecond
+
+// errLocked is returned by tryLockFile when another process holds the lock
+var errLocked = errors.New("locked")
+
+type heldLock struct {
+	f     *os.File
+	count int
+}
+
+var heldLocks = struct {
+	sync.Mutex
+	m map[string]*heldLock
+}{m: make(map[string]*heldLock)}
+
+// lockHolder is written into the lock file by the process holding the lock
+type lockHolder struct {
+	Pid     int       `json:"pid"`
+	Host    string    `json:"host"`
+	User    string    `json:"user"`
+	Command string    `json:"command,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+func (h *lockHolder) String() string {
+	s := fmt.Sprintf("pid %d (%s@%s)", h.Pid, h.User, h.Host)
+	if h.Command != "" {
+		s = fmt.Sprintf("%s running %q", s, h.Command)
+	}
+	return fmt.Sprintf("%s since %s", s, h.Since.Local().Format(time.RFC3339))
+}
+
+func currentLockHolder() *lockHolder {
+	host, _ := os.Hostname()
+	return &lockHolder{
+		Pid:     os.Getpid(),
+		Host:    host,
+		User:    journalUser(),
+		Command: JournalCommand,
+		Since:   time.Now().UTC(),
+	}
+}
+
+func readLockHolder(f *os.File) *lockHolder {
+	d := make([]byte, 4096)
+	n, _ := f.ReadAt(d, 0)
+	var h lockHolder
+	if n == 0 || json.Unmarshal(d[:n], &h) != nil {
+		return nil
+	}
+	return &h
+}
+
+// lockDir takes the advisory lock of the directory, waiting up to
+// LockTimeout for another process to release it. Locks are reentrant
+// within a process. The returned function releases the lock.
+func lockDir(dir string) (func(), error) {
+	if IsDryRun() {
+		return func() {}, nil
+	}
+	fp := cleanPath(filepath.Join(dir, LockFile))
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+	if l, ok := heldLocks.m[fp]; ok {
+		l.count++
+		return func() { unlockDir(fp) }, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(fp, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock %#q: %v", fp, err)
+	}
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		err := tryLockFile(f)
+		if err == nil {
+			break
+		}
+		if err != errLocked {
+			f.Close()
+			return nil, fmt.Errorf("error locking %#q: %v", fp, err)
+		}
+		if time.Now().After(deadline) {
+			holder := "another process"
+			if h := readLockHolder(f); h != nil {
+				holder = h.String()
+			}
+			f.Close()
+			return nil, fmt.Errorf("timed out after %v waiting for the lock on %#q held by %s", LockTimeout, dir, holder)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+
+	// record the holder for processes waiting on the lock
+	if d, err := json.Marshal(currentLockHolder()); err == nil {
+		_ = f.Truncate(0)
+		_, _ = f.WriteAt(d, 0)
+	}
+	heldLocks.m[fp] = &heldLock{f: f, count: 1}
+	return func() { unlockDir(fp) }, nil
+}
+
+// LockStore takes the lock of the store, so that a change made from what was read
+// isn't interleaved with the changes of other processes. The returned function
+// releases the lock.
+func (s *Store) LockStore() (func(), error) {
+	return lockDir(s.Dir)
+}
+
+// LockKeyStore takes the lock of the keystore, the returned function releases it
+func LockKeyStore() (func(), error) {
+	return lockDir(GetKeysDir())
+}
+
+func unlockDir(fp string) {
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+	l, ok := heldLocks.m[fp]
+	if !ok {
+		return
+	}
+	l.count--
+	if l.count > 0 {
+		return
+	}
+	delete(heldLocks.m, fp)
+	_ = l.f.Truncate(0)
+	_ = unlockFile(l.f)
+	_ = l.f.Close()
+}
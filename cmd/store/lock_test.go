@@ -0,0 +1,111 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// holdLock takes the lock of the directory as another process would
+func holdLock(t *testing.T, dir string, h *lockHolder) *os.File {
+	f, err := os.OpenFile(filepath.Join(dir, LockFile), os.O_RDWR|os.O_CREATE, 0600)
+	require.NoError(t, err)
+	require.NoError(t, tryLockFile(f))
+	d, err := json.Marshal(h)
+	require.NoError(t, err)
+	_, err = f.WriteAt(d, 0)
+	require.NoError(t, err)
+	return f
+}
+
+func TestLockTimeoutNamesHolder(t *testing.T) {
+	_, _, okp := CreateOperatorKey(t)
+	s := CreateTestStoreForOperator(t, "O", okp)
+	defer os.RemoveAll(s.Dir)
+
+	old := LockTimeout
+	LockTimeout = 100 * time.Millisecond
+	defer func() { LockTimeout = old }()
+
+	f := holdLock(t, s.Dir, &lockHolder{Pid: 4242, Host: "ci", User: "bot", Command: "nsc edit account", Since: time.Now()})
+	_, apk, _ := CreateAccountKey(t)
+	ac := jwt.NewAccountClaims(apk)
+	ac.Name = "A"
+	token, err := ac.Encode(okp)
+	require.NoError(t, err)
+	err = s.StoreRaw([]byte(token))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out")
+	require.Contains(t, err.Error(), "pid 4242 (bot@ci)")
+	require.Contains(t, err.Error(), "nsc edit account")
+	require.False(t, s.HasAccount("A"))
+
+	require.NoError(t, unlockFile(f))
+	require.NoError(t, f.Close())
+	require.NoError(t, s.StoreRaw([]byte(token)))
+	require.True(t, s.HasAccount("A"))
+}
+
+func TestLockIsReentrant(t *testing.T) {
+	dir := MakeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	unlock, err := lockDir(dir)
+	require.NoError(t, err)
+	nested, err := lockDir(dir)
+	require.NoError(t, err)
+	nested()
+
+	// still held by this process
+	f, err := os.OpenFile(filepath.Join(dir, LockFile), os.O_RDWR, 0600)
+	require.NoError(t, err)
+	defer f.Close()
+	require.Equal(t, errLocked, tryLockFile(f))
+	h := readLockHolder(f)
+	require.NotNil(t, h)
+	require.Equal(t, os.Getpid(), h.Pid)
+
+	unlock()
+	require.NoError(t, tryLockFile(f))
+	require.NoError(t, unlockFile(f))
+}
+
+func TestWriteFileIsAtomic(t *testing.T) {
+	dir := MakeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	fp := filepath.Join(dir, "a.jwt")
+	require.NoError(t, writeFile(fp, []byte("one"), 0600))
+	require.NoError(t, writeFile(fp, []byte("two"), 0600))
+	d, err := ioutil.ReadFile(fp)
+	require.NoError(t, err)
+	require.Equal(t, "two", string(d))
+
+	// no temp files are left behind
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.False(t, strings.Contains(infos[0].Name(), ".tmp"))
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nkeys"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// DefaultDerivationPath is the derivation path used when none is specified
+const DefaultDerivationPath = "m/0"
+
+// names of the keys derived from a mnemonic
+const (
+	DerivedOperatorKey             = "operator"
+	DerivedOperatorSigningKey      = "operator-signing-key"
+	DerivedSystemAccountKey        = "system-account"
+	DerivedSystemAccountSigningKey = "system-account-signing-key"
+	DerivedSystemUserKey           = "system-user"
+)
+
+// NewMnemonic returns a new 24 word BIP39 mnemonic
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(256)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// NormalizeMnemonic collapses the whitespace and case of a mnemonic
+func NormalizeMnemonic(mnemonic string) string {
+	return strings.ToLower(strings.Join(strings.Fields(mnemonic), " "))
+}
+
+// ValidateDerivationPath checks that the path is m followed by one or more
+// numeric segments
+func ValidateDerivationPath(path string) error {
+	segments := strings.Split(path, "/")
+	ok := len(segments) > 1 && segments[0] == "m"
+	for _, s := range segments[1:] {
+		ok = ok && s != "" && strings.Trim(s, "0123456789") == ""
+	}
+	if !ok {
+		return fmt.Errorf("derivation path %q must look like m/0 or m/1/2", path)
+	}
+	return nil
+}
+
+// KeyDeriver derives nkeys deterministically from a mnemonic. The seed of a
+// key is the HMAC-SHA512 of the derivation path and the key's name keyed
+// with the BIP39 seed of the mnemonic, truncated to the 32 bytes of an
+// ed25519 seed. The same mnemonic, path and name always yield the same key.
+type KeyDeriver struct {
+	seed []byte
+	path string
+}
+
+func NewKeyDeriver(mnemonic string, path string) (*KeyDeriver, error) {
+	mnemonic = NormalizeMnemonic(mnemonic)
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, fmt.Errorf("mnemonic is not valid - check the words and their order")
+	}
+	if path == "" {
+		path = DefaultDerivationPath
+	}
+	if err := ValidateDerivationPath(path); err != nil {
+		return nil, err
+	}
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, err
+	}
+	return &KeyDeriver{seed: seed, path: path}, nil
+}
+
+// Path returns the derivation path of the deriver
+func (d *KeyDeriver) Path() string {
+	return d.path
+}
+
+// Derive returns the key with the specified name and prefix
+func (d *KeyDeriver) Derive(name string, prefix nkeys.PrefixByte) (nkeys.KeyPair, error) {
+	mac := hmac.New(sha512.New, d.seed)
+	mac.Write([]byte("nsc/" + d.path + "/" + name))
+	raw := mac.Sum(nil)
+	defer wipe(raw)
+	return nkeys.FromRawSeed(prefix, raw[:32])
+}
+
+// Wipe clears the seed of the deriver
+func (d *KeyDeriver) Wipe() {
+	wipe(d.seed)
+}
+
+func wipe(buf []byte) {
+	for i := range buf {
+		buf[i] = 'x'
+	}
+}
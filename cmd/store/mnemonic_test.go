@@ -0,0 +1,68 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/require"
+)
+
+func derivePublicKey(t *testing.T, mnemonic string, path string, name string) string {
+	d, err := NewKeyDeriver(mnemonic, path)
+	require.NoError(t, err)
+	defer d.Wipe()
+	kp, err := d.Derive(name, nkeys.PrefixByteOperator)
+	require.NoError(t, err)
+	pk, err := kp.PublicKey()
+	require.NoError(t, err)
+	return pk
+}
+
+func TestKeyDeriverIsDeterministic(t *testing.T) {
+	m, err := NewMnemonic()
+	require.NoError(t, err)
+	require.Len(t, strings.Fields(m), 24)
+
+	pk := derivePublicKey(t, m, "", DerivedOperatorKey)
+	require.True(t, nkeys.IsValidPublicOperatorKey(pk))
+	// whitespace and case of the phrase don't matter
+	require.Equal(t, pk, derivePublicKey(t, "  "+strings.ToUpper(m)+"\n", DefaultDerivationPath, DerivedOperatorKey))
+
+	require.NotEqual(t, pk, derivePublicKey(t, m, "m/1", DerivedOperatorKey))
+	require.NotEqual(t, pk, derivePublicKey(t, m, "", DerivedOperatorSigningKey))
+	m2, err := NewMnemonic()
+	require.NoError(t, err)
+	require.NotEqual(t, pk, derivePublicKey(t, m2, "", DerivedOperatorKey))
+}
+
+func TestKeyDeriverValidation(t *testing.T) {
+	m, err := NewMnemonic()
+	require.NoError(t, err)
+	words := strings.Fields(m)
+	words[0] = "notaword"
+	_, err = NewKeyDeriver(strings.Join(words, " "), "")
+	require.Error(t, err)
+
+	for _, p := range []string{"m", "m/", "x/0", "m/a", "m/0/", "0"} {
+		require.Error(t, ValidateDerivationPath(p), p)
+	}
+	for _, p := range []string{"m/0", "m/1/22"} {
+		require.NoError(t, ValidateDerivationPath(p), p)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QuarantineDir is the directory in the keystore holding the keys and creds
+// moved aside by 'nsc keys prune', one timestamped directory per prune.
+// Files in a quarantine keep their path relative to the keystore.
+const QuarantineDir = "quarantine"
+
+const quarantineTimeFormat = "20060102T150405Z"
+
+func GetQuarantineDir() string {
+	return filepath.Join(GetKeysDir(), QuarantineDir)
+}
+
+// IsQuarantined returns true if the path is in the keystore's quarantine
+func IsQuarantined(fp string) bool {
+	rel, err := filepath.Rel(GetQuarantineDir(), fp)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// NewQuarantine returns the name of a quarantine that doesn't exist yet
+func (k *KeyStore) NewQuarantine(t time.Time) string {
+	base := t.UTC().Format(quarantineTimeFormat)
+	name := base
+	for i := 1; ; i++ {
+		if _, err := statFile(filepath.Join(GetQuarantineDir(), name)); err != nil {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// Quarantines returns the names of the quarantines, oldest first
+func (k *KeyStore) Quarantines() ([]string, error) {
+	infos, err := readDir(GetQuarantineDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, i := range infos {
+		if i.IsDir() {
+			names = append(names, i.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// QuarantinedFiles returns the paths relative to the keystore of the files
+// in the named quarantine
+func (k *KeyStore) QuarantinedFiles(name string) ([]string, error) {
+	dir := filepath.Join(GetQuarantineDir(), name)
+	if _, err := statFile(dir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("quarantine %q does not exist", name)
+		}
+		return nil, err
+	}
+	var files []string
+	err := walkFiles(dir, func(fp string, info os.FileInfo) error {
+		rel, err := filepath.Rel(dir, fp)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// AllCreds returns the paths of the creds files in the keystore
+func (k *KeyStore) AllCreds() ([]string, error) {
+	var creds []string
+	err := walkFiles(filepath.Join(GetKeysDir(), CredsDir), func(fp string, info os.FileInfo) error {
+		if filepath.Ext(fp) == CredsExtension {
+			creds = append(creds, fp)
+		}
+		return nil
+	})
+	return creds, err
+}
+
+func pubKeyFromPath(fp string) (string, bool) {
+	if filepath.Ext(fp) != NKeyExtension {
+		return "", false
+	}
+	n := filepath.Base(fp)
+	return n[:len(n)-len(NKeyExtension)], true
+}
+
+// Quarantine moves a key or creds file of the keystore into the named
+// quarantine, returning its new path
+func (k *KeyStore) Quarantine(name string, fp string) (string, error) {
+	unlock, err := lockDir(GetKeysDir())
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	root := GetKeysDir()
+	rel, err := filepath.Rel(root, fp)
+	if err != nil || strings.HasPrefix(rel, "..") || IsQuarantined(fp) {
+		return "", fmt.Errorf("%#q is not in the keystore", fp)
+	}
+	to := filepath.Join(GetQuarantineDir(), name, rel)
+	if err := moveFile(fp, to); err != nil {
+		return "", err
+	}
+	if pk, ok := pubKeyFromPath(fp); ok {
+		// the metadata of the key goes with it
+		mp := strings.TrimSuffix(fp, NKeyExtension) + MetadataExtension
+		if _, err := statFile(mp); err == nil {
+			if err := moveFile(mp, strings.TrimSuffix(to, NKeyExtension)+MetadataExtension); err != nil {
+				return to, err
+			}
+		}
+		if err := k.Journal.recordKey(JournalRemoveKey, pk); err != nil {
+			return to, err
+		}
+	}
+	removeEmptyDirs(filepath.Dir(fp), root)
+	return to, nil
+}
+
+// Restore moves a file of the named quarantine back into the keystore,
+// returning its restored path. A file that is already in the keystore with
+// the same contents is dropped from the quarantine.
+func (k *KeyStore) Restore(name string, rel string) (string, error) {
+	unlock, err := lockDir(GetKeysDir())
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	qd := GetQuarantineDir()
+	from := filepath.Join(qd, name, rel)
+	to := filepath.Join(GetKeysDir(), rel)
+	if _, err := statFile(to); err == nil {
+		current, err := readFile(to)
+		if err != nil {
+			return "", err
+		}
+		quarantined, err := readFile(from)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(current, quarantined) {
+			return "", fmt.Errorf("%#q already exists and is different", to)
+		}
+		if err := removeFile(from); err != nil {
+			return "", err
+		}
+	} else if err := moveFile(from, to); err != nil {
+		return "", err
+	} else if pk, ok := pubKeyFromPath(to); ok {
+		if err := k.Journal.recordKey(JournalStoreKey, pk); err != nil {
+			return to, err
+		}
+	}
+	removeEmptyDirs(filepath.Dir(from), qd)
+	return to, nil
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuarantineRestore(t *testing.T) {
+	dir, done := withTestKeystore(t)
+	defer done()
+
+	ks := NewKeyStore("O")
+	_, pk, kp := CreateAccountKey(t)
+	fp, err := ks.Store(kp)
+	require.NoError(t, err)
+
+	name := ks.NewQuarantine(time.Now())
+	qp, err := ks.Quarantine(name, fp)
+	require.NoError(t, err)
+	require.FileExists(t, qp)
+	require.True(t, IsQuarantined(qp))
+	require.NoFileExists(t, fp)
+
+	// the quarantine is neither a key of the keystore nor an old keyring
+	keys, err := ks.AllKeys()
+	require.NoError(t, err)
+	require.NotContains(t, keys, pk)
+	old, err := IsOldKeyRing(dir)
+	require.NoError(t, err)
+	require.False(t, old)
+
+	require.NotEqual(t, name, ks.NewQuarantine(time.Now()))
+	names, err := ks.Quarantines()
+	require.NoError(t, err)
+	require.Equal(t, []string{name}, names)
+	// the key's metadata is quarantined with it
+	files, err := ks.QuarantinedFiles(name)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.True(t, IsKeyMetadata(files[0]))
+
+	for _, f := range files {
+		_, err := ks.Restore(name, f)
+		require.NoError(t, err)
+	}
+	require.True(t, ks.HasPrivateKey(pk))
+	m, err := ks.GetKeyMetadata(pk)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	require.NoDirExists(t, filepath.Join(GetQuarantineDir(), name))
+}
+
+func TestRestoreKeepsDifferentFiles(t *testing.T) {
+	_, done := withTestKeystore(t)
+	defer done()
+
+	ks := NewKeyStore("O")
+	fp := ks.CalcUserCredsPath("A", "U")
+	require.NoError(t, Write(fp, []byte("old")))
+	name := ks.NewQuarantine(time.Now())
+	_, err := ks.Quarantine(name, fp)
+	require.NoError(t, err)
+	require.NoError(t, Write(fp, []byte("new")))
+
+	files, err := ks.QuarantinedFiles(name)
+	require.NoError(t, err)
+	_, err = ks.Restore(name, files[0])
+	require.Error(t, err)
+	d, err := ioutil.ReadFile(fp)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(d))
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Shamir's secret sharing over GF(2^8), using the AES polynomial
+// x^8 + x^4 + x^3 + x + 1. Every byte of the secret is the constant term
+// of a random polynomial of degree threshold-1, a share holds the
+// polynomial values at the share's index.
+
+var gfExp [510]byte
+var gfLog [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfExp[i+255] = x
+		gfLog[x] = byte(i)
+		// multiply by the generator 3
+		x ^= gfDouble(x)
+	}
+}
+
+func gfDouble(x byte) byte {
+	if x&0x80 != 0 {
+		return x<<1 ^ 0x1b
+	}
+	return x << 1
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// SplitSecret splits the secret into n shares, any threshold of which
+// recover it. The first byte of each share is its index (1..n).
+func SplitSecret(secret []byte, n int, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+	if threshold < 2 {
+		return nil, errors.New("threshold must be at least 2")
+	}
+	if n < threshold {
+		return nil, fmt.Errorf("the number of shares (%d) must be at least the threshold (%d)", n, threshold)
+	}
+	if n > 255 {
+		return nil, errors.New("the number of shares cannot be greater than 255")
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+	coef := make([]byte, threshold)
+	defer func() {
+		for i := range coef {
+			coef[i] = 0
+		}
+	}()
+	for j, b := range secret {
+		coef[0] = b
+		if _, err := rand.Read(coef[1:]); err != nil {
+			return nil, err
+		}
+		for _, s := range shares {
+			// Horner's method
+			var y byte
+			for k := threshold - 1; k >= 0; k-- {
+				y = gfMul(y, s[0]) ^ coef[k]
+			}
+			s[j+1] = y
+		}
+	}
+	return shares, nil
+}
+
+// CombineShares recovers a secret from shares created by SplitSecret. If
+// fewer shares than the threshold are provided the result is garbage, so
+// callers must verify it.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least 2 shares are required")
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, errors.New("share is empty")
+	}
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if len(s) != size {
+			return nil, errors.New("shares have different lengths")
+		}
+		if s[0] == 0 {
+			return nil, errors.New("share has an invalid index")
+		}
+		if seen[s[0]] {
+			return nil, fmt.Errorf("share %d was provided more than once", s[0])
+		}
+		seen[s[0]] = true
+	}
+	secret := make([]byte, size-1)
+	for j := range secret {
+		// Lagrange interpolation at x = 0
+		var v byte
+		for i, si := range shares {
+			l := byte(1)
+			for k, sk := range shares {
+				if i == k {
+					continue
+				}
+				l = gfMul(l, gfDiv(sk[0], sk[0]^si[0]))
+			}
+			v ^= gfMul(si[j+1], l)
+		}
+		secret[j] = v
+	}
+	return secret, nil
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGFInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		require.Equal(t, byte(1), gfMul(byte(a), gfDiv(1, byte(a))))
+	}
+}
+
+func TestSplitCombineSecret(t *testing.T) {
+	seed, _, _ := CreateOperatorKey(t)
+	shares, err := SplitSecret(seed, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+
+	for _, set := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var sel [][]byte
+		for _, i := range set {
+			sel = append(sel, shares[i])
+		}
+		s, err := CombineShares(sel)
+		require.NoError(t, err)
+		require.Equal(t, seed, s)
+	}
+
+	s, err := CombineShares(shares[:2])
+	require.NoError(t, err)
+	require.NotEqual(t, seed, s)
+}
+
+func TestSplitSecretArguments(t *testing.T) {
+	_, err := SplitSecret([]byte("x"), 5, 1)
+	require.Error(t, err)
+	_, err = SplitSecret([]byte("x"), 2, 3)
+	require.Error(t, err)
+	_, err = SplitSecret([]byte("x"), 256, 3)
+	require.Error(t, err)
+	_, err = SplitSecret(nil, 5, 3)
+	require.Error(t, err)
+}
+
+func TestCombineSharesRejectsDuplicates(t *testing.T) {
+	shares, err := SplitSecret([]byte("secret"), 3, 2)
+	require.NoError(t, err)
+	_, err = CombineShares([][]byte{shares[0], shares[0]})
+	require.Error(t, err)
+	_, err = CombineShares([][]byte{shares[0], shares[1][:3]})
+	require.Error(t, err)
+}
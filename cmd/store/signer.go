@@ -0,0 +1,394 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nkeys"
+)
+
+// SignerEnv configures an external signer. A value starting with
+// `unix:` names a unix socket to connect to, anything else is an
+// executable (with optional arguments) that is run for every request.
+const SignerEnv = "NSC_SIGNER"
+
+const SignerUnixPrefix = "unix:"
+
+const (
+	SignerOpKeys = "keys"
+	SignerOpSign = "sign"
+)
+
+// SignerTimeout bounds a single request to an external signer
+var SignerTimeout = 30 * time.Second
+
+// Signer signs on behalf of nsc with keys nsc doesn't hold
+type Signer interface {
+	// PublicKeys returns the public keys the signer can sign with
+	PublicKeys() ([]string, error)
+	// Sign returns the signature of payload made with the key pubkey
+	Sign(pubkey string, payload []byte) ([]byte, error)
+}
+
+// SignerRequest is a request sent to an external signer as a JSON line
+type SignerRequest struct {
+	Op        string `json:"op"`
+	PublicKey string `json:"public_key,omitempty"`
+	Payload   []byte `json:"payload,omitempty"`
+}
+
+// SignerResponse is the JSON line an external signer responds with
+type SignerResponse struct {
+	PublicKeys []string `json:"public_keys,omitempty"`
+	Signature  []byte   `json:"signature,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+var signerMu sync.Mutex
+var signerOverride Signer
+var signerConfig string
+var signerCache *cachingSigner
+
+// SetSigner sets the external signer, overriding $NSC_SIGNER. Setting nil
+// restores the configuration from the environment.
+func SetSigner(s Signer) {
+	signerMu.Lock()
+	defer signerMu.Unlock()
+	signerOverride = s
+	signerCache = nil
+}
+
+// ExternalSigner returns the configured external signer or nil. A running
+// agent ($NSC_AGENT_SOCK) is preferred over $NSC_SIGNER.
+func ExternalSigner() Signer {
+	signerMu.Lock()
+	defer signerMu.Unlock()
+	if signerOverride != nil {
+		return signerOverride
+	}
+	v := strings.TrimSpace(os.Getenv(SignerEnv))
+	if sock := strings.TrimSpace(os.Getenv(AgentSockEnv)); sock != "" {
+		v = SignerUnixPrefix + sock
+	}
+	if v == "" {
+		return nil
+	}
+	if signerCache == nil || signerConfig != v {
+		signerConfig = v
+		signerCache = &cachingSigner{Signer: ParseSigner(v)}
+	}
+	return signerCache
+}
+
+// ParseSigner returns the signer described by a $NSC_SIGNER value
+func ParseSigner(v string) Signer {
+	if strings.HasPrefix(v, SignerUnixPrefix) {
+		return &SocketSigner{Path: strings.TrimPrefix(v, SignerUnixPrefix)}
+	}
+	return &ExecSigner{Command: strings.Fields(v)}
+}
+
+// cachingSigner remembers the public keys of a signer for the process
+type cachingSigner struct {
+	Signer
+	once sync.Once
+	keys []string
+	err  error
+}
+
+func (c *cachingSigner) PublicKeys() ([]string, error) {
+	c.once.Do(func() {
+		c.keys, c.err = c.Signer.PublicKeys()
+	})
+	return c.keys, c.err
+}
+
+func signerHas(s Signer, pubkey string) (bool, error) {
+	keys, err := s.PublicKeys()
+	if err != nil {
+		return false, err
+	}
+	for _, k := range keys {
+		if k == pubkey {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ExternalKeyPair returns a key pair that signs with the external signer,
+// or nil if no signer is configured or the signer doesn't hold the key
+func ExternalKeyPair(pubkey string) (nkeys.KeyPair, error) {
+	s := ExternalSigner()
+	if s == nil || !nkeys.IsValidPublicKey(pubkey) {
+		return nil, nil
+	}
+	ok, err := signerHas(s, pubkey)
+	if err != nil {
+		return nil, fmt.Errorf("error contacting the external signer: %v", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return &externalKeyPair{pub: pubkey, signer: s}, nil
+}
+
+// IsExternalKeyPair returns true if the key pair signs with an external signer
+func IsExternalKeyPair(kp nkeys.KeyPair) bool {
+	_, ok := kp.(*externalKeyPair)
+	return ok
+}
+
+// externalKeyPair is an nkeys.KeyPair whose private key lives in the signer
+type externalKeyPair struct {
+	pub    string
+	signer Signer
+}
+
+func (e *externalKeyPair) Seed() ([]byte, error) {
+	return nil, fmt.Errorf("the seed for %s is held by the external signer", e.pub)
+}
+
+func (e *externalKeyPair) PublicKey() (string, error) {
+	return e.pub, nil
+}
+
+func (e *externalKeyPair) PrivateKey() ([]byte, error) {
+	return nil, fmt.Errorf("the private key for %s is held by the external signer", e.pub)
+}
+
+func (e *externalKeyPair) Sign(input []byte) ([]byte, error) {
+	sig, err := e.signer.Sign(e.pub, input)
+	if err != nil {
+		return nil, fmt.Errorf("external signer failed to sign with %s: %v", e.pub, err)
+	}
+	// don't trust the signer blindly
+	if err := e.Verify(input, sig); err != nil {
+		return nil, fmt.Errorf("external signer returned an invalid signature for %s", e.pub)
+	}
+	return sig, nil
+}
+
+func (e *externalKeyPair) Verify(input []byte, sig []byte) error {
+	kp, err := nkeys.FromPublicKey(e.pub)
+	if err != nil {
+		return err
+	}
+	return kp.Verify(input, sig)
+}
+
+func (e *externalKeyPair) Wipe() {}
+
+func signerResult(r *SignerResponse, err error) (*SignerResponse, error) {
+	if err != nil {
+		return nil, err
+	}
+	if r.Error != "" {
+		return nil, errors.New(r.Error)
+	}
+	return r, nil
+}
+
+// ExecSigner runs an executable for every request, writing the request
+// to its stdin and reading the response from its stdout
+type ExecSigner struct {
+	Command []string
+}
+
+func (s *ExecSigner) call(req *SignerRequest) (*SignerResponse, error) {
+	if len(s.Command) == 0 {
+		return nil, errors.New("no signer executable specified")
+	}
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(s.Command[0], s.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(append(in, '\n'))
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	t := time.AfterFunc(SignerTimeout, func() { cmd.Process.Kill() })
+	err = cmd.Wait()
+	t.Stop()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	var resp SignerResponse
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("error parsing the signer response: %v", err)
+	}
+	return &resp, nil
+}
+
+func (s *ExecSigner) PublicKeys() ([]string, error) {
+	r, err := signerResult(s.call(&SignerRequest{Op: SignerOpKeys}))
+	if err != nil {
+		return nil, err
+	}
+	return r.PublicKeys, nil
+}
+
+func (s *ExecSigner) Sign(pubkey string, payload []byte) ([]byte, error) {
+	r, err := signerResult(s.call(&SignerRequest{Op: SignerOpSign, PublicKey: pubkey, Payload: payload}))
+	if err != nil {
+		return nil, err
+	}
+	return r.Signature, nil
+}
+
+// SocketSigner connects to a unix socket for every request
+type SocketSigner struct {
+	Path string
+}
+
+func (s *SocketSigner) call(req *SignerRequest) (*SignerResponse, error) {
+	c, err := net.DialTimeout("unix", s.Path, SignerTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(SignerTimeout))
+	if err := json.NewEncoder(c).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp SignerResponse
+	if err := json.NewDecoder(bufio.NewReader(c)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("error parsing the signer response: %v", err)
+	}
+	return &resp, nil
+}
+
+func (s *SocketSigner) PublicKeys() ([]string, error) {
+	r, err := signerResult(s.call(&SignerRequest{Op: SignerOpKeys}))
+	if err != nil {
+		return nil, err
+	}
+	return r.PublicKeys, nil
+}
+
+func (s *SocketSigner) Sign(pubkey string, payload []byte) ([]byte, error) {
+	r, err := signerResult(s.call(&SignerRequest{Op: SignerOpSign, PublicKey: pubkey, Payload: payload}))
+	if err != nil {
+		return nil, err
+	}
+	return r.Signature, nil
+}
+
+// LocalSigner is a reference signer holding key pairs in memory
+type LocalSigner struct {
+	mu   sync.Mutex
+	keys map[string]nkeys.KeyPair
+}
+
+func NewLocalSigner(kps ...nkeys.KeyPair) (*LocalSigner, error) {
+	s := &LocalSigner{keys: make(map[string]nkeys.KeyPair)}
+	for _, kp := range kps {
+		if err := s.Add(kp); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *LocalSigner) Add(kp nkeys.KeyPair) error {
+	pk, err := kp.PublicKey()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[pk] = kp
+	return nil
+}
+
+func (s *LocalSigner) PublicKeys() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s *LocalSigner) Sign(pubkey string, payload []byte) ([]byte, error) {
+	s.mu.Lock()
+	kp := s.keys[pubkey]
+	s.mu.Unlock()
+	if kp == nil {
+		return nil, fmt.Errorf("key %s is not available", pubkey)
+	}
+	return kp.Sign(payload)
+}
+
+// HandleSignerRequest reads one request from r, executes it with the
+// signer and writes the response to w
+func HandleSignerRequest(r io.Reader, w io.Writer, s Signer) error {
+	var req SignerRequest
+	var resp SignerResponse
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("error parsing request: %v", err)
+	} else {
+		var err error
+		switch req.Op {
+		case SignerOpKeys:
+			resp.PublicKeys, err = s.PublicKeys()
+		case SignerOpSign:
+			resp.Signature, err = s.Sign(req.PublicKey, req.Payload)
+		default:
+			err = fmt.Errorf("unknown operation %q", req.Op)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+	}
+	return json.NewEncoder(w).Encode(&resp)
+}
+
+// ServeSigner handles signer requests arriving on the listener until it
+// is closed
+func ServeSigner(l net.Listener, s Signer) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(SignerTimeout))
+			HandleSignerRequest(c, c, s)
+		}(c)
+	}
+}
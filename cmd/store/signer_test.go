@@ -0,0 +1,121 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/require"
+)
+
+const testSignerSeedEnv = "NSC_TEST_SIGNER_SEED"
+
+// TestSignerHelperProcess isn't a real test - it is the executable
+// started by TestExecSigner
+func TestSignerHelperProcess(t *testing.T) {
+	seed := os.Getenv(testSignerSeedEnv)
+	if seed == "" {
+		return
+	}
+	kp, err := nkeys.FromSeed([]byte(seed))
+	if err != nil {
+		os.Exit(1)
+	}
+	s, _ := NewLocalSigner(kp)
+	HandleSignerRequest(os.Stdin, os.Stdout, s)
+	os.Exit(0)
+}
+
+func requireExternalSigning(t *testing.T, pk string) {
+	ks := NewKeyStore("O")
+	kp, err := ks.GetKeyPair(pk)
+	require.NoError(t, err)
+	require.NotNil(t, kp)
+	require.True(t, IsExternalKeyPair(kp))
+	require.True(t, ks.CanSign(pk))
+	require.False(t, ks.HasPrivateKey(pk))
+	_, err = kp.Seed()
+	require.Error(t, err)
+
+	_, apk, _ := CreateAccountKey(t)
+	ac := jwt.NewAccountClaims(apk)
+	token, err := ac.Encode(kp)
+	require.NoError(t, err)
+	dc, err := jwt.DecodeAccountClaims(token)
+	require.NoError(t, err)
+	require.Equal(t, pk, dc.Issuer)
+
+	// keys the signer doesn't have are not resolved
+	_, opk, _ := CreateOperatorKey(t)
+	kp, err = ks.GetKeyPair(opk)
+	require.NoError(t, err)
+	require.Nil(t, kp)
+	require.False(t, ks.CanSign(opk))
+}
+
+func TestSocketSigner(t *testing.T) {
+	dir := MakeTempDir(t)
+	defer os.RemoveAll(dir)
+	_, pk, kp := CreateOperatorKey(t)
+	ls, err := NewLocalSigner(kp)
+	require.NoError(t, err)
+
+	sock := filepath.Join(dir, "signer.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer l.Close()
+	go ServeSigner(l, ls)
+
+	require.NoError(t, os.Setenv(SignerEnv, SignerUnixPrefix+sock))
+	defer os.Unsetenv(SignerEnv)
+	requireExternalSigning(t, pk)
+}
+
+func TestExecSigner(t *testing.T) {
+	seed, pk, _ := CreateOperatorKey(t)
+	require.NoError(t, os.Setenv(testSignerSeedEnv, string(seed)))
+	defer os.Unsetenv(testSignerSeedEnv)
+	SetSigner(&ExecSigner{Command: []string{os.Args[0], "-test.run=TestSignerHelperProcess"}})
+	defer SetSigner(nil)
+	requireExternalSigning(t, pk)
+}
+
+type badSigner struct {
+	*LocalSigner
+}
+
+func (b *badSigner) Sign(pubkey string, payload []byte) ([]byte, error) {
+	return b.LocalSigner.Sign(pubkey, []byte("something else"))
+}
+
+func TestExternalSignatureIsVerified(t *testing.T) {
+	_, pk, kp := CreateOperatorKey(t)
+	ls, err := NewLocalSigner(kp)
+	require.NoError(t, err)
+	SetSigner(&badSigner{LocalSigner: ls})
+	defer SetSigner(nil)
+
+	ekp, err := ExternalKeyPair(pk)
+	require.NoError(t, err)
+	_, err = ekp.Sign([]byte("payload"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid signature")
+}
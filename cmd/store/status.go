@@ -17,6 +17,7 @@ package store
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -33,6 +34,23 @@ const (
 	ERR
 )
 
+func (c StatusCode) String() string {
+	switch c {
+	case OK:
+		return "OK"
+	case WARN:
+		return "WARN"
+	case ERR:
+		return "ERR"
+	default:
+		return "NONE"
+	}
+}
+
+func (c StatusCode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
 type PrintOption int
 
 const (
@@ -498,3 +516,54 @@ func (mj MultiJob) Summary() (string, error) {
 
 	return fmt.Sprintf("%d jobs succeeded - there were %d errors and %d warnings", ok, err, warn), nil
 }
+
+// StatusNode is the machine readable form of a Status and its children
+type StatusNode struct {
+	Code           StatusCode    `json:"code"`
+	Label          string        `json:"label,omitempty"`
+	Messages       []string      `json:"messages,omitempty"`
+	Children       []*StatusNode `json:"children,omitempty"`
+	ServerMessages []string      `json:"server_messages,omitempty"`
+}
+
+// NewStatusNode converts the status tree into StatusNodes. Details of a Report
+// become children, except ServerMessages which are attached to the report
+// that received them.
+func NewStatusNode(s Status) *StatusNode {
+	if s == nil || reflect.ValueOf(s).IsNil() {
+		return nil
+	}
+	switch v := s.(type) {
+	case *Report:
+		n := &StatusNode{Code: v.Code(), Label: v.Label}
+		for _, d := range v.Details {
+			if d == nil || reflect.ValueOf(d).IsNil() {
+				continue
+			}
+			if sm, ok := d.(*ServerMessage); ok {
+				n.ServerMessages = append(n.ServerMessages, sm.SrvMessage)
+				continue
+			}
+			if c := NewStatusNode(d); c != nil {
+				n.Children = append(n.Children, c)
+			}
+		}
+		return n
+	case *ServerMessage:
+		return &StatusNode{Code: v.Code(), ServerMessages: []string{v.SrvMessage}}
+	case MultiJob:
+		n := &StatusNode{Code: v.Code()}
+		for _, j := range v {
+			if c := NewStatusNode(j); c != nil {
+				n.Children = append(n.Children, c)
+			}
+		}
+		return n
+	default:
+		n := &StatusNode{Code: s.Code()}
+		if m := s.Message(); m != "" {
+			n.Messages = []string{m}
+		}
+		return n
+	}
+}
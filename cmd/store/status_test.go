@@ -16,6 +16,7 @@
 package store
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -180,3 +181,26 @@ func Test_ServerMessagesAlwaysShow(t *testing.T) {
 	require.Contains(t, lines[1], "one")
 	require.Contains(t, lines[2], "server says")
 }
+
+func Test_StatusNode(t *testing.T) {
+	r := NewDetailedReport(true)
+	r.Label = "push"
+	sub := NewReport(OK, "server A")
+	sub.Add(NewServerMessage("jwt updated"))
+	r.Add(sub)
+	r.AddWarning("server B didn't respond")
+
+	n := NewStatusNode(r)
+	require.Equal(t, WARN, n.Code)
+	require.Equal(t, "push", n.Label)
+	require.Len(t, n.Children, 2)
+	require.Equal(t, "server A", n.Children[0].Label)
+	require.Equal(t, []string{"jwt updated"}, n.Children[0].ServerMessages)
+	require.Empty(t, n.Children[0].Children)
+	require.Equal(t, WARN, n.Children[1].Code)
+
+	d, err := json.Marshal(n)
+	require.NoError(t, err)
+	require.Contains(t, string(d), `"code":"WARN"`)
+	require.Contains(t, string(d), `"server_messages":["jwt updated"]`)
+}
@@ -135,13 +135,13 @@ func CreateStore(env string, operatorsDir string, operator *NamedKey) (*Store, e
 		},
 	}
 
-	if _, err := os.Stat(root); os.IsNotExist(err) {
-		if err := os.MkdirAll(root, 0700); err != nil {
+	if _, err := statFile(root); os.IsNotExist(err) {
+		if err := mkdirAll(root); err != nil {
 			return nil, err
 		}
 	}
 
-	files, err := ioutil.ReadDir(root)
+	files, err := readDir(root)
 	if err != nil {
 		return nil, err
 	}
@@ -173,7 +173,7 @@ func CreateStore(env string, operatorsDir string, operator *NamedKey) (*Store, e
 
 	for _, d := range standardDirs {
 		dp := s.resolve(d, "")
-		if err = os.MkdirAll(dp, 0700); err != nil {
+		if err = mkdirAll(dp); err != nil {
 			return nil, fmt.Errorf("error creating %#q: %v", dp, err)
 		}
 	}
@@ -206,7 +206,7 @@ func (s *Store) createOperatorToken(operator *NamedKey) (string, error) {
 // LoadStore loads a store from the specified directory path.
 func LoadStore(dir string) (*Store, error) {
 	sf := filepath.Join(dir, NSCFile)
-	if _, err := os.Stat(sf); os.IsNotExist(err) {
+	if _, err := statFile(sf); os.IsNotExist(err) {
 		return nil, fmt.Errorf("%#q is not a valid configuration directory", dir)
 	}
 
@@ -241,7 +241,7 @@ func (s *Store) HasAccount(name string) bool {
 }
 
 func (s *Store) has(fp string) bool {
-	if _, err := os.Stat(fp); os.IsNotExist(err) {
+	if _, err := statFile(fp); os.IsNotExist(err) {
 		return false
 	}
 	return true
@@ -252,7 +252,7 @@ func (s *Store) Read(name ...string) ([]byte, error) {
 	s.Lock()
 	defer s.Unlock()
 	fp := s.resolve(name...)
-	d, err := ioutil.ReadFile(fp)
+	d, err := readFile(fp)
 	if err != nil {
 		return nil, fmt.Errorf("error reading %#q: %v", fp, err)
 	}
@@ -263,14 +263,21 @@ func (s *Store) Read(name ...string) ([]byte, error) {
 func (s *Store) Write(data []byte, name ...string) error {
 	s.Lock()
 	defer s.Unlock()
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	fp := s.resolve(name...)
-	dp := filepath.Dir(fp)
-
-	if err := os.MkdirAll(dp, 0700); err != nil {
+	previous, err := readFile(fp)
+	if err != nil {
+		previous = nil
+	}
+	if err := writeFile(fp, data, 0600); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(fp, data, 0600)
+	return s.Journal().recordJwt(filepath.Join(name...), previous, data)
 }
 
 func (s *Store) List(path ...string) ([]os.FileInfo, error) {
@@ -278,15 +285,30 @@ func (s *Store) List(path ...string) ([]os.FileInfo, error) {
 	defer s.Unlock()
 
 	fp := s.resolve(path...)
-	return ioutil.ReadDir(fp)
+	return readDir(fp)
 }
 
 // Delete the specified file name or subpath from the store
 func (s *Store) Delete(name ...string) error {
 	s.Lock()
 	defer s.Unlock()
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	fp := s.resolve(name...)
-	return os.Remove(fp)
+	previous, err := readFile(fp)
+	if err != nil {
+		previous = nil
+	}
+	if err := removeFile(fp); err != nil {
+		return err
+	}
+	if previous == nil {
+		return nil
+	}
+	return s.Journal().recordJwt(filepath.Join(name...), previous, nil)
 }
 
 func (s *Store) ListSubContainers(name ...string) ([]string, error) {
@@ -411,12 +433,20 @@ func (s *Store) handleManagedAccount(data []byte) (*Report, error) {
 	return r, nil
 }
 
+// StoreClaim stores the claim, an account of a managed store is pushed to
+// the account server of the operator
 func (s *Store) StoreClaim(data []byte) (*Report, error) {
+	return s.StoreClaimPush(data, true)
+}
+
+// StoreClaimPush stores the claim like StoreClaim, an account of a managed
+// store is pushed only when push is set
+func (s *Store) StoreClaimPush(data []byte, push bool) (*Report, error) {
 	ct, err := s.ClaimType(data)
 	if err != nil {
 		return nil, err
 	}
-	if ct == jwt.AccountClaim && s.IsManaged() {
+	if push && ct == jwt.AccountClaim && s.IsManaged() && !IsDryRun() {
 		var pull Report
 		pp, err := s.handleManagedAccount(data)
 		if pp != nil {
@@ -721,6 +751,7 @@ func (s *Store) GetContext() (*Context, error) {
 
 	c.Store = s
 	c.KeyStore = NewKeyStore(s.Info.Name)
+	c.KeyStore.Journal = s.Journal()
 
 	root, err := s.LoadRootClaim()
 	if err != nil {
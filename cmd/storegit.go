@@ -0,0 +1,440 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xlab/tablewriter"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+var storeCmd = &cobra.Command{
+	Use:   "store",
+	Short: "Manage the git history of the store",
+}
+
+func init() {
+	GetRootCmd().AddCommand(storeCmd)
+	storeCmd.AddCommand(createStoreAutoCommitCmd())
+	storeCmd.AddCommand(createStoreLogCmd())
+	storeCmd.AddCommand(createStoreCheckoutCmd())
+}
+
+func createStoreAutoCommitCmd() *cobra.Command {
+	var params StoreAutoCommitParams
+	cmd := &cobra.Command{
+		Use:   "autocommit",
+		Short: "Commit the changes every nsc command makes to the store",
+		Long: `Commit the changes every nsc command makes to the store
+
+The store root is made a git repository if it isn't one, and every nsc
+command that changes the store commits its changes. The commit message
+names the command and the operators, accounts and users it changed with
+their changed claim fields. The journal and lock files are not committed.
+The keystore is never part of the store.
+
+The setting is stored in the git configuration of the repository.`,
+		Example: `nsc store autocommit
+nsc store autocommit --disable`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunMaybeStorelessAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().BoolVarP(&params.disable, "disable", "", false, "stop committing the changes")
+	return cmd
+}
+
+func createStoreLogCmd() *cobra.Command {
+	var params StoreLogParams
+	cmd := &cobra.Command{
+		Use:          "log",
+		Short:        "Show the commits that changed the operator",
+		Example:      `nsc store log --max-count 10`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().IntVarP(&params.max, "max-count", "n", 0, "maximum number of commits to show (0 is all)")
+	return cmd
+}
+
+func createStoreCheckoutCmd() *cobra.Command {
+	var params StoreCheckoutParams
+	cmd := &cobra.Command{
+		Use:   "checkout <rev>",
+		Short: "Restore the operator to its state at a commit",
+		Long: `Restore the operator to its state at a commit
+
+The operator directory, its accounts and users are restored to their state
+at the commit. JWTs created after the commit are removed. The history is
+not rewritten, the restored state is committed as a new commit when
+autocommit is enabled. The keystore is not changed.
+
+Restored JWTs need to be pushed to account servers or resolvers to take effect.`,
+		Example:      `nsc store checkout HEAD~2`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().BoolVarP(&params.force, "force", "F", false, "discard uncommitted changes to the operator")
+	return cmd
+}
+
+// storeGitRepo returns the git repository holding the store root
+func storeGitRepo() (*store.GitRepo, error) {
+	root := GetConfig().StoreRoot
+	if root == "" {
+		return nil, errors.New("no store root is set")
+	}
+	g := store.OpenGitRepo(root)
+	if g == nil {
+		return nil, fmt.Errorf("store root %#q is not in a git repository - enable it with 'nsc store autocommit'", AbbrevHomePaths(root))
+	}
+	return g, nil
+}
+
+// commitStoreChanges commits the changes made to the written paths of the
+// store root if autocommit is enabled. Other changes to the store root are
+// left uncommitted. Nothing is committed while git is merging or holds the
+// index, the merge is committed by git.
+func commitStoreChanges(command string, written []string) error {
+	root := GetConfig().StoreRoot
+	if root == "" || len(written) == 0 {
+		return nil
+	}
+	g := store.OpenGitRepo(root)
+	if g == nil || !g.AutoCommit() || g.Merging() || g.IndexLocked() {
+		return nil
+	}
+	changes, err := g.Changes(root)
+	if err != nil {
+		return err
+	}
+	changes = writtenChanges(g, root, changes, written)
+	if len(changes) == 0 {
+		return nil
+	}
+	return g.Commit(changes, commitMessage(g, root, command, changes))
+}
+
+// writtenChanges returns the changes to the written paths, which are
+// files or directories whose files were all changed
+func writtenChanges(g *store.GitRepo, root string, changes []store.GitChange, written []string) []store.GitChange {
+	aroot, err := filepath.Abs(root)
+	if err != nil {
+		return nil
+	}
+	rroot, err := g.Rel(root)
+	if err != nil {
+		return nil
+	}
+	var rels []string
+	for _, fp := range written {
+		rel, err := filepath.Rel(aroot, fp)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		rels = append(rels, path.Join(rroot, filepath.ToSlash(rel)))
+	}
+	var wc []store.GitChange
+	for _, c := range changes {
+		for _, r := range rels {
+			if r == "." || c.Path == r || strings.HasPrefix(c.Path, r+"/") {
+				wc = append(wc, c)
+				break
+			}
+		}
+	}
+	return wc
+}
+
+// commitMessage names the command and describes the changes to JWTs
+// with the claim fields that changed
+func commitMessage(g *store.GitRepo, root string, command string, changes []store.GitChange) string {
+	rroot, _ := g.Rel(root)
+	var lines []string
+	other := 0
+	for _, c := range changes {
+		rel := strings.TrimPrefix(strings.TrimPrefix(c.Path, rroot), "/")
+		if rroot == "." {
+			rel = c.Path
+		}
+		parts := strings.SplitN(rel, "/", 2)
+		if len(parts) != 2 {
+			other++
+			continue
+		}
+		kind, account, name, ok := store.JwtEntity(parts[1])
+		if !ok {
+			other++
+			continue
+		}
+		if kind == store.KindUser {
+			name = account + "/" + name
+		}
+		var previous, next []byte
+		if g.HasHead() {
+			previous, _ = g.Show("HEAD", c.Path)
+		}
+		if !c.Deleted {
+			next, _ = ioutil.ReadFile(filepath.Join(g.Dir, filepath.FromSlash(c.Path)))
+		}
+		switch {
+		case c.Deleted:
+			lines = append(lines, fmt.Sprintf("delete %s %s", kind, name))
+		case len(previous) == 0:
+			lines = append(lines, fmt.Sprintf("add %s %s", kind, name))
+		default:
+			l := fmt.Sprintf("update %s %s", kind, name)
+			if fields := changedFields(previous, next); len(fields) > 0 {
+				l = fmt.Sprintf("%s: %s", l, strings.Join(fields, ", "))
+			}
+			lines = append(lines, l)
+		}
+	}
+	if other > 0 {
+		lines = append(lines, fmt.Sprintf("%d other file(s)", other))
+	}
+	subject := command
+	if subject == "" {
+		subject = "nsc"
+	}
+	return subject + "\n\n" + strings.Join(lines, "\n") + "\n"
+}
+
+// changedFields returns the paths of the claim fields that differ
+func changedFields(a []byte, b []byte) []string {
+	diff, err := DiffClaims(a, b)
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var fields []string
+	for _, l := range diff {
+		// lines are "<op> <path>: <values>"
+		f := strings.SplitN(strings.TrimSpace(l[1:]), ":", 2)[0]
+		if !seen[f] {
+			seen[f] = true
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+type StoreAutoCommitParams struct {
+	disable bool
+}
+
+// DryRunUnsupported - git initializes and configures the work tree, which
+// a dry-run can't capture
+func (p *StoreAutoCommitParams) DryRunUnsupported() {}
+
+func (p *StoreAutoCommitParams) SetDefaults(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *StoreAutoCommitParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *StoreAutoCommitParams) Load(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *StoreAutoCommitParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *StoreAutoCommitParams) Validate(ctx ActionCtx) error {
+	if GetConfig().StoreRoot == "" {
+		return errors.New("no store root is set")
+	}
+	return nil
+}
+
+func (p *StoreAutoCommitParams) Run(ctx ActionCtx) (store.Status, error) {
+	root := GetConfig().StoreRoot
+	g := store.OpenGitRepo(root)
+	if p.disable {
+		if g == nil || !g.AutoCommit() {
+			return store.OKStatus("autocommit is not enabled for %#q", AbbrevHomePaths(root)), nil
+		}
+		if err := g.SetAutoCommit(false); err != nil {
+			return nil, err
+		}
+		return store.OKStatus("disabled autocommit for %#q", AbbrevHomePaths(root)), nil
+	}
+
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	if g == nil {
+		var err error
+		if g, err = store.InitGitRepo(root); err != nil {
+			return nil, err
+		}
+		r.AddOK("initialized a git repository in %#q", AbbrevHomePaths(root))
+	}
+	if err := g.SetAutoCommit(true); err != nil {
+		return nil, err
+	}
+	r.AddOK("enabled autocommit for %#q", AbbrevHomePaths(root))
+	// the current state is committed by the action
+	store.RecordWrite(root)
+	return r, nil
+}
+
+type StoreLogParams struct {
+	max     int
+	commits []store.GitCommit
+}
+
+func (p *StoreLogParams) SetDefaults(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *StoreLogParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *StoreLogParams) Load(ctx ActionCtx) error {
+	g, err := storeGitRepo()
+	if err != nil {
+		return err
+	}
+	p.commits, err = g.Log(ctx.StoreCtx().Store.Dir, p.max)
+	return err
+}
+
+func (p *StoreLogParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *StoreLogParams) Validate(ctx ActionCtx) error {
+	if p.max < 0 {
+		return errors.New("--max-count must be 0 or more")
+	}
+	return nil
+}
+
+func (p *StoreLogParams) Run(ctx ActionCtx) (store.Status, error) {
+	return &StoreLog{Operator: ctx.StoreCtx().Store.GetName(), Commits: p.commits}, nil
+}
+
+// StoreLog is the status of the store log command
+type StoreLog struct {
+	Operator string
+	Commits  []store.GitCommit
+}
+
+func (l *StoreLog) Code() store.StatusCode {
+	return store.OK
+}
+
+func (l *StoreLog) Message() string {
+	table := tablewriter.CreateTable()
+	table.AddTitle(fmt.Sprintf("Commits of %s", l.Operator))
+	if len(l.Commits) == 0 {
+		table.AddRow("No commits")
+		return table.Render()
+	}
+	table.AddHeaders("Commit", "Time", "Author", "Subject")
+	for _, c := range l.Commits {
+		table.AddRow(shortHash(c.Hash), c.Time.Local().Format(time.RFC3339), c.Author, c.Subject)
+	}
+	return table.Render()
+}
+
+func (l *StoreLog) JsonDocument() (interface{}, error) {
+	if l.Commits == nil {
+		return []store.GitCommit{}, nil
+	}
+	return l.Commits, nil
+}
+
+type StoreCheckoutParams struct {
+	force bool
+	rev   string
+	g     *store.GitRepo
+}
+
+// DryRunUnsupported - git restores the files, which a dry-run can't capture
+func (p *StoreCheckoutParams) DryRunUnsupported() {}
+
+func (p *StoreCheckoutParams) SetDefaults(ctx ActionCtx) error {
+	p.rev = ctx.Args()[0]
+	return nil
+}
+
+func (p *StoreCheckoutParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *StoreCheckoutParams) Load(ctx ActionCtx) error {
+	var err error
+	p.g, err = storeGitRepo()
+	return err
+}
+
+func (p *StoreCheckoutParams) PostInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *StoreCheckoutParams) Validate(ctx ActionCtx) error {
+	var err error
+	if p.rev, err = p.g.ResolveRev(p.rev); err != nil {
+		return err
+	}
+	if p.force {
+		return nil
+	}
+	changes, err := p.g.Changes(ctx.StoreCtx().Store.Dir)
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("operator %q has %d uncommitted change(s) - commit them or use --force to discard them", ctx.StoreCtx().Store.GetName(), len(changes))
+	}
+	return nil
+}
+
+func (p *StoreCheckoutParams) Run(ctx ActionCtx) (store.Status, error) {
+	s := ctx.StoreCtx().Store
+	if err := p.g.Checkout(p.rev, s.Dir); err != nil {
+		return nil, err
+	}
+	r := store.NewDetailedReport(true)
+	r.ReportSum = false
+	r.AddOK("restored operator %q to %s", s.GetName(), shortHash(p.rev))
+	if !p.g.AutoCommit() {
+		r.AddWarning("autocommit is not enabled - the restored state is not committed")
+	}
+	return r, nil
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func requireGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+}
+
+func lastCommitMessage(t *testing.T, dir string) string {
+	d, err := exec.Command("git", "-C", dir, "log", "-1", "--format=%B").Output()
+	require.NoError(t, err)
+	return string(d)
+}
+
+func Test_StoreAutoCommit(t *testing.T) {
+	requireGit(t)
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	root := ts.GetStoresRoot()
+
+	_, _, err := ExecuteCmd(createStoreLogCmd())
+	require.Error(t, err)
+
+	_, stderr, err := ExecuteCmd(createStoreAutoCommitCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, "enabled autocommit")
+	require.DirExists(t, filepath.Join(root, ".git"))
+	require.Contains(t, lastCommitMessage(t, root), "add operator O")
+
+	ts.AddAccount(t, "A")
+	require.Contains(t, lastCommitMessage(t, root), "add account A")
+
+	_, _, err = ExecuteCmd(createEditAccount(), "--name", "A", "--conns", "10")
+	require.NoError(t, err)
+	m := lastCommitMessage(t, root)
+	require.Contains(t, m, "--conns=10")
+	require.Contains(t, m, "update account A: nats.limits.conn")
+
+	// dry-runs and failed commands don't commit
+	g := store.OpenGitRepo(root)
+	require.NotNil(t, g)
+	commits, err := g.Log(root, 0)
+	require.NoError(t, err)
+	require.Len(t, commits, 3)
+	_, _, err = ExecuteCmd(HoistRootFlags(createEditAccount()), "--name", "A", "--conns", "20", "--dry-run")
+	require.NoError(t, err)
+	commits, err = g.Log(root, 0)
+	require.NoError(t, err)
+	require.Len(t, commits, 3)
+
+	_, stderr, err = ExecuteCmd(createStoreLogCmd(), "--max-count", "2")
+	require.NoError(t, err)
+	require.Contains(t, stderr, "--conns=10")
+	require.NotContains(t, stderr, "autocommit")
+
+	_, _, err = ExecuteCmd(createStoreAutoCommitCmd(), "--disable")
+	require.NoError(t, err)
+	ts.AddAccount(t, "B")
+	commits, err = g.Log(root, 0)
+	require.NoError(t, err)
+	require.Len(t, commits, 3)
+}
+
+func Test_StoreCheckout(t *testing.T) {
+	requireGit(t)
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	root := ts.GetStoresRoot()
+	_, _, err := ExecuteCmd(createStoreAutoCommitCmd())
+	require.NoError(t, err)
+	ts.AddAccount(t, "A")
+	_, _, err = ExecuteCmd(createEditAccount(), "--name", "A", "--conns", "10")
+	require.NoError(t, err)
+
+	// a stray change blocks the checkout
+	stray := filepath.Join(ts.Store.Dir, "stray.txt")
+	require.NoError(t, ioutil.WriteFile(stray, []byte("x"), 0600))
+	_, _, err = ExecuteCmd(createStoreCheckoutCmd(), "HEAD~2")
+	require.Error(t, err)
+
+	_, stderr, err := ExecuteCmd(createStoreCheckoutCmd(), "HEAD~2", "--force")
+	require.NoError(t, err)
+	require.Contains(t, stderr, `restored operator "O"`)
+	require.NoFileExists(t, stray)
+	require.False(t, ts.Store.Has(store.Accounts, "A", store.JwtName("A")))
+	require.True(t, strings.HasPrefix(lastCommitMessage(t, root), "checkout"))
+	require.Contains(t, lastCommitMessage(t, root), "delete account A")
+
+	// the checkout is journaled and can be undone
+	entries, err := ts.Store.Journal().Entries()
+	require.NoError(t, err)
+	last := entries[len(entries)-1]
+	require.Equal(t, store.JournalDelete, last.Op)
+	require.Equal(t, "A", last.Account)
+	require.Contains(t, last.Command, "checkout")
+	_, _, err = ExecuteCmd(createUndoCmd())
+	require.NoError(t, err)
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), ac.Limits.Conn)
+
+	// back to the state after the edit
+	_, _, err = ExecuteCmd(createStoreCheckoutCmd(), "HEAD~2")
+	require.NoError(t, err)
+	ac, err = ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), ac.Limits.Conn)
+	// the journal was kept
+	entries, err = ts.Store.Journal().Entries()
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+
+	_, _, err = ExecuteCmd(createStoreCheckoutCmd(), "nosuchrev")
+	require.Error(t, err)
+}
+
+func Test_StoreAutoCommitOnlyWrittenFiles(t *testing.T) {
+	requireGit(t)
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	root := ts.GetStoresRoot()
+	_, _, err := ExecuteCmd(createStoreAutoCommitCmd())
+	require.NoError(t, err)
+	d, err := ioutil.ReadFile(filepath.Join(root, ".gitignore"))
+	require.NoError(t, err)
+	require.Contains(t, string(d), "**/"+store.HistoryDir+"/")
+
+	// the hooks of the work tree run
+	marker := filepath.Join(ts.Dir, "hooked")
+	if runtime.GOOS != "windows" {
+		hook := fmt.Sprintf("#!/bin/sh\ntouch '%s'\n", marker)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, ".git", "hooks", "pre-commit"), []byte(hook), 0700))
+	}
+
+	// a change made before the command is left uncommitted
+	stray := filepath.Join(ts.Store.Dir, "stray.txt")
+	require.NoError(t, ioutil.WriteFile(stray, []byte("x"), 0600))
+	ts.AddAccount(t, "A")
+	require.Contains(t, lastCommitMessage(t, root), "add account A")
+
+	g := store.OpenGitRepo(root)
+	require.NotNil(t, g)
+	changes, err := g.Changes(root)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, "O/stray.txt", changes[0].Path)
+	if runtime.GOOS != "windows" {
+		require.FileExists(t, marker)
+	}
+}
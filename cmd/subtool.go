@@ -65,6 +65,9 @@ type SubParams struct {
 	maxMessages int
 }
 
+// LongRunning - it waits for messages until it is interrupted
+func (p *SubParams) LongRunning() {}
+
 func (p *SubParams) SetDefaults(ctx ActionCtx) error {
 	return p.AccountUserContextParams.SetDefaults(ctx)
 }
@@ -0,0 +1,435 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"gopkg.in/yaml.v2"
+)
+
+// Topology is the desired state of an operator and its accounts as
+// described by a topology file. Attributes that are not specified
+// are expected to have their default value.
+type Topology struct {
+	Operator *OperatorTopology  `yaml:"operator,omitempty" json:"operator,omitempty"`
+	Accounts []*AccountTopology `yaml:"accounts,omitempty" json:"accounts,omitempty"`
+}
+
+type OperatorTopology struct {
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Key is the public key of the operator, it is checked but not used to create the operator
+	Key                string   `yaml:"key,omitempty" json:"key,omitempty"`
+	AccountServerURL   string   `yaml:"account_server_url,omitempty" json:"account_server_url,omitempty"`
+	ServiceURLs        []string `yaml:"service_urls,omitempty" json:"service_urls,omitempty"`
+	SystemAccount      string   `yaml:"system_account,omitempty" json:"system_account,omitempty"`
+	RequireSigningKeys bool     `yaml:"require_signing_keys,omitempty" json:"require_signing_keys,omitempty"`
+	SigningKeys        []string `yaml:"signing_keys,omitempty" json:"signing_keys,omitempty"`
+	Tags               []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+type AccountTopology struct {
+	Name string `yaml:"name" json:"name"`
+	// Key is the public key of the account, it is checked if the
+	// account exists and ignored when the account is created
+	Key                string                        `yaml:"key,omitempty" json:"key,omitempty"`
+	Description        string                        `yaml:"description,omitempty" json:"description,omitempty"`
+	InfoURL            string                        `yaml:"info_url,omitempty" json:"info_url,omitempty"`
+	Tags               []string                      `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Limits             *AccountLimitsTopology        `yaml:"limits,omitempty" json:"limits,omitempty"`
+	DefaultPermissions *PermissionsTopology          `yaml:"default_permissions,omitempty" json:"default_permissions,omitempty"`
+	SigningKeys        []*SigningKeyTopology         `yaml:"signing_keys,omitempty" json:"signing_keys,omitempty"`
+	Exports            []*ExportTopology             `yaml:"exports,omitempty" json:"exports,omitempty"`
+	Imports            []*ImportTopology             `yaml:"imports,omitempty" json:"imports,omitempty"`
+	Mappings           map[string][]*MappingTopology `yaml:"mappings,omitempty" json:"mappings,omitempty"`
+	Users              []*UserTopology               `yaml:"users,omitempty" json:"users,omitempty"`
+}
+
+// AccountLimitsTopology holds the account limits, limits
+// that are not specified are unlimited (or disabled for jetstream)
+type AccountLimitsTopology struct {
+	Conns           *int64 `yaml:"conns,omitempty" json:"conns,omitempty"`
+	LeafConns       *int64 `yaml:"leaf_conns,omitempty" json:"leaf_conns,omitempty"`
+	Subscriptions   *int64 `yaml:"subscriptions,omitempty" json:"subscriptions,omitempty"`
+	Data            *int64 `yaml:"data,omitempty" json:"data,omitempty"`
+	Payload         *int64 `yaml:"payload,omitempty" json:"payload,omitempty"`
+	Imports         *int64 `yaml:"imports,omitempty" json:"imports,omitempty"`
+	Exports         *int64 `yaml:"exports,omitempty" json:"exports,omitempty"`
+	WildcardExports *bool  `yaml:"wildcard_exports,omitempty" json:"wildcard_exports,omitempty"`
+	JsMemStorage    *int64 `yaml:"js_mem_storage,omitempty" json:"js_mem_storage,omitempty"`
+	JsDiskStorage   *int64 `yaml:"js_disk_storage,omitempty" json:"js_disk_storage,omitempty"`
+	JsStreams       *int64 `yaml:"js_streams,omitempty" json:"js_streams,omitempty"`
+	JsConsumer      *int64 `yaml:"js_consumer,omitempty" json:"js_consumer,omitempty"`
+}
+
+type PermissionsTopology struct {
+	PubAllow     []string `yaml:"pub_allow,omitempty" json:"pub_allow,omitempty"`
+	PubDeny      []string `yaml:"pub_deny,omitempty" json:"pub_deny,omitempty"`
+	SubAllow     []string `yaml:"sub_allow,omitempty" json:"sub_allow,omitempty"`
+	SubDeny      []string `yaml:"sub_deny,omitempty" json:"sub_deny,omitempty"`
+	MaxResponses int      `yaml:"max_responses,omitempty" json:"max_responses,omitempty"`
+	ResponseTTL  string   `yaml:"response_ttl,omitempty" json:"response_ttl,omitempty"`
+}
+
+type UserLimitsTopology struct {
+	Subs    *int64 `yaml:"subs,omitempty" json:"subs,omitempty"`
+	Data    *int64 `yaml:"data,omitempty" json:"data,omitempty"`
+	Payload *int64 `yaml:"payload,omitempty" json:"payload,omitempty"`
+}
+
+// UserPermissionLimitsTopology are the settings shared by users
+// and the templates of scoped signing keys
+type UserPermissionLimitsTopology struct {
+	Permissions    *PermissionsTopology `yaml:"permissions,omitempty" json:"permissions,omitempty"`
+	Limits         *UserLimitsTopology  `yaml:"limits,omitempty" json:"limits,omitempty"`
+	Bearer         bool                 `yaml:"bearer,omitempty" json:"bearer,omitempty"`
+	SourceNetworks []string             `yaml:"source_networks,omitempty" json:"source_networks,omitempty"`
+	ConnTypes      []string             `yaml:"conn_types,omitempty" json:"conn_types,omitempty"`
+}
+
+// SigningKeyTopology is an account signing key. Keys with a role or
+// a template are scoped. A key without a public key is looked up by
+// its role, and generated if the account doesn't have a key with the role.
+type SigningKeyTopology struct {
+	Key      string                        `yaml:"key,omitempty" json:"key,omitempty"`
+	Role     string                        `yaml:"role,omitempty" json:"role,omitempty"`
+	Template *UserPermissionLimitsTopology `yaml:"template,omitempty" json:"template,omitempty"`
+}
+
+type ExportTopology struct {
+	Name                 string           `yaml:"name,omitempty" json:"name,omitempty"`
+	Subject              string           `yaml:"subject" json:"subject"`
+	Type                 string           `yaml:"type,omitempty" json:"type,omitempty"`
+	Private              bool             `yaml:"private,omitempty" json:"private,omitempty"`
+	ResponseType         string           `yaml:"response_type,omitempty" json:"response_type,omitempty"`
+	ResponseThreshold    string           `yaml:"response_threshold,omitempty" json:"response_threshold,omitempty"`
+	Latency              *LatencyTopology `yaml:"latency,omitempty" json:"latency,omitempty"`
+	AccountTokenPosition uint             `yaml:"account_token_position,omitempty" json:"account_token_position,omitempty"`
+	Advertise            bool             `yaml:"advertise,omitempty" json:"advertise,omitempty"`
+	Description          string           `yaml:"description,omitempty" json:"description,omitempty"`
+	InfoURL              string           `yaml:"info_url,omitempty" json:"info_url,omitempty"`
+}
+
+type LatencyTopology struct {
+	Subject  string `yaml:"subject" json:"subject"`
+	Sampling string `yaml:"sampling" json:"sampling"`
+}
+
+// ImportTopology is a public import from an account (name or public key)
+// or a private import described by an activation token (path or url)
+type ImportTopology struct {
+	Name    string `yaml:"name,omitempty" json:"name,omitempty"`
+	Account string `yaml:"account,omitempty" json:"account,omitempty"`
+	// AccountKey is the public key of the account, it is used if the
+	// account name cannot be resolved in the current operator
+	AccountKey   string `yaml:"account_key,omitempty" json:"account_key,omitempty"`
+	Subject      string `yaml:"subject,omitempty" json:"subject,omitempty"`
+	LocalSubject string `yaml:"local_subject,omitempty" json:"local_subject,omitempty"`
+	Type         string `yaml:"type,omitempty" json:"type,omitempty"`
+	Share        bool   `yaml:"share,omitempty" json:"share,omitempty"`
+	Token        string `yaml:"token,omitempty" json:"token,omitempty"`
+}
+
+type MappingTopology struct {
+	Subject string `yaml:"subject" json:"subject"`
+	Weight  uint8  `yaml:"weight,omitempty" json:"weight,omitempty"`
+	Cluster string `yaml:"cluster,omitempty" json:"cluster,omitempty"`
+}
+
+type UserTopology struct {
+	Name string `yaml:"name" json:"name"`
+	// Key is the public key of the user, it is checked if the
+	// user exists and ignored when the user is created
+	Key string `yaml:"key,omitempty" json:"key,omitempty"`
+	// SigningKey is the role or public key of the account signing key
+	// that signs the user, the account key is used if not specified
+	SigningKey                   string   `yaml:"signing_key,omitempty" json:"signing_key,omitempty"`
+	Tags                         []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	UserPermissionLimitsTopology `yaml:",inline"`
+}
+
+// LoadTopology reads and validates a topology file
+func LoadTopology(fp string) (*Topology, error) {
+	d, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	var t Topology
+	if err := yaml.UnmarshalStrict(d, &t); err != nil {
+		return nil, fmt.Errorf("error parsing %#q: %v", fp, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid topology %#q: %v", fp, err)
+	}
+	// token paths are relative to the topology file
+	for _, a := range t.Accounts {
+		for _, im := range a.Imports {
+			if im.Token != "" && !IsURL(im.Token) && !filepath.IsAbs(im.Token) {
+				im.Token = filepath.Join(filepath.Dir(fp), im.Token)
+			}
+		}
+	}
+	return &t, nil
+}
+
+// Validate checks the topology for errors that don't depend on the store
+func (t *Topology) Validate() error {
+	if t.Operator != nil && t.Operator.Key != "" && !nkeys.IsValidPublicOperatorKey(t.Operator.Key) {
+		return fmt.Errorf("operator key %q is not an operator public key", t.Operator.Key)
+	}
+	names := make(map[string]bool)
+	for _, a := range t.Accounts {
+		if a.Name == "" {
+			return fmt.Errorf("accounts require a name")
+		}
+		if names[a.Name] {
+			return fmt.Errorf("account %q is specified more than once", a.Name)
+		}
+		names[a.Name] = true
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("account %q: %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+func (a *AccountTopology) validate() error {
+	if a.Key != "" && !nkeys.IsValidPublicAccountKey(a.Key) {
+		return fmt.Errorf("key %q is not an account public key", a.Key)
+	}
+	roles := make(map[string]bool)
+	for _, sk := range a.SigningKeys {
+		if sk.Key == "" && sk.Role == "" {
+			return fmt.Errorf("signing keys require a key or a role")
+		}
+		if sk.Role != "" {
+			if roles[sk.Role] {
+				return fmt.Errorf("signing key role %q is specified more than once", sk.Role)
+			}
+			roles[sk.Role] = true
+		}
+		if _, err := sk.Template.jwtUserPermissionLimits(jwt.NewUserScope().Template); err != nil {
+			return err
+		}
+	}
+	subjects := make(map[string]bool)
+	for _, e := range a.Exports {
+		if _, err := e.jwtExport(); err != nil {
+			return err
+		}
+		if subjects[e.Subject] {
+			return fmt.Errorf("export %q is specified more than once", e.Subject)
+		}
+		subjects[e.Subject] = true
+	}
+	for _, im := range a.Imports {
+		if im.Token == "" && ((im.Account == "" && im.AccountKey == "") || im.Subject == "") {
+			return fmt.Errorf("imports require a token or an account and a subject")
+		}
+		if im.AccountKey != "" && !nkeys.IsValidPublicAccountKey(im.AccountKey) {
+			return fmt.Errorf("import %q: %q is not an account public key", im.Subject, im.AccountKey)
+		}
+		if im.Token != "" && (im.Account != "" || im.AccountKey != "" || im.Subject != "") {
+			return fmt.Errorf("imports with a token cannot specify an account or a subject")
+		}
+		if _, err := exportType(im.Type); err != nil {
+			return err
+		}
+	}
+	users := make(map[string]bool)
+	for _, u := range a.Users {
+		if u.Name == "" {
+			return fmt.Errorf("users require a name")
+		}
+		if users[u.Name] {
+			return fmt.Errorf("user %q is specified more than once", u.Name)
+		}
+		users[u.Name] = true
+		if u.Key != "" && !nkeys.IsValidPublicUserKey(u.Key) {
+			return fmt.Errorf("user %q: key %q is not a user public key", u.Name, u.Key)
+		}
+		if _, err := u.jwtUserPermissionLimits(jwt.NewUserClaims("U").UserPermissionLimits); err != nil {
+			return fmt.Errorf("user %q: %v", u.Name, err)
+		}
+		if a.scopedSigningKey(u.SigningKey) != nil && !u.UserPermissionLimitsTopology.isEmpty() {
+			return fmt.Errorf("user %q is signed by a scoped signing key and cannot have permissions or limits", u.Name)
+		}
+	}
+	return nil
+}
+
+// scopedSigningKey returns the scoped signing key with the role or public key
+func (a *AccountTopology) scopedSigningKey(ref string) *SigningKeyTopology {
+	if ref == "" {
+		return nil
+	}
+	for _, sk := range a.SigningKeys {
+		if (sk.Role == ref || sk.Key == ref) && sk.scoped() {
+			return sk
+		}
+	}
+	return nil
+}
+
+func (s *SigningKeyTopology) scoped() bool {
+	return s.Role != "" || s.Template != nil
+}
+
+func (u *UserPermissionLimitsTopology) isEmpty() bool {
+	return u.Permissions == nil && u.Limits == nil && !u.Bearer && len(u.SourceNetworks) == 0 && len(u.ConnTypes) == 0
+}
+
+func exportType(s string) (jwt.ExportType, error) {
+	switch strings.ToLower(s) {
+	case "", "stream":
+		return jwt.Stream, nil
+	case "service":
+		return jwt.Service, nil
+	}
+	return jwt.Unknown, fmt.Errorf("unknown export type %q - valid types are \"stream\" or \"service\"", s)
+}
+
+func limitOrDefault(v *int64, def int64) int64 {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+// jwtLimits returns the account limits described by the topology
+func (l *AccountLimitsTopology) jwtLimits() jwt.OperatorLimits {
+	limits := jwt.NewAccountClaims("A").Limits
+	if l == nil {
+		return limits
+	}
+	limits.Conn = limitOrDefault(l.Conns, limits.Conn)
+	limits.LeafNodeConn = limitOrDefault(l.LeafConns, limits.LeafNodeConn)
+	limits.Subs = limitOrDefault(l.Subscriptions, limits.Subs)
+	limits.Data = limitOrDefault(l.Data, limits.Data)
+	limits.Payload = limitOrDefault(l.Payload, limits.Payload)
+	limits.Imports = limitOrDefault(l.Imports, limits.Imports)
+	limits.Exports = limitOrDefault(l.Exports, limits.Exports)
+	if l.WildcardExports != nil {
+		limits.WildcardExports = *l.WildcardExports
+	}
+	limits.MemoryStorage = limitOrDefault(l.JsMemStorage, limits.MemoryStorage)
+	limits.DiskStorage = limitOrDefault(l.JsDiskStorage, limits.DiskStorage)
+	limits.Streams = limitOrDefault(l.JsStreams, limits.Streams)
+	limits.Consumer = limitOrDefault(l.JsConsumer, limits.Consumer)
+	return limits
+}
+
+func (p *PermissionsTopology) jwtPermissions() (jwt.Permissions, error) {
+	var perms jwt.Permissions
+	if p == nil {
+		return perms, nil
+	}
+	perms.Pub.Allow.Add(p.PubAllow...)
+	perms.Pub.Deny.Add(p.PubDeny...)
+	perms.Sub.Allow.Add(p.SubAllow...)
+	perms.Sub.Deny.Add(p.SubDeny...)
+	if p.MaxResponses != 0 || p.ResponseTTL != "" {
+		perms.Resp = &jwt.ResponsePermission{MaxMsgs: p.MaxResponses}
+		if p.ResponseTTL != "" {
+			ttl, err := time.ParseDuration(p.ResponseTTL)
+			if err != nil {
+				return perms, fmt.Errorf("invalid response ttl %q: %v", p.ResponseTTL, err)
+			}
+			perms.Resp.Expires = ttl
+		}
+	}
+	return perms, nil
+}
+
+// jwtUserPermissionLimits returns the defaults updated with the settings in the topology
+func (u *UserPermissionLimitsTopology) jwtUserPermissionLimits(defaults jwt.UserPermissionLimits) (jwt.UserPermissionLimits, error) {
+	upl := defaults
+	if u == nil {
+		return upl, nil
+	}
+	var err error
+	if upl.Permissions, err = u.Permissions.jwtPermissions(); err != nil {
+		return upl, err
+	}
+	if u.Limits != nil {
+		upl.Subs = limitOrDefault(u.Limits.Subs, upl.Subs)
+		upl.Data = limitOrDefault(u.Limits.Data, upl.Data)
+		upl.Payload = limitOrDefault(u.Limits.Payload, upl.Payload)
+	}
+	upl.BearerToken = u.Bearer
+	upl.Src = jwt.CIDRList{}
+	upl.Src.Add(u.SourceNetworks...)
+	upl.AllowedConnectionTypes = nil
+	for _, ct := range u.ConnTypes {
+		ct = strings.ToUpper(ct)
+		switch ct {
+		case jwt.ConnectionTypeLeafnode, jwt.ConnectionTypeMqtt, jwt.ConnectionTypeStandard, jwt.ConnectionTypeWebsocket:
+		default:
+			return upl, fmt.Errorf("unknown connection type %s", ct)
+		}
+		upl.AllowedConnectionTypes.Add(ct)
+	}
+	return upl, nil
+}
+
+// jwtExport returns the export described by the topology
+func (e *ExportTopology) jwtExport() (*jwt.Export, error) {
+	if e.Subject == "" {
+		return nil, fmt.Errorf("exports require a subject")
+	}
+	kind, err := exportType(e.Type)
+	if err != nil {
+		return nil, err
+	}
+	export := &jwt.Export{
+		Name:                 e.Name,
+		Subject:              jwt.Subject(e.Subject),
+		Type:                 kind,
+		TokenReq:             e.Private,
+		AccountTokenPosition: e.AccountTokenPosition,
+		Advertise:            e.Advertise,
+		Info:                 jwt.Info{Description: e.Description, InfoURL: e.InfoURL},
+	}
+	if export.Name == "" {
+		export.Name = e.Subject
+	}
+	if kind == jwt.Service {
+		export.ResponseType = jwt.ResponseTypeSingleton
+		if e.ResponseType != "" {
+			export.ResponseType = jwt.ResponseType(e.ResponseType)
+		}
+		if e.ResponseThreshold != "" {
+			if export.ResponseThreshold, err = time.ParseDuration(e.ResponseThreshold); err != nil {
+				return nil, fmt.Errorf("export %q has an invalid response threshold: %v", e.Subject, err)
+			}
+		}
+		if e.Latency != nil {
+			if err := SamplingValidator(e.Latency.Sampling); err != nil {
+				return nil, fmt.Errorf("export %q has an invalid latency sampling: %v", e.Subject, err)
+			}
+			export.Latency = &jwt.ServiceLatency{Results: jwt.Subject(e.Latency.Subject), Sampling: latSamplingRate(e.Latency.Sampling)}
+		}
+	} else if e.ResponseType != "" || e.ResponseThreshold != "" || e.Latency != nil {
+		return nil, fmt.Errorf("export %q is a stream - response and latency settings only apply to services", e.Subject)
+	}
+	return export, nil
+}
@@ -0,0 +1,340 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	cli "github.com/nats-io/cliprompts/v2"
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/cobra"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func createUndoCmd() *cobra.Command {
+	var params UndoParams
+	cmd := &cobra.Command{
+		Use:   "undo",
+		Short: "Revert the last changes made to the operator, its accounts and users",
+		Long: `Revert the last changes made to the operator, its accounts and users
+
+The previous JWTs recorded by the journal (see 'nsc history') are restored
+into the store, JWTs that were created by a change are removed. Changes
+are reverted newest first. Changes to the keystore are not reverted.
+
+Restored account JWTs need to be pushed to the account server or resolver,
+the accounts that need it are listed when the command completes.`,
+		Example: `nsc undo
+nsc undo --count 3
+nsc undo --count 3 --dry-run`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().IntVarP(&params.count, "count", "n", 1, "number of changes to revert")
+	return cmd
+}
+
+func createRollbackCmd() *cobra.Command {
+	var params RollbackParams
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Revert the changes made to the operator, its accounts and users after a date",
+		Long: `Revert the changes made to the operator, its accounts and users after a date
+
+All changes recorded by the journal (see 'nsc history') after the date are
+reverted newest first, as 'nsc undo' would. The date is specified as an
+RFC3339 date or a unix timestamp.`,
+		Example: `nsc rollback --to 2021-06-01T00:00:00Z
+nsc rollback --to 1622505600 --dry-run`,
+		Args:         MaxArgs(0),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunAction(cmd, args, &params)
+		},
+	}
+	cmd.Flags().VarP(&params.to, "to", "", "revert the changes made after the date")
+	return cmd
+}
+
+func init() {
+	GetRootCmd().AddCommand(createUndoCmd())
+	GetRootCmd().AddCommand(createRollbackCmd())
+}
+
+// revertParams reverts journal entries, newest first
+type revertParams struct {
+	changes []*store.JournalEntry
+}
+
+func (p *revertParams) load(ctx ActionCtx) ([]*store.JournalEntry, error) {
+	changes, err := ctx.StoreCtx().Store.Journal().Revertible()
+	if err != nil {
+		return nil, err
+	}
+	// newest first
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	return changes, nil
+}
+
+func (p *revertParams) confirm() error {
+	ok, err := cli.Confirm(fmt.Sprintf("revert %d change(s)", len(p.changes)), true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("revert cancelled")
+	}
+	return nil
+}
+
+func (p *revertParams) revert(ctx ActionCtx, label string) (store.Status, error) {
+	s := ctx.StoreCtx().Store
+	r := store.NewReport(store.OK, label)
+	r.Opt = store.DetailsOnly
+	push := make(map[string]bool)
+	for i, e := range p.changes {
+		cr := store.NewReport(store.OK, "revert %s %s from %s", e.Op, journalEntity(e), e.Time.Local().Format(time.RFC3339))
+		r.Add(cr)
+		if err := p.revertEntry(ctx, e, cr); err != nil {
+			cr.AddFromError(err)
+		}
+		if cr.HasErrors() {
+			// older changes build on this one
+			if i < len(p.changes)-1 {
+				r.AddWarning("%d older change(s) were not reverted", len(p.changes)-1-i)
+			}
+			break
+		}
+		if e.Kind == store.KindAccount {
+			push[e.Account] = true
+		}
+		if e.Kind == store.KindOperator {
+			cr.AddWarning("servers using the operator JWT need to be updated")
+		}
+	}
+	if len(push) > 0 && !s.IsManaged() {
+		var accounts []string
+		for a := range push {
+			accounts = append(accounts, a)
+		}
+		sort.Strings(accounts)
+		for _, a := range accounts {
+			if s.HasAccount(a) {
+				r.AddWarning("account %q needs to be pushed - 'nsc push --account %s'", a, a)
+			} else {
+				r.AddWarning("account %q was removed from the store but remains on the account server", a)
+			}
+		}
+	}
+	return r, nil
+}
+
+func (p *revertParams) revertEntry(ctx ActionCtx, e *store.JournalEntry, r *store.Report) error {
+	s := ctx.StoreCtx().Store
+	j := s.Journal()
+	fp := filepath.FromSlash(e.Path)
+
+	current := ""
+	if s.Has(fp) {
+		d, err := s.Read(fp)
+		if err != nil {
+			return err
+		}
+		current = store.HashJwt(d)
+	}
+	if current != e.NewHash {
+		return fmt.Errorf("%s was modified after the change - not reverting", journalEntity(e))
+	}
+
+	if e.PreviousHash == "" {
+		if e.Kind == store.KindOperator {
+			return errors.New("the operator cannot be removed - use 'nsc env' to select another operator")
+		}
+		return j.Revert(e, func() error {
+			if err := s.Delete(fp); err != nil {
+				return err
+			}
+			if e.Kind == store.KindAccount {
+				// maybe remove the users and account directories
+				_ = s.Delete(store.Accounts, e.Account, store.Users)
+				_ = s.Delete(store.Accounts, e.Account)
+			}
+			r.AddOK("removed %s", journalEntity(e))
+			return nil
+		})
+	}
+
+	token, err := j.ReadJwt(e.PreviousHash)
+	if err != nil {
+		return err
+	}
+	if err := p.checkSigner(ctx, e, token); err != nil {
+		return err
+	}
+	return j.Revert(e, func() error {
+		rs, err := s.StoreClaim(token)
+		if rs != nil {
+			r.Add(rs)
+		}
+		if err != nil {
+			return err
+		}
+		r.AddOK("restored %s", journalEntity(e))
+		return nil
+	})
+}
+
+// checkSigner verifies the restored jwt is still issued by its
+// current parent, as the store would otherwise not accept it
+func (p *revertParams) checkSigner(ctx ActionCtx, e *store.JournalEntry, token []byte) error {
+	s := ctx.StoreCtx().Store
+	switch e.Kind {
+	case store.KindAccount:
+		ac, err := jwt.DecodeAccountClaims(string(token))
+		if err != nil {
+			return err
+		}
+		if s.IsManaged() {
+			return nil
+		}
+		oc, err := s.ReadOperatorClaim()
+		if err != nil {
+			return err
+		}
+		if !oc.DidSign(ac) {
+			return fmt.Errorf("account %q was not issued by the operator or one of its signing keys", e.Account)
+		}
+	case store.KindUser:
+		uc, err := jwt.DecodeUserClaims(string(token))
+		if err != nil {
+			return err
+		}
+		ac, err := s.ReadAccountClaim(e.Account)
+		if err != nil {
+			return err
+		}
+		if !ac.DidSign(uc) {
+			return fmt.Errorf("user %q was not issued by account %q or one of its signing keys", e.Name, e.Account)
+		}
+	case store.KindOperator:
+		if _, err := jwt.DecodeOperatorClaims(string(token)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type UndoParams struct {
+	revertParams
+	count int
+}
+
+func (p *UndoParams) SetDefaults(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *UndoParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *UndoParams) Load(ctx ActionCtx) error {
+	changes, err := p.load(ctx)
+	if err != nil {
+		return err
+	}
+	if p.count < len(changes) && p.count > 0 {
+		changes = changes[:p.count]
+	}
+	p.changes = changes
+	return nil
+}
+
+func (p *UndoParams) PostInteractive(ctx ActionCtx) error {
+	return p.confirm()
+}
+
+func (p *UndoParams) Validate(ctx ActionCtx) error {
+	if p.count < 1 {
+		return errors.New("--count must be at least 1")
+	}
+	if len(p.changes) == 0 {
+		return errors.New("there are no changes to undo")
+	}
+	return nil
+}
+
+func (p *UndoParams) Run(ctx ActionCtx) (store.Status, error) {
+	return p.revert(ctx, "undo")
+}
+
+type RollbackParams struct {
+	revertParams
+	to dateTime
+}
+
+func (p *RollbackParams) SetDefaults(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *RollbackParams) PreInteractive(ctx ActionCtx) error {
+	return nil
+}
+
+func (p *RollbackParams) Load(ctx ActionCtx) error {
+	changes, err := p.load(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range changes {
+		if e.Time.Unix() > int64(p.to) {
+			p.changes = append(p.changes, e)
+		}
+	}
+	return nil
+}
+
+func (p *RollbackParams) PostInteractive(ctx ActionCtx) error {
+	if len(p.changes) == 0 {
+		return nil
+	}
+	return p.confirm()
+}
+
+func (p *RollbackParams) Validate(ctx ActionCtx) error {
+	if p.to == 0 {
+		return errors.New("--to is required")
+	}
+	return nil
+}
+
+func (p *RollbackParams) Run(ctx ActionCtx) (store.Status, error) {
+	label := fmt.Sprintf("rollback to %s", time.Unix(int64(p.to), 0).Format(time.RFC3339))
+	if len(p.changes) == 0 {
+		return store.NewReport(store.OK, label).AddOK("no changes were made after %s",
+			time.Unix(int64(p.to), 0).Format(time.RFC3339)), nil
+	}
+	return p.revert(ctx, label)
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright 2021 The NATS Authors
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kbehouse/nsc/cmd/store"
+)
+
+func Test_UndoRestoresPreviousJwt(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	_, _, err := ExecuteCmd(createEditAccount(), "--name", "A", "--conns", "10")
+	require.NoError(t, err)
+
+	_, stderr, err := ExecuteCmd(createUndoCmd())
+	require.NoError(t, err)
+	require.Contains(t, stderr, `account "A" needs to be pushed`)
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), ac.Limits.Conn)
+
+	entries, err := ts.Store.Journal().Entries()
+	require.NoError(t, err)
+	last := entries[len(entries)-1]
+	require.Equal(t, entries[len(entries)-2].ID, last.Reverts)
+
+	// the reverted edit is skipped, the next undo removes the account
+	_, _, err = ExecuteCmd(createUndoCmd())
+	require.NoError(t, err)
+	require.False(t, ts.Store.HasAccount("A"))
+}
+
+func Test_UndoCount(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddAccount(t, "B")
+	ts.AddUser(t, "B", "U")
+
+	_, _, err := ExecuteCmd(createUndoCmd(), "--count", "2")
+	require.NoError(t, err)
+	require.True(t, ts.Store.HasAccount("A"))
+	require.False(t, ts.Store.HasAccount("B"))
+
+	// only the operator creation remains, which cannot be reverted
+	_, _, err = ExecuteCmd(createUndoCmd(), "--count", "2")
+	require.Error(t, err)
+	require.True(t, ts.Store.Has(store.JwtName("O")))
+}
+
+func Test_UndoRestoresDeletedUser(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	ts.AddUser(t, "A", "U")
+	_, _, err := ExecuteCmd(CreateDeleteUserCmd(), "--name", "U")
+	require.NoError(t, err)
+	require.False(t, ts.Store.Has(store.Accounts, "A", store.Users, store.JwtName("U")))
+
+	_, _, err = ExecuteCmd(createUndoCmd())
+	require.NoError(t, err)
+	uc, err := ts.Store.ReadUserClaim("A", "U")
+	require.NoError(t, err)
+	require.Equal(t, "U", uc.Name)
+}
+
+func Test_UndoDetectsModifiedJwt(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	_, _, err := ExecuteCmd(createEditAccount(), "--name", "A", "--conns", "10")
+	require.NoError(t, err)
+
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	ac.Limits.Conn = 20
+	token, err := ac.Encode(ts.OperatorKey)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(ts.Store.Resolve(store.Accounts, "A", store.JwtName("A")), []byte(token), 0600))
+
+	_, stderr, err := ExecuteCmd(createUndoCmd())
+	require.Error(t, err)
+	require.Contains(t, stderr, "was modified after the change")
+	ac, err = ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Equal(t, int64(20), ac.Limits.Conn)
+}
+
+func Test_UndoDryRun(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+
+	_, _, err := ExecuteCmd(HoistRootFlags(createUndoCmd()), "--dry-run")
+	require.NoError(t, err)
+	require.True(t, ts.Store.HasAccount("A"))
+	changes, err := ts.Store.Journal().Revertible()
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+}
+
+func Test_RollbackTo(t *testing.T) {
+	ts := NewTestStore(t, "O")
+	defer ts.Done(t)
+	ts.AddAccount(t, "A")
+	to := time.Now().Unix()
+	time.Sleep(time.Second + 100*time.Millisecond)
+
+	_, _, err := ExecuteCmd(createEditAccount(), "--name", "A", "--conns", "10")
+	require.NoError(t, err)
+	ts.AddAccount(t, "B")
+	ts.AddUser(t, "A", "U")
+
+	_, stderr, err := ExecuteCmd(createRollbackCmd(), "--to", fmt.Sprintf("%d", to))
+	require.NoError(t, err)
+	require.Contains(t, stderr, `account "B" was removed from the store`)
+	require.False(t, ts.Store.HasAccount("B"))
+	require.False(t, ts.Store.Has(store.Accounts, "A", store.Users, store.JwtName("U")))
+	ac, err := ts.Store.ReadAccountClaim("A")
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), ac.Limits.Conn)
+
+	_, stderr, err = ExecuteCmd(createRollbackCmd(), "--to", fmt.Sprintf("%d", to))
+	require.NoError(t, err)
+	require.Contains(t, stderr, "no changes were made after")
+
+	_, _, err = ExecuteCmd(createRollbackCmd())
+	require.Error(t, err)
+}
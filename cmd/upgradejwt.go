@@ -44,7 +44,7 @@ func backup(file string, dir string) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		if info.IsDir() || info.Name() == store.LockFile {
 			return nil
 		}
 		wrtr, err := w.Create(strings.TrimPrefix(path, dir))
@@ -22,6 +22,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/kbehouse/nsc/cmd/store"
@@ -201,5 +202,12 @@ func TestUpgradeBackup(t *testing.T) {
 	require.NoError(t, err)
 	defer closer.Close()
 	require.NoError(t, err)
-	require.Len(t, closer.File, 2) // .nsc and O.jwt
+	// .nsc and O.jwt, and the journal recording the changes
+	var files []string
+	for _, f := range closer.File {
+		if !strings.HasPrefix(f.Name, "/"+store.HistoryDir+"/") {
+			files = append(files, f.Name)
+		}
+	}
+	require.ElementsMatch(t, []string{"/.nsc", "/O.jwt"}, files)
 }
@@ -65,6 +65,10 @@ func ResetSharedFlags() {
 	Json = false
 	Raw = false
 	JsonPath = ""
+	OutputFlag = TextOutput
+	DryRunFlag = false
+	lastStatusCode = store.NONE
+	jsonDocumentWritten = false
 }
 
 func NewEmptyStore(t *testing.T) *TestStore {
@@ -45,12 +45,13 @@ validate -f <file>`,
 				// this error was not during the sync operation return as it is
 				return err
 			}
-			cmd.Println(params.render(fmt.Sprintf("Operator %q", GetConfig().Operator), params.operator))
-			sort.Strings(params.accounts)
-			for _, v := range params.accounts {
-				cmd.Println(params.render(fmt.Sprintf("Account %q", v), params.accountValidations[v]))
+			if IsJsonOutput() {
+				if err := writeJsonDocument(cmd, params.document()); err != nil {
+					return err
+				}
+			} else {
+				params.renderAll(cmd)
 			}
-
 			if params.foundErrors() {
 				cmd.SilenceUsage = true
 				return errors.New("validation found errors")
@@ -64,6 +65,14 @@ validate -f <file>`,
 	return cmd
 }
 
+func (p *ValidateCmdParams) renderAll(cmd *cobra.Command) {
+	cmd.Println(p.render(fmt.Sprintf("Operator %q", GetConfig().Operator), p.operator))
+	sort.Strings(p.accounts)
+	for _, v := range p.accounts {
+		cmd.Println(p.render(fmt.Sprintf("Account %q", v), p.accountValidations[v]))
+	}
+}
+
 func init() {
 	GetRootCmd().AddCommand(createValidateCommand())
 }
@@ -313,3 +322,54 @@ func (p *ValidateCmdParams) render(name string, issues *jwt.ValidationResults) s
 	}
 	return table.Render()
 }
+
+// ValidationIssueDocument is the json representation of a validation issue
+type ValidationIssueDocument struct {
+	Description string `json:"description"`
+	Blocking    bool   `json:"blocking"`
+	TimeCheck   bool   `json:"time_check"`
+}
+
+// ValidationDocument is the json representation of the validation results of a jwt
+type ValidationDocument struct {
+	Name   string                     `json:"name"`
+	Issues []*ValidationIssueDocument `json:"issues"`
+}
+
+func validationDocument(name string, vr *jwt.ValidationResults) *ValidationDocument {
+	d := &ValidationDocument{Name: name, Issues: []*ValidationIssueDocument{}}
+	if vr != nil {
+		for _, v := range vr.Issues {
+			d.Issues = append(d.Issues, &ValidationIssueDocument{Description: v.Description, Blocking: v.Blocking, TimeCheck: v.TimeCheck})
+		}
+	}
+	return d
+}
+
+func (p *ValidateCmdParams) document() interface{} {
+	code := store.OK
+	if p.foundErrors() {
+		code = store.ERR
+	} else if p.operator != nil && len(p.operator.Issues) > 0 {
+		code = store.WARN
+	}
+	doc := struct {
+		Code     store.StatusCode      `json:"code"`
+		Operator *ValidationDocument   `json:"operator,omitempty"`
+		Accounts []*ValidationDocument `json:"accounts"`
+	}{Code: code, Accounts: []*ValidationDocument{}}
+	if p.file == "" {
+		doc.Operator = validationDocument(GetConfig().Operator, p.operator)
+	}
+	sort.Strings(p.accounts)
+	for _, v := range p.accounts {
+		vr := p.accountValidations[v]
+		if code == store.OK && vr != nil && len(vr.Issues) > 0 {
+			code = store.WARN
+		}
+		doc.Accounts = append(doc.Accounts, validationDocument(v, vr))
+	}
+	doc.Code = code
+	lastStatusCode = code
+	return doc
+}
@@ -23,9 +23,12 @@ require (
 	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.2.1
 	github.com/stretchr/testify v1.6.1
+	github.com/tyler-smith/go-bip39 v1.1.0
 	github.com/xlab/tablewriter v0.0.0-20160610135559-80b567a11ad5
+	golang.org/x/crypto v0.0.0-20210314154223-e6e6c4f2bb5b
 	golang.org/x/oauth2 v0.0.0-20181203162652-d668ce993890 // indirect
-	gopkg.in/yaml.v2 v2.2.2 // indirect
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68
+	gopkg.in/yaml.v2 v2.2.2
 )
 
 go 1.16